@@ -1,16 +1,40 @@
 package godivert
 
-import "fmt"
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// WINDIVERT_ADDRESS bit fields, stored in a single UINT32 after the timestamp
+const (
+	addrLayerShift     = 0
+	addrEventShift     = 8
+	addrSniffedBit     = 16
+	addrOutboundBit    = 17
+	addrLoopbackBit    = 18
+	addrImpostorBit    = 19
+	addrIPv6Bit        = 20
+	addrIPChecksumBit  = 21
+	addrTCPChecksumBit = 22
+	addrUDPChecksumBit = 23
+)
+
+// Size of the WINDIVERT_ADDRESS struct in bytes, on both x86 and x64
+const winDivertAddressSize = 80
 
 // Represents a WinDivertAddress struct
 // See : https://reqrypt.org/windivert-doc.html#divert_address
-// As go doesn't not support bit fields
-// we use a little trick to get the Direction, Loopback, Import and PseudoChecksum fields
+// The layout matches WinDivert 2.x, as go doesn't not support bit fields
+// the Layer, Event and flag bits are read from Bits and the layer data from the union
+// through the accessors. Timestamp stays a field (a method can't have the same name),
+// it is the QueryPerformanceCounter value of the event.
+// The IfIdx and SubIfIdx fields of the 1.x layout are now the IfIdx and SubIfIdx methods (SetIfIdx
+// and SetSubIfIdx to write them), the Data flags byte is still available through Data and SetData.
 type WinDivertAddress struct {
 	Timestamp int64
-	IfIdx     uint32
-	SubIfIdx  uint32
-	Data      uint8
+	Bits      uint32
+	Reserved  uint32
+	Union     [64]byte
 }
 
 func (w *WinDivertAddress) String() string {
@@ -22,39 +46,254 @@ func (w *WinDivertAddress) String() string {
 		"\t\tImpostor=%t\n"+
 		"\t\tPseudoChecksum={IP=%t TCP=%t UDP=%t}\n"+
 		"\t}",
-		w.Timestamp, w.IfIdx, w.SubIfIdx, w.Direction(), w.Loopback(), w.Impostor(),
+		w.Timestamp, w.IfIdx(), w.SubIfIdx(), w.Direction(), w.Loopback(), w.Impostor(),
 		w.PseudoIPChecksum(), w.PseudoTCPChecksum(), w.PseudoUDPChecksum())
 }
 
+// Returns a NETWORK layer address for injecting a packet built from scratch
+// The address is outbound on interface 0: outbound packets are routed by the stack so no interface is needed,
+// set the direction and the interface (SetIfIdx, see RouteLookup) to inject an inbound packet.
+// Call SetIPv6 for an IPv6 packet.
+func NewAddress() *WinDivertAddress {
+	addr := &WinDivertAddress{}
+	addr.setLayer(WinDivertLayerNetwork)
+	addr.setEvent(WinDivertEventNetworkPacket)
+	addr.SetOutbound(true)
+	return addr
+}
+
+// Returns the given bit of the bit fields
+func (w *WinDivertAddress) bit(n uint) bool {
+	return (w.Bits>>n)&0x1 == 1
+}
+
+// Sets or clears the given bit of the bit fields
+func (w *WinDivertAddress) setBit(n uint, value bool) {
+	if value {
+		w.Bits |= 1 << n
+	} else {
+		w.Bits &^= 1 << n
+	}
+}
+
+// Returns the layer of the address
+func (w *WinDivertAddress) Layer() Layer {
+	return Layer(w.Bits >> addrLayerShift)
+}
+
+// Sets the layer of the address, e.g. to build fixtures or bridge layers
+// If the current event isn't valid on the new layer it is replaced by the first event of the layer
+func (w *WinDivertAddress) SetLayer(layer Layer) error {
+	events := layer.events()
+	if events == nil {
+		return fmt.Errorf("invalid layer %d", uint8(layer))
+	}
+
+	w.setLayer(layer)
+	if !layer.validEvent(w.Event()) {
+		w.setEvent(events[0])
+	}
+	return nil
+}
+
+func (w *WinDivertAddress) setLayer(layer Layer) {
+	w.Bits = w.Bits&^(0xff<<addrLayerShift) | uint32(layer)<<addrLayerShift
+}
+
+// Returns the event of the address
+func (w *WinDivertAddress) Event() Event {
+	return Event(w.Bits >> addrEventShift)
+}
+
+// Sets the event of the address
+// Returns an error if the event can't happen on the address's layer, set the layer first
+func (w *WinDivertAddress) SetEvent(event Event) error {
+	if !w.Layer().validEvent(event) {
+		return fmt.Errorf("event %v isn't valid on the %v layer", event, w.Layer())
+	}
+	w.setEvent(event)
+	return nil
+}
+
+func (w *WinDivertAddress) setEvent(event Event) {
+	w.Bits = w.Bits&^(0xff<<addrEventShift) | uint32(event)<<addrEventShift
+}
+
+// WINDIVERT_DATA_NETWORK, the union of the NETWORK and NETWORK_FORWARD layers
+type NetworkData struct {
+	IfIdx    uint32
+	SubIfIdx uint32
+}
+
+// Returns the union decoded as network data
+// Returns an error if the address isn't on a NETWORK layer: the union then holds flow,
+// socket or reflect data and reading interface indices from it would be meaningless
+func (w *WinDivertAddress) Network() (NetworkData, error) {
+	if !w.Layer().isNetwork() {
+		return NetworkData{}, fmt.Errorf("the %v layer carries no network data", w.Layer())
+	}
+	return NetworkData{IfIdx: w.IfIdx(), SubIfIdx: w.SubIfIdx()}, nil
+}
+
+// Returns the direction of the packet, only the NETWORK layer has one
+// Forwarded packets (NETWORK_FORWARD) are neither inbound nor outbound
+// and the other layers carry no packet, an error is returned for them
+func (w *WinDivertAddress) NetworkDirection() (Direction, error) {
+	if w.Layer() != WinDivertLayerNetwork {
+		return WinDivertDirectionInbound, fmt.Errorf("the %v layer has no packet direction", w.Layer())
+	}
+	return w.Direction(), nil
+}
+
+// Returns the interface index of the packet (NETWORK layers)
+// The value is meaningless on the other layers, see Network
+func (w *WinDivertAddress) IfIdx() uint32 {
+	return binary.LittleEndian.Uint32(w.Union[0:4])
+}
+
+// Returns the sub-interface index of the packet (NETWORK layers)
+func (w *WinDivertAddress) SubIfIdx() uint32 {
+	return binary.LittleEndian.Uint32(w.Union[4:8])
+}
+
+// Sets the interface index of the packet (NETWORK layers)
+func (w *WinDivertAddress) SetIfIdx(ifIdx uint32) {
+	binary.LittleEndian.PutUint32(w.Union[0:4], ifIdx)
+}
+
+// Sets the sub-interface index of the packet (NETWORK layers)
+func (w *WinDivertAddress) SetSubIfIdx(subIfIdx uint32) {
+	binary.LittleEndian.PutUint32(w.Union[4:8], subIfIdx)
+}
+
 // Returns the direction of the packet
 // WinDivertDirectionInbound (true) for inbounds packets
 // WinDivertDirectionOutbounds (false) for outbounds packets
+// WinDivert clears the outbound bit on NETWORK_FORWARD so forwarded packets read as inbound,
+// use NetworkDirection to tell them apart
 func (w *WinDivertAddress) Direction() Direction {
-	return Direction(w.Data&0x1 == 1)
+	return Direction(!w.Outbound())
+}
+
+// Sets the direction of the packet
+// WinDivert only knows loopback packets as outbound ones: a loopback packet injected inbound
+// never reaches the local stack, so the direction of a loopback address always stays outbound.
+// A reply to a loopback packet must also be injected outbound to be delivered locally.
+func (w *WinDivertAddress) SetDirection(direction Direction) {
+	outbound := direction == WinDivertDirectionOutbound || w.Loopback()
+	w.setBit(addrOutboundBit, outbound)
+}
+
+// Returns true if the event has been sniffed (WinDivertFlagSniff), the packet wasn't blocked
+func (w *WinDivertAddress) Sniffed() bool {
+	return w.bit(addrSniffedBit)
+}
+
+// Returns true for outbound packets (NETWORK layer) and outbound flows and sockets
+func (w *WinDivertAddress) Outbound() bool {
+	return w.bit(addrOutboundBit)
+}
+
+// Sets or clears the outbound bit
+// Unlike SetDirection the loopback bit isn't looked at
+func (w *WinDivertAddress) SetOutbound(outbound bool) {
+	w.setBit(addrOutboundBit, outbound)
 }
 
 // Returns true if the packet is a loopback packet
 func (w *WinDivertAddress) Loopback() bool {
-	return (w.Data>>1)&0x1 == 1
+	return w.bit(addrLoopbackBit)
 }
 
 // Returns true if the packet is an impostor
 // See https://reqrypt.org/windivert-doc.html#divert_address for more information
 func (w *WinDivertAddress) Impostor() bool {
-	return (w.Data>>2)&0x1 == 1
+	return w.bit(addrImpostorBit)
 }
 
-// Returns true if the packet uses a pseudo IP checksum
+// Returns true if the packet or the flow is IPv6
+func (w *WinDivertAddress) IPv6() bool {
+	return w.bit(addrIPv6Bit)
+}
+
+// Sets or clears the IPv6 bit, it must match the IP version of the packet injected with the address
+func (w *WinDivertAddress) SetIPv6(ipv6 bool) {
+	w.setBit(addrIPv6Bit, ipv6)
+}
+
+// Returns true if the IPv4 checksum of the packet is valid
+func (w *WinDivertAddress) IPChecksum() bool {
+	return w.bit(addrIPChecksumBit)
+}
+
+// Returns true if the TCP checksum of the packet is valid
+func (w *WinDivertAddress) TCPChecksum() bool {
+	return w.bit(addrTCPChecksumBit)
+}
+
+// Returns true if the UDP checksum of the packet is valid
+func (w *WinDivertAddress) UDPChecksum() bool {
+	return w.bit(addrUDPChecksumBit)
+}
+
+// Returns true if the IP checksum of the packet isn't known to be valid (e.g. offloaded to the hardware)
 func (w *WinDivertAddress) PseudoIPChecksum() bool {
-	return (w.Data>>3)&0x1 == 1
+	return !w.IPChecksum()
 }
 
-// Returns true if the packet uses a pseudo TCP checksum
+// Returns true if the TCP checksum of the packet isn't known to be valid
 func (w *WinDivertAddress) PseudoTCPChecksum() bool {
-	return (w.Data>>4)&0x1 == 1
+	return !w.TCPChecksum()
 }
 
-// Returns true if the packet uses a pseudo UDP checksum
+// Returns true if the UDP checksum of the packet isn't known to be valid
 func (w *WinDivertAddress) PseudoUDPChecksum() bool {
-	return (w.Data>>5)&0x1 == 1
+	return !w.UDPChecksum()
+}
+
+// Flags of the Data byte of the 1.x layout, see Data
+const (
+	dataInbound = 1 << iota
+	dataLoopback
+	dataImpostor
+	dataPseudoIPChecksum
+	dataPseudoTCPChecksum
+	dataPseudoUDPChecksum
+)
+
+// Returns the flags in the format of the Data field of the 1.x layout: direction (1 for inbound),
+// loopback, impostor then the pseudo IP, TCP and UDP checksums, from the lowest bit
+//
+// Deprecated: use Direction, Loopback, Impostor and the Pseudo*Checksum methods.
+func (w *WinDivertAddress) Data() uint8 {
+	flags := []struct {
+		flag uint8
+		set  bool
+	}{
+		{dataInbound, w.Direction() == WinDivertDirectionInbound},
+		{dataLoopback, w.Loopback()},
+		{dataImpostor, w.Impostor()},
+		{dataPseudoIPChecksum, w.PseudoIPChecksum()},
+		{dataPseudoTCPChecksum, w.PseudoTCPChecksum()},
+		{dataPseudoUDPChecksum, w.PseudoUDPChecksum()},
+	}
+	var data uint8
+	for _, f := range flags {
+		if f.set {
+			data |= f.flag
+		}
+	}
+	return data
+}
+
+// Sets the flags from a Data byte of the 1.x layout, see Data
+//
+// Deprecated: use SetDirection, SetOutbound and the other setters.
+func (w *WinDivertAddress) SetData(data uint8) {
+	w.setBit(addrOutboundBit, data&dataInbound == 0)
+	w.setBit(addrLoopbackBit, data&dataLoopback != 0)
+	w.setBit(addrImpostorBit, data&dataImpostor != 0)
+	w.setBit(addrIPChecksumBit, data&dataPseudoIPChecksum == 0)
+	w.setBit(addrTCPChecksumBit, data&dataPseudoTCPChecksum == 0)
+	w.setBit(addrUDPChecksumBit, data&dataPseudoUDPChecksum == 0)
 }
@@ -0,0 +1,16 @@
+//go:build amd64 || 386
+
+package godivert
+
+import "unsafe"
+
+// WinDivertAddress is passed as is to the DLL, these declarations fail to compile
+// if its layout drifts from WINDIVERT_ADDRESS on x64 or x86.
+// On x86 int64 is only 4 bytes aligned but Timestamp comes first so the offsets are the same.
+var (
+	_ [0]struct{} = [unsafe.Sizeof(WinDivertAddress{}) - winDivertAddressSize]struct{}{}
+	_ [0]struct{} = [unsafe.Offsetof(WinDivertAddress{}.Timestamp) - 0]struct{}{}
+	_ [0]struct{} = [unsafe.Offsetof(WinDivertAddress{}.Bits) - 8]struct{}{}
+	_ [0]struct{} = [unsafe.Offsetof(WinDivertAddress{}.Reserved) - 12]struct{}{}
+	_ [0]struct{} = [unsafe.Offsetof(WinDivertAddress{}.Union) - 16]struct{}{}
+)
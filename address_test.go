@@ -0,0 +1,297 @@
+package godivert
+
+import (
+	"encoding/binary"
+	"testing"
+	"unsafe"
+)
+
+func TestWinDivertAddressLayout(t *testing.T) {
+	var addr WinDivertAddress
+	if size := unsafe.Sizeof(addr); size != winDivertAddressSize {
+		t.Fatalf("WinDivertAddress is %d bytes, want %d", size, winDivertAddressSize)
+	}
+
+	tests := []struct {
+		field  string
+		offset uintptr
+		want   uintptr
+	}{
+		{"Timestamp", unsafe.Offsetof(addr.Timestamp), 0},
+		{"Bits", unsafe.Offsetof(addr.Bits), 8},
+		{"Reserved", unsafe.Offsetof(addr.Reserved), 12},
+		{"Union", unsafe.Offsetof(addr.Union), 16},
+	}
+	for _, test := range tests {
+		if test.offset != test.want {
+			t.Errorf("%s at offset %d, want %d", test.field, test.offset, test.want)
+		}
+	}
+}
+
+// Checks the accessors against the bytes the DLL reads and writes
+func TestWinDivertAddressBytes(t *testing.T) {
+	var addr WinDivertAddress
+	raw := unsafe.Slice((*byte)(unsafe.Pointer(&addr)), winDivertAddressSize)
+
+	addr.Timestamp = 0x0102030405060708
+	addr.setLayer(WinDivertLayerNetworkForward)
+	addr.setEvent(WinDivertEventNetworkPacket)
+	addr.SetOutbound(true)
+	addr.SetIPv6(true)
+	addr.SetIfIdx(7)
+	addr.SetSubIfIdx(9)
+
+	if got := binary.LittleEndian.Uint64(raw[0:8]); got != 0x0102030405060708 {
+		t.Errorf("Timestamp bytes %#x", got)
+	}
+	bits := binary.LittleEndian.Uint32(raw[8:12])
+	if want := uint32(WinDivertLayerNetworkForward) | 1<<addrOutboundBit | 1<<addrIPv6Bit; bits != want {
+		t.Errorf("bit fields %#x, want %#x", bits, want)
+	}
+	if ifIdx, subIfIdx := binary.LittleEndian.Uint32(raw[16:20]), binary.LittleEndian.Uint32(raw[20:24]); ifIdx != 7 || subIfIdx != 9 {
+		t.Errorf("interface bytes %d.%d, want 7.9", ifIdx, subIfIdx)
+	}
+}
+
+// Decodes addresses as WinDivert 2.2 writes them: the timestamp, the layer, event and flag bytes,
+// then the interface indexes at the start of the union
+func TestWinDivertAddressDecode(t *testing.T) {
+	type flags struct {
+		sniffed, outbound, loopback, impostor, ipv6, ipChecksum, tcpChecksum, udpChecksum bool
+	}
+
+	tests := []struct {
+		name      string
+		blob      []byte
+		timestamp int64
+		layer     Layer
+		event     Event
+		ifIdx     uint32
+		subIfIdx  uint32
+		flags     flags
+		direction Direction
+	}{
+		{
+			name: "outbound IPv4 packet with valid checksums",
+			blob: []byte{
+				0x88, 0x77, 0x66, 0x55, 0x44, 0x33, 0x22, 0x11,
+				0x00, 0x00, 0xe2, 0x00, 0x00, 0x00, 0x00, 0x00,
+				0x0c, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00,
+			},
+			timestamp: 0x1122334455667788,
+			layer:     WinDivertLayerNetwork,
+			event:     WinDivertEventNetworkPacket,
+			ifIdx:     12,
+			subIfIdx:  1,
+			flags:     flags{outbound: true, ipChecksum: true, tcpChecksum: true, udpChecksum: true},
+			direction: WinDivertDirectionOutbound,
+		},
+		{
+			name: "inbound IPv6 loopback impostor",
+			blob: []byte{
+				0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+				0x00, 0x00, 0x1c, 0x00, 0x00, 0x00, 0x00, 0x00,
+				0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			},
+			timestamp: 1,
+			layer:     WinDivertLayerNetwork,
+			event:     WinDivertEventNetworkPacket,
+			ifIdx:     1,
+			flags:     flags{loopback: true, impostor: true, ipv6: true},
+			direction: WinDivertDirectionInbound,
+		},
+		{
+			name: "forwarded packet",
+			blob: []byte{
+				0x00, 0x10, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+				0x01, 0x00, 0x40, 0x00, 0x00, 0x00, 0x00, 0x00,
+				0x07, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00,
+			},
+			timestamp: 0x1000,
+			layer:     WinDivertLayerNetworkForward,
+			event:     WinDivertEventNetworkPacket,
+			ifIdx:     7,
+			subIfIdx:  2,
+			flags:     flags{tcpChecksum: true},
+			direction: WinDivertDirectionInbound,
+		},
+		{
+			name: "sniffed flow deletion",
+			blob: []byte{
+				0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f,
+				0x02, 0x02, 0x13, 0x00, 0x00, 0x00, 0x00, 0x00,
+			},
+			timestamp: 0x7fffffffffffffff,
+			layer:     WinDivertLayerFlow,
+			event:     WinDivertEventFlowDeleted,
+			flags:     flags{sniffed: true, outbound: true, ipv6: true},
+			direction: WinDivertDirectionOutbound,
+		},
+		{
+			name: "reflect open",
+			blob: []byte{
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+				0x04, 0x08, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00,
+			},
+			layer:     WinDivertLayerReflect,
+			event:     WinDivertEventReflectOpen,
+			flags:     flags{sniffed: true},
+			direction: WinDivertDirectionInbound,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var addr WinDivertAddress
+			copy(unsafe.Slice((*byte)(unsafe.Pointer(&addr)), winDivertAddressSize), test.blob)
+
+			if addr.Timestamp != test.timestamp {
+				t.Errorf("Timestamp = %#x, want %#x", addr.Timestamp, test.timestamp)
+			}
+			if addr.Layer() != test.layer || addr.Event() != test.event {
+				t.Errorf("layer %v, event %v, want %v, %v", addr.Layer(), addr.Event(), test.layer, test.event)
+			}
+			if test.layer <= WinDivertLayerNetworkForward && (addr.IfIdx() != test.ifIdx || addr.SubIfIdx() != test.subIfIdx) {
+				t.Errorf("interface %d.%d, want %d.%d", addr.IfIdx(), addr.SubIfIdx(), test.ifIdx, test.subIfIdx)
+			}
+			got := flags{addr.Sniffed(), addr.Outbound(), addr.Loopback(), addr.Impostor(),
+				addr.IPv6(), addr.IPChecksum(), addr.TCPChecksum(), addr.UDPChecksum()}
+			if got != test.flags {
+				t.Errorf("flags %+v, want %+v", got, test.flags)
+			}
+			if addr.Direction() != test.direction {
+				t.Errorf("Direction() = %v, want %v", addr.Direction(), test.direction)
+			}
+		})
+	}
+}
+
+func TestWinDivertAddressData(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(w *WinDivertAddress)
+		want  uint8
+	}{
+		{"outbound with valid checksums", func(w *WinDivertAddress) {
+			w.SetOutbound(true)
+			w.setBit(addrIPChecksumBit, true)
+			w.setBit(addrTCPChecksumBit, true)
+			w.setBit(addrUDPChecksumBit, true)
+		}, 0},
+		{"inbound", func(w *WinDivertAddress) {}, dataInbound | dataPseudoIPChecksum | dataPseudoTCPChecksum | dataPseudoUDPChecksum},
+		{"loopback impostor", func(w *WinDivertAddress) {
+			w.SetOutbound(true)
+			w.setBit(addrLoopbackBit, true)
+			w.setBit(addrImpostorBit, true)
+			w.setBit(addrIPChecksumBit, true)
+		}, dataLoopback | dataImpostor | dataPseudoTCPChecksum | dataPseudoUDPChecksum},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var addr WinDivertAddress
+			test.setup(&addr)
+			if got := addr.Data(); got != test.want {
+				t.Errorf("Data() = %#b, want %#b", got, test.want)
+			}
+
+			var back WinDivertAddress
+			back.SetData(test.want)
+			if back.Bits != addr.Bits {
+				t.Errorf("SetData(%#b) bits %#x, want %#x", test.want, back.Bits, addr.Bits)
+			}
+		})
+	}
+}
+
+func TestWinDivertAddressLayerEvent(t *testing.T) {
+	tests := []struct {
+		name      string
+		layer     Layer
+		event     Event
+		wantEvent Event
+		wantErr   bool
+	}{
+		{"network packet", WinDivertLayerNetwork, WinDivertEventNetworkPacket, WinDivertEventNetworkPacket, false},
+		{"forward packet", WinDivertLayerNetworkForward, WinDivertEventNetworkPacket, WinDivertEventNetworkPacket, false},
+		{"flow deleted", WinDivertLayerFlow, WinDivertEventFlowDeleted, WinDivertEventFlowDeleted, false},
+		{"socket listen", WinDivertLayerSocket, WinDivertEventSocketListen, WinDivertEventSocketListen, false},
+		{"reflect close", WinDivertLayerReflect, WinDivertEventReflectClose, WinDivertEventReflectClose, false},
+		// 非法事件不改变地址，保留层的第一个事件
+		{"socket event on the flow layer", WinDivertLayerFlow, WinDivertEventSocketBind, WinDivertEventFlowEstablished, true},
+		{"flow event on the network layer", WinDivertLayerNetwork, WinDivertEventFlowEstablished, WinDivertEventNetworkPacket, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			addr := NewAddress()
+			addr.SetIfIdx(4)
+			if err := addr.SetLayer(test.layer); err != nil {
+				t.Fatalf("SetLayer(%v) = %v", test.layer, err)
+			}
+			if err := addr.SetEvent(test.event); (err != nil) != test.wantErr {
+				t.Fatalf("SetEvent(%v) = %v, want an error %v", test.event, err, test.wantErr)
+			}
+			if addr.Layer() != test.layer || addr.Event() != test.wantEvent {
+				t.Errorf("layer %v, event %v, want %v, %v", addr.Layer(), addr.Event(), test.layer, test.wantEvent)
+			}
+			if !addr.Outbound() || addr.IfIdx() != 4 {
+				t.Error("setting the layer and event changed the other fields")
+			}
+		})
+	}
+}
+
+func TestWinDivertAddressSetLayerInvalid(t *testing.T) {
+	addr := NewAddress()
+	if err := addr.SetLayer(Layer(42)); err == nil {
+		t.Error("SetLayer(42) = nil error")
+	}
+	if addr.Layer() != WinDivertLayerNetwork || addr.Event() != WinDivertEventNetworkPacket {
+		t.Errorf("layer %v, event %v after an invalid SetLayer, want them unchanged", addr.Layer(), addr.Event())
+	}
+}
+
+func TestWinDivertAddressNetwork(t *testing.T) {
+	tests := []struct {
+		name          string
+		layer         Layer
+		outbound      bool
+		wantNetwork   bool
+		wantDirection bool
+		direction     Direction
+	}{
+		{"network outbound", WinDivertLayerNetwork, true, true, true, WinDivertDirectionOutbound},
+		{"network inbound", WinDivertLayerNetwork, false, true, true, WinDivertDirectionInbound},
+		{"forward", WinDivertLayerNetworkForward, false, true, false, WinDivertDirectionInbound},
+		{"flow", WinDivertLayerFlow, true, false, false, WinDivertDirectionInbound},
+		{"socket", WinDivertLayerSocket, true, false, false, WinDivertDirectionInbound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var addr WinDivertAddress
+			addr.setLayer(test.layer)
+			addr.SetOutbound(test.outbound)
+			addr.SetIfIdx(12)
+			addr.SetSubIfIdx(1)
+
+			data, err := addr.Network()
+			if (err == nil) != test.wantNetwork {
+				t.Fatalf("Network() = %+v, %v, want network data %v", data, err, test.wantNetwork)
+			}
+			if test.wantNetwork && (data != NetworkData{IfIdx: 12, SubIfIdx: 1}) {
+				t.Errorf("Network() = %+v, want interface 12.1", data)
+			}
+
+			direction, err := addr.NetworkDirection()
+			if (err == nil) != test.wantDirection {
+				t.Fatalf("NetworkDirection() = %v, %v, want a direction %v", direction, err, test.wantDirection)
+			}
+			if direction != test.direction {
+				t.Errorf("NetworkDirection() = %v, want %v", direction, test.direction)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package godivert
+
+import "sync"
+
+// Directions a flow has been seen in
+type flowDirections struct {
+	inbound  bool
+	outbound bool
+}
+
+// Tracks, per flow, whether packets have been seen inbound, outbound or both
+// A flow seen in only one direction usually means the other direction doesn't match the filter
+// (or takes another path), which is why rewriting rules don't apply to the responses.
+// Flows are keyed from the local host's point of view: the source of an outbound packet,
+// the destination of an inbound one. It is safe for concurrent use.
+type AsymmetryDetector struct {
+	mu    sync.Mutex
+	flows map[FlowKey]flowDirections
+}
+
+func NewAsymmetryDetector() *AsymmetryDetector {
+	return &AsymmetryDetector{
+		flows: make(map[FlowKey]flowDirections),
+	}
+}
+
+// Records the direction of the packet for its flow
+func (d *AsymmetryDetector) Observe(p *Packet) {
+	key := p.FlowKey()
+	inbound := p.Direction() == WinDivertDirectionInbound
+	if inbound {
+		key = key.Reverse()
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	directions := d.flows[key]
+	if inbound {
+		directions.inbound = true
+	} else {
+		directions.outbound = true
+	}
+	d.flows[key] = directions
+}
+
+// Returns the directions the flow has been seen in, key is given from the local host's point of view
+func (d *AsymmetryDetector) Seen(key FlowKey) (inbound, outbound bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	directions := d.flows[key]
+	return directions.inbound, directions.outbound
+}
+
+// Returns the flows seen in only one direction, from the local host's point of view
+func (d *AsymmetryDetector) OneWayFlows() []FlowKey {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var flows []FlowKey
+	for key, directions := range d.flows {
+		if directions.inbound != directions.outbound {
+			flows = append(flows, key)
+		}
+	}
+	return flows
+}
+
+// Stops tracking the flow
+func (d *AsymmetryDetector) Forget(key FlowKey) {
+	d.mu.Lock()
+	delete(d.flows, key)
+	d.mu.Unlock()
+}
+
+// Stops tracking every flow
+func (d *AsymmetryDetector) Reset() {
+	d.mu.Lock()
+	d.flows = make(map[FlowKey]flowDirections)
+	d.mu.Unlock()
+}
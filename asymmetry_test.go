@@ -0,0 +1,72 @@
+package godivert
+
+import (
+	"examples/header"
+	"net"
+	"testing"
+)
+
+// Returns a TCP packet of the given direction from src:srcPort to dst:dstPort
+func directedPacket(src string, srcPort uint16, dst string, dstPort uint16, inbound bool) *Packet {
+	addr := NewAddress()
+	addr.SetOutbound(!inbound)
+	packet := NewPacket(buildIPv4(header.TCP, tcpBytes(srcPort, dstPort, 0x10, nil)), addr)
+	packet.ParseHeaders()
+	packet.SetSrcIP(net.ParseIP(src))
+	packet.SetDstIP(net.ParseIP(dst))
+	return packet
+}
+
+func TestAsymmetryDetector(t *testing.T) {
+	local := net.ParseIP("192.168.1.10")
+	both := NewFlowKey(local, 50000, net.ParseIP("1.1.1.1"), 443, header.TCP)
+	outOnly := NewFlowKey(local, 50001, net.ParseIP("8.8.8.8"), 80, header.TCP)
+	inOnly := NewFlowKey(local, 22, net.ParseIP("203.0.113.5"), 40000, header.TCP)
+
+	d := NewAsymmetryDetector()
+	for _, packet := range []*Packet{
+		directedPacket("192.168.1.10", 50000, "1.1.1.1", 443, false),
+		directedPacket("1.1.1.1", 443, "192.168.1.10", 50000, true),
+		directedPacket("192.168.1.10", 50000, "1.1.1.1", 443, false),
+		directedPacket("192.168.1.10", 50001, "8.8.8.8", 80, false),
+		directedPacket("203.0.113.5", 40000, "192.168.1.10", 22, true),
+	} {
+		d.Observe(packet)
+	}
+
+	tests := []struct {
+		name                 string
+		key                  FlowKey
+		wantInbound, wantOut bool
+	}{
+		{"both directions", both, true, true},
+		{"outbound only", outOnly, false, true},
+		{"inbound only", inOnly, true, false},
+		{"seen from the remote side", both.Reverse(), false, false},
+		{"unknown", NewFlowKey(local, 1, local, 2, header.UDP), false, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if inbound, outbound := d.Seen(test.key); inbound != test.wantInbound || outbound != test.wantOut {
+				t.Errorf("Seen(%v) = %v, %v, want %v, %v", test.key, inbound, outbound, test.wantInbound, test.wantOut)
+			}
+		})
+	}
+
+	oneWay := make(map[FlowKey]bool)
+	for _, key := range d.OneWayFlows() {
+		oneWay[key] = true
+	}
+	if len(oneWay) != 2 || !oneWay[outOnly] || !oneWay[inOnly] {
+		t.Errorf("OneWayFlows() = %v, want %v and %v", d.OneWayFlows(), outOnly, inOnly)
+	}
+
+	d.Forget(outOnly)
+	if flows := d.OneWayFlows(); len(flows) != 1 || flows[0] != inOnly {
+		t.Errorf("OneWayFlows() = %v after Forget, want only %v", flows, inOnly)
+	}
+	d.Reset()
+	if inbound, outbound := d.Seen(both); inbound || outbound || len(d.OneWayFlows()) != 0 {
+		t.Error("flows still tracked after Reset")
+	}
+}
@@ -0,0 +1,71 @@
+package godivert
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Reported by OpenBidirectional when the filter doesn't match both directions of the traffic
+var ErrOneDirection = errors.New("filter doesn't match both directions")
+
+// Opens a handle meant to rewrite both directions of the traffic (NAT, proxies)
+// The filter is checked against the FiltersOverlap corpus first: if it only matches inbound
+// or only outbound packets (e.g. "outbound and tcp") an error wrapping ErrOneDirection is passed
+// to opts.ErrorHandler, as the responses then never reach the handle and can't be rewritten back.
+// The handle is opened anyway. An invalid filter is returned as a *FilterError.
+// Use Packets or ForEach on the returned handle to process the packets of both directions.
+func OpenBidirectional(opts OpenOptions) (*WinDivertHandle, error) {
+	if opts.Filter == "" {
+		opts.Filter = "true"
+	}
+	inbound, outbound, err := FilterDirections(opts.Filter)
+	if err != nil {
+		return nil, err
+	}
+	if err := directionError(opts.Filter, inbound, outbound); err != nil && opts.ErrorHandler != nil {
+		opts.ErrorHandler(err)
+	}
+
+	return Open(opts)
+}
+
+// Returns whether the filter matches inbound and outbound packets of the FiltersOverlap corpus
+// Like FiltersOverlap this is an empirical check
+func FilterDirections(filter string) (inbound, outbound bool, err error) {
+	compiled, err := CompileFilter(filter)
+	if err != nil {
+		return false, false, err
+	}
+
+	for _, packet := range overlapCorpus() {
+		if packet.Direction() == WinDivertDirectionInbound && inbound ||
+			packet.Direction() == WinDivertDirectionOutbound && outbound {
+			continue
+		}
+
+		if compiled.Eval(packet) {
+			if packet.Direction() == WinDivertDirectionInbound {
+				inbound = true
+			} else {
+				outbound = true
+			}
+		}
+		if inbound && outbound {
+			break
+		}
+	}
+	return inbound, outbound, nil
+}
+
+// Returns the error OpenBidirectional reports, nil if the filter matches both directions
+func directionError(filter string, inbound, outbound bool) error {
+	switch {
+	case inbound && !outbound:
+		return fmt.Errorf("%w: %q only matches inbound packets", ErrOneDirection, filter)
+	case outbound && !inbound:
+		return fmt.Errorf("%w: %q only matches outbound packets", ErrOneDirection, filter)
+	case !inbound && !outbound:
+		return fmt.Errorf("%w: %q matches no generated packet", ErrOneDirection, filter)
+	}
+	return nil
+}
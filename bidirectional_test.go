@@ -0,0 +1,45 @@
+package godivert
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestOpenBidirectional(t *testing.T) {
+	tests := []struct {
+		filter  string
+		wantErr bool
+		warning bool
+	}{
+		{"tcp", false, false},
+		{"", false, false},
+		{"outbound and tcp", false, true},
+		{"inbound and udp", false, true},
+		{"false", false, true},
+		{"bogus", true, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.filter, func(t *testing.T) {
+			driver := newFakeDriver(t)
+			newFakeFilterLanguage(t)
+			var log errorLog
+
+			wd, err := OpenBidirectional(OpenOptions{Filter: test.filter, Unregistered: true, ErrorHandler: log.handle})
+			if (err != nil) != test.wantErr {
+				t.Fatalf("OpenBidirectional() = %v, want error %v", err, test.wantErr)
+			}
+			if err == nil {
+				defer wd.Close()
+			}
+			// 单向的过滤器只警告，句柄照样打开
+			if opened := driver.openHandles() == 1; opened == test.wantErr {
+				t.Errorf("handle opened: %v", opened)
+			}
+			warned := len(log.errors) == 1 && errors.Is(log.errors[0], ErrOneDirection)
+			if warned != test.warning || len(log.errors) > 1 {
+				t.Errorf("reported %v, want a warning: %v", log.errors, test.warning)
+			}
+		})
+	}
+}
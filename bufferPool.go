@@ -2,23 +2,69 @@ package godivert
 
 import "sync"
 
-// 创建一个全局的缓冲池
-var bufferPool = sync.Pool{
-	New: func() interface{} {
-		return make([]byte, PacketBufferSize)
-	},
+// Smallest buffer size a handle can be opened with, see OpenOptions.BufferSize
+const MinPacketBufferSize = 128
+
+// A pool of buffers of a single size
+type sizedBufferPool struct {
+	size int
+	pool sync.Pool
 }
 
-func GetBuffer() []byte {
-	return bufferPool.Get().([]byte)
+// 每种大小一个缓冲池，大小相同的句柄共用；默认缓冲池的大小是 PacketBufferSize
+var (
+	bufferPools       sync.Map // int -> *sizedBufferPool
+	defaultBufferPool = bufferPoolFor(PacketBufferSize)
+)
+
+// Returns the pool of the buffers of the given size, it is created on first use
+func bufferPoolFor(size int) *sizedBufferPool {
+	if pool, ok := bufferPools.Load(size); ok {
+		return pool.(*sizedBufferPool)
+	}
+	pool := &sizedBufferPool{size: size}
+	pool.pool.New = func() interface{} {
+		return make([]byte, size)
+	}
+	actual, _ := bufferPools.LoadOrStore(size, pool)
+	return actual.(*sizedBufferPool)
 }
 
-func ReturnBuffer(buffer []byte, length int) {
-	if len(buffer) == PacketBufferSize {
-		// 清理缓冲区内容
+func (p *sizedBufferPool) get() []byte {
+	return p.pool.Get().([]byte)
+}
+
+func (p *sizedBufferPool) put(buffer []byte, length int) {
+	buffer = buffer[:p.size]
+	if length > p.size {
+		length = p.size
+	}
+	// 清理缓冲区内容
+	if ZeroReturnedBuffers {
 		for i := 0; i < length; i++ {
 			buffer[i] = 0
 		}
-		bufferPool.Put(buffer)
+	}
+	p.pool.Put(buffer)
+}
+
+// ZeroReturnedBuffers controls whether ReturnBuffer clears the buffer before putting it back in the pool.
+// Recv overwrites the bytes it returns so clearing is only a safety net: with it disabled the stale bytes
+// of a previous packet stay beyond packetLen and can leak to code reading past Raw (e.g. re-slicing Buffer).
+// Disable it on high PPS forwarders where the memset is pure overhead.
+var ZeroReturnedBuffers = true
+
+// Returns a buffer of PacketBufferSize bytes from the default pool
+func GetBuffer() []byte {
+	return defaultBufferPool.get()
+}
+
+// Puts the buffer back in the pool of its size, length is the number of bytes to clear
+// Only buffers handed out by a pool are recycled: the capacity of buffer must be the size of a pool
+// (PacketBufferSize or the BufferSize of a handle), so a packet's Raw can be passed as long as it
+// still starts the pooled array. Other slices are left to the garbage collector.
+func ReturnBuffer(buffer []byte, length int) {
+	if pool, ok := bufferPools.Load(cap(buffer)); ok {
+		pool.(*sizedBufferPool).put(buffer, length)
 	}
 }
@@ -0,0 +1,93 @@
+package godivert
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestReturnBufferZeroing(t *testing.T) {
+	tests := []struct {
+		name   string
+		zero   bool
+		length int
+		want   []byte
+	}{
+		{"zeroed", true, 4, []byte{0, 0, 0, 0, 5, 6}},
+		{"kept", false, 4, []byte{1, 2, 3, 4, 5, 6}},
+		{"length past the buffer", true, 100, []byte{0, 0, 0, 0, 0, 0}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			saved := ZeroReturnedBuffers
+			ZeroReturnedBuffers = test.zero
+			t.Cleanup(func() { ZeroReturnedBuffers = saved })
+
+			// 独立大小的缓冲池，其它测试不会取走这个缓冲区
+			pool := bufferPoolFor(6)
+			buffer := []byte{1, 2, 3, 4, 5, 6}
+			pool.put(buffer[:2], test.length)
+			if !bytes.Equal(buffer, test.want) {
+				t.Errorf("buffer %v after put, want %v", buffer, test.want)
+			}
+		})
+	}
+}
+
+func TestReturnBufferForeignSlice(t *testing.T) {
+	// 容量不是任何缓冲池的大小，不会被回收也不会被清零
+	buffer := []byte{1, 2, 3}
+	ReturnBuffer(buffer, len(buffer))
+	if !bytes.Equal(buffer, []byte{1, 2, 3}) {
+		t.Errorf("foreign buffer cleared to %v", buffer)
+	}
+}
+
+func TestReturnBufferByCapacity(t *testing.T) {
+	tests := []struct {
+		name   string
+		slice  func(buffer []byte) []byte
+		length int
+		want   []byte
+	}{
+		{"whole buffer", func(b []byte) []byte { return b }, 3, []byte{0, 0, 0, 4, 5, 6, 7}},
+		{"packet starting the buffer", func(b []byte) []byte { return b[:2] }, 5, []byte{0, 0, 0, 0, 0, 6, 7}},
+		{"empty packet", func(b []byte) []byte { return b[:0] }, 1, []byte{0, 2, 3, 4, 5, 6, 7}},
+		// 追加后重新分配的切片不属于缓冲池
+		{"grown past the buffer", func(b []byte) []byte { return append(b, 8) }, 7, []byte{1, 2, 3, 4, 5, 6, 7}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			saved := ZeroReturnedBuffers
+			ZeroReturnedBuffers = true
+			t.Cleanup(func() { ZeroReturnedBuffers = saved })
+
+			bufferPoolFor(7)
+			buffer := []byte{1, 2, 3, 4, 5, 6, 7}
+			ReturnBuffer(test.slice(buffer), test.length)
+			if !bytes.Equal(buffer, test.want) {
+				t.Errorf("buffer %v after ReturnBuffer, want %v", buffer, test.want)
+			}
+		})
+	}
+}
+
+// Cost of returning the buffer of a typical MTU sized packet, with and without the zeroing
+func BenchmarkReturnBuffer(b *testing.B) {
+	for _, bench := range []struct {
+		name string
+		zero bool
+	}{{"zeroed", true}, {"kept", false}} {
+		b.Run(bench.name, func(b *testing.B) {
+			saved := ZeroReturnedBuffers
+			ZeroReturnedBuffers = bench.zero
+			defer func() { ZeroReturnedBuffers = saved }()
+
+			b.SetBytes(1500)
+			for i := 0; i < b.N; i++ {
+				ReturnBuffer(GetBuffer(), 1500)
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package godivert
+
+import (
+	"encoding/binary"
+	"errors"
+	"examples/header"
+	"fmt"
+)
+
+// Recalculates in Go the checksums of the packets that have been modified
+// It is meant for batches: it avoids one DLL call per packet and the packets left untouched are skipped.
+// The IPv4, TCP, UDP, ICMPv4 and ICMPv6 checksums are supported (like WinDivertHelperCalcChecksums),
+// the checksum flags of the addresses are set and the headers are no longer seen as modified
+// so sending the packets doesn't recalculate them a second time.
+// The first error is returned once every packet has been processed.
+func HelperCalcChecksumBatch(packets []*Packet) error {
+	var firstErr error
+	for _, packet := range packets {
+		if !packet.parsed || !packet.needNewChecksum() {
+			continue
+		}
+		if err := packet.calcChecksums(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Returns true if one of the headers has been modified
+func (p *Packet) needNewChecksum() bool {
+	return p.IpHdr.NeedNewChecksum() || p.NextHeader != nil && p.NextHeader.NeedNewChecksum()
+}
+
+// Calculates the checksums of the packet in Go
+func (p *Packet) calcChecksums() error {
+	p.VerifyParsed()
+
+	if p.hdrLen > len(p.Raw) {
+		return errors.New("cannot calculate the checksums, packet is truncated")
+	}
+
+	if ipv4Hdr, ok := p.IpHdr.(*header.IPv4Header); ok {
+		binary.BigEndian.PutUint16(ipv4Hdr.Raw[10:12], 0)
+		binary.BigEndian.PutUint16(ipv4Hdr.Raw[10:12], foldChecksum(sumBytes(ipv4Hdr.Raw, 0)))
+		ipv4Hdr.Modified = false
+		p.setAddrChecksumBit(addrIPChecksumBit)
+	}
+
+	transport := p.Raw[p.hdrLen:]
+	var offset int
+	var pseudo bool
+	var addrBit uint
+
+	switch p.nextHeaderType {
+	case header.TCP:
+		offset, pseudo, addrBit = 16, true, addrTCPChecksumBit
+	case header.UDP:
+		offset, pseudo, addrBit = 6, true, addrUDPChecksumBit
+	case header.ICMPv4:
+		offset, pseudo = 2, false
+	case header.ICMPv6:
+		offset, pseudo = 2, true
+	default:
+		return nil
+	}
+
+	if len(transport) < offset+2 {
+		return fmt.Errorf("cannot calculate the %s checksum, packet is truncated", header.ProtocolName(p.nextHeaderType))
+	}
+
+	binary.BigEndian.PutUint16(transport[offset:offset+2], 0)
+	var sum uint32
+	if pseudo {
+		sum = p.pseudoHeaderSum(len(transport))
+	}
+	checksum := foldChecksum(sumBytes(transport, sum))
+	if checksum == 0 && p.nextHeaderType == header.UDP {
+		// UDP 校验和为 0 表示没有校验和
+		checksum = 0xffff
+	}
+	binary.BigEndian.PutUint16(transport[offset:offset+2], checksum)
+
+	p.clearTransportModified()
+	if addrBit != 0 {
+		p.setAddrChecksumBit(addrBit)
+	}
+	return nil
+}
+
+// Returns the sum of the pseudo header used by the TCP, UDP and ICMPv6 checksums
+func (p *Packet) pseudoHeaderSum(length int) uint32 {
+	var sum uint32
+	if p.ipVersion == header.IPv4 {
+		sum = sumBytes(p.Raw[12:20], 0)
+		sum += uint32(p.nextHeaderType)
+		sum += uint32(length)
+	} else {
+		sum = sumBytes(p.Raw[8:40], 0)
+		sum += uint32(length>>16) + uint32(length&0xffff)
+		sum += uint32(p.nextHeaderType)
+	}
+	return sum
+}
+
+func (p *Packet) clearTransportModified() {
+	switch nextHdr := p.NextHeader.(type) {
+	case *header.TCPHeader:
+		nextHdr.Modified = false
+	case *header.UDPHeader:
+		nextHdr.Modified = false
+	case *header.ICMPv4Header:
+		nextHdr.Modified = false
+	case *header.ICMPv6Header:
+		nextHdr.Modified = false
+	}
+}
+
+func (p *Packet) setAddrChecksumBit(n uint) {
+	if p.Addr != nil {
+		p.Addr.setBit(n, true)
+	}
+}
+
+// Adds the 16 bits big endian words of data to sum, an odd trailing byte is padded with zero
+func sumBytes(data []byte, sum uint32) uint32 {
+	n := len(data) &^ 1
+	for i := 0; i < n; i += 2 {
+		sum += uint32(data[i])<<8 | uint32(data[i+1])
+	}
+	if len(data)&1 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	return sum
+}
+
+// Folds the carries of sum and returns its one's complement
+func foldChecksum(sum uint32) uint16 {
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// State of a checksum of a received packet, see ChecksumStatus
+type ChecksumState int
+
+const (
+	// The checksum can't be trusted nor checked: offloaded to the hardware on outbound
+	// packets, absent (IPv6 header, UDP checksum of 0) or the protocol isn't in the packet
+	ChecksumUnknown ChecksumState = iota
+	// The checksum is correct
+	ChecksumValid
+	// The checksum is wrong
+	ChecksumInvalid
+)
+
+func (s ChecksumState) String() string {
+	switch s {
+	case ChecksumValid:
+		return "Valid"
+	case ChecksumInvalid:
+		return "Invalid"
+	default:
+		return "Unknown"
+	}
+}
+
+// Returns the state of the IPv4, TCP and UDP checksums of the packet
+// A checksum WinDivert flagged as valid in the address is valid. Otherwise an outbound checksum
+// is unknown, it is usually computed later by the network card (checksum offload) so the value
+// on the wire isn't known yet, and an inbound checksum is checked in Go.
+func (p *Packet) ChecksumStatus() (ip, tcp, udp ChecksumState) {
+	p.VerifyParsed()
+
+	if p.ipVersion == header.IPv4 && p.hdrLen <= len(p.Raw) {
+		ip = p.checksumState(addrIPChecksumBit, func() bool {
+			return foldChecksum(sumBytes(p.Raw[:p.hdrLen], 0)) == 0
+		})
+	}
+
+	if p.hdrLen > len(p.Raw) {
+		return ip, tcp, udp
+	}
+	transport := p.Raw[p.hdrLen:]
+	verifyTransport := func() bool {
+		return foldChecksum(sumBytes(transport, p.pseudoHeaderSum(len(transport)))) == 0
+	}
+
+	switch p.nextHeaderType {
+	case header.TCP:
+		tcp = p.checksumState(addrTCPChecksumBit, verifyTransport)
+	case header.UDP:
+		if len(transport) >= header.UDPHeaderLen && binary.BigEndian.Uint16(transport[6:8]) != 0 {
+			udp = p.checksumState(addrUDPChecksumBit, verifyTransport)
+		}
+	}
+	return ip, tcp, udp
+}
+
+// Returns the state of a checksum from the address bit n, verify checks it in Go
+func (p *Packet) checksumState(n uint, verify func() bool) ChecksumState {
+	if p.Addr != nil {
+		if p.Addr.bit(n) {
+			return ChecksumValid
+		}
+		if p.Addr.Direction() == WinDivertDirectionOutbound {
+			return ChecksumUnknown
+		}
+	}
+	if verify() {
+		return ChecksumValid
+	}
+	return ChecksumInvalid
+}
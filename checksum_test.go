@@ -0,0 +1,383 @@
+package godivert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"examples/header"
+	"testing"
+)
+
+// Checks the checksums of raw the plain RFC 1071 way, independently from sumBytes and foldChecksum
+func checksumsValid(raw []byte) (ip, transport bool) {
+	sum := func(data []byte, acc uint64) uint64 {
+		for i := 0; i < len(data); i++ {
+			if i%2 == 0 {
+				acc += uint64(data[i]) << 8
+			} else {
+				acc += uint64(data[i])
+			}
+		}
+		return acc
+	}
+	valid := func(acc uint64) bool {
+		for acc > 0xffff {
+			acc = acc>>16 + acc&0xffff
+		}
+		return acc == 0xffff
+	}
+
+	var pseudo uint64
+	var protocol uint8
+	var start int
+	if raw[0]>>4 == 4 {
+		start = int(raw[0]&0xf) * 4
+		protocol = raw[9]
+		ip = valid(sum(raw[:start], 0))
+		pseudo = sum(raw[12:20], 0) + uint64(protocol) + uint64(len(raw)-start)
+	} else {
+		start, protocol = header.IPv6HeaderLen, raw[6]
+		ip = true
+		pseudo = sum(raw[8:40], 0) + uint64(protocol) + uint64(len(raw)-start)
+	}
+	if protocol == header.ICMPv4 {
+		pseudo = 0
+	}
+	return ip, valid(sum(raw[start:], pseudo))
+}
+
+func TestHelperCalcChecksumBatch(t *testing.T) {
+	payload := []byte("hello, checksum")
+
+	tests := []struct {
+		name    string
+		raw     []byte
+		modify  func(p *Packet)
+		addrBit uint // 0 when WinDivertAddress has no bit for the checksum
+	}{
+		{
+			name:    "IPv4 TCP",
+			raw:     buildIPv4(header.TCP, tcpBytes(1234, 80, 0x18, payload)),
+			modify:  func(p *Packet) { p.NextHeader.SetDstPort(8080) },
+			addrBit: addrTCPChecksumBit,
+		},
+		{
+			name:    "IPv4 UDP odd length",
+			raw:     buildIPv4(header.UDP, udpBytes(1234, 53, payload[:5])),
+			modify:  func(p *Packet) { p.NextHeader.SetSrcPort(4321) },
+			addrBit: addrUDPChecksumBit,
+		},
+		{
+			name:    "IPv6 UDP",
+			raw:     buildIPv6(header.UDP, udpBytes(1234, 53, payload)),
+			modify:  func(p *Packet) { p.NextHeader.SetDstPort(5353) },
+			addrBit: addrUDPChecksumBit,
+		},
+		{
+			name:    "IPv6 TCP",
+			raw:     buildIPv6(header.TCP, tcpBytes(1234, 443, 0x02, nil)),
+			modify:  func(p *Packet) { p.NextHeader.SetSrcPort(5555) },
+			addrBit: addrTCPChecksumBit,
+		},
+		{
+			name:    "ICMPv4",
+			raw:     buildIPv4(header.ICMPv4, icmpBytes(header.ICMPEchoRequest, payload)),
+			modify:  func(p *Packet) { p.NextHeader.(*header.ICMPv4Header).SetIdentifier(99) },
+			addrBit: addrIPChecksumBit,
+		},
+		{
+			name:   "ICMPv6",
+			raw:    buildIPv6(header.ICMPv6, icmpBytes(header.ICMPv6EchoRequest, payload)),
+			modify: func(p *Packet) { p.NextHeader.(*header.ICMPv6Header).SetIdentifier(99) },
+		},
+		{
+			name:    "IPv4 header only",
+			raw:     buildIPv4(header.UDP, udpBytes(1234, 53, payload)),
+			modify:  func(p *Packet) { p.IpHdr.(*header.IPv4Header).SetTTL(1) },
+			addrBit: addrIPChecksumBit,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			raw := append([]byte(nil), test.raw...)
+			packet := NewPacket(raw, &WinDivertAddress{})
+			packet.ParseHeaders()
+			test.modify(packet)
+
+			if err := HelperCalcChecksumBatch([]*Packet{packet}); err != nil {
+				t.Fatalf("HelperCalcChecksumBatch() = %v", err)
+			}
+			if ip, transport := checksumsValid(packet.Raw); !ip || !transport {
+				t.Errorf("checksums valid: IP %v, transport %v", ip, transport)
+			}
+			if packet.needNewChecksum() {
+				t.Error("the headers are still seen as modified")
+			}
+			if test.addrBit != 0 && !packet.Addr.bit(test.addrBit) {
+				t.Errorf("address checksum bit %d not set", test.addrBit)
+			}
+		})
+	}
+}
+
+func TestHelperCalcChecksumBatchSkipsUnmodified(t *testing.T) {
+	raw := buildIPv4(header.UDP, udpBytes(1234, 53, []byte("payload")))
+	// 未修改的包即使校验和错误也不重新计算
+	binary.BigEndian.PutUint16(raw[26:28], 0xbeef)
+	packet := NewPacket(raw, &WinDivertAddress{})
+	packet.ParseHeaders()
+
+	if err := HelperCalcChecksumBatch([]*Packet{packet, NewPacket(raw, nil)}); err != nil {
+		t.Fatalf("HelperCalcChecksumBatch() = %v", err)
+	}
+	if got := binary.BigEndian.Uint16(raw[26:28]); got != 0xbeef {
+		t.Errorf("checksum of an unmodified packet changed to %#x", got)
+	}
+}
+
+func TestHelperCalcChecksumBatchTruncated(t *testing.T) {
+	packet := NewPacket(buildIPv4(header.TCP, tcpBytes(1234, 80, 0x10, nil)), nil)
+	packet.ParseHeaders()
+	packet.NextHeader.SetDstPort(8080)
+	// 校验和字段已在包外
+	packet.Raw = packet.Raw[:header.IPv4HeaderLen+10]
+
+	if err := HelperCalcChecksumBatch([]*Packet{packet}); err == nil {
+		t.Error("HelperCalcChecksumBatch() of a truncated TCP segment = nil, want an error")
+	}
+}
+
+func TestFoldChecksum(t *testing.T) {
+	// https://en.wikipedia.org/wiki/Internet_checksum#Calculating_the_IPv4_header_checksum
+	ipv4Header := []byte{
+		0x45, 0x00, 0x00, 0x73, 0x00, 0x00, 0x40, 0x00, 0x40, 0x11,
+		0x00, 0x00, 0xc0, 0xa8, 0x00, 0x01, 0xc0, 0xa8, 0x00, 0xc7,
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want uint16
+	}{
+		{"IPv4 header", ipv4Header, 0xb861},
+		{"empty", nil, 0xffff},
+		{"odd length", []byte{0x01}, 0xfeff},
+		{"carries", []byte{0xff, 0xff, 0xff, 0xff, 0x00, 0x02}, 0xfffd},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := foldChecksum(sumBytes(test.data, 0)); got != test.want {
+				t.Errorf("checksum = %#04x, want %#04x", got, test.want)
+			}
+		})
+	}
+}
+
+// Modified packets of a typical batch, re-parsed and modified before each run
+func checksumBatch(n int) []*Packet {
+	packets := make([]*Packet, n)
+	for i := range packets {
+		packets[i] = NewPacket(buildIPv4(header.TCP, tcpBytes(1234, 80, 0x18, make([]byte, 1200))), &WinDivertAddress{})
+	}
+	return packets
+}
+
+func BenchmarkHelperCalcChecksumBatch(b *testing.B) {
+	packets := checksumBatch(64)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, packet := range packets {
+			packet.ParseHeaders()
+			packet.NextHeader.SetDstPort(uint16(i))
+		}
+		if err := HelperCalcChecksumBatch(packets); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Same batch with one WinDivertHelperCalcChecksums call per packet, needs the WinDivert DLL
+func BenchmarkHelperCalcChecksumDLL(b *testing.B) {
+	if err := winDivertHelperCalcChecksums.Find(); err != nil {
+		b.Skip("WinDivert DLL not available:", err)
+	}
+	packets := checksumBatch(64)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, packet := range packets {
+			packet.ParseHeaders()
+			packet.NextHeader.SetDstPort(uint16(i))
+			if err := HelperCalcChecksumWithFlags(packet, 0); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestChecksumStatus(t *testing.T) {
+	// 返回校验和正确的包，corrupt 时改坏 IP 和传输层的校验和
+	packet := func(raw []byte, corrupt bool) []byte {
+		p := NewPacket(raw, nil)
+		p.ParseHeaders()
+		p.markModified()
+		if err := HelperCalcChecksumBatch([]*Packet{p}); err != nil {
+			t.Fatal(err)
+		}
+		if corrupt {
+			if raw[0]>>4 == 4 {
+				raw[10] ^= 0xff
+			}
+			raw[len(raw)-1] ^= 0xff
+		}
+		return raw
+	}
+	tcp := func() []byte { return buildIPv4(header.TCP, tcpBytes(50000, 80, 0x18, []byte("data"))) }
+	udp := func() []byte { return buildIPv4(header.UDP, udpBytes(1234, 53, []byte("data"))) }
+	address := func(outbound, flagged bool) *WinDivertAddress {
+		addr := NewAddress()
+		addr.SetOutbound(outbound)
+		addr.setBit(addrIPChecksumBit, flagged)
+		addr.setBit(addrTCPChecksumBit, flagged)
+		addr.setBit(addrUDPChecksumBit, flagged)
+		return addr
+	}
+	zeroUDPChecksum := udp()
+	zeroUDPChecksum = packet(zeroUDPChecksum, false)
+	zeroUDPChecksum[header.IPv4HeaderLen+6], zeroUDPChecksum[header.IPv4HeaderLen+7] = 0, 0
+
+	tests := []struct {
+		name         string
+		raw          []byte
+		addr         *WinDivertAddress
+		ip, tcp, udp ChecksumState
+	}{
+		{"without address", packet(tcp(), false), nil, ChecksumValid, ChecksumValid, ChecksumUnknown},
+		{"without address, corrupted", packet(tcp(), true), nil, ChecksumInvalid, ChecksumInvalid, ChecksumUnknown},
+		{"inbound", packet(udp(), false), address(false, false), ChecksumValid, ChecksumUnknown, ChecksumValid},
+		{"inbound corrupted", packet(udp(), true), address(false, false), ChecksumInvalid, ChecksumUnknown, ChecksumInvalid},
+		{"inbound flagged valid", packet(tcp(), true), address(false, true), ChecksumValid, ChecksumValid, ChecksumUnknown},
+		{"outbound offloaded", packet(tcp(), true), address(true, false), ChecksumUnknown, ChecksumUnknown, ChecksumUnknown},
+		{"outbound flagged valid", packet(udp(), false), address(true, true), ChecksumValid, ChecksumUnknown, ChecksumValid},
+		{"UDP without checksum", zeroUDPChecksum, address(false, false), ChecksumValid, ChecksumUnknown, ChecksumUnknown},
+		{"IPv6", packet(buildIPv6(header.TCP, tcpBytes(50000, 80, 0x18, nil)), false), address(false, false), ChecksumUnknown, ChecksumValid, ChecksumUnknown},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ip, tcp, udp := NewPacket(test.raw, test.addr).ChecksumStatus()
+			if ip != test.ip || tcp != test.tcp || udp != test.udp {
+				t.Errorf("ChecksumStatus() = %v, %v, %v, want %v, %v, %v", ip, tcp, udp, test.ip, test.tcp, test.udp)
+			}
+		})
+	}
+}
+
+// Regression test of the checksums of known packets, through the Go implementation
+// and through WinDivertHelperCalcChecksums when the DLL is available
+func TestChecksumKnownPackets(t *testing.T) {
+	calcs := []struct {
+		name string
+		dll  bool
+		calc func(p *Packet) error
+	}{
+		{"HelperCalcChecksumBatch", false, func(p *Packet) error { return HelperCalcChecksumBatch([]*Packet{p}) }},
+		{"WinDivertHelperCalcChecksums", true, func(p *Packet) error { return HelperCalcChecksumWithFlags(p, 0) }},
+	}
+
+	// 常见的 IPv4 头校验和示例：192.168.0.1 到 192.168.0.199，校验和 0xb861
+	knownIPv4 := []byte{0x45, 0x00, 0x00, 0x73, 0x00, 0x00, 0x40, 0x00, 0x40, 0x11, 0x00, 0x00, 192, 168, 0, 1, 192, 168, 0, 199}
+	knownUDP := append(knownIPv4, udpBytes(50000, 53, bytes.Repeat([]byte("godivert"), 11)[:87])...)
+
+	// 期望值与本包的实现无关，是另外计算的
+	tests := []struct {
+		name          string
+		raw           []byte
+		offset        int
+		wantIP        uint16
+		wantTransport uint16
+	}{
+		{"IPv4 UDP", knownUDP, header.IPv4HeaderLen + 6, 0xb861, 0xf657},
+		{"IPv6 TCP", buildIPv6(header.TCP, tcpBytes(50000, 443, 0x18, []byte("data"))), header.IPv6HeaderLen + 16, 0, 0x140e},
+		{"ICMPv4", buildIPv4(header.ICMPv4, icmpBytes(8, []byte("ping"))), header.IPv4HeaderLen + 2, 0, 0x1927},
+	}
+
+	for _, calc := range calcs {
+		for _, test := range tests {
+			t.Run(calc.name+"/"+test.name, func(t *testing.T) {
+				if err := winDivertHelperCalcChecksums.Find(); calc.dll && err != nil {
+					t.Skip("WinDivert DLL not available:", err)
+				}
+				raw := append([]byte(nil), test.raw...)
+				if raw[0]>>4 == 4 {
+					// 清掉 buildIPv4 算好的 IP 校验和
+					raw[10], raw[11] = 0, 0
+				}
+				packet := NewPacket(raw, NewAddress())
+				packet.ParseHeaders()
+				packet.markModified()
+
+				if err := calc.calc(packet); err != nil {
+					t.Fatal(err)
+				}
+				if test.wantIP != 0 {
+					if got := binary.BigEndian.Uint16(raw[10:12]); got != test.wantIP {
+						t.Errorf("IP checksum %#04x, want %#04x", got, test.wantIP)
+					}
+				}
+				if got := binary.BigEndian.Uint16(raw[test.offset:]); got != test.wantTransport {
+					t.Errorf("transport checksum %#04x, want %#04x", got, test.wantTransport)
+				}
+			})
+		}
+	}
+}
+
+// The helpers pass the address struct itself to WinDivert, not a pointer to the Addr field
+func TestHelpersPassAddress(t *testing.T) {
+	savedCalc, savedEval := divertCalcChecksums, divertEvalFilter
+	t.Cleanup(func() { divertCalcChecksums, divertEvalFilter = savedCalc, savedEval })
+
+	var calcAddr, evalAddr *WinDivertAddress
+	divertCalcChecksums = func(packet []byte, addr *WinDivertAddress, flags uint64) error {
+		calcAddr = addr
+		return nil
+	}
+	divertEvalFilter = func(filter *byte, packet []byte, addr *WinDivertAddress) bool {
+		evalAddr = addr
+		return true
+	}
+
+	tests := []struct {
+		name string
+		addr *WinDivertAddress
+	}{
+		{"outbound address", NewAddress()},
+		{"inbound address", &WinDivertAddress{}},
+		{"no address", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			packet := NewPacket(buildIPv4(header.UDP, udpBytes(1234, 53, nil)), test.addr)
+
+			if err := HelperCalcChecksumWithFlags(packet, 0); err != nil {
+				t.Fatal(err)
+			}
+			if calcAddr != test.addr {
+				t.Errorf("WinDivertHelperCalcChecksums got the address %p, want %p", calcAddr, test.addr)
+			}
+
+			if _, err := HelperEvalFilter(packet, "udp"); err != nil {
+				t.Fatal(err)
+			}
+			if test.addr != nil && evalAddr != test.addr {
+				t.Errorf("WinDivertHelperEvalFilter got the address %p, want %p", evalAddr, test.addr)
+			}
+			// 没有地址时使用零值地址
+			if test.addr == nil && (evalAddr == nil || *evalAddr != WinDivertAddress{}) {
+				t.Errorf("WinDivertHelperEvalFilter got %v without address, want a zero address", evalAddr)
+			}
+		})
+	}
+}
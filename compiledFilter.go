@@ -0,0 +1,125 @@
+package godivert
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// Size of the buffer receiving a compiled filter object or a formatted filter
+// WinDivert filters are limited to 256 instructions, both forms fit easily
+const compiledFilterMaxLen = 16 * 1024
+
+// A filter compiled once by WinDivertHelperCompileFilter
+// Evaluating it skips the parsing HelperEvalFilter does on every call, use it when the same
+// filter is matched against many packets
+type CompiledFilter struct {
+	filter string
+	layer  Layer
+	// 编译后的过滤器对象，以 0 结尾，可以直接传给 WinDivert
+	object []byte
+}
+
+// Compiles the given filter for the network layer
+// https://reqrypt.org/windivert-doc.html#divert_helper_compile_filter
+func CompileFilter(filter string) (*CompiledFilter, error) {
+	return CompileFilterWithLayer(filter, WinDivertLayerNetwork)
+}
+
+// Compiles the given filter for the given layer
+// Returns a *FilterError with WinDivert's description and position if the filter is invalid
+func CompileFilterWithLayer(filter string, layer Layer) (*CompiledFilter, error) {
+	object := make([]byte, compiledFilterMaxLen)
+	if err := compileFilter(filter, layer, object); err != nil {
+		return nil, err
+	}
+
+	n := 0
+	for n < len(object) && object[n] != 0 {
+		n++
+	}
+	return &CompiledFilter{
+		filter: filter,
+		layer:  layer,
+		object: append([]byte(nil), object[:n+1]...),
+	}, nil
+}
+
+// Calls WinDivertHelperCompileFilter, the object is only written if object isn't empty
+func compileFilter(filter string, layer Layer, object []byte) error {
+	filterBytePtr, err := syscall.BytePtrFromString(filter)
+	if err != nil {
+		return err
+	}
+
+	var errorStr *byte
+	var errorPos uint32
+	success := divertCompileFilter(filterBytePtr, layer, object, &errorStr, &errorPos)
+	if !success {
+		return &FilterError{
+			Filter:   filter,
+			Message:  cString(errorStr),
+			Position: int(errorPos),
+		}
+	}
+	return nil
+}
+
+// Returns the filter string the object was compiled from
+func (f *CompiledFilter) String() string {
+	return f.filter
+}
+
+// Returns the layer the filter was compiled for
+func (f *CompiledFilter) Layer() Layer {
+	return f.layer
+}
+
+// Returns true if the packet matches the compiled filter
+// A packet without address is evaluated with a zero address (inbound, IPv4, network layer)
+// https://reqrypt.org/windivert-doc.html#divert_helper_eval_filter
+func (f *CompiledFilter) Eval(packet *Packet) bool {
+	if packet.PacketLen == 0 || int(packet.PacketLen) > len(packet.Raw) {
+		return false
+	}
+
+	addr := packet.Addr
+	if addr == nil {
+		addr = &WinDivertAddress{}
+	}
+	return divertEvalFilter(&f.object[0], packet.Raw[:packet.PacketLen], addr)
+}
+
+// Returns the filter as WinDivert interprets it for the given layer
+// The filter can be a filter string or a compiled object (CompiledFilter's object), the result is
+// the canonical human readable form. If the filter is invalid the compile error with its position is returned.
+// https://reqrypt.org/windivert-doc.html#divert_helper_format_filter
+func FormatFilter(filter string, layer Layer) (string, error) {
+	filterBytePtr, err := syscall.BytePtrFromString(filter)
+	if err != nil {
+		return "", err
+	}
+
+	buffer := make([]byte, compiledFilterMaxLen)
+	if err := divertFormatFilter(filterBytePtr, layer, buffer); err != nil {
+		// 编译一次以拿到错误描述和位置
+		if _, compileErr := CompileFilterWithLayer(filter, layer); compileErr != nil {
+			return "", compileErr
+		}
+		return "", fmt.Errorf("cannot format filter %q: %v", filter, err)
+	}
+
+	return cString(&buffer[0]), nil
+}
+
+// Returns the NUL terminated string WinDivert points to, WinDivert's error strings are static
+func cString(p *byte) string {
+	if p == nil {
+		return ""
+	}
+	var n int
+	for *(*byte)(unsafe.Add(unsafe.Pointer(p), n)) != 0 {
+		n++
+	}
+	return string(unsafe.Slice(p, n))
+}
@@ -0,0 +1,180 @@
+package godivert
+
+import (
+	"errors"
+	"examples/header"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestCompiledFilter(t *testing.T) {
+	newFakeFilterLanguage(t)
+	packets := []*Packet{
+		NewPacket(buildIPv4(header.UDP, udpBytes(50000, 53, nil)), nil),
+		NewPacket(buildIPv4(header.TCP, tcpBytes(50000, 443, 0x02, nil)), nil),
+		NewPacket(buildIPv6(header.TCP, tcpBytes(50000, 80, 0x02, nil)), nil),
+		NewPacket(buildIPv4(header.ICMPv4, icmpBytes(8, nil)), nil),
+	}
+
+	tests := []struct {
+		name   string
+		filter string
+		want   []bool
+	}{
+		{"protocol", "tcp", []bool{false, true, true, false}},
+		{"port", "tcp.DstPort == 443 or udp.DstPort == 53", []bool{true, true, false, false}},
+		{"address", "ipv6.DstAddr == fd00::2 and tcp", []bool{false, false, true, false}},
+		{"nothing", "udp and tcp", []bool{false, false, false, false}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			compiled, err := CompileFilter(test.filter)
+			if err != nil {
+				t.Fatalf("CompileFilter(%q) = %v", test.filter, err)
+			}
+			if compiled.String() != test.filter || compiled.Layer() != WinDivertLayerNetwork {
+				t.Errorf("compiled %q for the %v layer", compiled, compiled.Layer())
+			}
+			for i, packet := range packets {
+				// 编译后的过滤器和 HelperEvalFilter 结果一致
+				match, err := HelperEvalFilter(packet, test.filter)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := compiled.Eval(packet); got != test.want[i] || got != match {
+					t.Errorf("packet %d: Eval() = %v, HelperEvalFilter() = %v, want %v", i, got, match, test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCompileFilterInvalid(t *testing.T) {
+	newFakeFilterLanguage(t)
+
+	compiled, err := CompileFilter("tcp.DstPort = 80")
+	var filterErr *FilterError
+	if !errors.As(err, &filterErr) {
+		t.Fatalf("CompileFilter() = %v, %v, want a *FilterError", compiled, err)
+	}
+	if filterErr.Filter != "tcp.DstPort = 80" || filterErr.Message == "" {
+		t.Errorf("FilterError %+v, want the filter and WinDivert's message", filterErr)
+	}
+	if _, err := CompileFilter("tcp\x00"); err == nil {
+		t.Error("CompileFilter() of a filter holding a NUL = nil error")
+	}
+}
+
+func TestCompiledFilterEmptyPacket(t *testing.T) {
+	newFakeFilterLanguage(t)
+	compiled, err := CompileFilter("tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tooLong := NewPacket(buildIPv4(header.TCP, tcpBytes(1, 2, 0x02, nil)), nil)
+	tooLong.PacketLen = uint(len(tooLong.Raw)) + 1
+	for _, packet := range []*Packet{NewPacket(nil, nil), tooLong} {
+		if compiled.Eval(packet) {
+			t.Errorf("Eval() of a packet of %d bytes with a %d bytes buffer = true", packet.PacketLen, len(packet.Raw))
+		}
+	}
+}
+
+// Replaces WinDivertHelperFormatFilter: the filter's blanks are collapsed and it is checked with
+// the fake filter language from newFakeFilterLanguage, formatErr is returned instead for a valid filter if it isn't nil
+// Returns the layer of the last call.
+func fakeFormatFilter(t *testing.T, formatErr error) *Layer {
+	newFakeFilterLanguage(t)
+	saved := divertFormatFilter
+	t.Cleanup(func() { divertFormatFilter = saved })
+
+	layer := new(Layer)
+	divertFormatFilter = func(filter *byte, filterLayer Layer, buffer []byte) error {
+		*layer = filterLayer
+		formatted := strings.Join(strings.Fields(cString(filter)), " ")
+		if _, _, err := evalFilterExpr(formatted, nil); err != nil {
+			return syscall.Errno(87) // ERROR_INVALID_PARAMETER
+		}
+		if formatErr != nil {
+			return formatErr
+		}
+		copy(buffer, formatted+"\x00")
+		return nil
+	}
+	return layer
+}
+
+func TestFormatFilter(t *testing.T) {
+	tests := []struct {
+		name      string
+		filter    string
+		formatErr error
+		want      string
+		// Position of the *FilterError, -1 for no error and -2 for an error that isn't one
+		wantPos int
+	}{
+		{"normalized", "tcp   and  outbound", nil, "tcp and outbound", -1},
+		{"compile error", "tcp and bogus", nil, "", len("tcp and ")},
+		// 过滤器有效但格式化失败，返回格式化的错误
+		{"format failed", "tcp and inbound", syscall.Errno(122), "", -2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			layer := fakeFormatFilter(t, test.formatErr)
+			got, err := FormatFilter(test.filter, WinDivertLayerFlow)
+			if got != test.want {
+				t.Errorf("FormatFilter(%q) = %q, want %q", test.filter, got, test.want)
+			}
+			if *layer != WinDivertLayerFlow {
+				t.Errorf("formatted for the %v layer, want %v", *layer, WinDivertLayerFlow)
+			}
+
+			var filterErr *FilterError
+			switch {
+			case test.wantPos == -1 && err != nil:
+				t.Errorf("FormatFilter() = %v", err)
+			case test.wantPos >= 0 && (!errors.As(err, &filterErr) || filterErr.Position != test.wantPos || filterErr.Filter != test.filter):
+				t.Errorf("FormatFilter() = %v, want a *FilterError at %d", err, test.wantPos)
+			case test.wantPos == -2 && (err == nil || errors.As(err, &filterErr) || !strings.Contains(err.Error(), "cannot format filter")):
+				t.Errorf("FormatFilter() = %v, want the format error", err)
+			}
+		})
+	}
+}
+
+// A filter long enough that parsing it costs more than evaluating it
+const benchmarkFilter = "outbound and (tcp.DstPort == 443 or tcp.DstPort == 80 or udp.DstPort == 53) and ip.DstAddr >= 10.0.0.0 and ip.DstAddr <= 10.255.255.255"
+
+// Evaluating the filter string parses it on every call, needs the WinDivert DLL
+func BenchmarkHelperEvalFilter(b *testing.B) {
+	if err := winDivertHelperEvalFilter.Find(); err != nil {
+		b.Skip("WinDivert DLL not available:", err)
+	}
+	packet := NewPacket(buildIPv4(header.TCP, tcpBytes(50000, 443, 0x18, make([]byte, 512))), NewAddress())
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := HelperEvalFilter(packet, benchmarkFilter); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Same filter compiled once, needs the WinDivert DLL
+func BenchmarkCompiledFilterEval(b *testing.B) {
+	if err := winDivertHelperEvalFilter.Find(); err != nil {
+		b.Skip("WinDivert DLL not available:", err)
+	}
+	compiled, err := CompileFilter(benchmarkFilter)
+	if err != nil {
+		b.Fatal(err)
+	}
+	packet := NewPacket(buildIPv4(header.TCP, tcpBytes(50000, 443, 0x18, make([]byte, 512))), NewAddress())
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		compiled.Eval(packet)
+	}
+}
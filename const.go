@@ -1,9 +1,17 @@
 package godivert
 
+import "fmt"
+
 type Direction bool
 
+// WinDivert layer a handle is opened on
+// See https://reqrypt.org/windivert-doc.html#divert_open
+type Layer uint8
+
 const (
 	// WINDIVERT_MTU_MAX (40 + 0xFFFF) 64kb
+	// The IP length fields are 16 bits so any packet WinDivert delivers fits, loopback segments included:
+	// WinDivert hands out IP packets, not GSO/TSO batches, and a segment can't exceed the IP total length.
 	PacketBufferSize   = 65575
 	PacketChanCapacity = 256
 
@@ -11,15 +19,135 @@ const (
 	WinDivertDirectionInbound  Direction = true
 )
 
+// Event reported by an address, the possible events depend on the layer
+// See https://reqrypt.org/windivert-doc.html#divert_address
+type Event uint8
+
+const (
+	WinDivertLayerNetwork Layer = iota
+	WinDivertLayerNetworkForward
+	WinDivertLayerFlow
+	WinDivertLayerSocket
+	WinDivertLayerReflect
+)
+
+const (
+	WinDivertEventNetworkPacket Event = iota
+	WinDivertEventFlowEstablished
+	WinDivertEventFlowDeleted
+	WinDivertEventSocketBind
+	WinDivertEventSocketConnect
+	WinDivertEventSocketListen
+	WinDivertEventSocketAccept
+	WinDivertEventSocketClose
+	WinDivertEventReflectOpen
+	WinDivertEventReflectClose
+)
+
 const (
 	WinDivertFlagSniff uint8 = 1 << iota
 	WinDivertFlagDrop  uint8 = 1 << iota
+	// Deprecated: WinDivert 1.x flag, in 2.x this value is WinDivertFlagRecvOnly
 	WinDivertFlagDebug uint8 = 1 << iota
 )
 
+const (
+	WinDivertFlagRecvOnly  uint8 = 0x04
+	WinDivertFlagSendOnly  uint8 = 0x08
+	WinDivertFlagNoInstall uint8 = 0x10
+	// Capture the inbound IP fragments instead of the reassembled packets (NETWORK layer only)
+	WinDivertFlagFragments uint8 = 0x20
+)
+
+// Flags of WinDivertHelperCalcChecksums, each one skips a checksum
+// See https://reqrypt.org/windivert-doc.html#divert_helper_calc_checksums
+const (
+	WinDivertHelperNoIPChecksum     uint64 = 1
+	WinDivertHelperNoICMPChecksum   uint64 = 2
+	WinDivertHelperNoICMPv6Checksum uint64 = 4
+	WinDivertHelperNoTCPChecksum    uint64 = 8
+	WinDivertHelperNoUDPChecksum    uint64 = 16
+)
+
 func (d Direction) String() string {
 	if bool(d) {
 		return "Inbound"
 	}
 	return "Outbound"
 }
+
+func (l Layer) String() string {
+	switch l {
+	case WinDivertLayerNetwork:
+		return "Network"
+	case WinDivertLayerNetworkForward:
+		return "NetworkForward"
+	case WinDivertLayerFlow:
+		return "Flow"
+	case WinDivertLayerSocket:
+		return "Socket"
+	case WinDivertLayerReflect:
+		return "Reflect"
+	default:
+		return fmt.Sprintf("Layer(%d)", uint8(l))
+	}
+}
+
+// Returns the events an address of the layer can carry
+func (l Layer) events() []Event {
+	switch l {
+	case WinDivertLayerNetwork, WinDivertLayerNetworkForward:
+		return []Event{WinDivertEventNetworkPacket}
+	case WinDivertLayerFlow:
+		return []Event{WinDivertEventFlowEstablished, WinDivertEventFlowDeleted}
+	case WinDivertLayerSocket:
+		return []Event{WinDivertEventSocketBind, WinDivertEventSocketConnect, WinDivertEventSocketListen,
+			WinDivertEventSocketAccept, WinDivertEventSocketClose}
+	case WinDivertLayerReflect:
+		return []Event{WinDivertEventReflectOpen, WinDivertEventReflectClose}
+	default:
+		return nil
+	}
+}
+
+// Returns true for the layers carrying packets, NETWORK and NETWORK_FORWARD
+func (l Layer) isNetwork() bool {
+	return l == WinDivertLayerNetwork || l == WinDivertLayerNetworkForward
+}
+
+// Returns true if an address of the layer can carry the event
+func (l Layer) validEvent(event Event) bool {
+	for _, e := range l.events() {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func (e Event) String() string {
+	switch e {
+	case WinDivertEventNetworkPacket:
+		return "Packet"
+	case WinDivertEventFlowEstablished:
+		return "Established"
+	case WinDivertEventFlowDeleted:
+		return "Deleted"
+	case WinDivertEventSocketBind:
+		return "Bind"
+	case WinDivertEventSocketConnect:
+		return "Connect"
+	case WinDivertEventSocketListen:
+		return "Listen"
+	case WinDivertEventSocketAccept:
+		return "Accept"
+	case WinDivertEventSocketClose:
+		return "SocketClose"
+	case WinDivertEventReflectOpen:
+		return "Open"
+	case WinDivertEventReflectClose:
+		return "ReflectClose"
+	default:
+		return fmt.Sprintf("Event(%d)", uint8(e))
+	}
+}
@@ -0,0 +1,192 @@
+package godivert
+
+import (
+	"encoding/binary"
+	"errors"
+	"examples/header"
+	"fmt"
+	"strings"
+)
+
+// DNS record types and class used in the answers
+const (
+	DNSTypeA     = 1
+	DNSTypeCNAME = 5
+	DNSTypeTXT   = 16
+	DNSTypeAAAA  = 28
+	DNSClassIN   = 1
+)
+
+const (
+	dnsHeaderLen = 12
+	// 指向报文偏移 12 处的问题名称的压缩指针
+	dnsQuestionNamePtr = 0xc000 | dnsHeaderLen
+)
+
+// An answer record of BuildDNSResponse
+type DNSRecord struct {
+	// Owner name, the name of the first question if empty
+	Name string
+	// DNSType* value
+	Type uint16
+	// DNSClassIN if 0
+	Class uint16
+	TTL   uint32
+	// RDATA in wire format, e.g. the 4 bytes of the address for an A record
+	Data []byte
+}
+
+// Builds the response to a DNS query, ready to be injected back towards the client
+// The endpoints and the direction are swapped, the transaction ID, the opcode, the RD flag and
+// the questions are echoed and QR and AA are set. The additional records of the query (EDNS)
+// aren't echoed. The lengths and checksums are computed, the packet doesn't use the buffer pool.
+func BuildDNSResponse(query *Packet, answers []DNSRecord) (*Packet, error) {
+	query.VerifyParsed()
+
+	if query.nextHeaderType != header.UDP {
+		return nil, fmt.Errorf("cannot build a DNS response to protocolID=%d, query isn't UDP", query.nextHeaderType)
+	}
+	udpStart := query.hdrLen
+	dnsStart := udpStart + header.UDPHeaderLen
+	if dnsStart+dnsHeaderLen > len(query.Raw) {
+		return nil, errors.New("cannot build a DNS response, query is truncated")
+	}
+	// 报文长度以 UDP 头为准，忽略 IP 包末尾的填充
+	message := query.Raw[dnsStart:]
+	if udpLen := int(binary.BigEndian.Uint16(query.Raw[udpStart+4 : udpStart+6])); udpLen >= header.UDPHeaderLen+dnsHeaderLen && udpStart+udpLen <= len(query.Raw) {
+		message = query.Raw[dnsStart : udpStart+udpLen]
+	}
+	if message[2]&0x80 != 0 {
+		return nil, errors.New("cannot build a DNS response, the packet is already a response")
+	}
+
+	questionCount := binary.BigEndian.Uint16(message[4:6])
+	questionsEnd := dnsHeaderLen
+	for i := 0; i < int(questionCount); i++ {
+		nameEnd, err := dnsSkipName(message, questionsEnd)
+		if err != nil {
+			return nil, err
+		}
+		// QTYPE 和 QCLASS
+		questionsEnd = nameEnd + 4
+		if questionsEnd > len(message) {
+			return nil, errors.New("cannot build a DNS response, question is truncated")
+		}
+	}
+
+	response := make([]byte, 0, questionsEnd+len(answers)*32)
+	response = append(response, message[:dnsHeaderLen]...)
+	// QR、保留 opcode、AA、保留 RD，RCODE 为 0
+	response[2] = 0x80 | message[2]&0x78 | 0x04 | message[2]&0x01
+	response[3] = 0
+	binary.BigEndian.PutUint16(response[6:8], uint16(len(answers)))
+	binary.BigEndian.PutUint16(response[8:10], 0)
+	binary.BigEndian.PutUint16(response[10:12], 0)
+	response = append(response, message[dnsHeaderLen:questionsEnd]...)
+
+	for _, answer := range answers {
+		if answer.Name == "" {
+			if questionCount == 0 {
+				return nil, errors.New("cannot build a DNS response, answer without name and query without question")
+			}
+			response = binary.BigEndian.AppendUint16(response, dnsQuestionNamePtr)
+		} else {
+			var err error
+			if response, err = dnsAppendName(response, answer.Name); err != nil {
+				return nil, err
+			}
+		}
+
+		class := answer.Class
+		if class == 0 {
+			class = DNSClassIN
+		}
+		if len(answer.Data) > 0xffff {
+			return nil, fmt.Errorf("cannot build a DNS response, record data of %d bytes", len(answer.Data))
+		}
+		response = binary.BigEndian.AppendUint16(response, answer.Type)
+		response = binary.BigEndian.AppendUint16(response, class)
+		response = binary.BigEndian.AppendUint32(response, answer.TTL)
+		response = binary.BigEndian.AppendUint16(response, uint16(len(answer.Data)))
+		response = append(response, answer.Data...)
+	}
+
+	rawLen := dnsStart + len(response)
+	if rawLen > 0xffff {
+		return nil, fmt.Errorf("cannot build a DNS response of %d bytes", rawLen)
+	}
+
+	raw := make([]byte, rawLen)
+	copy(raw, query.Raw[:udpStart])
+	copy(raw[dnsStart:], response)
+
+	// 交换地址和端口
+	udp := raw[udpStart:dnsStart]
+	copy(udp[0:2], query.Raw[udpStart+2:udpStart+4])
+	copy(udp[2:4], query.Raw[udpStart:udpStart+2])
+	binary.BigEndian.PutUint16(udp[4:6], uint16(rawLen-udpStart))
+
+	if query.ipVersion == header.IPv4 {
+		copy(raw[12:16], query.Raw[16:20])
+		copy(raw[16:20], query.Raw[12:16])
+		binary.BigEndian.PutUint16(raw[2:4], uint16(rawLen))
+		// 不分片，清空标志和偏移
+		binary.BigEndian.PutUint16(raw[6:8], 0)
+		raw[8] = 64
+	} else {
+		copy(raw[8:24], query.Raw[24:40])
+		copy(raw[24:40], query.Raw[8:24])
+		// 负载长度包含扩展头，从固定头之后算起
+		binary.BigEndian.PutUint16(raw[4:6], uint16(rawLen-header.IPv6HeaderLen))
+		raw[7] = 64
+	}
+
+	packet := &Packet{
+		Raw:       raw,
+		PacketLen: uint(rawLen),
+	}
+	if query.Addr != nil {
+		addr := *query.Addr
+		packet.Addr = &addr
+		packet.ReverseDirection()
+	}
+	packet.ParseHeaders()
+	if err := packet.calcChecksums(); err != nil {
+		return nil, err
+	}
+	return packet, nil
+}
+
+// Returns the offset following the name starting at offset in the DNS message
+func dnsSkipName(message []byte, offset int) (int, error) {
+	for {
+		if offset >= len(message) {
+			return 0, errors.New("cannot build a DNS response, name is truncated")
+		}
+		length := int(message[offset])
+		switch {
+		case length == 0:
+			return offset + 1, nil
+		case length&0xc0 == 0xc0:
+			// 压缩指针占两个字节并结束名称
+			return offset + 2, nil
+		default:
+			offset += 1 + length
+		}
+	}
+}
+
+// Appends the name encoded as DNS labels
+func dnsAppendName(message []byte, name string) ([]byte, error) {
+	name = strings.TrimSuffix(name, ".")
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			if len(label) == 0 || len(label) > 63 {
+				return nil, fmt.Errorf("cannot encode the DNS name %q, invalid label", name)
+			}
+			message = append(message, uint8(len(label)))
+			message = append(message, label...)
+		}
+	}
+	return append(message, 0), nil
+}
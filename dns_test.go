@@ -0,0 +1,123 @@
+package godivert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"examples/header"
+	"testing"
+)
+
+// Returns a DNS query message for the A record of name, with the RD flag
+func dnsQuery(name string) []byte {
+	message := []byte{0x12, 0x34, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0}
+	message, _ = dnsAppendName(message, name)
+	message = binary.BigEndian.AppendUint16(message, DNSTypeA)
+	return binary.BigEndian.AppendUint16(message, DNSClassIN)
+}
+
+// An IPv6 hop-by-hop options header followed by the transport header, padded with PadN
+func hopByHop(next uint8, transport []byte) []byte {
+	return append([]byte{next, 0, 1, 4, 0, 0, 0, 0}, transport...)
+}
+
+func TestBuildDNSResponse(t *testing.T) {
+	query := dnsQuery("example.com")
+	udp := udpBytes(50000, 53, query)
+	answer := DNSRecord{Type: DNSTypeA, TTL: 60, Data: []byte{10, 0, 0, 1}}
+
+	tests := []struct {
+		name string
+		raw  []byte
+		// Offset of the UDP header in the response
+		udpStart int
+		// false when checksumsValid can't walk the headers
+		checkSums bool
+	}{
+		{"IPv4", buildIPv4(header.UDP, udp), header.IPv4HeaderLen, true},
+		{"IPv4 padded", append(buildIPv4(header.UDP, udp), 0, 0, 0, 0), header.IPv4HeaderLen, false},
+		{"IPv6", buildIPv6(header.UDP, udp), header.IPv6HeaderLen, true},
+		{"IPv6 extension header", buildIPv6(0, hopByHop(header.UDP, udp)), header.IPv6HeaderLen + 8, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			packet := NewPacket(test.raw, &WinDivertAddress{})
+			response, err := BuildDNSResponse(packet, []DNSRecord{answer})
+			if err != nil {
+				t.Fatalf("BuildDNSResponse() = %v", err)
+			}
+			raw := response.Raw
+			udp := raw[test.udpStart:]
+			message := udp[header.UDPHeaderLen:]
+
+			if got := int(binary.BigEndian.Uint16(udp[4:6])); got != len(udp) {
+				t.Errorf("UDP length %d, want %d", got, len(udp))
+			}
+			if raw[0]>>4 == 4 {
+				if got := int(binary.BigEndian.Uint16(raw[2:4])); got != len(raw) {
+					t.Errorf("total length %d, want %d", got, len(raw))
+				}
+				if !bytes.Equal(raw[12:16], test.raw[16:20]) || !bytes.Equal(raw[16:20], test.raw[12:16]) {
+					t.Error("IPv4 addresses not swapped")
+				}
+			} else {
+				if got := int(binary.BigEndian.Uint16(raw[4:6])); got != len(raw)-header.IPv6HeaderLen {
+					t.Errorf("payload length %d, want %d", got, len(raw)-header.IPv6HeaderLen)
+				}
+				if !bytes.Equal(raw[8:24], test.raw[24:40]) || !bytes.Equal(raw[24:40], test.raw[8:24]) {
+					t.Error("IPv6 addresses not swapped")
+				}
+			}
+			if src, dst := binary.BigEndian.Uint16(udp[0:2]), binary.BigEndian.Uint16(udp[2:4]); src != 53 || dst != 50000 {
+				t.Errorf("ports %d -> %d, want 53 -> 50000", src, dst)
+			}
+
+			// 问题原样返回，后面是一条指向问题名称的 A 记录
+			want := append([]byte(nil), query...)
+			want[2], want[3] = 0x85, 0
+			binary.BigEndian.PutUint16(want[6:8], 1)
+			want = binary.BigEndian.AppendUint16(want, dnsQuestionNamePtr)
+			want = append(want, 0, DNSTypeA, 0, DNSClassIN, 0, 0, 0, 60, 0, 4, 10, 0, 0, 1)
+			if !bytes.Equal(message, want) {
+				t.Errorf("DNS message\n%x\nwant\n%x", message, want)
+			}
+
+			if !response.Addr.Outbound() {
+				t.Error("response not injected outbound")
+			}
+			if test.checkSums {
+				if ip, transport := checksumsValid(raw); !ip || !transport {
+					t.Errorf("checksums valid: IP %v, UDP %v", ip, transport)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildDNSResponseErrors(t *testing.T) {
+	query := dnsQuery("example.com")
+	response := append([]byte(nil), query...)
+	response[2] |= 0x80
+	truncated := dnsQuery("example.com")
+	binary.BigEndian.PutUint16(truncated[4:6], 2)
+
+	tests := []struct {
+		name    string
+		raw     []byte
+		answers []DNSRecord
+	}{
+		{"TCP", buildIPv4(header.TCP, tcpBytes(50000, 53, 0x18, query)), nil},
+		{"short message", buildIPv4(header.UDP, udpBytes(50000, 53, query[:8])), nil},
+		{"already a response", buildIPv4(header.UDP, udpBytes(50000, 53, response)), nil},
+		{"missing question", buildIPv4(header.UDP, udpBytes(50000, 53, truncated)), nil},
+		{"invalid name", buildIPv4(header.UDP, udpBytes(50000, 53, query)), []DNSRecord{{Name: "a..b", Type: DNSTypeA}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := BuildDNSResponse(NewPacket(test.raw, nil), test.answers); err == nil {
+				t.Error("BuildDNSResponse() = nil error, want an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,290 @@
+package godivert
+
+import (
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// Calls to the DLL that open, close and shut down the handles, receive, parse and inject the packets
+// calculate their checksums, evaluate the filters and set the parameters.
+// They are variables so the tests can replace them and run without the driver.
+// Each returns nil on success and the Windows error code otherwise, the filter and TTL helpers their BOOL result.
+var (
+	divertOpen = func(filter *byte, layer Layer, priority int16, flags uint64) (uintptr, error) {
+		args := []uintptr{
+			uintptr(unsafe.Pointer(filter)),
+			uintptr(layer),
+			uintptr(priority),
+		}
+		args = append(args, uint64Args(flags)...)
+		handle, _, err := winDivertOpen.Call(args...)
+		// 指针放在 args 里，调用期间要保证过滤器字符串不被回收
+		runtime.KeepAlive(filter)
+		if handle == uintptr(syscall.InvalidHandle) {
+			return handle, err
+		}
+		return handle, nil
+	}
+
+	divertClose = func(handle uintptr) error {
+		success, _, err := winDivertClose.Call(handle)
+		if success == 0 {
+			return err
+		}
+		return nil
+	}
+
+	divertShutdown = func(handle uintptr, how ShutdownMode) error {
+		success, _, err := winDivertShutdown.Call(handle, uintptr(how))
+		if success == 0 {
+			return err
+		}
+		return nil
+	}
+
+	divertRecv = func(handle uintptr, packet []byte, recvLen *uint, addr *WinDivertAddress) error {
+		var success uintptr
+		var err error
+		if len(packet) == 0 {
+			// 没有数据包的层，pPacket 为 NULL
+			success, _, err = winDivertRecv.Call(handle, 0, 0, 0, uintptr(unsafe.Pointer(addr)))
+		} else {
+			success, _, err = winDivertRecv.Call(
+				handle,
+				uintptr(unsafe.Pointer(&packet[0])),
+				uintptr(len(packet)),
+				uintptr(unsafe.Pointer(recvLen)),
+				uintptr(unsafe.Pointer(addr)))
+		}
+		if success == 0 {
+			return err
+		}
+		return nil
+	}
+
+	// RecvContext 的重叠读取，测试中替换为 Recv
+	divertRecvContext = (*WinDivertHandle).recvOverlapped
+
+	divertSend = func(handle uintptr, packet *byte, packetLen uint, sendLen *uint, addr *WinDivertAddress) error {
+		success, _, err := winDivertSend.Call(
+			handle,
+			uintptr(unsafe.Pointer(packet)),
+			uintptr(packetLen),
+			uintptr(unsafe.Pointer(sendLen)),
+			uintptr(unsafe.Pointer(addr)))
+		if success == 0 {
+			return err
+		}
+		return nil
+	}
+
+	divertCalcChecksums = func(packet []byte, addr *WinDivertAddress, flags uint64) error {
+		args := []uintptr{
+			uintptr(unsafe.Pointer(&packet[0])),
+			uintptr(len(packet)),
+			uintptr(unsafe.Pointer(addr)), // addr 可以为 nil
+		}
+		//用于控制校验和计算的标志，0 表示计算所有类型的校验和
+		args = append(args, uint64Args(flags)...)
+		success, _, err := winDivertHelperCalcChecksums.Call(args...)
+		runtime.KeepAlive(packet)
+		runtime.KeepAlive(addr)
+		if success == 0 {
+			return err
+		}
+		return nil
+	}
+
+	// object 为空时只检查过滤器
+	divertCompileFilter = func(filter *byte, layer Layer, object []byte, errorStr **byte, errorPos *uint32) bool {
+		var objectPtr *byte
+		if len(object) > 0 {
+			objectPtr = &object[0]
+		}
+		success, _, _ := winDivertHelperCompileFilter.Call(
+			uintptr(unsafe.Pointer(filter)),
+			uintptr(layer),
+			uintptr(unsafe.Pointer(objectPtr)),
+			uintptr(len(object)),
+			uintptr(unsafe.Pointer(errorStr)),
+			uintptr(unsafe.Pointer(errorPos)))
+		return success != 0
+	}
+
+	// filter 可以是过滤器字符串或编译后的对象，返回 BOOL 结果，不匹配和错误都是 false
+	divertEvalFilter = func(filter *byte, packet []byte, addr *WinDivertAddress) bool {
+		success, _, _ := winDivertHelperEvalFilter.Call(
+			uintptr(unsafe.Pointer(filter)),
+			uintptr(unsafe.Pointer(&packet[0])),
+			uintptr(len(packet)),
+			uintptr(unsafe.Pointer(addr)))
+		return success != 0
+	}
+
+	// filter 同样可以是过滤器字符串或编译后的对象，格式化的结果写入 buffer
+	divertFormatFilter = func(filter *byte, layer Layer, buffer []byte) error {
+		success, _, err := winDivertHelperFormatFilter.Call(
+			uintptr(unsafe.Pointer(filter)),
+			uintptr(layer),
+			uintptr(unsafe.Pointer(&buffer[0])),
+			uintptr(len(buffer)))
+		if success == 0 {
+			return err
+		}
+		return nil
+	}
+
+	// 只有 TTL 减 1 后不为 0 才返回 TRUE
+	divertDecrementTTL = func(packet []byte) bool {
+		success, _, _ := winDivertHelperDecrementTTL.Call(
+			uintptr(unsafe.Pointer(&packet[0])),
+			uintptr(len(packet)))
+		return success != 0
+	}
+
+	// addrLen 传入 addrs 的字节数，返回收到的地址的字节数
+	divertRecvEx = func(handle uintptr, buffer []byte, recvLen *uint32, addrs []WinDivertAddress, addrLen *uint32) error {
+		var success uintptr
+		var err error
+		if unsafe.Sizeof(uintptr(0)) == 4 {
+			success, _, err = winDivertRecvEx.Call(
+				handle,
+				uintptr(unsafe.Pointer(&buffer[0])),
+				uintptr(len(buffer)),
+				uintptr(unsafe.Pointer(recvLen)),
+				0, 0, // flags
+				uintptr(unsafe.Pointer(&addrs[0])),
+				uintptr(unsafe.Pointer(addrLen)),
+				0) // lpOverlapped
+		} else {
+			success, _, err = winDivertRecvEx.Call(
+				handle,
+				uintptr(unsafe.Pointer(&buffer[0])),
+				uintptr(len(buffer)),
+				uintptr(unsafe.Pointer(recvLen)),
+				0, // flags
+				uintptr(unsafe.Pointer(&addrs[0])),
+				uintptr(unsafe.Pointer(addrLen)),
+				0) // lpOverlapped
+		}
+		if success == 0 {
+			return err
+		}
+		return nil
+	}
+
+	// DLL 没有导出这个函数时返回 Find 的错误，拒绝这个包时 ipVersion 为 0
+	divertParseHeaders = func(packet []byte) (helperHeaders, error) {
+		if err := winDivertHelperParsePacket.Find(); err != nil {
+			return helperHeaders{}, err
+		}
+
+		var ipv4Hdr, ipv6Hdr, icmpHdr, icmpv6Hdr, tcpHdr, udpHdr, data uintptr
+		var protocol uint8
+		var dataLen uint32
+		success, _, _ := winDivertHelperParsePacket.Call(
+			uintptr(unsafe.Pointer(&packet[0])),
+			uintptr(len(packet)),
+			uintptr(unsafe.Pointer(&ipv4Hdr)),
+			uintptr(unsafe.Pointer(&ipv6Hdr)),
+			uintptr(unsafe.Pointer(&protocol)),
+			uintptr(unsafe.Pointer(&icmpHdr)),
+			uintptr(unsafe.Pointer(&icmpv6Hdr)),
+			uintptr(unsafe.Pointer(&tcpHdr)),
+			uintptr(unsafe.Pointer(&udpHdr)),
+			uintptr(unsafe.Pointer(&data)),
+			uintptr(unsafe.Pointer(&dataLen)),
+			0,
+			0)
+		if success == 0 || ipv4Hdr == 0 && ipv6Hdr == 0 {
+			return helperHeaders{}, nil
+		}
+
+		parsed := helperHeaders{ipVersion: 4, protocol: protocol, transport: len(packet)}
+		if ipv4Hdr == 0 {
+			parsed.ipVersion = 6
+		}
+		// 返回的指针都指向 packet 内部，换算成偏移
+		base := uintptr(unsafe.Pointer(&packet[0]))
+		for _, hdr := range []uintptr{tcpHdr, udpHdr, icmpHdr, icmpv6Hdr, data} {
+			if hdr != 0 {
+				parsed.transport = int(hdr - base)
+				break
+			}
+		}
+		return parsed, nil
+	}
+
+	// 返回 packet 中第一个包之后剩余的字节数，ok 为 false 表示没有下一个包
+	divertParsePacket = func(packet []byte) (nextLen uint32, ok bool) {
+		var next uintptr
+		winDivertHelperParsePacket.Call(
+			uintptr(unsafe.Pointer(&packet[0])),
+			uintptr(len(packet)),
+			0, 0, 0, 0, 0, 0, 0, 0, 0,
+			uintptr(unsafe.Pointer(&next)),
+			uintptr(unsafe.Pointer(&nextLen)))
+		return nextLen, next != 0
+	}
+
+	divertHashPacket = func(packet []byte, seed uint64) uint64 {
+		args := []uintptr{
+			uintptr(unsafe.Pointer(&packet[0])),
+			uintptr(len(packet)),
+		}
+		args = append(args, uint64Args(seed)...)
+		r1, r2, _ := winDivertHelperHashPacket.Call(args...)
+		runtime.KeepAlive(packet)
+		return uint64Result(r1, r2)
+	}
+
+	divertSendEx = func(handle uintptr, packets []byte, sendLen *uint32, addrs []WinDivertAddress) error {
+		// 指针必须直接写在 Call 的参数里，调用期间 sendLen 才不会随栈移动
+		var success uintptr
+		var err error
+		if unsafe.Sizeof(uintptr(0)) == 4 {
+			// x86 上 UINT64 flags 占两个参数
+			success, _, err = winDivertSendEx.Call(
+				handle,
+				uintptr(unsafe.Pointer(&packets[0])),
+				uintptr(len(packets)),
+				uintptr(unsafe.Pointer(sendLen)),
+				0, 0, // flags
+				uintptr(unsafe.Pointer(&addrs[0])),
+				uintptr(len(addrs)*winDivertAddressSize),
+				0) // lpOverlapped
+		} else {
+			success, _, err = winDivertSendEx.Call(
+				handle,
+				uintptr(unsafe.Pointer(&packets[0])),
+				uintptr(len(packets)),
+				uintptr(unsafe.Pointer(sendLen)),
+				0, // flags
+				uintptr(unsafe.Pointer(&addrs[0])),
+				uintptr(len(addrs)*winDivertAddressSize),
+				0) // lpOverlapped
+		}
+		if success == 0 {
+			return err
+		}
+		return nil
+	}
+
+	divertSetParam = func(handle uintptr, param Param, value uint64) error {
+		args := append([]uintptr{handle, uintptr(param)}, uint64Args(value)...)
+		success, _, err := winDivertSetParam.Call(args...)
+		if success == 0 {
+			return err
+		}
+		return nil
+	}
+
+	divertGetParam = func(handle uintptr, param Param, value *uint64) error {
+		success, _, err := winDivertGetParam.Call(handle, uintptr(param), uintptr(unsafe.Pointer(value)))
+		if success == 0 {
+			return err
+		}
+		return nil
+	}
+)
@@ -0,0 +1,157 @@
+package godivert
+
+import (
+	"encoding/binary"
+	"examples/header"
+	"sync"
+)
+
+// Maximum number of flows tracked by the drop estimation, the table is reset beyond
+const dropEstimatorMaxFlows = 65536
+
+// Infers the packets lost before reaching the handle from the gaps in the TCP sequence numbers
+type dropEstimator struct {
+	mu       sync.Mutex
+	flows    map[FlowKey]dropFlow
+	observed uint64
+	missed   uint64
+}
+
+// Next expected sequence number and last payload size of a flow
+type dropFlow struct {
+	nextSeq     uint32
+	segmentSize uint32
+}
+
+func newDropEstimator() *dropEstimator {
+	return &dropEstimator{flows: make(map[FlowKey]dropFlow)}
+}
+
+// Accounts a received packet, only TCP segments carrying data or SYN/FIN are used
+func (e *dropEstimator) observe(raw []byte) {
+	key, seq, length, ok := dropSegment(raw)
+	if !ok {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.observed++
+	flow, known := e.flows[key]
+	if known {
+		// 序号超前说明中间的段没有被收到，按上一个段的大小估算丢失的包数
+		if gap := int32(seq - flow.nextSeq); gap > 0 && flow.segmentSize > 0 {
+			e.missed += (uint64(gap) + uint64(flow.segmentSize) - 1) / uint64(flow.segmentSize)
+		} else if gap < 0 {
+			// 重传，不前移
+			return
+		}
+	} else if len(e.flows) >= dropEstimatorMaxFlows {
+		e.flows = make(map[FlowKey]dropFlow)
+	}
+
+	e.flows[key] = dropFlow{nextSeq: seq + length, segmentSize: length}
+}
+
+func (e *dropEstimator) rate() float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.observed+e.missed == 0 {
+		return 0
+	}
+	return float64(e.missed) / float64(e.observed+e.missed)
+}
+
+// Reads the flow, the sequence number and the sequence length of a TCP segment straight from its bytes
+func dropSegment(raw []byte) (key FlowKey, seq uint32, length uint32, ok bool) {
+	if len(raw) < 1 {
+		return key, 0, 0, false
+	}
+
+	var hdrLen, totalLen int
+	switch raw[0] >> 4 {
+	case 4:
+		hdrLen = int(raw[0]&0xf) << 2
+		if len(raw) < 20 || raw[9] != header.TCP || binary.BigEndian.Uint16(raw[6:8])&0x1fff != 0 {
+			return key, 0, 0, false
+		}
+		totalLen = int(binary.BigEndian.Uint16(raw[2:4]))
+		copy(key.SrcIP[12:], raw[12:16])
+		copy(key.DstIP[12:], raw[16:20])
+		key.SrcIP[10], key.SrcIP[11] = 0xff, 0xff
+		key.DstIP[10], key.DstIP[11] = 0xff, 0xff
+	case 6:
+		if len(raw) < 40 {
+			return key, 0, 0, false
+		}
+		var next uint8
+		if hdrLen, next = header.IPv6TransportOffset(raw); next != header.TCP {
+			return key, 0, 0, false
+		}
+		totalLen = 40 + int(binary.BigEndian.Uint16(raw[4:6]))
+		copy(key.SrcIP[:], raw[8:24])
+		copy(key.DstIP[:], raw[24:40])
+	default:
+		return key, 0, 0, false
+	}
+
+	if totalLen > len(raw) || hdrLen+20 > totalLen {
+		return key, 0, 0, false
+	}
+	tcp := raw[hdrLen:totalLen]
+	tcpHdrLen := int(tcp[12]>>4) * 4
+	if tcpHdrLen < 20 || tcpHdrLen > len(tcp) {
+		return key, 0, 0, false
+	}
+
+	length = uint32(len(tcp) - tcpHdrLen)
+	// SYN 和 FIN 各占一个序号
+	length += uint32(tcp[13]&0x1) + uint32(tcp[13]>>1&0x1)
+	if length == 0 {
+		return key, 0, 0, false
+	}
+
+	key.SrcPort = binary.BigEndian.Uint16(tcp[0:2])
+	key.DstPort = binary.BigEndian.Uint16(tcp[2:4])
+	key.Protocol = header.TCP
+	return key, binary.BigEndian.Uint32(tcp[4:8]), length, true
+}
+
+// Starts estimating the rate of the packets lost before the handle received them
+// See EstimatedDropRate. Calling it again resets the estimation.
+func (wd *WinDivertHandle) EnableDropEstimation() {
+	wd.drops.Store(newDropEstimator())
+}
+
+// Stops estimating the drop rate
+func (wd *WinDivertHandle) DisableDropEstimation() {
+	wd.drops.Store(nil)
+}
+
+// Returns the estimated fraction of packets lost before reaching the handle, between 0 and 1
+// WinDivert exposes no drop statistic, so the drops are inferred from the TCP segments received:
+// when a segment starts after the next expected sequence number the missing bytes are counted as
+// lost packets the size of the flow's previous segment. The estimation has limitations:
+//   - only TCP is accounted, other protocols carry no sequence to check
+//   - losses on the network (before the host) are counted too, they also show up as gaps
+//   - a filter matching only part of a flow's segments makes every skipped segment look lost
+//   - segments reordered on the network are counted as losses then ignored as retransmissions
+//
+// It is a trend indicator, e.g. to justify raising QUEUE_LENGTH, rather than an exact count.
+// Returns 0 if the estimation isn't enabled (see EnableDropEstimation).
+func (wd *WinDivertHandle) EstimatedDropRate() float64 {
+	e := wd.drops.Load()
+	if e == nil {
+		return 0
+	}
+	return e.rate()
+}
+
+// Accounts the packet in the drop estimation if it is enabled
+func (wd *WinDivertHandle) observeDrops(raw []byte) {
+	if e := wd.drops.Load(); e != nil {
+		e.observe(raw)
+	}
+}
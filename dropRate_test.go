@@ -0,0 +1,74 @@
+package godivert
+
+import (
+	"encoding/binary"
+	"errors"
+	"examples/header"
+	"testing"
+)
+
+// Returns an IPv4 TCP segment with the given sequence number carrying 100 bytes
+func dropRateSegment(seq uint32) []byte {
+	tcp := tcpBytes(50000, 443, 0x10, make([]byte, 100))
+	binary.BigEndian.PutUint32(tcp[4:8], seq)
+	return buildIPv4(header.TCP, tcp)
+}
+
+func TestEstimatedDropRate(t *testing.T) {
+	tests := []struct {
+		name     string
+		disabled bool
+		packets  [][]byte
+		want     float64
+	}{
+		{"in order", false, [][]byte{dropRateSegment(1000), dropRateSegment(1100), dropRateSegment(1200)}, 0},
+		// 1200 和 1300 两个段没收到
+		{"sequence gap", false, [][]byte{dropRateSegment(1000), dropRateSegment(1100), dropRateSegment(1400)}, 0.4},
+		{"retransmission", false, [][]byte{dropRateSegment(1000), dropRateSegment(1100), dropRateSegment(1000), dropRateSegment(1200)}, 0},
+		{"UDP ignored", false, [][]byte{dropRateSegment(1000), buildIPv4(header.UDP, udpBytes(1, 2, nil)), dropRateSegment(1100)}, 0},
+		{"disabled", true, [][]byte{dropRateSegment(1000), dropRateSegment(1100), dropRateSegment(1400)}, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			driver := newFakeDriver(t)
+			wd := openFake(t, OpenOptions{})
+			if !test.disabled {
+				wd.EnableDropEstimation()
+			}
+			for _, raw := range test.packets {
+				driver.divert(raw, *NewAddress())
+			}
+
+			for {
+				packet, err := wd.Recv()
+				if errors.Is(err, ErrShutdown) {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				wd.dropPacket(packet)
+			}
+			if got := wd.EstimatedDropRate(); got != test.want {
+				t.Errorf("EstimatedDropRate() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDisableDropEstimation(t *testing.T) {
+	newFakeDriver(t)
+	wd := openFake(t, OpenOptions{})
+	wd.EnableDropEstimation()
+	wd.observeDrops(dropRateSegment(1000))
+	wd.observeDrops(dropRateSegment(1200))
+	if wd.EstimatedDropRate() == 0 {
+		t.Fatal("EstimatedDropRate() = 0 after a gap")
+	}
+
+	wd.DisableDropEstimation()
+	if got := wd.EstimatedDropRate(); got != 0 {
+		t.Errorf("EstimatedDropRate() = %v once disabled, want 0", got)
+	}
+}
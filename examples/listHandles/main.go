@@ -0,0 +1,63 @@
+package main
+
+import (
+	godivert "examples"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// 自动切换工作目录
+func init() {
+	// 程序所在目录
+	var execDir = "C:\\Users\\dajinglingpake\\GolandProjects\\godivert\\examples"
+	pwd, _ := os.Getwd()
+	fmt.Println("开始工作目录", pwd)
+	if pwd == execDir {
+		fmt.Println("不需要切换工作目录")
+		return
+	}
+	fmt.Println("切换工作目录到", execDir)
+	if err := os.Chdir(execDir); err != nil {
+		log.Fatal(err)
+	}
+	pwd, _ = os.Getwd()
+	fmt.Println("切换后工作目录:", pwd)
+}
+
+// Prints the WinDivert handles opened and closed on the system for 30 seconds
+func main() {
+	if err := godivert.LoadDLL("./WinDivert-2.2.2-A/x64/WinDivert.dll", "./WinDivert-2.2.2-A/x86/WinDivert.dll"); err != nil {
+		panic(err)
+	}
+
+	winDivert, err := godivert.Open(godivert.OpenOptions{
+		Layer: godivert.WinDivertLayerReflect,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println("Listening")
+
+	go func() {
+		time.Sleep(30 * time.Second)
+		winDivert.Shutdown(godivert.WinDivertShutdownRecv)
+	}()
+
+	for {
+		event, err := winDivert.RecvReflect()
+		if err == godivert.ErrShutdown {
+			break
+		}
+		if err != nil {
+			fmt.Println("RecvReflect Error:", err)
+			break
+		}
+		fmt.Println(event)
+	}
+
+	fmt.Println("Stopping...")
+	winDivert.Close()
+}
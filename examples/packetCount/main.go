@@ -19,7 +19,7 @@ func checkPacket(wd *godivert.WinDivertHandle, packetChan <-chan *godivert.Packe
 }
 
 func countPacket(packet *godivert.Packet) {
-	if packet.Addr.Data&0x1 == 1 {
+	if packet.Direction() == godivert.WinDivertDirectionInbound {
 		inbound++
 	} else {
 		outbound++
@@ -58,7 +58,9 @@ func init() {
 }
 
 func main() {
-	godivert.LoadDLL("./WinDivert-2.2.2-A/x64/WinDivert.dll", "./WinDivert-2.2.2-A/x86/WinDivert.dll")
+	if err := godivert.LoadDLL("./WinDivert-2.2.2-A/x64/WinDivert.dll", "./WinDivert-2.2.2-A/x86/WinDivert.dll"); err != nil {
+		panic(err)
+	}
 
 	winDivert, err := godivert.NewWinDivertHandle("true")
 	if err != nil {
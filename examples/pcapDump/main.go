@@ -0,0 +1,85 @@
+package main
+
+import (
+	godivert "examples"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// 自动切换工作目录
+func init() {
+	// 程序所在目录
+	var execDir = "C:\\Users\\dajinglingpake\\GolandProjects\\godivert\\examples"
+	pwd, _ := os.Getwd()
+	fmt.Println("开始工作目录", pwd)
+	if pwd == execDir {
+		fmt.Println("不需要切换工作目录")
+		return
+	}
+	fmt.Println("切换工作目录到", execDir)
+	if err := os.Chdir(execDir); err != nil {
+		log.Fatal(err)
+	}
+	pwd, _ = os.Getwd()
+	fmt.Println("切换后工作目录:", pwd)
+}
+
+// Captures the traffic for 15 seconds into capture.pcap, the packets are reinjected
+func main() {
+	if err := godivert.LoadDLL("./WinDivert-2.2.2-A/x64/WinDivert.dll", "./WinDivert-2.2.2-A/x86/WinDivert.dll"); err != nil {
+		panic(err)
+	}
+
+	file, err := os.Create("capture.pcap")
+	if err != nil {
+		panic(err)
+	}
+	defer file.Close()
+
+	pcapWriter, err := godivert.NewPcapWriter(file)
+	if err != nil {
+		panic(err)
+	}
+
+	winDivert, err := godivert.NewWinDivertHandle("true")
+	if err != nil {
+		panic(err)
+	}
+
+	packetChan, err := winDivert.Packets()
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println("Starting")
+
+	var count int
+	timeout := time.After(15 * time.Second)
+loop:
+	for {
+		select {
+		case packet, ok := <-packetChan:
+			if !ok {
+				break loop
+			}
+			// 先写入文件，Send 之后缓冲区会被回收
+			if err := pcapWriter.WritePacket(packet); err != nil {
+				fmt.Println("WritePacket Error:", err)
+			}
+			count++
+			packet.Send(winDivert)
+		case <-timeout:
+			break loop
+		}
+	}
+
+	fmt.Println("Stopping...")
+	winDivert.Close()
+
+	if err := pcapWriter.Flush(); err != nil {
+		panic(err)
+	}
+	fmt.Printf("Wrote %d packets to capture.pcap\n", count)
+}
@@ -26,7 +26,9 @@ func init() {
 }
 
 func main() {
-	godivert.LoadDLL("./WinDivert-2.2.2-A/x64/WinDivert.dll", "./WinDivert-2.2.2-A/x86/WinDivert.dll")
+	if err := godivert.LoadDLL("./WinDivert-2.2.2-A/x64/WinDivert.dll", "./WinDivert-2.2.2-A/x86/WinDivert.dll"); err != nil {
+		panic(err)
+	}
 
 	winDivert, err := godivert.NewWinDivertHandle("true")
 	if err != nil {
@@ -0,0 +1,34 @@
+package godivert
+
+import (
+	"fmt"
+	"net"
+)
+
+// Common filters, see https://reqrypt.org/windivert-doc.html#filter_language
+const (
+	FilterTCP   = "tcp"
+	FilterUDP   = "udp"
+	FilterDNS   = "udp and (udp.SrcPort == 53 or udp.DstPort == 53)"
+	FilterHTTP  = "tcp and (tcp.SrcPort == 80 or tcp.DstPort == 80)"
+	FilterHTTPS = "tcp and (tcp.SrcPort == 443 or tcp.DstPort == 443)"
+	FilterICMP  = "icmp or icmpv6"
+)
+
+// Returns a filter matching the packets sent from or to the given IP
+// Returns an error if ip isn't a valid IPv4 or IPv6 address
+func FilterHostExpr(ip net.IP) (string, error) {
+	if ip4 := ip.To4(); ip4 != nil {
+		return fmt.Sprintf("(ip.SrcAddr == %s or ip.DstAddr == %s)", ip4, ip4), nil
+	}
+	if len(ip) == net.IPv6len {
+		return fmt.Sprintf("(ipv6.SrcAddr == %s or ipv6.DstAddr == %s)", ip, ip), nil
+	}
+	return "", fmt.Errorf("cannot build a host filter, invalid IP %v", ip)
+}
+
+// Returns a filter matching the TCP and UDP packets sent from or to the given port
+func FilterPortExpr(port uint16) string {
+	return fmt.Sprintf("(tcp.SrcPort == %d or tcp.DstPort == %d or udp.SrcPort == %d or udp.DstPort == %d)",
+		port, port, port, port)
+}
@@ -0,0 +1,54 @@
+package godivert
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Returned when WinDivert rejects a filter
+// Message is WinDivert's description of the error and Position the byte offset in Filter where it was found
+type FilterError struct {
+	Filter   string
+	Message  string
+	Position int
+}
+
+func (e *FilterError) Error() string {
+	if near := e.Substring(); near != "" {
+		return fmt.Sprintf("invalid filter: %s at position %d near %q", e.Message, e.Position, near)
+	}
+	return fmt.Sprintf("invalid filter: %s at position %d", e.Message, e.Position)
+}
+
+// Returns the filter on a first line and a caret under the error position on a second one
+// e.g. "tcp.DstPort == 80 andd udp\n                  ^"
+func (e *FilterError) Excerpt() string {
+	pos := e.Position
+	if pos < 0 {
+		pos = 0
+	}
+	if pos > len(e.Filter) {
+		pos = len(e.Filter)
+	}
+	// 制表符保留在缩进中，保证插入符对齐
+	indent := strings.Map(func(r rune) rune {
+		if r == '\t' {
+			return r
+		}
+		return ' '
+	}, e.Filter[:pos])
+	return e.Filter + "\n" + indent + "^"
+}
+
+// Returns the part of the filter starting at the error position, up to the next space
+// Returns an empty string if the error is at the end of the filter
+func (e *FilterError) Substring() string {
+	if e.Position < 0 || e.Position >= len(e.Filter) {
+		return ""
+	}
+	near := e.Filter[e.Position:]
+	if i := strings.IndexByte(near, ' '); i > 0 {
+		near = near[:i]
+	}
+	return near
+}
@@ -0,0 +1,343 @@
+package godivert
+
+import (
+	"bytes"
+	"examples/header"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Message of the errors reported by the fake filter compiler, NUL terminated like WinDivert's
+var fakeFilterError = []byte("unknown field\x00")
+
+// Replaces the filter helpers of the DLL by an evaluator of the subset of the filter language
+// the library produces: and, or, not, parentheses, true, false, the direction, loopback and protocol
+// flags, and the comparisons of the ports and addresses to constants.
+// The compiled object is the filter string, an invalid filter reports the position of the faulty token.
+func newFakeFilterLanguage(t *testing.T) {
+	savedCompile, savedEval := divertCompileFilter, divertEvalFilter
+	t.Cleanup(func() {
+		divertCompileFilter, divertEvalFilter = savedCompile, savedEval
+	})
+
+	divertCompileFilter = func(filter *byte, layer Layer, object []byte, errorStr **byte, errorPos *uint32) bool {
+		if _, pos, err := evalFilterExpr(cString(filter), nil); err != nil {
+			*errorStr, *errorPos = &fakeFilterError[0], uint32(pos)
+			return false
+		}
+		// 假的编译结果就是过滤器字符串本身
+		if len(object) > 0 {
+			copy(object, cString(filter)+"\x00")
+		}
+		return true
+	}
+	divertEvalFilter = func(filter *byte, raw []byte, addr *WinDivertAddress) bool {
+		packet := NewPacket(append([]byte(nil), raw...), addr)
+		packet.ParseHeaders()
+		match, _, err := evalFilterExpr(cString(filter), packet)
+		return err == nil && match
+	}
+}
+
+// Evaluates the filter against the packet, packet can be nil to only check the syntax
+// Returns the position in the filter of the token the error is on.
+func evalFilterExpr(filter string, packet *Packet) (bool, int, error) {
+	e := &filterEval{packet: packet, end: len(filter)}
+	// 括号单独成为一个词
+	for i := 0; i < len(filter); {
+		switch c := filter[i]; {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '(' || c == ')':
+			e.tokens = append(e.tokens, filterToken{filter[i : i+1], i})
+			i++
+		default:
+			start := i
+			for i < len(filter) && !strings.ContainsRune(" \t\n()", rune(filter[i])) {
+				i++
+			}
+			e.tokens = append(e.tokens, filterToken{filter[start:i], start})
+		}
+	}
+
+	match, err := e.or()
+	if err == nil && e.i < len(e.tokens) {
+		err = e.fail(e.i, "unexpected %q", e.tokens[e.i].text)
+	}
+	return match, e.errPos, err
+}
+
+type filterToken struct {
+	text string
+	pos  int
+}
+
+type filterEval struct {
+	tokens []filterToken
+	// Index of the next token
+	i      int
+	packet *Packet
+	// Length of the filter, the position of the errors at its end
+	end    int
+	errPos int
+}
+
+func (e *filterEval) next() string {
+	if e.i >= len(e.tokens) {
+		return ""
+	}
+	e.i++
+	return e.tokens[e.i-1].text
+}
+
+func (e *filterEval) peek(token string) bool {
+	return e.i < len(e.tokens) && e.tokens[e.i].text == token
+}
+
+// Returns an error positioned on the token at index
+func (e *filterEval) fail(index int, format string, args ...any) error {
+	e.errPos = e.end
+	if index < len(e.tokens) {
+		e.errPos = e.tokens[index].pos
+	}
+	return fmt.Errorf(format, args...)
+}
+
+func (e *filterEval) or() (bool, error) {
+	match, err := e.and()
+	for err == nil && e.peek("or") {
+		e.next()
+		var right bool
+		right, err = e.and()
+		match = match || right
+	}
+	return match, err
+}
+
+func (e *filterEval) and() (bool, error) {
+	match, err := e.unary()
+	for err == nil && e.peek("and") {
+		e.next()
+		var right bool
+		right, err = e.unary()
+		match = match && right
+	}
+	return match, err
+}
+
+func (e *filterEval) unary() (bool, error) {
+	switch {
+	case e.peek("not"):
+		e.next()
+		match, err := e.unary()
+		return !match, err
+	case e.peek("("):
+		e.next()
+		match, err := e.or()
+		if err == nil && !e.peek(")") {
+			return false, e.fail(e.i, "missing )")
+		}
+		e.next()
+		return match, err
+	}
+	return e.atom()
+}
+
+func (e *filterEval) atom() (bool, error) {
+	p := e.packet
+	start := e.i
+	field := e.next()
+	if field == "" {
+		return false, e.fail(start, "missing test")
+	}
+
+	if match, ok := e.flag(field); ok {
+		return p != nil && match, nil
+	}
+
+	var protocol, name string
+	switch field {
+	case "tcp.SrcPort", "tcp.DstPort", "udp.SrcPort", "udp.DstPort", "ip.SrcAddr", "ip.DstAddr", "ipv6.SrcAddr", "ipv6.DstAddr":
+		protocol, name, _ = strings.Cut(field, ".")
+	default:
+		return false, e.fail(start, "unknown field %q", field)
+	}
+	op, value := e.next(), e.next()
+
+	var cmp int
+	known := false
+	if name == "SrcPort" || name == "DstPort" {
+		port, err := strconv.ParseUint(value, 10, 16)
+		if err != nil {
+			return false, e.fail(start+2, "invalid port %q for %s", value, field)
+		}
+		if p != nil && p.NextHeaderProtocolName() == strings.ToUpper(protocol) {
+			got, _ := p.SrcPort()
+			if name == "DstPort" {
+				got, _ = p.DstPort()
+			}
+			cmp, known = int(got)-int(port), true
+		}
+	} else {
+		ip := net.ParseIP(value)
+		version := header.IPv4
+		if protocol == "ipv6" {
+			version = header.IPv6
+		}
+		if ip == nil || (ip.To4() != nil) != (version == header.IPv4) {
+			return false, e.fail(start+2, "invalid address %q for %s", value, field)
+		}
+		if p != nil && p.ipVersion == version {
+			got := p.DstIP()
+			if name == "SrcAddr" {
+				got = p.SrcIP()
+			}
+			cmp, known = bytes.Compare(got.To16(), ip.To16()), true
+		}
+	}
+
+	var match bool
+	switch op {
+	case "==":
+		match = cmp == 0
+	case "!=":
+		match = cmp != 0
+	case "<":
+		match = cmp < 0
+	case "<=":
+		match = cmp <= 0
+	case ">":
+		match = cmp > 0
+	case ">=":
+		match = cmp >= 0
+	default:
+		return false, e.fail(start+1, "invalid operator %q after %q", op, field)
+	}
+	// 包没有这个字段时测试不匹配
+	return known && match, nil
+}
+
+// Returns the value of a flag test for the packet, ok is false if token isn't a flag
+func (e *filterEval) flag(token string) (match, ok bool) {
+	p := e.packet
+	switch token {
+	case "true":
+		return true, true
+	case "false":
+		return false, true
+	case "inbound", "outbound", "loopback", "ip", "ipv6", "tcp", "udp", "icmp", "icmpv6":
+	default:
+		return false, false
+	}
+	if p == nil {
+		return false, true
+	}
+
+	switch token {
+	case "inbound":
+		return !p.Addr.Outbound(), true
+	case "outbound":
+		return p.Addr.Outbound(), true
+	case "loopback":
+		return p.Addr.Loopback(), true
+	case "ip":
+		return p.ipVersion == header.IPv4, true
+	case "ipv6":
+		return p.ipVersion == header.IPv6, true
+	case "tcp":
+		return p.nextHeaderType == header.TCP, true
+	case "udp":
+		return p.nextHeaderType == header.UDP, true
+	case "icmp":
+		return p.nextHeaderType == header.ICMPv4, true
+	}
+	return p.nextHeaderType == header.ICMPv6, true
+}
+
+// The generated filters only use the fields the DLL knows
+func TestCommonFiltersCompile(t *testing.T) {
+	if err := winDivertHelperCompileFilter.Find(); err != nil {
+		t.Skip("WinDivert DLL not available:", err)
+	}
+	host4, _ := FilterHostExpr(net.ParseIP("10.0.0.2"))
+	host6, _ := FilterHostExpr(net.ParseIP("fd00::2"))
+
+	for _, filter := range []string{FilterTCP, FilterUDP, FilterDNS, FilterHTTP, FilterHTTPS, FilterICMP, host4, host6, FilterPortExpr(8080)} {
+		if ok, err := HelperCheckFilter(filter); !ok || err != nil {
+			t.Errorf("HelperCheckFilter(%q) = %v, %v", filter, ok, err)
+		}
+	}
+}
+
+func TestCommonFilters(t *testing.T) {
+	newFakeFilterLanguage(t)
+	host4, err := FilterHostExpr(net.ParseIP("10.0.0.2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	host6, err := FilterHostExpr(net.ParseIP("fd00::2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	packets := map[string][]byte{
+		"DNS query":     buildIPv4(header.UDP, udpBytes(50000, 53, nil)),
+		"DNS response":  buildIPv6(header.UDP, udpBytes(53, 50000, nil)),
+		"HTTP request":  buildIPv4(header.TCP, tcpBytes(50000, 80, 0x02, nil)),
+		"HTTPS request": buildIPv6(header.TCP, tcpBytes(50000, 443, 0x02, nil)),
+		"HTTPS reply":   buildIPv4(header.TCP, tcpBytes(443, 50000, 0x12, nil)),
+		"ping":          buildIPv4(header.ICMPv4, icmpBytes(8, nil)),
+		"ping6":         buildIPv6(header.ICMPv6, icmpBytes(128, nil)),
+		"UDP 8080":      buildIPv4(header.UDP, udpBytes(50000, 8080, nil)),
+		"TCP 8080":      buildIPv6(header.TCP, tcpBytes(8080, 50000, 0x10, nil)),
+	}
+
+	tests := []struct {
+		name   string
+		filter string
+		want   []string
+	}{
+		{"FilterTCP", FilterTCP, []string{"HTTP request", "HTTPS request", "HTTPS reply", "TCP 8080"}},
+		{"FilterUDP", FilterUDP, []string{"DNS query", "DNS response", "UDP 8080"}},
+		{"FilterDNS", FilterDNS, []string{"DNS query", "DNS response"}},
+		{"FilterHTTP", FilterHTTP, []string{"HTTP request"}},
+		{"FilterHTTPS", FilterHTTPS, []string{"HTTPS request", "HTTPS reply"}},
+		{"FilterICMP", FilterICMP, []string{"ping", "ping6"}},
+		{"IPv4 host", host4, []string{"DNS query", "HTTP request", "HTTPS reply", "ping", "UDP 8080"}},
+		{"IPv6 host", host6, []string{"DNS response", "HTTPS request", "ping6", "TCP 8080"}},
+		{"port", FilterPortExpr(8080), []string{"UDP 8080", "TCP 8080"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if ok, err := HelperCheckFilter(test.filter); !ok || err != nil {
+				t.Fatalf("HelperCheckFilter(%q) = %v, %v", test.filter, ok, err)
+			}
+
+			want := make(map[string]bool)
+			for _, name := range test.want {
+				want[name] = true
+			}
+			for name, raw := range packets {
+				match, err := HelperEvalFilter(NewPacket(raw, nil), test.filter)
+				if err != nil {
+					t.Fatalf("HelperEvalFilter(%s) = %v", name, err)
+				}
+				if match != want[name] {
+					t.Errorf("%s matches %v, want %v", name, match, want[name])
+				}
+			}
+		})
+	}
+}
+
+func TestFilterHostExprInvalid(t *testing.T) {
+	for _, ip := range []net.IP{nil, net.IP{1, 2, 3}} {
+		if filter, err := FilterHostExpr(ip); err == nil {
+			t.Errorf("FilterHostExpr(%v) = %q, want an error", ip, filter)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package godivert
+
+import (
+	"examples/header"
+	"sync"
+)
+
+// Collects the first bytes sent in each direction of the TCP flows, e.g. a TLS ClientHello for the SNI
+// It is much lighter than a stream reassembly: the in-order payload of a flow is accumulated
+// until N bytes are known or a segment with the PSH flag ends the first flight of data,
+// the callback then receives the bytes and the flow is no longer tracked until it ends (FIN or RST).
+// Segments arriving ahead of a hole are ignored, the retransmission fills the hole.
+// It is safe for concurrent use, the callback is called outside of the lock.
+type FirstBytesCollector struct {
+	n        int
+	callback func(key FlowKey, data []byte)
+
+	mu    sync.Mutex
+	flows map[FlowKey]*firstBytesFlow
+}
+
+// State of a flow of the FirstBytesCollector
+type firstBytesFlow struct {
+	nextSeq uint32
+	data    []byte
+	done    bool
+}
+
+// Creates a FirstBytesCollector collecting up to n bytes per flow direction
+// callback owns the data it receives
+func NewFirstBytesCollector(n int, callback func(key FlowKey, data []byte)) *FirstBytesCollector {
+	return &FirstBytesCollector{
+		n:        n,
+		callback: callback,
+		flows:    make(map[FlowKey]*firstBytesFlow),
+	}
+}
+
+// Accounts a packet, non TCP packets are ignored
+func (c *FirstBytesCollector) Observe(p *Packet) {
+	p.VerifyParsed()
+
+	tcpHdr, ok := p.NextHeader.(*header.TCPHeader)
+	if !ok {
+		return
+	}
+	key := p.FlowKey()
+
+	data := c.observe(key, tcpHdr)
+	if data != nil {
+		c.callback(key, data)
+	}
+}
+
+// Updates the flow and returns the collected bytes once they are complete
+func (c *FirstBytesCollector) observe(key FlowKey, tcpHdr *header.TCPHeader) []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	flow := c.flows[key]
+
+	if tcpHdr.FIN() || tcpHdr.RST() {
+		delete(c.flows, key)
+		if flow == nil || flow.done {
+			return nil
+		}
+		// 连接结束前不足 N 字节也交给回调
+		flow.append(tcpHdr, c.n)
+		if len(flow.data) == 0 {
+			return nil
+		}
+		return flow.data
+	}
+
+	if tcpHdr.SYN() {
+		c.flows[key] = &firstBytesFlow{nextSeq: tcpHdr.SeqNum() + 1}
+		return nil
+	}
+
+	if flow == nil {
+		if len(tcpHdr.Payload) == 0 {
+			return nil
+		}
+		// 握手之前就已存在的连接，从第一个数据段开始
+		flow = &firstBytesFlow{nextSeq: tcpHdr.SeqNum()}
+		c.flows[key] = flow
+	}
+	if flow.done {
+		return nil
+	}
+
+	flow.append(tcpHdr, c.n)
+	if len(flow.data) >= c.n || tcpHdr.PSH() && len(flow.data) > 0 {
+		data := flow.data
+		flow.data = nil
+		flow.done = true
+		return data
+	}
+	return nil
+}
+
+// Appends the in-order part of the segment's payload, up to n bytes in total
+func (f *firstBytesFlow) append(tcpHdr *header.TCPHeader, n int) {
+	payload := tcpHdr.Payload
+	// 序号差值按有符号数比较以处理回绕
+	offset := int32(f.nextSeq - tcpHdr.SeqNum())
+	if offset < 0 || int(offset) >= len(payload) {
+		return
+	}
+	payload = payload[offset:]
+	f.nextSeq += uint32(len(payload))
+
+	if remaining := n - len(f.data); len(payload) > remaining {
+		payload = payload[:remaining]
+	}
+	f.data = append(f.data, payload...)
+}
+
+// Stops tracking the flow
+func (c *FirstBytesCollector) Forget(key FlowKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.flows, key)
+}
+
+// Returns the number of flows tracked, the finished ones included until they end
+func (c *FirstBytesCollector) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.flows)
+}
@@ -0,0 +1,137 @@
+package godivert
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"examples/header"
+	"io"
+	"net"
+	"testing"
+)
+
+// Returns the TLS ClientHello record a client sends to serverName
+func clientHello(t *testing.T, serverName string) []byte {
+	t.Helper()
+
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		tls.Client(client, &tls.Config{ServerName: serverName}).Handshake()
+		client.Close()
+	}()
+
+	record := make([]byte, 5)
+	if _, err := io.ReadFull(server, record); err != nil {
+		t.Fatal(err)
+	}
+	record = append(record, make([]byte, binary.BigEndian.Uint16(record[3:5]))...)
+	if _, err := io.ReadFull(server, record[5:]); err != nil {
+		t.Fatal(err)
+	}
+	return record
+}
+
+// Returns a TCP segment from 10.0.0.1:50000 to 10.0.0.2:443
+func firstBytesSegment(flags uint8, seq uint32, payload []byte) *Packet {
+	tcp := tcpBytes(50000, 443, flags, payload)
+	binary.BigEndian.PutUint32(tcp[4:8], seq)
+	return NewPacket(buildIPv4(header.TCP, tcp), NewAddress())
+}
+
+func TestFirstBytesCollector(t *testing.T) {
+	const syn, ack, psh, fin = 0x02, 0x10, 0x08, 0x01
+	hello := clientHello(t, "example.com")
+	half := len(hello) / 2
+	first, second := hello[:half], hello[half:]
+
+	tests := []struct {
+		name     string
+		n        int
+		segments []*Packet
+		want     []byte
+		ended    bool
+	}{
+		{"SNI across two segments", 4096, []*Packet{
+			firstBytesSegment(syn, 999, nil),
+			firstBytesSegment(ack, 1000, nil),
+			firstBytesSegment(ack, 1000, first),
+			firstBytesSegment(ack|psh, 1000+uint32(half), second),
+		}, hello, false},
+		{"limited to n bytes", 16, []*Packet{
+			firstBytesSegment(syn, 999, nil),
+			firstBytesSegment(ack, 1000, first),
+			firstBytesSegment(ack|psh, 1000+uint32(half), second),
+		}, hello[:16], false},
+		{"segment ahead of a hole", 4096, []*Packet{
+			firstBytesSegment(syn, 999, nil),
+			firstBytesSegment(ack|psh, 1000+uint32(half), second),
+			firstBytesSegment(ack, 1000, first),
+			firstBytesSegment(ack|psh, 1000+uint32(half), second),
+		}, hello, false},
+		{"overlapping retransmission", 4096, []*Packet{
+			firstBytesSegment(syn, 999, nil),
+			firstBytesSegment(ack, 1000, first[:10]),
+			firstBytesSegment(ack, 1000, first),
+			firstBytesSegment(ack|psh, 1000+uint32(half), second),
+		}, hello, false},
+		{"connection already established", 4096, []*Packet{
+			firstBytesSegment(ack, 5000, first),
+			firstBytesSegment(ack|psh, 5000+uint32(half), second),
+		}, hello, false},
+		{"FIN before n bytes", 4096, []*Packet{
+			firstBytesSegment(syn, 999, nil),
+			firstBytesSegment(ack, 1000, first),
+			firstBytesSegment(ack|fin, 1000+uint32(half), nil),
+		}, first, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var calls int
+			var got []byte
+			c := NewFirstBytesCollector(test.n, func(key FlowKey, data []byte) {
+				calls++
+				got = data
+				if key.DstPort != 443 {
+					t.Errorf("callback for the flow %v", key)
+				}
+			})
+			for _, segment := range test.segments {
+				c.Observe(segment)
+			}
+			// 之后的数据不再触发回调，结束的连接已经不再跟踪
+			if !test.ended {
+				c.Observe(firstBytesSegment(ack|psh, 1000+uint32(len(hello)), []byte("more")))
+			}
+
+			if calls != 1 || !bytes.Equal(got, test.want) {
+				t.Fatalf("%d callbacks with %d bytes, want one with %d bytes", calls, len(got), len(test.want))
+			}
+		})
+	}
+
+	if !bytes.Contains(hello, []byte("example.com")) {
+		t.Error("the ClientHello carries no SNI")
+	}
+}
+
+func TestFirstBytesCollectorForgetsEndedFlows(t *testing.T) {
+	const syn, ack, psh, fin = 0x02, 0x10, 0x08, 0x01
+	c := NewFirstBytesCollector(4, func(key FlowKey, data []byte) {})
+
+	c.Observe(firstBytesSegment(syn, 999, nil))
+	c.Observe(firstBytesSegment(ack|psh, 1000, []byte("hello")))
+	if c.Len() != 1 {
+		t.Errorf("Len() = %d once the bytes are collected, want the flow kept until it ends", c.Len())
+	}
+	c.Observe(firstBytesSegment(ack|fin, 1005, nil))
+	if c.Len() != 0 {
+		t.Errorf("Len() = %d after FIN, want 0", c.Len())
+	}
+
+	c.Observe(NewPacket(buildIPv4(header.UDP, udpBytes(1234, 53, []byte("query"))), nil))
+	if c.Len() != 0 {
+		t.Error("a UDP packet is tracked")
+	}
+}
@@ -0,0 +1,55 @@
+package godivert
+
+import (
+	"examples/header"
+	"fmt"
+	"net"
+)
+
+// Identifies a flow by its 5-tuple
+// IPv4 addresses are stored in their IPv4-mapped IPv6 form so FlowKey can be used as a map key
+type FlowKey struct {
+	SrcIP    [net.IPv6len]byte
+	DstIP    [net.IPv6len]byte
+	SrcPort  uint16
+	DstPort  uint16
+	Protocol uint8
+}
+
+// Creates a FlowKey from its components
+func NewFlowKey(srcIP net.IP, srcPort uint16, dstIP net.IP, dstPort uint16, protocol uint8) FlowKey {
+	key := FlowKey{
+		SrcPort:  srcPort,
+		DstPort:  dstPort,
+		Protocol: protocol,
+	}
+	copy(key.SrcIP[:], srcIP.To16())
+	copy(key.DstIP[:], dstIP.To16())
+	return key
+}
+
+// Returns the key of the flow the packet belongs to
+// Ports are 0 for protocols without ports
+func (p *Packet) FlowKey() FlowKey {
+	p.VerifyParsed()
+
+	srcPort, _ := p.SrcPort()
+	dstPort, _ := p.DstPort()
+	return NewFlowKey(p.SrcIP(), srcPort, p.DstIP(), dstPort, p.nextHeaderType)
+}
+
+// Returns the key of the other direction of the flow
+func (k FlowKey) Reverse() FlowKey {
+	return FlowKey{
+		SrcIP:    k.DstIP,
+		DstIP:    k.SrcIP,
+		SrcPort:  k.DstPort,
+		DstPort:  k.SrcPort,
+		Protocol: k.Protocol,
+	}
+}
+
+func (k FlowKey) String() string {
+	return fmt.Sprintf("%s %s > %s", header.ProtocolName(k.Protocol),
+		summaryEndpoint(net.IP(k.SrcIP[:]), k.SrcPort, true), summaryEndpoint(net.IP(k.DstIP[:]), k.DstPort, true))
+}
@@ -3,3 +3,14 @@ module examples
 go 1.22
 
 //require github.com/williamfhe/godivert v0.0.0-20181229124620-a48c5b872c73
+
+require (
+	github.com/google/gopacket v1.1.19
+	gvisor.dev/gvisor v0.0.0-20230927004350-cbd86285d259
+)
+
+require (
+	github.com/google/btree v1.0.1 // indirect
+	golang.org/x/sys v0.12.0 // indirect
+	golang.org/x/time v0.0.0-20220210224613-90d013bbcef8 // indirect
+)
@@ -0,0 +1,31 @@
+//go:build gopacket
+
+package gopacketbridge
+
+import (
+	godivert "examples"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// Decodes the packet with gopacket, the first layer is IPv4 or IPv6 depending on the IP version
+// The packet's bytes aren't copied (gopacket.NoCopy): the result must not be used once the packet
+// has been sent or released, Clone the packet first to keep it.
+// The capture timestamp isn't set, WinDivert timestamps are performance counter values.
+func Layers(p *godivert.Packet) gopacket.Packet {
+	return LayersWithOptions(p, gopacket.DecodeOptions{NoCopy: true})
+}
+
+// Same as Layers with the given decode options, e.g. Lazy or DecodeStreamsAsDatagrams
+func LayersWithOptions(p *godivert.Packet, opts gopacket.DecodeOptions) gopacket.Packet {
+	return gopacket.NewPacket(p.Raw, firstLayer(p), opts)
+}
+
+// Returns the decoder of the IP header, read from the version nibble so the packet needn't be parsed
+func firstLayer(p *godivert.Packet) gopacket.Decoder {
+	if len(p.Raw) > 0 && p.Raw[0]>>4 == 6 {
+		return layers.LayerTypeIPv6
+	}
+	return layers.LayerTypeIPv4
+}
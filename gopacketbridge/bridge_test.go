@@ -0,0 +1,54 @@
+//go:build gopacket
+
+package gopacketbridge
+
+import (
+	godivert "examples"
+	"testing"
+
+	"github.com/google/gopacket/layers"
+)
+
+func TestLayers(t *testing.T) {
+	// UDP 1234 -> 53，没有负载
+	udp := []byte{0x04, 0xd2, 0x00, 0x35, 0x00, 0x08, 0x00, 0x00}
+	ipv4 := append([]byte{
+		0x45, 0x00, 0x00, 0x1c, 0x00, 0x01, 0x00, 0x00, 0x40, 0x11, 0x00, 0x00,
+		10, 0, 0, 1, 10, 0, 0, 2,
+	}, udp...)
+	ipv6 := append([]byte{
+		0x60, 0x00, 0x00, 0x00, 0x00, 0x08, 0x11, 0x40,
+		0xfd, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1,
+		0xfd, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2,
+	}, udp...)
+
+	tests := []struct {
+		name string
+		raw  []byte
+		want []string
+	}{
+		{"IPv4", ipv4, []string{"IPv4", "UDP"}},
+		{"IPv6", ipv6, []string{"IPv6", "UDP"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			decoded := Layers(godivert.NewPacket(test.raw, nil))
+			if err := decoded.ErrorLayer(); err != nil {
+				t.Fatalf("decoding error: %v", err.Error())
+			}
+			got := decoded.Layers()
+			if len(got) != len(test.want) {
+				t.Fatalf("decoded %d layers, want %v", len(got), test.want)
+			}
+			for i, layer := range got {
+				if layer.LayerType().String() != test.want[i] {
+					t.Errorf("layer %d is %v, want %s", i, layer.LayerType(), test.want[i])
+				}
+			}
+			if udp, ok := decoded.Layer(layers.LayerTypeUDP).(*layers.UDP); !ok || udp.DstPort != 53 {
+				t.Errorf("UDP layer %v, want destination port 53", decoded.Layer(layers.LayerTypeUDP))
+			}
+		})
+	}
+}
@@ -0,0 +1,4 @@
+// Package gopacketbridge decodes WinDivert packets with google/gopacket
+// It is a separate package so godivert itself keeps no dependency: the bridge is only compiled with
+// the gopacket build tag. go.mod requires github.com/google/gopacket for it, build with -tags gopacket.
+package gopacketbridge
@@ -9,12 +9,65 @@ const (
 	UDPHeaderLen     = 8
 	ICMPv4HeaderLen  = 8
 	ICMPv6HeaderLen  = 8
+	UDPLiteHeaderLen = 8
 
-	ICMPv4 = 1
-	TCP    = 6
-	UDP    = 17
-	ICMPv6 = 58
+	ICMPv4  = 1
+	TCP     = 6
+	UDP     = 17
+	ICMPv6  = 58
+	UDPLite = 136
 
 	IPv4 = 4
 	IPv6 = 6
 )
+
+// IPv4 flags as returned by IPv4Header.Flags
+const (
+	IPv4FlagMoreFragments = 0x1
+	IPv4FlagDontFragment  = 0x2
+)
+
+// ICMP echo message types
+const (
+	ICMPEchoReply     = 0
+	ICMPEchoRequest   = 8
+	ICMPv6EchoRequest = 128
+	ICMPv6EchoReply   = 129
+)
+
+// IPv6 extension header types
+// https://www.iana.org/assignments/ipv6-parameters/ipv6-parameters.xhtml#extension-header
+const (
+	IPv6HopByHop    = 0
+	IPv6Routing     = 43
+	IPv6Fragment    = 44
+	IPv6AuthHeader  = 51
+	IPv6DestOptions = 60
+	// No header follows
+	IPv6NoNextHeader = 59
+)
+
+// TCP flags as laid out in the data offset/flags field of the header
+const (
+	TCPFlagFIN uint16 = 1 << iota
+	TCPFlagSYN
+	TCPFlagRST
+	TCPFlagPSH
+	TCPFlagACK
+	TCPFlagURG
+	TCPFlagECE
+	TCPFlagCWR
+	TCPFlagNS
+)
+
+// TCP option kinds
+// https://www.iana.org/assignments/tcp-parameters/tcp-parameters.xhtml
+const (
+	TCPOptionEnd           = 0
+	TCPOptionNOP           = 1
+	TCPOptionMSS           = 2
+	TCPOptionWindowScale   = 3
+	TCPOptionSACKPermitted = 4
+	TCPOptionSACK          = 5
+	TCPOptionTimestamps    = 8
+)
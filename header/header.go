@@ -18,7 +18,7 @@ type IPHeader interface {
 }
 
 // Represents a protocol header
-// Supported headers are TCP, UDP, ICMPv4, ICMPv6
+// Supported headers are TCP, UDP, UDP-Lite, ICMPv4, ICMPv6
 type ProtocolHeader interface {
 	String() string
 
@@ -31,19 +31,169 @@ type ProtocolHeader interface {
 	NeedNewChecksum() bool
 }
 
+// Names of the IANA protocol numbers, parsed or not
+// The IANA keywords are used, except for the protocols parsed by this package and IP in IP that
+// keep their historical names. 146 to 252 are unassigned.
+// See : https://www.iana.org/assignments/protocol-numbers/protocol-numbers.xhtml
+var protocolNames = map[uint8]string{
+	0:       "HOPOPT",
+	ICMPv4:  "ICMPv4",
+	2:       "IGMP",
+	3:       "GGP",
+	4:       "IPv4-in-IP",
+	5:       "ST",
+	TCP:     "TCP",
+	7:       "CBT",
+	8:       "EGP",
+	9:       "IGP",
+	10:      "BBN-RCC-MON",
+	11:      "NVP-II",
+	12:      "PUP",
+	13:      "ARGUS",
+	14:      "EMCON",
+	15:      "XNET",
+	16:      "CHAOS",
+	UDP:     "UDP",
+	18:      "MUX",
+	19:      "DCN-MEAS",
+	20:      "HMP",
+	21:      "PRM",
+	22:      "XNS-IDP",
+	23:      "TRUNK-1",
+	24:      "TRUNK-2",
+	25:      "LEAF-1",
+	26:      "LEAF-2",
+	27:      "RDP",
+	28:      "IRTP",
+	29:      "ISO-TP4",
+	30:      "NETBLT",
+	31:      "MFE-NSP",
+	32:      "MERIT-INP",
+	33:      "DCCP",
+	34:      "3PC",
+	35:      "IDPR",
+	36:      "XTP",
+	37:      "DDP",
+	38:      "IDPR-CMTP",
+	39:      "TP++",
+	40:      "IL",
+	41:      "IPv6-in-IP",
+	42:      "SDRP",
+	43:      "IPv6-Route",
+	44:      "IPv6-Frag",
+	45:      "IDRP",
+	46:      "RSVP",
+	47:      "GRE",
+	48:      "DSR",
+	49:      "BNA",
+	50:      "ESP",
+	51:      "AH",
+	52:      "I-NLSP",
+	53:      "SWIPE",
+	54:      "NARP",
+	55:      "Min-IPv4",
+	56:      "TLSP",
+	57:      "SKIP",
+	ICMPv6:  "ICMPv6",
+	59:      "IPv6-NoNxt",
+	60:      "IPv6-Opts",
+	61:      "Host Internal",
+	62:      "CFTP",
+	63:      "Local Network",
+	64:      "SAT-EXPAK",
+	65:      "KRYPTOLAN",
+	66:      "RVD",
+	67:      "IPPC",
+	68:      "Distributed File System",
+	69:      "SAT-MON",
+	70:      "VISA",
+	71:      "IPCV",
+	72:      "CPNX",
+	73:      "CPHB",
+	74:      "WSN",
+	75:      "PVP",
+	76:      "BR-SAT-MON",
+	77:      "SUN-ND",
+	78:      "WB-MON",
+	79:      "WB-EXPAK",
+	80:      "ISO-IP",
+	81:      "VMTP",
+	82:      "SECURE-VMTP",
+	83:      "VINES",
+	84:      "IPTM",
+	85:      "NSFNET-IGP",
+	86:      "DGP",
+	87:      "TCF",
+	88:      "EIGRP",
+	89:      "OSPF",
+	90:      "Sprite-RPC",
+	91:      "LARP",
+	92:      "MTP",
+	93:      "AX.25",
+	94:      "IPIP",
+	95:      "MICP",
+	96:      "SCC-SP",
+	97:      "EtherIP",
+	98:      "ENCAP",
+	99:      "Private Encryption",
+	100:     "GMTP",
+	101:     "IFMP",
+	102:     "PNNI",
+	103:     "PIM",
+	104:     "ARIS",
+	105:     "SCPS",
+	106:     "QNX",
+	107:     "A/N",
+	108:     "IPComp",
+	109:     "SNP",
+	110:     "Compaq-Peer",
+	111:     "IPX-in-IP",
+	112:     "VRRP",
+	113:     "PGM",
+	114:     "0-hop",
+	115:     "L2TP",
+	116:     "DDX",
+	117:     "IATP",
+	118:     "STP",
+	119:     "SRP",
+	120:     "UTI",
+	121:     "SMP",
+	122:     "SM",
+	123:     "PTP",
+	124:     "ISIS over IPv4",
+	125:     "FIRE",
+	126:     "CRTP",
+	127:     "CRUDP",
+	128:     "SSCOPMCE",
+	129:     "IPLT",
+	130:     "SPS",
+	131:     "PIPE",
+	132:     "SCTP",
+	133:     "FC",
+	134:     "RSVP-E2E-IGNORE",
+	135:     "Mobility",
+	UDPLite: "UDP-Lite",
+	137:     "MPLS-in-IP",
+	138:     "manet",
+	139:     "HIP",
+	140:     "Shim6",
+	141:     "WESP",
+	142:     "ROHC",
+	143:     "Ethernet",
+	144:     "AGGFRAG",
+	145:     "NSH",
+	253:     "Experimental",
+	254:     "Experimental",
+	255:     "Reserved",
+}
+
 // Returns the name of the given protocol number
+// The protocol doesn't have to be implemented by this package, the unassigned numbers
+// are named "Unimplemented Protocol"
 // See : https://en.wikipedia.org/wiki/List_of_IP_protocol_numbers
 func ProtocolName(protocol uint8) string {
-	switch protocol {
-	case ICMPv4:
-		return "ICMPv4"
-	case TCP:
-		return "TCP"
-	case UDP:
-		return "UDP"
-	case ICMPv6:
-		return "ICMPv6"
-	default:
-		return "Unimplemented Protocol"
+	if name, ok := protocolNames[protocol]; ok {
+		return name
 	}
+	return "Unimplemented Protocol"
 }
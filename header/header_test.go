@@ -0,0 +1,130 @@
+package header
+
+import "testing"
+
+func TestNewHeadersShortRaw(t *testing.T) {
+	tcp := make([]byte, TCPHeaderLen+4)
+	tcp[12] = 6 << 4
+
+	tests := []struct {
+		name        string
+		payload     func(raw []byte) []byte
+		raw         []byte
+		wantPayload int // -1 when Payload must be nil
+	}{
+		{"UDP", func(raw []byte) []byte { return NewUDPHeader(raw).Payload }, make([]byte, 12), 4},
+		{"UDP cut", func(raw []byte) []byte { return NewUDPHeader(raw).Payload }, make([]byte, 7), -1},
+		{"ICMPv4 cut", func(raw []byte) []byte { return NewICMPv4Header(raw).Payload }, make([]byte, 3), -1},
+		{"ICMPv6 cut", func(raw []byte) []byte { return NewICMPv6Header(raw).Payload }, nil, -1},
+		{"TCP with options", func(raw []byte) []byte { return NewTCPHeader(raw).Payload }, tcp, 0},
+		{"TCP options cut", func(raw []byte) []byte { return NewTCPHeader(raw).Payload }, tcp[:TCPHeaderLen+2], -1},
+		{"TCP cut", func(raw []byte) []byte { return NewTCPHeader(raw).Payload }, tcp[:10], -1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			payload := test.payload(test.raw)
+			if test.wantPayload < 0 {
+				if payload != nil {
+					t.Errorf("Payload = %x, want nil", payload)
+				}
+				return
+			}
+			if payload == nil || len(payload) != test.wantPayload {
+				t.Errorf("Payload = %x, want %d bytes", payload, test.wantPayload)
+			}
+		})
+	}
+}
+
+func TestNewIPHeadersShortRaw(t *testing.T) {
+	if h := NewIPv4Header(nil); len(h.Raw) != 0 {
+		t.Errorf("NewIPv4Header(nil).Raw = %x", h.Raw)
+	}
+	// 头部长度超过 raw 时截到 raw 的长度，不读 raw 之后的容量
+	ipv4 := make([]byte, 12, 60)
+	ipv4[0] = 0x4f
+	if h := NewIPv4Header(ipv4); len(h.Raw) != 12 {
+		t.Errorf("NewIPv4Header() of 12 bytes has %d bytes", len(h.Raw))
+	}
+	if h := NewIPv6Header(make([]byte, 30, 60)); len(h.Raw) != 30 {
+		t.Errorf("NewIPv6Header() of 30 bytes has %d bytes", len(h.Raw))
+	}
+}
+
+func TestIPv6TransportOffset(t *testing.T) {
+	fixed := func(next uint8, rest ...byte) []byte {
+		raw := make([]byte, IPv6HeaderLen, IPv6HeaderLen+len(rest))
+		raw[0], raw[6] = 0x60, next
+		return append(raw, rest...)
+	}
+	hopByHop := []byte{UDP, 0, 0, 0, 0, 0, 0, 0}
+	firstFragment := []byte{TCP, 0, 0, 0, 0, 0, 0, 1}
+	laterFragment := []byte{TCP, 0, 0, 8, 0, 0, 0, 1}
+	udp := make([]byte, UDPHeaderLen)
+
+	tests := []struct {
+		name       string
+		raw        []byte
+		wantOffset int
+		wantNext   uint8
+	}{
+		{"no extension", fixed(UDP, udp...), IPv6HeaderLen, UDP},
+		{"hop-by-hop", fixed(IPv6HopByHop, append(hopByHop, udp...)...), IPv6HeaderLen + 8, UDP},
+		{"first fragment", fixed(IPv6Fragment, firstFragment...), IPv6HeaderLen + 8, TCP},
+		{"later fragment", fixed(IPv6Fragment, laterFragment...), IPv6HeaderLen, IPv6Fragment},
+		{"extension header cut", fixed(IPv6HopByHop, hopByHop[:4]...), IPv6HeaderLen, IPv6HopByHop},
+		{"fixed header cut", fixed(UDP)[:20], 20, IPv6NoNextHeader},
+		{"empty", nil, 0, IPv6NoNextHeader},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			offset, next := IPv6TransportOffset(test.raw)
+			if offset != test.wantOffset || next != test.wantNext {
+				t.Errorf("IPv6TransportOffset() = %d, %d, want %d, %d", offset, next, test.wantOffset, test.wantNext)
+			}
+		})
+	}
+}
+
+func TestProtocolName(t *testing.T) {
+	tests := []struct {
+		protocol uint8
+		want     string
+	}{
+		{0, "HOPOPT"},
+		{ICMPv4, "ICMPv4"},
+		{TCP, "TCP"},
+		{UDP, "UDP"},
+		{41, "IPv6-in-IP"},
+		{47, "GRE"},
+		{50, "ESP"},
+		{ICMPv6, "ICMPv6"},
+		{IPv6NoNextHeader, "IPv6-NoNxt"},
+		{132, "SCTP"},
+		{UDPLite, "UDP-Lite"},
+		{145, "NSH"},
+		{146, "Unimplemented Protocol"},
+		{252, "Unimplemented Protocol"},
+		{253, "Experimental"},
+		{255, "Reserved"},
+	}
+
+	for _, test := range tests {
+		if got := ProtocolName(test.protocol); got != test.want {
+			t.Errorf("ProtocolName(%d) = %q, want %q", test.protocol, got, test.want)
+		}
+	}
+}
+
+func TestProtocolNameCoversIANA(t *testing.T) {
+	for protocol := 0; protocol <= 0xff; protocol++ {
+		name := ProtocolName(uint8(protocol))
+		// 146 到 252 未分配
+		unassigned := protocol >= 146 && protocol <= 252
+		if unassigned != (name == "Unimplemented Protocol") {
+			t.Errorf("ProtocolName(%d) = %q", protocol, name)
+		}
+	}
+}
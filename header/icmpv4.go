@@ -11,15 +11,20 @@ import (
 
 // Represents a ICMP header
 // https://en.wikipedia.org/wiki/Internet_Control_Message_Protocol#Header
+// Like UDPHeader, Raw holds the whole message and Payload the data following the 8 bytes header
 type ICMPv4Header struct {
 	Raw      []byte
 	Modified bool
+	Payload  []byte
 }
 
+// NewICMPv4Header creates a new ICMPv4Header from the message's bytes, Payload is nil if raw is shorter than the header
 func NewICMPv4Header(raw []byte) *ICMPv4Header {
-	return &ICMPv4Header{
-		Raw: raw,
+	h := &ICMPv4Header{Raw: raw}
+	if len(raw) >= ICMPv4HeaderLen {
+		h.Payload = raw[ICMPv4HeaderLen:]
 	}
+	return h
 }
 
 func (h *ICMPv4Header) String() string {
@@ -75,6 +80,49 @@ func (h *ICMPv4Header) Checksum() uint16 {
 	return binary.BigEndian.Uint16(h.Raw[2:4])
 }
 
+// Returns true if the message is an echo request or an echo reply
+func (h *ICMPv4Header) IsEcho() bool {
+	return h.Type() == ICMPEchoRequest || h.Type() == ICMPEchoReply
+}
+
+// Reads the header's bytes and returns the identifier (first half of the body)
+// Only meaningful for echo messages, see IsEcho
+func (h *ICMPv4Header) Identifier() uint16 {
+	return binary.BigEndian.Uint16(h.Raw[4:6])
+}
+
+func (h *ICMPv4Header) SetIdentifier(id uint16) {
+	h.Modified = true
+	binary.BigEndian.PutUint16(h.Raw[4:6], id)
+}
+
+// Reads the header's bytes and returns the sequence number (second half of the body)
+// Only meaningful for echo messages, see IsEcho
+func (h *ICMPv4Header) SequenceNumber() uint16 {
+	return binary.BigEndian.Uint16(h.Raw[6:8])
+}
+
+func (h *ICMPv4Header) SetSequenceNumber(seq uint16) {
+	h.Modified = true
+	binary.BigEndian.PutUint16(h.Raw[6:8], seq)
+}
+
+func (h *ICMPv4Header) GetPayload() []byte {
+	return h.Payload
+}
+
+// SetPayload sets the message payload and updates the Raw field accordingly.
+// When the length changes Raw is rebuilt, the packet must then be updated with Packet.SetPayload
+func (h *ICMPv4Header) SetPayload(val []byte) {
+	if len(val) == len(h.Payload) {
+		copy(h.Raw[ICMPv4HeaderLen:], val)
+	} else {
+		h.Raw = append(h.Raw[:ICMPv4HeaderLen], val...)
+	}
+	h.Payload = h.Raw[ICMPv4HeaderLen:]
+	h.Modified = true
+}
+
 // Returns the length of the header in bytes (8 bytes)
 func (h *ICMPv4Header) HeaderLen() int {
 	return ICMPv4HeaderLen
@@ -11,15 +11,20 @@ import (
 
 // Represents a ICMPv6 header
 // https://en.wikipedia.org/wiki/Internet_Control_Message_Protocol_for_IPv6#Message_types_and_formats
+// Like UDPHeader, Raw holds the whole message and Payload the data following the 8 bytes header
 type ICMPv6Header struct {
 	Raw      []byte
 	Modified bool
+	Payload  []byte
 }
 
+// NewICMPv6Header creates a new ICMPv6Header from the message's bytes, Payload is nil if raw is shorter than the header
 func NewICMPv6Header(raw []byte) *ICMPv6Header {
-	return &ICMPv6Header{
-		Raw: raw,
+	h := &ICMPv6Header{Raw: raw}
+	if len(raw) >= ICMPv6HeaderLen {
+		h.Payload = raw[ICMPv6HeaderLen:]
 	}
+	return h
 }
 
 func (h *ICMPv6Header) String() string {
@@ -75,6 +80,49 @@ func (h *ICMPv6Header) Checksum() uint16 {
 	return binary.BigEndian.Uint16(h.Raw[2:4])
 }
 
+// Returns true if the message is an echo request or an echo reply
+func (h *ICMPv6Header) IsEcho() bool {
+	return h.Type() == ICMPv6EchoRequest || h.Type() == ICMPv6EchoReply
+}
+
+// Reads the header's bytes and returns the identifier (first half of the body)
+// Only meaningful for echo messages, see IsEcho
+func (h *ICMPv6Header) Identifier() uint16 {
+	return binary.BigEndian.Uint16(h.Raw[4:6])
+}
+
+func (h *ICMPv6Header) SetIdentifier(id uint16) {
+	h.Modified = true
+	binary.BigEndian.PutUint16(h.Raw[4:6], id)
+}
+
+// Reads the header's bytes and returns the sequence number (second half of the body)
+// Only meaningful for echo messages, see IsEcho
+func (h *ICMPv6Header) SequenceNumber() uint16 {
+	return binary.BigEndian.Uint16(h.Raw[6:8])
+}
+
+func (h *ICMPv6Header) SetSequenceNumber(seq uint16) {
+	h.Modified = true
+	binary.BigEndian.PutUint16(h.Raw[6:8], seq)
+}
+
+func (h *ICMPv6Header) GetPayload() []byte {
+	return h.Payload
+}
+
+// SetPayload sets the message payload and updates the Raw field accordingly.
+// When the length changes Raw is rebuilt, the packet must then be updated with Packet.SetPayload
+func (h *ICMPv6Header) SetPayload(val []byte) {
+	if len(val) == len(h.Payload) {
+		copy(h.Raw[ICMPv6HeaderLen:], val)
+	} else {
+		h.Raw = append(h.Raw[:ICMPv6HeaderLen], val...)
+	}
+	h.Payload = h.Raw[ICMPv6HeaderLen:]
+	h.Modified = true
+}
+
 // Returns the length of the header in bytes (8 bytes)
 func (h *ICMPv6Header) HeaderLen() int {
 	return ICMPv6HeaderLen
@@ -13,8 +13,15 @@ type IPv4Header struct {
 	Modified bool
 }
 
+// NewIPv4Header creates a new IPv4Header, Raw is cut to the header length or to raw if it's shorter
 func NewIPv4Header(raw []byte) *IPv4Header {
-	hdrLen := (raw[0] & 0xf) << 2
+	if len(raw) == 0 {
+		return &IPv4Header{Raw: raw}
+	}
+	hdrLen := int(raw[0]&0xf) << 2
+	if hdrLen > len(raw) {
+		hdrLen = len(raw)
+	}
 	return &IPv4Header{
 		Raw: raw[:hdrLen],
 	}
@@ -59,6 +66,16 @@ func (h *IPv4Header) TOS() uint8 {
 	return h.Raw[1]
 }
 
+// Reads the header's bytes and returns the Differentiated Services Code Point (6 high bits of the TOS)
+func (h *IPv4Header) DSCP() uint8 {
+	return h.Raw[1] >> 2
+}
+
+// Reads the header's bytes and returns the Explicit Congestion Notification (2 low bits of the TOS)
+func (h *IPv4Header) ECN() uint8 {
+	return h.Raw[1] & 0x3
+}
+
 // Reads the header's bytes and returns the total length of the packet
 func (h *IPv4Header) TotalLen() uint16 {
 	return binary.BigEndian.Uint16(h.Raw[2:4])
@@ -69,14 +86,34 @@ func (h *IPv4Header) ID() uint16 {
 	return binary.BigEndian.Uint16(h.Raw[4:6])
 }
 
-// Reads the header's bytes and returns the flags
+// Same as ID
+func (h *IPv4Header) Identification() uint16 {
+	return h.ID()
+}
+
+// Reads the header's bytes and returns the flags (IPv4FlagDontFragment, IPv4FlagMoreFragments)
 func (h *IPv4Header) Flags() uint8 {
 	return h.Raw[6] >> 5
 }
 
-// Reads the header's bytes and returns the Fragment Offset
+// Returns true if the Don't Fragment flag is set
+func (h *IPv4Header) DontFragment() bool {
+	return h.Flags()&IPv4FlagDontFragment != 0
+}
+
+// Returns true if the More Fragments flag is set
+func (h *IPv4Header) MoreFragments() bool {
+	return h.Flags()&IPv4FlagMoreFragments != 0
+}
+
+// Reads the header's bytes and returns the Fragment Offset, in 8 bytes units
 func (h *IPv4Header) FragOff() uint16 {
-	return binary.BigEndian.Uint16(h.Raw[6:8]) & 0x7f
+	return binary.BigEndian.Uint16(h.Raw[6:8]) & 0x1fff
+}
+
+// Returns true if the packet is a fragment: More Fragments is set or the offset isn't 0
+func (h *IPv4Header) IsFragment() bool {
+	return h.MoreFragments() || h.FragOff() != 0
 }
 
 // Reads the header's bytes and returns the Time To Live of the packet
@@ -89,6 +126,11 @@ func (h *IPv4Header) NextHeader() uint8 {
 	return h.Raw[9]
 }
 
+// Same as NextHeader
+func (h *IPv4Header) Protocol() uint8 {
+	return h.NextHeader()
+}
+
 // Reads the header's bytes and returns the Checksum
 func (h *IPv4Header) Checksum() (uint16, error) {
 	return binary.BigEndian.Uint16(h.Raw[10:12]), nil
@@ -126,6 +168,36 @@ func (h *IPv4Header) SetDstIP(ip net.IP) {
 	copy(h.Raw[16:20], ip[12:16])
 }
 
+// Sets the Type Of Service byte (DSCP and ECN) of the packet
+func (h *IPv4Header) SetTOS(tos uint8) {
+	h.Modified = true
+	h.Raw[1] = tos
+}
+
+// Sets the Differentiated Services Code Point, the ECN bits are kept
+func (h *IPv4Header) SetDSCP(dscp uint8) {
+	h.Modified = true
+	h.Raw[1] = dscp<<2 | h.Raw[1]&0x3
+}
+
+// Sets the Explicit Congestion Notification bits, the DSCP is kept
+func (h *IPv4Header) SetECN(ecn uint8) {
+	h.Modified = true
+	h.Raw[1] = h.Raw[1]&^0x3 | ecn&0x3
+}
+
+// Sets the Time To Live of the packet
+func (h *IPv4Header) SetTTL(ttl uint8) {
+	h.Modified = true
+	h.Raw[8] = ttl
+}
+
+// Sets the ID of the packet
+func (h *IPv4Header) SetID(id uint16) {
+	h.Modified = true
+	binary.BigEndian.PutUint16(h.Raw[4:6], id)
+}
+
 func (h *IPv4Header) SetTotalLen(totalLength uint16) {
 	h.Modified = true
 	binary.BigEndian.PutUint16(h.Raw[2:4], totalLength)
@@ -0,0 +1,116 @@
+package header
+
+import "testing"
+
+func TestIPv4SetTOS(t *testing.T) {
+	tests := []struct {
+		tos       uint8
+		dscp, ecn uint8
+	}{
+		{0x00, 0, 0},
+		{0xb8, 46, 0}, // EF
+		{0x89, 34, 1}, // AF41, ECT(1)
+		{0xff, 63, 3},
+	}
+
+	for _, test := range tests {
+		raw := make([]byte, IPv4HeaderLen)
+		raw[0] = 0x45
+		h := NewIPv4Header(raw)
+		if h.NeedNewChecksum() {
+			t.Fatal("a new header needs a new checksum")
+		}
+
+		h.SetTOS(test.tos)
+		if h.TOS() != test.tos || raw[1] != test.tos {
+			t.Errorf("TOS() = %#x, byte %#x after SetTOS(%#x)", h.TOS(), raw[1], test.tos)
+		}
+		if h.DSCP() != test.dscp || h.ECN() != test.ecn {
+			t.Errorf("SetTOS(%#x): DSCP %d, ECN %d, want %d, %d", test.tos, h.DSCP(), h.ECN(), test.dscp, test.ecn)
+		}
+		if !h.NeedNewChecksum() {
+			t.Errorf("SetTOS(%#x) doesn't flag the checksum for recalculation", test.tos)
+		}
+	}
+}
+
+// A fragment of a UDP datagram sent with the Router Alert and Record Route options, IHL 8
+var ipv4WithOptions = []byte{
+	0x48, 0x2e, 0x00, 0x2c, // IHL 8, DSCP 11 ECN 2, total length 44
+	0xbe, 0xef, 0x20, 0x03, // ID 0xbeef, More Fragments, offset 3
+	0x40, UDP, 0x00, 0x00, // TTL 64
+	192, 168, 1, 10,
+	8, 8, 4, 4,
+	0x94, 0x04, 0x00, 0x00, // Router Alert
+	0x07, 0x07, 0x04, 0, 0, 0, 0, // Record Route, one empty slot
+	0x00, // End of Options List
+	0x30, 0x39, 0x00, 0x35, 0x00, 0x0c, 0x00, 0x00, 'd', 'a', 't', 'a',
+}
+
+func TestIPv4Accessors(t *testing.T) {
+	h := NewIPv4Header(append([]byte(nil), ipv4WithOptions...))
+
+	tests := []struct {
+		name string
+		got  any
+		want any
+	}{
+		{"Version", h.Version(), 4},
+		{"HeaderLen", h.HeaderLen(), uint8(32)},
+		{"DSCP", h.DSCP(), uint8(11)},
+		{"ECN", h.ECN(), uint8(2)},
+		{"TotalLen", h.TotalLen(), uint16(44)},
+		{"Identification", h.Identification(), uint16(0xbeef)},
+		{"Flags", h.Flags(), uint8(IPv4FlagMoreFragments)},
+		{"DontFragment", h.DontFragment(), false},
+		{"MoreFragments", h.MoreFragments(), true},
+		{"FragOff", h.FragOff(), uint16(3)},
+		{"IsFragment", h.IsFragment(), true},
+		{"TTL", h.TTL(), uint8(64)},
+		{"Protocol", h.Protocol(), uint8(UDP)},
+		{"SrcIP", h.SrcIP().String(), "192.168.1.10"},
+		{"DstIP", h.DstIP().String(), "8.8.4.4"},
+		{"Options", string(h.Options()), string(ipv4WithOptions[IPv4HeaderLen:32])},
+	}
+	for _, test := range tests {
+		if test.got != test.want {
+			t.Errorf("%s() = %v, want %v", test.name, test.got, test.want)
+		}
+	}
+
+	// 没有选项时返回 nil
+	raw := append([]byte{0x45}, ipv4WithOptions[1:IPv4HeaderLen]...)
+	if options := NewIPv4Header(raw).Options(); options != nil {
+		t.Errorf("Options() = %x without options, want nil", options)
+	}
+}
+
+func TestIPv4SettersMarkModified(t *testing.T) {
+	tests := []struct {
+		name  string
+		set   func(h *IPv4Header)
+		check func(h *IPv4Header) bool
+	}{
+		{"SetTTL", func(h *IPv4Header) { h.SetTTL(1) }, func(h *IPv4Header) bool { return h.TTL() == 1 }},
+		{"SetID", func(h *IPv4Header) { h.SetID(0x1234) }, func(h *IPv4Header) bool { return h.ID() == 0x1234 }},
+		{"SetDSCP", func(h *IPv4Header) { h.SetDSCP(46) }, func(h *IPv4Header) bool { return h.DSCP() == 46 && h.ECN() == 2 }},
+		{"SetECN", func(h *IPv4Header) { h.SetECN(3) }, func(h *IPv4Header) bool { return h.DSCP() == 11 && h.ECN() == 3 }},
+		{"SetTotalLen", func(h *IPv4Header) { h.SetTotalLen(40) }, func(h *IPv4Header) bool { return h.TotalLen() == 40 }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := NewIPv4Header(append([]byte(nil), ipv4WithOptions...))
+			test.set(h)
+			if !test.check(h) {
+				t.Errorf("%s didn't write the field", test.name)
+			}
+			if !h.NeedNewChecksum() {
+				t.Errorf("%s doesn't flag the checksum for recalculation", test.name)
+			}
+			if string(h.Options()) != string(ipv4WithOptions[IPv4HeaderLen:32]) {
+				t.Errorf("%s changed the options", test.name)
+			}
+		})
+	}
+}
@@ -14,7 +14,11 @@ type IPv6Header struct {
 	Modified bool
 }
 
+// NewIPv6Header creates a new IPv6Header, Raw is cut to the fixed header or to raw if it's shorter
 func NewIPv6Header(raw []byte) *IPv6Header {
+	if len(raw) < IPv6HeaderLen {
+		return &IPv6Header{Raw: raw}
+	}
 	return &IPv6Header{
 		Raw: raw[:IPv6HeaderLen],
 	}
@@ -54,6 +58,16 @@ func (h *IPv6Header) TrafficClass() uint8 {
 	return (h.Raw[0]&0xf)<<4 | (h.Raw[1] >> 4)
 }
 
+// Reads the header's bytes and returns the Differentiated Services Code Point (6 high bits of the traffic class)
+func (h *IPv6Header) DSCP() uint8 {
+	return h.TrafficClass() >> 2
+}
+
+// Reads the header's bytes and returns the Explicit Congestion Notification (2 low bits of the traffic class)
+func (h *IPv6Header) ECN() uint8 {
+	return h.TrafficClass() & 0x3
+}
+
 // Reads the header's bytes and returns the flow label
 func (h *IPv6Header) FlowLabel() uint32 {
 	return uint32(h.Raw[1]&0xf)<<16 | uint32(h.Raw[2])<<8 | uint32(h.Raw[3])
@@ -64,16 +78,100 @@ func (h *IPv6Header) PayloadLen() uint16 {
 	return binary.BigEndian.Uint16(h.Raw[4:6])
 }
 
+// Same as PayloadLen
+func (h *IPv6Header) PayloadLength() uint16 {
+	return h.PayloadLen()
+}
+
+// Sets the traffic class (DSCP and ECN), it spans the low nibble of Raw[0] and the high nibble of Raw[1]
+func (h *IPv6Header) SetTrafficClass(tc uint8) {
+	h.Modified = true
+	h.Raw[0] = h.Raw[0]&0xf0 | tc>>4
+	h.Raw[1] = tc<<4 | h.Raw[1]&0xf
+}
+
+// Sets the Differentiated Services Code Point, the ECN bits are kept
+func (h *IPv6Header) SetDSCP(dscp uint8) {
+	h.SetTrafficClass(dscp<<2 | h.ECN())
+}
+
+// Sets the Explicit Congestion Notification bits, the DSCP is kept
+func (h *IPv6Header) SetECN(ecn uint8) {
+	h.SetTrafficClass(h.TrafficClass()&^0x3 | ecn&0x3)
+}
+
+// Sets the 20 bits flow label, the higher bits of label are ignored
+func (h *IPv6Header) SetFlowLabel(label uint32) {
+	h.Modified = true
+	h.Raw[1] = h.Raw[1]&0xf0 | uint8(label>>16)&0xf
+	h.Raw[2] = uint8(label >> 8)
+	h.Raw[3] = uint8(label)
+}
+
+// Sets the length of the payload (extension headers included)
+func (h *IPv6Header) SetPayloadLength(length uint16) {
+	h.Modified = true
+	binary.BigEndian.PutUint16(h.Raw[4:6], length)
+}
+
 // Reads the header's bytes and returns the protocol number
 func (h *IPv6Header) NextHeader() uint8 {
 	return h.Raw[6]
 }
 
+// Walks the extension header chain following the fixed header in raw
+// Returns the offset of the upper-layer header and its protocol number.
+// The walk stops at a non-first fragment (the Fragment header offset and IPv6Fragment are returned then)
+// or at the first header that doesn't fit in raw. A raw shorter than the fixed header gives len(raw) and IPv6NoNextHeader.
+func IPv6TransportOffset(raw []byte) (int, uint8) {
+	offset := IPv6HeaderLen
+	if len(raw) < IPv6HeaderLen {
+		// 不完整的 IPv6 头部后面没有传输层头部
+		return len(raw), IPv6NoNextHeader
+	}
+	next := raw[6]
+	for {
+		switch next {
+		case IPv6HopByHop, IPv6Routing, IPv6DestOptions, IPv6AuthHeader, IPv6Fragment:
+		default:
+			return offset, next
+		}
+		if offset+8 > len(raw) {
+			return offset, next
+		}
+
+		var extLen int
+		switch next {
+		case IPv6Fragment:
+			// 非首个分片后面没有传输层头部
+			if binary.BigEndian.Uint16(raw[offset+2:offset+4])&0xfff8 != 0 {
+				return offset, next
+			}
+			extLen = 8
+		case IPv6AuthHeader:
+			extLen = (int(raw[offset+1]) + 2) << 2
+		default:
+			extLen = (int(raw[offset+1]) + 1) << 3
+		}
+		if offset+extLen > len(raw) {
+			return offset, next
+		}
+		next = raw[offset]
+		offset += extLen
+	}
+}
+
 // Reads the header's bytes and returns the hop limit
 func (h *IPv6Header) HopLimit() uint8 {
 	return h.Raw[7]
 }
 
+// Sets the hop limit of the packet
+func (h *IPv6Header) SetHopLimit(hopLimit uint8) {
+	h.Modified = true
+	h.Raw[7] = hopLimit
+}
+
 // Reads the header's bytes and returns the source IP
 func (h *IPv6Header) SrcIP() net.IP {
 	srcIP := make(net.IP, net.IPv6len)
@@ -0,0 +1,106 @@
+package header
+
+import "testing"
+
+// Returns a fixed IPv6 header with the given first four bytes
+func ipv6Header(b0, b1, b2, b3 byte) *IPv6Header {
+	raw := make([]byte, IPv6HeaderLen)
+	raw[0], raw[1], raw[2], raw[3] = b0, b1, b2, b3
+	raw[6], raw[7] = UDP, 64
+	return NewIPv6Header(raw)
+}
+
+func TestIPv6FlowLabel(t *testing.T) {
+	tests := []struct {
+		name  string
+		label uint32
+		bytes [3]byte
+	}{
+		{"zero", 0, [3]byte{0x00, 0x00, 0x00}},
+		{"high nibble only", 0xf0000, [3]byte{0x0f, 0x00, 0x00}},
+		{"across the three bytes", 0x12345, [3]byte{0x01, 0x23, 0x45}},
+		{"largest", 0xfffff, [3]byte{0x0f, 0xff, 0xff}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// 流标签的高 4 位和流量类别共用 Raw[1]，两者互不影响
+			h := ipv6Header(0x6a, 0xb0, 0, 0)
+			h.SetFlowLabel(test.label)
+
+			if h.FlowLabel() != test.label {
+				t.Errorf("FlowLabel() = %#x, want %#x", h.FlowLabel(), test.label)
+			}
+			if got := [3]byte{h.Raw[1] & 0xf, h.Raw[2], h.Raw[3]}; got != test.bytes {
+				t.Errorf("flow label bytes %x, want %x", got, test.bytes)
+			}
+			if h.Version() != 6 || h.TrafficClass() != 0xab {
+				t.Errorf("version %d, traffic class %#x after SetFlowLabel, want 6, 0xab", h.Version(), h.TrafficClass())
+			}
+			if !h.Modified {
+				t.Error("SetFlowLabel doesn't mark the header modified")
+			}
+		})
+	}
+
+	h := ipv6Header(0x60, 0, 0, 0)
+	h.SetFlowLabel(0xabc12345)
+	if h.FlowLabel() != 0x12345 || h.TrafficClass() != 0 {
+		t.Errorf("FlowLabel() = %#x, traffic class %#x, want the bits above 20 ignored", h.FlowLabel(), h.TrafficClass())
+	}
+}
+
+func TestIPv6TrafficClass(t *testing.T) {
+	tests := []struct {
+		name      string
+		tc        uint8
+		dscp, ecn uint8
+	}{
+		{"zero", 0x00, 0, 0},
+		{"EF", 0xb8, 46, 0},
+		{"AF41 ECT(1)", 0x89, 34, 1},
+		{"all set", 0xff, 63, 3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := ipv6Header(0x60, 0x0c, 0xde, 0xf0)
+			h.SetTrafficClass(test.tc)
+
+			if h.TrafficClass() != test.tc || h.DSCP() != test.dscp || h.ECN() != test.ecn {
+				t.Errorf("traffic class %#x, DSCP %d, ECN %d, want %#x, %d, %d", h.TrafficClass(), h.DSCP(), h.ECN(), test.tc, test.dscp, test.ecn)
+			}
+			if h.Version() != 6 || h.FlowLabel() != 0xcdef0 {
+				t.Errorf("version %d, flow label %#x after SetTrafficClass, want 6, 0xcdef0", h.Version(), h.FlowLabel())
+			}
+
+			h.SetDSCP(10)
+			if h.DSCP() != 10 || h.ECN() != test.ecn {
+				t.Errorf("SetDSCP(10): DSCP %d, ECN %d, want 10, %d", h.DSCP(), h.ECN(), test.ecn)
+			}
+			h.SetECN(2)
+			if h.DSCP() != 10 || h.ECN() != 2 {
+				t.Errorf("SetECN(2): DSCP %d, ECN %d, want 10, 2", h.DSCP(), h.ECN())
+			}
+		})
+	}
+}
+
+func TestIPv6HopLimitPayloadLength(t *testing.T) {
+	h := ipv6Header(0x60, 0, 0, 0)
+	h.SetHopLimit(1)
+	h.SetPayloadLength(0x1234)
+
+	if h.HopLimit() != 1 || h.Raw[7] != 1 {
+		t.Errorf("HopLimit() = %d, want 1", h.HopLimit())
+	}
+	if h.PayloadLength() != 0x1234 || h.PayloadLen() != 0x1234 || h.Raw[4] != 0x12 || h.Raw[5] != 0x34 {
+		t.Errorf("PayloadLength() = %#x, bytes %x, want 0x1234", h.PayloadLength(), h.Raw[4:6])
+	}
+	if h.NextHeader() != UDP || h.Version() != 6 {
+		t.Error("the setters changed the other fields")
+	}
+	if !h.Modified {
+		t.Error("the setters don't mark the header modified")
+	}
+}
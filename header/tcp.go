@@ -3,6 +3,7 @@ package header
 import (
 	"encoding/binary"
 	"fmt"
+	"strings"
 )
 
 // Represents a TCP header
@@ -14,12 +15,16 @@ type TCPHeader struct {
 }
 
 // NewTCPHeader creates a new TCPHeader with the given raw data.
+// Payload is nil if raw is shorter than the header length it announces.
 func NewTCPHeader(raw []byte) *TCPHeader {
-	hdrLen := (raw[12] >> 4) * 4
-	return &TCPHeader{
-		Raw:     raw,          // Raw 字段被赋值为整个 raw 切片。
-		Payload: raw[hdrLen:], // Payload 字段被赋值为 raw 切片从 hdrLen 开始到末尾的部分，这表示 TCP 负载数据。
+	h := &TCPHeader{Raw: raw} // Raw 字段被赋值为整个 raw 切片。
+	if len(raw) >= TCPHeaderLen {
+		// Payload 字段被赋值为 raw 切片从 hdrLen 开始到末尾的部分，这表示 TCP 负载数据。
+		if hdrLen := int(raw[12]>>4) * 4; hdrLen <= len(raw) {
+			h.Payload = raw[hdrLen:]
+		}
 	}
+	return h
 }
 
 func (h *TCPHeader) String() string {
@@ -179,6 +184,93 @@ func (h *TCPHeader) FIN() bool {
 	return h.Raw[13]&0x1 == 1
 }
 
+// Reads the header's bytes and returns the 9 flags as a combination of the TCPFlag* values
+func (h *TCPHeader) Flags() uint16 {
+	return uint16(h.Raw[12]&0x1)<<8 | uint16(h.Raw[13])
+}
+
+// Sets the 9 flags from a combination of the TCPFlag* values
+// The data offset and reserved bits sharing Raw[12] with NS are kept
+func (h *TCPHeader) SetFlags(flags uint16) {
+	h.Modified = true
+	h.Raw[12] = h.Raw[12]&0xfe | uint8(flags>>8)&0x1
+	h.Raw[13] = uint8(flags)
+}
+
+// Sets or clears one of the TCPFlag* values, leaving the other flags as they are
+func (h *TCPHeader) setFlag(flag uint16, set bool) {
+	if set {
+		h.SetFlags(h.Flags() | flag)
+	} else {
+		h.SetFlags(h.Flags() &^ flag)
+	}
+}
+
+// Sets or clears the NS flag, the data offset and reserved bits are kept
+func (h *TCPHeader) SetNS(set bool) {
+	h.setFlag(TCPFlagNS, set)
+}
+
+// Sets or clears the CWR flag, the data offset and reserved bits are kept
+func (h *TCPHeader) SetCWR(set bool) {
+	h.setFlag(TCPFlagCWR, set)
+}
+
+// Sets or clears the ECE flag, the data offset and reserved bits are kept
+func (h *TCPHeader) SetECE(set bool) {
+	h.setFlag(TCPFlagECE, set)
+}
+
+// Sets or clears the URG flag, the data offset and reserved bits are kept
+func (h *TCPHeader) SetURG(set bool) {
+	h.setFlag(TCPFlagURG, set)
+}
+
+// Sets or clears the ACK flag, the data offset and reserved bits are kept
+func (h *TCPHeader) SetACK(set bool) {
+	h.setFlag(TCPFlagACK, set)
+}
+
+// Sets or clears the PSH flag, the data offset and reserved bits are kept
+func (h *TCPHeader) SetPSH(set bool) {
+	h.setFlag(TCPFlagPSH, set)
+}
+
+// Sets or clears the RST flag, the data offset and reserved bits are kept
+func (h *TCPHeader) SetRST(set bool) {
+	h.setFlag(TCPFlagRST, set)
+}
+
+// Sets or clears the SYN flag, the data offset and reserved bits are kept
+func (h *TCPHeader) SetSYN(set bool) {
+	h.setFlag(TCPFlagSYN, set)
+}
+
+// Sets or clears the FIN flag, the data offset and reserved bits are kept
+func (h *TCPHeader) SetFIN(set bool) {
+	h.setFlag(TCPFlagFIN, set)
+}
+
+// Returns the names of the flags that are set joined with commas, e.g. "SYN,ACK"
+// SYN and FIN come first then the flags in the order of the header
+func (h *TCPHeader) FlagsString() string {
+	flags := []struct {
+		set  bool
+		name string
+	}{
+		{h.SYN(), "SYN"}, {h.FIN(), "FIN"}, {h.RST(), "RST"}, {h.PSH(), "PSH"}, {h.ACK(), "ACK"},
+		{h.URG(), "URG"}, {h.ECE(), "ECE"}, {h.CWR(), "CWR"}, {h.NS(), "NS"},
+	}
+
+	var names []string
+	for _, flag := range flags {
+		if flag.set {
+			names = append(names, flag.name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
 // END FLAGS
 
 // Reads the header's bytes and returns the window size
@@ -196,6 +288,24 @@ func (h *TCPHeader) UrgPtr() uint16 {
 	return binary.BigEndian.Uint16(h.Raw[18:20])
 }
 
+// Returns the urgent data of the segment or nil if the URG flag isn't set
+// Following RFC 6093 the urgent pointer is the offset of the byte following the urgent data,
+// it is capped to the payload length as a pointer beyond the segment is valid but carries no data here
+func (h *TCPHeader) UrgentData() []byte {
+	if !h.URG() {
+		return nil
+	}
+
+	urgLen := int(h.UrgPtr())
+	if urgLen == 0 {
+		return nil
+	}
+	if urgLen > len(h.Payload) {
+		urgLen = len(h.Payload)
+	}
+	return h.Payload[:urgLen]
+}
+
 // Reads the header's bytes and returns the options as a byte slice if they exist or nil
 func (h *TCPHeader) Options() []byte {
 	hdrLen := h.HeaderLen()
@@ -205,6 +315,92 @@ func (h *TCPHeader) Options() []byte {
 	return h.Raw[TCPHeaderLen:hdrLen]
 }
 
+// Represents a single TCP option
+// Data doesn't contain the kind and length bytes
+type TCPOption struct {
+	Kind uint8
+	Data []byte
+}
+
+// Reads the options and returns them one by one
+// NOP and End of Option List are skipped, parsing stops on a malformed option
+func (h *TCPHeader) ParsedOptions() []TCPOption {
+	options := h.Options()
+	var parsed []TCPOption
+
+	for i := 0; i < len(options); {
+		kind := options[i]
+		if kind == TCPOptionEnd {
+			break
+		}
+		if kind == TCPOptionNOP {
+			i++
+			continue
+		}
+		if i+1 >= len(options) {
+			break
+		}
+		optLen := int(options[i+1])
+		if optLen < 2 || i+optLen > len(options) {
+			break
+		}
+		parsed = append(parsed, TCPOption{
+			Kind: kind,
+			Data: options[i+2 : i+optLen],
+		})
+		i += optLen
+	}
+
+	return parsed
+}
+
+// Represents a selective acknowledgment block
+// Left is the first sequence number of the block, Right the one following its last byte
+// Duplicate is true for a D-SACK block (RFC 2883) reporting data received twice
+type SACKBlock struct {
+	Left      uint32
+	Right     uint32
+	Duplicate bool
+}
+
+// Reads the SACK option and returns its blocks or nil if there is none
+// Only the first block can be a D-SACK: it is either below the cumulative ACK
+// or contained in the second block
+func (h *TCPHeader) SACKBlocks() []SACKBlock {
+	var blocks []SACKBlock
+
+	for _, option := range h.ParsedOptions() {
+		if option.Kind != TCPOptionSACK {
+			continue
+		}
+		for i := 0; i+8 <= len(option.Data); i += 8 {
+			blocks = append(blocks, SACKBlock{
+				Left:  binary.BigEndian.Uint32(option.Data[i : i+4]),
+				Right: binary.BigEndian.Uint32(option.Data[i+4 : i+8]),
+			})
+		}
+		break
+	}
+
+	if len(blocks) == 0 {
+		return nil
+	}
+
+	first := blocks[0]
+	if h.ACK() && seqLessEqual(first.Right, h.AckNum()) {
+		blocks[0].Duplicate = true
+	} else if len(blocks) > 1 && seqLessEqual(blocks[1].Left, first.Left) && seqLessEqual(first.Right, blocks[1].Right) {
+		blocks[0].Duplicate = true
+	}
+
+	return blocks
+}
+
+// Compares two sequence numbers taking the wrap around into account
+func seqLessEqual(a, b uint32) bool {
+	return int32(a-b) <= 0
+}
+
 // Returns true if the header has been modified
 func (h *TCPHeader) NeedNewChecksum() bool {
 	return h.Modified
@@ -0,0 +1,234 @@
+package header
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// Returns an ACK segment acknowledging ack and carrying the given options, padded with NOPs
+func tcpWithOptions(ack uint32, options []byte) *TCPHeader {
+	for len(options)%4 != 0 {
+		options = append([]byte{TCPOptionNOP}, options...)
+	}
+	raw := make([]byte, TCPHeaderLen, TCPHeaderLen+len(options))
+	binary.BigEndian.PutUint32(raw[8:12], ack)
+	raw[12] = uint8(TCPHeaderLen+len(options)) / 4 << 4
+	raw[13] = uint8(TCPFlagACK)
+	return NewTCPHeader(append(raw, options...))
+}
+
+// Returns a SACK option holding the given edges, left and right of each block in turn
+func sackOption(edges ...uint32) []byte {
+	option := []byte{TCPOptionSACK, uint8(2 + 4*len(edges))}
+	for _, edge := range edges {
+		option = binary.BigEndian.AppendUint32(option, edge)
+	}
+	return option
+}
+
+func TestSACKBlocks(t *testing.T) {
+	timestamps := []byte{TCPOptionTimestamps, 10, 0, 0, 0, 1, 0, 0, 0, 2}
+
+	tests := []struct {
+		name string
+		tcp  *TCPHeader
+		want []SACKBlock
+	}{
+		{
+			name: "two blocks",
+			tcp:  tcpWithOptions(1000, sackOption(2000, 3000, 4000, 5000)),
+			want: []SACKBlock{{Left: 2000, Right: 3000}, {Left: 4000, Right: 5000}},
+		},
+		{
+			name: "after timestamps",
+			tcp:  tcpWithOptions(1000, append(timestamps, sackOption(2000, 3000)...)),
+			want: []SACKBlock{{Left: 2000, Right: 3000}},
+		},
+		{
+			name: "D-SACK below the cumulative ACK",
+			tcp:  tcpWithOptions(5000, sackOption(1000, 2000, 6000, 7000)),
+			want: []SACKBlock{{Left: 1000, Right: 2000, Duplicate: true}, {Left: 6000, Right: 7000}},
+		},
+		{
+			name: "D-SACK inside the second block",
+			tcp:  tcpWithOptions(1000, sackOption(3000, 3500, 2000, 4000)),
+			want: []SACKBlock{{Left: 3000, Right: 3500, Duplicate: true}, {Left: 2000, Right: 4000}},
+		},
+		{
+			name: "sequence numbers wrapping around",
+			tcp:  tcpWithOptions(0xfffffff0, sackOption(0xfffffff8, 0x10)),
+			want: []SACKBlock{{Left: 0xfffffff8, Right: 0x10}},
+		},
+		{
+			name: "no SACK option",
+			tcp:  tcpWithOptions(1000, timestamps),
+		},
+		{
+			name: "malformed option",
+			tcp:  tcpWithOptions(1000, []byte{TCPOptionSACK, 40, 0, 0}),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.tcp.SACKBlocks(); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("SACKBlocks() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestUrgentData(t *testing.T) {
+	// 返回带负载的段，urgent 为 true 时设置 URG 标志
+	segment := func(urgent bool, urgPtr uint16, payload string) *TCPHeader {
+		raw := make([]byte, TCPHeaderLen, TCPHeaderLen+len(payload))
+		raw[12] = TCPHeaderLen / 4 << 4
+		flags := TCPFlagACK | TCPFlagPSH
+		if urgent {
+			flags |= TCPFlagURG
+		}
+		raw[13] = uint8(flags)
+		binary.BigEndian.PutUint16(raw[18:20], urgPtr)
+		return NewTCPHeader(append(raw, payload...))
+	}
+
+	tests := []struct {
+		name string
+		tcp  *TCPHeader
+		want []byte
+	}{
+		{"urgent prefix", segment(true, 3, "abcdef"), []byte("abc")},
+		{"whole payload urgent", segment(true, 6, "abcdef"), []byte("abcdef")},
+		{"pointer past the payload", segment(true, 100, "abcdef"), []byte("abcdef")},
+		{"zero pointer", segment(true, 0, "abcdef"), nil},
+		{"URG not set", segment(false, 3, "abcdef"), nil},
+		{"no payload", segment(true, 3, ""), []byte{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.tcp.UrgentData(); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("UrgentData() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFlagsString(t *testing.T) {
+	tests := []struct {
+		flags uint16
+		want  string
+	}{
+		{TCPFlagSYN, "SYN"},
+		{TCPFlagSYN | TCPFlagACK, "SYN,ACK"},
+		{TCPFlagFIN | TCPFlagACK | TCPFlagPSH, "FIN,PSH,ACK"},
+		{TCPFlagRST, "RST"},
+		{TCPFlagSYN | TCPFlagECE | TCPFlagCWR, "SYN,ECE,CWR"},
+		{TCPFlagACK | TCPFlagURG | TCPFlagNS, "ACK,URG,NS"},
+		{0, ""},
+	}
+
+	for _, test := range tests {
+		raw := make([]byte, TCPHeaderLen)
+		raw[12] = TCPHeaderLen/4<<4 | uint8(test.flags>>8)
+		raw[13] = uint8(test.flags)
+		if got := NewTCPHeader(raw).FlagsString(); got != test.want {
+			t.Errorf("FlagsString() of %#x = %q, want %q", test.flags, got, test.want)
+		}
+	}
+}
+
+func TestTCPFlagSetters(t *testing.T) {
+	tests := []struct {
+		name string
+		set  func(h *TCPHeader, set bool)
+		get  func(h *TCPHeader) bool
+		flag uint16
+	}{
+		{"NS", (*TCPHeader).SetNS, (*TCPHeader).NS, TCPFlagNS},
+		{"CWR", (*TCPHeader).SetCWR, (*TCPHeader).CWR, TCPFlagCWR},
+		{"ECE", (*TCPHeader).SetECE, (*TCPHeader).ECE, TCPFlagECE},
+		{"URG", (*TCPHeader).SetURG, (*TCPHeader).URG, TCPFlagURG},
+		{"ACK", (*TCPHeader).SetACK, (*TCPHeader).ACK, TCPFlagACK},
+		{"PSH", (*TCPHeader).SetPSH, (*TCPHeader).PSH, TCPFlagPSH},
+		{"RST", (*TCPHeader).SetRST, (*TCPHeader).RST, TCPFlagRST},
+		{"SYN", (*TCPHeader).SetSYN, (*TCPHeader).SYN, TCPFlagSYN},
+		{"FIN", (*TCPHeader).SetFIN, (*TCPHeader).FIN, TCPFlagFIN},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// 带选项的段，data offset 为 8，保留位全部置 1
+			h := tcpWithOptions(1000, make([]byte, 12))
+			h.Raw[12] |= 0x0e
+			before := h.Flags()
+
+			test.set(h, true)
+			if !test.get(h) || h.Flags() != before|test.flag {
+				t.Errorf("Set%s(true): flags %#x, want %#x", test.name, h.Flags(), before|test.flag)
+			}
+			if h.DataOffset() != 8 || h.Reserved() != 0x7 {
+				t.Errorf("Set%s(true): data offset %d, reserved %#x, want 8, 0x7", test.name, h.DataOffset(), h.Reserved())
+			}
+			if !h.Modified {
+				t.Errorf("Set%s doesn't mark the header modified", test.name)
+			}
+
+			test.set(h, false)
+			if test.get(h) || h.Flags() != before&^test.flag {
+				t.Errorf("Set%s(false): flags %#x, want %#x", test.name, h.Flags(), before&^test.flag)
+			}
+			if h.DataOffset() != 8 || h.Reserved() != 0x7 {
+				t.Errorf("Set%s(false): data offset %d, reserved %#x, want 8, 0x7", test.name, h.DataOffset(), h.Reserved())
+			}
+		})
+	}
+}
+
+func TestTCPSetRSTKeepsDataOffset(t *testing.T) {
+	for _, dataOffset := range []uint8{5, 6, 15} {
+		raw := make([]byte, int(dataOffset)*4)
+		raw[12] = dataOffset << 4
+		raw[13] = uint8(TCPFlagACK)
+		h := NewTCPHeader(raw)
+
+		h.SetRST(true)
+		if h.DataOffset() != dataOffset || raw[12] != dataOffset<<4 {
+			t.Errorf("SetRST(true) changed the data offset %d to %d (byte %#x)", dataOffset, h.DataOffset(), raw[12])
+		}
+		if raw[13] != uint8(TCPFlagACK|TCPFlagRST) {
+			t.Errorf("flags byte %#x after SetRST(true), want %#x", raw[13], TCPFlagACK|TCPFlagRST)
+		}
+	}
+}
+
+func TestTCPSetFlags(t *testing.T) {
+	tests := []struct {
+		name  string
+		flags uint16
+		want  [2]byte
+	}{
+		{"SYN", TCPFlagSYN, [2]byte{0x8e, 0x02}},
+		{"RST,ACK", TCPFlagRST | TCPFlagACK, [2]byte{0x8e, 0x14}},
+		{"all nine", 0x1ff, [2]byte{0x8f, 0xff}},
+		{"none", 0, [2]byte{0x8e, 0x00}},
+		// 高于 9 位的部分被忽略
+		{"bits above NS", 0xfe00 | TCPFlagFIN, [2]byte{0x8e, 0x01}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := tcpWithOptions(1000, make([]byte, 12))
+			h.Raw[12] |= 0x0f
+			h.SetFlags(test.flags)
+
+			if got := [2]byte{h.Raw[12], h.Raw[13]}; got != test.want {
+				t.Errorf("SetFlags(%#x) bytes %x, want %x", test.flags, got, test.want)
+			}
+			if h.Flags() != test.flags&0x1ff || !h.Modified {
+				t.Errorf("Flags() = %#x, modified %v, want %#x and modified", h.Flags(), h.Modified, test.flags&0x1ff)
+			}
+		})
+	}
+}
@@ -7,15 +7,20 @@ import (
 
 // Represents a UDP header
 // https://en.wikipedia.org/wiki/User_Datagram_Protocol#Packet_structure
+// Like TCPHeader, Raw holds the whole datagram and Payload the data following the header
 type UDPHeader struct {
 	Raw      []byte
 	Modified bool
+	Payload  []byte
 }
 
+// NewUDPHeader creates a new UDPHeader from the datagram's bytes, Payload is nil if raw is shorter than the header
 func NewUDPHeader(raw []byte) *UDPHeader {
-	return &UDPHeader{
-		Raw: raw,
+	h := &UDPHeader{Raw: raw}
+	if len(raw) >= UDPHeaderLen {
+		h.Payload = raw[UDPHeaderLen:]
 	}
+	return h
 }
 
 func (h *UDPHeader) String() string {
@@ -72,6 +77,29 @@ func (h *UDPHeader) Len() uint16 {
 	return binary.BigEndian.Uint16(h.Raw[4:6])
 }
 
+// Reads the header and returns the length field, the UDP header and data in bytes
+// Same as Len
+func (h *UDPHeader) Length() uint16 {
+	return h.Len()
+}
+
+func (h *UDPHeader) GetPayload() []byte {
+	return h.Payload
+}
+
+// SetPayload sets the datagram payload and updates the Raw field and the length field accordingly.
+// When the length changes Raw is rebuilt, the packet must then be updated with Packet.UpdateUDPHeader
+func (h *UDPHeader) SetPayload(val []byte) {
+	if len(val) == len(h.Payload) {
+		copy(h.Raw[UDPHeaderLen:], val)
+	} else {
+		h.Raw = append(h.Raw[:UDPHeaderLen], val...)
+		binary.BigEndian.PutUint16(h.Raw[4:6], uint16(UDPHeaderLen+len(val)))
+	}
+	h.Payload = h.Raw[UDPHeaderLen:]
+	h.Modified = true
+}
+
 // Reads the header's bytes and returns the checksum
 func (h *UDPHeader) Checksum() uint16 {
 	return binary.BigEndian.Uint16(h.Raw[6:8])
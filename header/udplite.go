@@ -0,0 +1,93 @@
+package header
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Represents a UDP-Lite header
+// https://en.wikipedia.org/wiki/UDP-Lite#Protocol
+// The length field of UDP is replaced by the checksum coverage.
+// Note that WinDivertHelperCalcChecksums doesn't know about UDP-Lite,
+// if the header is modified the checksum must be fixed by the caller.
+type UDPLiteHeader struct {
+	Raw      []byte
+	Modified bool
+}
+
+func NewUDPLiteHeader(raw []byte) *UDPLiteHeader {
+	return &UDPLiteHeader{
+		Raw: raw,
+	}
+}
+
+func (h *UDPLiteHeader) String() string {
+	if h == nil {
+		return "<nil>"
+	}
+
+	srcPort, _ := h.SrcPort()
+	dstPort, _ := h.DstPort()
+
+	return fmt.Sprintf("{\n"+
+		"\t\tProtocol=UDP-Lite\n"+
+		"\t\tSrcPort=%d\n"+
+		"\t\tDstPort=%d\n"+
+		"\t\tHeaderLen=%d\n"+
+		"\t\tChecksumCoverage=%d\n"+
+		"\t\tChecksum=%#x\n"+
+		"\t}", srcPort, dstPort, h.HeaderLen(), h.ChecksumCoverage(), h.Checksum())
+}
+
+// Returns the length of the header in bytes (8 bytes)
+func (h *UDPLiteHeader) HeaderLen() int {
+	return UDPLiteHeaderLen
+}
+
+// Reads the header's bytes and returns the source port
+func (h *UDPLiteHeader) SrcPort() (uint16, error) {
+	return binary.BigEndian.Uint16(h.Raw[0:2]), nil
+}
+
+// Reads the header's bytes and returns the destination port
+func (h *UDPLiteHeader) DstPort() (uint16, error) {
+	return binary.BigEndian.Uint16(h.Raw[2:4]), nil
+}
+
+// Sets the source port
+func (h *UDPLiteHeader) SetSrcPort(port uint16) error {
+	h.Modified = true
+	h.Raw[0] = uint8(port >> 8)
+	h.Raw[1] = uint8(port & 0xff)
+	return nil
+}
+
+// Sets the destination port
+func (h *UDPLiteHeader) SetDstPort(port uint16) error {
+	h.Modified = true
+	h.Raw[2] = uint8(port >> 8)
+	h.Raw[3] = uint8(port & 0xff)
+	return nil
+}
+
+// Reads the header's bytes and returns the number of bytes covered by the checksum
+// 0 means the whole datagram is covered
+func (h *UDPLiteHeader) ChecksumCoverage() uint16 {
+	return binary.BigEndian.Uint16(h.Raw[4:6])
+}
+
+// Sets the checksum coverage
+func (h *UDPLiteHeader) SetChecksumCoverage(coverage uint16) {
+	h.Modified = true
+	binary.BigEndian.PutUint16(h.Raw[4:6], coverage)
+}
+
+// Reads the header's bytes and returns the checksum
+func (h *UDPLiteHeader) Checksum() uint16 {
+	return binary.BigEndian.Uint16(h.Raw[6:8])
+}
+
+// Returns true if the header has been modified
+func (h *UDPLiteHeader) NeedNewChecksum() bool {
+	return h.Modified
+}
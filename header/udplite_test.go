@@ -0,0 +1,66 @@
+package header
+
+import "testing"
+
+func TestUDPLiteHeader(t *testing.T) {
+	// 源端口 5004，目的端口 5005，覆盖 8 字节，校验和 0xbeef
+	raw := []byte{0x13, 0x8c, 0x13, 0x8d, 0x00, 0x08, 0xbe, 0xef, 'r', 't', 'p'}
+	h := NewUDPLiteHeader(raw)
+
+	src, _ := h.SrcPort()
+	dst, _ := h.DstPort()
+	if src != 5004 || dst != 5005 {
+		t.Errorf("ports %d, %d, want 5004, 5005", src, dst)
+	}
+	if h.ChecksumCoverage() != 8 || h.Checksum() != 0xbeef || h.HeaderLen() != UDPLiteHeaderLen {
+		t.Errorf("coverage %d, checksum %#x, header length %d", h.ChecksumCoverage(), h.Checksum(), h.HeaderLen())
+	}
+	if h.NeedNewChecksum() {
+		t.Error("a header just parsed needs a new checksum")
+	}
+
+	h.SetSrcPort(40000)
+	h.SetDstPort(443)
+	src, _ = h.SrcPort()
+	dst, _ = h.DstPort()
+	if src != 40000 || dst != 443 || raw[0] != 0x9c || raw[1] != 0x40 || raw[2] != 0x01 || raw[3] != 0xbb {
+		t.Errorf("ports %d, %d, bytes %x after the setters, want 40000, 443", src, dst, raw[0:4])
+	}
+	if !h.NeedNewChecksum() {
+		t.Error("the port setters don't mark the header modified")
+	}
+}
+
+func TestUDPLiteChecksumCoverage(t *testing.T) {
+	tests := []struct {
+		name     string
+		coverage uint16
+		bytes    [2]byte
+	}{
+		// 0 表示校验和覆盖整个数据报
+		{"whole datagram", 0, [2]byte{0x00, 0x00}},
+		{"header only", UDPLiteHeaderLen, [2]byte{0x00, 0x08}},
+		{"header and part of the payload", 20, [2]byte{0x00, 0x14}},
+		{"largest", 0xffff, [2]byte{0xff, 0xff}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			raw := []byte{0x13, 0x8c, 0x13, 0x8d, 0x12, 0x34, 0xbe, 0xef}
+			h := NewUDPLiteHeader(raw)
+			h.SetChecksumCoverage(test.coverage)
+
+			if h.ChecksumCoverage() != test.coverage || [2]byte{raw[4], raw[5]} != test.bytes {
+				t.Errorf("ChecksumCoverage() = %d, bytes %x, want %d", h.ChecksumCoverage(), raw[4:6], test.coverage)
+			}
+			src, _ := h.SrcPort()
+			dst, _ := h.DstPort()
+			if src != 5004 || dst != 5005 || h.Checksum() != 0xbeef {
+				t.Errorf("ports %d, %d, checksum %#x after SetChecksumCoverage, want 5004, 5005, 0xbeef", src, dst, h.Checksum())
+			}
+			if !h.NeedNewChecksum() {
+				t.Error("SetChecksumCoverage doesn't mark the header modified")
+			}
+		})
+	}
+}
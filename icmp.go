@@ -0,0 +1,115 @@
+package godivert
+
+import (
+	"examples/header"
+	"fmt"
+)
+
+// Accessors shared by ICMPv4Header and ICMPv6Header
+type icmpHeader interface {
+	Type() uint8
+	Code() uint8
+	Checksum() uint16
+	IsEcho() bool
+	Identifier() uint16
+	SetIdentifier(id uint16)
+	SequenceNumber() uint16
+	SetSequenceNumber(seq uint16)
+}
+
+// Returns the ICMPv4 or ICMPv6 header of the packet, an error for the other protocols
+func (p *Packet) icmpHeader() (icmpHeader, error) {
+	p.VerifyParsed()
+
+	switch nextHdr := p.NextHeader.(type) {
+	case *header.ICMPv4Header:
+		return nextHdr, nil
+	case *header.ICMPv6Header:
+		return nextHdr, nil
+	}
+	return nil, fmt.Errorf("cannot read ICMP fields on protocolID=%d, packet isn't ICMP", p.nextHeaderType)
+}
+
+// Returns the echo header of the packet, an error if it isn't an ICMP echo request or reply
+func (p *Packet) icmpEchoHeader() (icmpHeader, error) {
+	icmpHdr, err := p.icmpHeader()
+	if err != nil {
+		return nil, err
+	}
+	if !icmpHdr.IsEcho() {
+		return nil, fmt.Errorf("cannot read echo fields on ICMP type %d, packet isn't an echo message", icmpHdr.Type())
+	}
+	return icmpHdr, nil
+}
+
+// Returns the ICMP type of the packet
+// Shortcut for NextHeader.Type(), returns an error if the packet isn't ICMPv4 or ICMPv6
+func (p *Packet) ICMPType() (uint8, error) {
+	icmpHdr, err := p.icmpHeader()
+	if err != nil {
+		return 0, err
+	}
+	return icmpHdr.Type(), nil
+}
+
+// Returns the ICMP code of the packet
+// Shortcut for NextHeader.Code(), returns an error if the packet isn't ICMPv4 or ICMPv6
+func (p *Packet) ICMPCode() (uint8, error) {
+	icmpHdr, err := p.icmpHeader()
+	if err != nil {
+		return 0, err
+	}
+	return icmpHdr.Code(), nil
+}
+
+// Returns the ICMP checksum of the packet
+// Shortcut for NextHeader.Checksum(), returns an error if the packet isn't ICMPv4 or ICMPv6
+func (p *Packet) ICMPChecksum() (uint16, error) {
+	icmpHdr, err := p.icmpHeader()
+	if err != nil {
+		return 0, err
+	}
+	return icmpHdr.Checksum(), nil
+}
+
+// Returns the identifier of the echo request or reply
+// Returns an error if the packet isn't an ICMPv4 or ICMPv6 echo message
+func (p *Packet) ICMPIdentifier() (uint16, error) {
+	icmpHdr, err := p.icmpEchoHeader()
+	if err != nil {
+		return 0, err
+	}
+	return icmpHdr.Identifier(), nil
+}
+
+// Sets the identifier of the echo request or reply, the header is marked as modified
+// Returns an error if the packet isn't an ICMPv4 or ICMPv6 echo message
+func (p *Packet) SetICMPIdentifier(id uint16) error {
+	icmpHdr, err := p.icmpEchoHeader()
+	if err != nil {
+		return err
+	}
+	icmpHdr.SetIdentifier(id)
+	return nil
+}
+
+// Returns the sequence number of the echo request or reply
+// Returns an error if the packet isn't an ICMPv4 or ICMPv6 echo message
+func (p *Packet) ICMPSequenceNumber() (uint16, error) {
+	icmpHdr, err := p.icmpEchoHeader()
+	if err != nil {
+		return 0, err
+	}
+	return icmpHdr.SequenceNumber(), nil
+}
+
+// Sets the sequence number of the echo request or reply, the header is marked as modified
+// Returns an error if the packet isn't an ICMPv4 or ICMPv6 echo message
+func (p *Packet) SetICMPSequenceNumber(seq uint16) error {
+	icmpHdr, err := p.icmpEchoHeader()
+	if err != nil {
+		return err
+	}
+	icmpHdr.SetSequenceNumber(seq)
+	return nil
+}
@@ -0,0 +1,76 @@
+package godivert
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// Size of the buffers the Format* helpers write the address in, an IPv6 address takes at most 45 characters
+const ipAddressStrLen = 64
+
+// Parses an IPv4 address by calling WinDivertHelperParseIPv4Address
+// The address is in host byte order, the way WinDivert compares ip.SrcAddr and ip.DstAddr in filters
+// https://reqrypt.org/windivert-doc.html#divert_helper_parse_ipv4_address
+func ParseIPv4(s string) (uint32, error) {
+	strPtr, err := syscall.BytePtrFromString(s)
+	if err != nil {
+		return 0, err
+	}
+
+	var addr uint32
+	success, _, _ := winDivertHelperParseIPv4Address.Call(
+		uintptr(unsafe.Pointer(strPtr)),
+		uintptr(unsafe.Pointer(&addr)))
+	if success == 0 {
+		return 0, fmt.Errorf("cannot parse IPv4 address %q", s)
+	}
+	return addr, nil
+}
+
+// Parses an IPv6 address by calling WinDivertHelperParseIPv6Address
+// The address is 4 UINT32 in host byte order, the way WinDivert stores it in its filter objects
+// https://reqrypt.org/windivert-doc.html#divert_helper_parse_ipv6_address
+func ParseIPv6(s string) ([4]uint32, error) {
+	var addr [4]uint32
+	strPtr, err := syscall.BytePtrFromString(s)
+	if err != nil {
+		return addr, err
+	}
+
+	success, _, _ := winDivertHelperParseIPv6Address.Call(
+		uintptr(unsafe.Pointer(strPtr)),
+		uintptr(unsafe.Pointer(&addr[0])))
+	if success == 0 {
+		return [4]uint32{}, fmt.Errorf("cannot parse IPv6 address %q", s)
+	}
+	return addr, nil
+}
+
+// Formats an IPv4 address in host byte order (see ParseIPv4) by calling WinDivertHelperFormatIPv4Address
+// https://reqrypt.org/windivert-doc.html#divert_helper_format_ipv4_address
+func FormatIPv4(addr uint32) (string, error) {
+	var buffer [ipAddressStrLen]byte
+	success, _, err := winDivertHelperFormatIPv4Address.Call(
+		uintptr(addr),
+		uintptr(unsafe.Pointer(&buffer[0])),
+		uintptr(len(buffer)))
+	if success == 0 {
+		return "", fmt.Errorf("cannot format IPv4 address %d: %w", addr, err)
+	}
+	return cString(&buffer[0]), nil
+}
+
+// Formats an IPv6 address in host byte order (see ParseIPv6) by calling WinDivertHelperFormatIPv6Address
+// https://reqrypt.org/windivert-doc.html#divert_helper_format_ipv6_address
+func FormatIPv6(addr [4]uint32) (string, error) {
+	var buffer [ipAddressStrLen]byte
+	success, _, err := winDivertHelperFormatIPv6Address.Call(
+		uintptr(unsafe.Pointer(&addr[0])),
+		uintptr(unsafe.Pointer(&buffer[0])),
+		uintptr(len(buffer)))
+	if success == 0 {
+		return "", fmt.Errorf("cannot format IPv6 address %v: %w", addr, err)
+	}
+	return cString(&buffer[0]), nil
+}
@@ -0,0 +1,91 @@
+package godivert
+
+import (
+	"examples/header"
+	"math/rand/v2"
+	"sync"
+)
+
+// How IPIDNormalizer rewrites the IPv4 identification
+type IPIDStrategy int
+
+const (
+	// Sets the ID to 0, only applied to DF packets (RFC 6864 allows any ID on atomic datagrams)
+	IPIDZero IPIDStrategy = iota
+	// Sets a random ID
+	IPIDRandom
+	// Sets a counter kept per flow and starting at a random value,
+	// the IDs no longer reveal the host's global counter or how many hosts share an address
+	IPIDPerFlow
+)
+
+// Rewrites the identification of the IPv4 packets to defeat IP ID based fingerprinting and host counting
+// Fragments are never rewritten, their ID is what ties them together.
+// It is safe for concurrent use.
+type IPIDNormalizer struct {
+	Strategy IPIDStrategy
+
+	mu       sync.Mutex
+	counters map[FlowKey]uint16
+}
+
+func NewIPIDNormalizer(strategy IPIDStrategy) *IPIDNormalizer {
+	return &IPIDNormalizer{
+		Strategy: strategy,
+		counters: make(map[FlowKey]uint16),
+	}
+}
+
+// Rewrites the ID of the packet following the strategy, IPv6 packets are left untouched
+// The IPv4 header is marked as modified so Send recalculates the checksum
+func (n *IPIDNormalizer) Normalize(p *Packet) {
+	p.VerifyParsed()
+
+	ipv4Hdr, ok := p.IpHdr.(*header.IPv4Header)
+	if !ok {
+		return
+	}
+
+	// MF 标志或片偏移不为 0 表示分片
+	if ipv4Hdr.IsFragment() {
+		return
+	}
+
+	switch n.Strategy {
+	case IPIDZero:
+		if ipv4Hdr.DontFragment() && ipv4Hdr.ID() != 0 {
+			ipv4Hdr.SetID(0)
+		}
+	case IPIDRandom:
+		ipv4Hdr.SetID(uint16(rand.Uint32()))
+	case IPIDPerFlow:
+		ipv4Hdr.SetID(n.next(p.FlowKey()))
+	}
+}
+
+// Returns the next ID of the flow
+func (n *IPIDNormalizer) next(key FlowKey) uint16 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.counters == nil {
+		n.counters = make(map[FlowKey]uint16)
+	}
+
+	id, ok := n.counters[key]
+	if ok {
+		id++
+	} else {
+		id = uint16(rand.Uint32())
+	}
+	n.counters[key] = id
+	return id
+}
+
+// Forgets the counters of every flow, e.g. periodically to bound the memory used
+func (n *IPIDNormalizer) Reset() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.counters = make(map[FlowKey]uint16)
+}
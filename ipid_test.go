@@ -0,0 +1,111 @@
+package godivert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"examples/header"
+	"testing"
+)
+
+// Returns a parsed IPv4 UDP packet with the ID 0x1234, flags are the high bits of the fragment field
+func ipidPacket(srcPort uint16, flags uint8) *Packet {
+	raw := buildIPv4(header.UDP, udpBytes(srcPort, 53, nil))
+	raw[6] |= flags
+	binary.BigEndian.PutUint16(raw[10:12], 0)
+	binary.BigEndian.PutUint16(raw[10:12], foldChecksum(sumBytes(raw[:header.IPv4HeaderLen], 0)))
+	packet := NewPacket(raw, NewAddress())
+	packet.ParseHeaders()
+	return packet
+}
+
+func ipID(p *Packet) uint16 {
+	return p.IpHdr.(*header.IPv4Header).ID()
+}
+
+func TestIPIDNormalizerZero(t *testing.T) {
+	const dontFragment, moreFragments = 0x40, 0x20
+
+	tests := []struct {
+		name   string
+		flags  uint8
+		wantID uint16
+	}{
+		{"DF packet", dontFragment, 0},
+		{"without DF", 0, 0x1234},
+		{"fragment", moreFragments, 0x1234},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			packet := ipidPacket(1234, test.flags)
+			NewIPIDNormalizer(IPIDZero).Normalize(packet)
+			if got := ipID(packet); got != test.wantID {
+				t.Errorf("ID %#x, want %#x", got, test.wantID)
+			}
+			if modified := packet.needNewChecksum(); modified != (test.wantID != 0x1234) {
+				t.Errorf("checksum to recalculate %v", modified)
+			}
+			if err := HelperCalcChecksumBatch([]*Packet{packet}); err != nil {
+				t.Fatal(err)
+			}
+			if ip, _ := checksumsValid(packet.Raw); !ip {
+				t.Error("invalid IP checksum after the recalculation")
+			}
+		})
+	}
+}
+
+func TestIPIDNormalizerRandom(t *testing.T) {
+	n := NewIPIDNormalizer(IPIDRandom)
+	ids := make(map[uint16]bool)
+	for i := 0; i < 32; i++ {
+		packet := ipidPacket(1234, 0)
+		n.Normalize(packet)
+		ids[ipID(packet)] = true
+	}
+	// 32 个随机值全部相同的概率可以忽略
+	if len(ids) < 2 {
+		t.Errorf("IDs %v, want random values", ids)
+	}
+
+	fragment := ipidPacket(1234, 0x20)
+	n.Normalize(fragment)
+	if ipID(fragment) != 0x1234 {
+		t.Error("a fragment's ID has been rewritten")
+	}
+}
+
+func TestIPIDNormalizerPerFlow(t *testing.T) {
+	n := NewIPIDNormalizer(IPIDPerFlow)
+	var first, second []uint16
+	for i := 0; i < 4; i++ {
+		a, b := ipidPacket(1000, 0), ipidPacket(2000, 0)
+		n.Normalize(a)
+		n.Normalize(b)
+		first, second = append(first, ipID(a)), append(second, ipID(b))
+	}
+
+	for _, ids := range [][]uint16{first, second} {
+		for i := 1; i < len(ids); i++ {
+			if ids[i] != ids[i-1]+1 {
+				t.Fatalf("IDs %v of a flow, want a counter", ids)
+			}
+		}
+	}
+
+	n.Reset()
+	if len(n.counters) != 0 {
+		t.Errorf("%d counters left after Reset", len(n.counters))
+	}
+}
+
+func TestIPIDNormalizerIPv6(t *testing.T) {
+	raw := buildIPv6(header.UDP, udpBytes(1234, 53, nil))
+	want := append([]byte(nil), raw...)
+	for _, strategy := range []IPIDStrategy{IPIDZero, IPIDRandom, IPIDPerFlow} {
+		NewIPIDNormalizer(strategy).Normalize(NewPacket(raw, nil))
+		if !bytes.Equal(raw, want) {
+			t.Fatalf("strategy %d changed an IPv6 packet", strategy)
+		}
+	}
+}
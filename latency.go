@@ -0,0 +1,97 @@
+package godivert
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// Default upper bounds of the latency histogram
+var DefaultLatencyBounds = []time.Duration{
+	10 * time.Microsecond,
+	50 * time.Microsecond,
+	100 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+}
+
+// A bucket of the latency histogram
+// Count is the number of packets whose latency is at most UpperBound and above the previous bucket's bound
+// The last bucket catches everything else, its UpperBound is math.MaxInt64
+type Bucket struct {
+	UpperBound time.Duration
+	Count      uint64
+}
+
+type latencyHistogram struct {
+	bounds []time.Duration
+	counts []atomic.Uint64
+}
+
+func newLatencyHistogram(bounds []time.Duration) *latencyHistogram {
+	if len(bounds) == 0 {
+		bounds = DefaultLatencyBounds
+	}
+	h := &latencyHistogram{
+		bounds: append(append([]time.Duration(nil), bounds...), math.MaxInt64),
+	}
+	h.counts = make([]atomic.Uint64, len(h.bounds))
+	return h
+}
+
+func (h *latencyHistogram) observe(latency time.Duration) {
+	for i, bound := range h.bounds {
+		if latency <= bound {
+			h.counts[i].Add(1)
+			return
+		}
+	}
+}
+
+// Records the latency of a packet received at timestamp and sent at now, both performance counter values
+func (h *latencyHistogram) observeTimestamp(timestamp, now int64) {
+	h.observe(qpcDuration(now - timestamp))
+}
+
+func (h *latencyHistogram) buckets() []Bucket {
+	buckets := make([]Bucket, len(h.bounds))
+	for i, bound := range h.bounds {
+		buckets[i] = Bucket{UpperBound: bound, Count: h.counts[i].Load()}
+	}
+	return buckets
+}
+
+// Starts recording, for every packet sent on the handle, the time elapsed between
+// the packet's timestamp (set by WinDivert on capture) and the moment just before it is injected.
+// bounds are the sorted upper bounds of the histogram buckets, DefaultLatencyBounds if empty.
+// Calling it again resets the histogram. Packets without address are not recorded.
+func (wd *WinDivertHandle) EnableLatencyTracking(bounds []time.Duration) {
+	wd.latency.Store(newLatencyHistogram(bounds))
+}
+
+// Stops recording the latency
+func (wd *WinDivertHandle) DisableLatencyTracking() {
+	wd.latency.Store(nil)
+}
+
+// Returns the latency histogram or nil if the tracking isn't enabled
+func (wd *WinDivertHandle) LatencyHistogram() []Bucket {
+	h := wd.latency.Load()
+	if h == nil {
+		return nil
+	}
+	return h.buckets()
+}
+
+// Records the latency of the packet if the tracking is enabled
+func (wd *WinDivertHandle) observeLatency(packet *Packet) {
+	h := wd.latency.Load()
+	if h == nil || packet.Addr == nil || packet.Addr.Timestamp == 0 {
+		return
+	}
+	h.observeTimestamp(packet.Addr.Timestamp, qpcNow())
+}
@@ -0,0 +1,76 @@
+package godivert
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Replaces the performance counter until the test ends: it ticks every 100ns and always reads now
+func mockPerfCounter(t *testing.T, now int64) {
+	savedNow, savedFrequency := qpcNow, qpcFrequency
+	t.Cleanup(func() { qpcNow, qpcFrequency = savedNow, savedFrequency })
+
+	qpcNow = func() int64 { return now }
+	qpcFrequency = func() int64 { return 10_000_000 }
+}
+
+func TestLatencyHistogram(t *testing.T) {
+	const now = 1_000_000_000
+	mockPerfCounter(t, now)
+	driver := newFakeDriver(t)
+	wd := openFake(t, OpenOptions{})
+
+	if buckets := wd.LatencyHistogram(); buckets != nil {
+		t.Fatalf("LatencyHistogram() = %v before EnableLatencyTracking, want nil", buckets)
+	}
+	wd.EnableLatencyTracking([]time.Duration{10 * time.Microsecond, time.Millisecond})
+
+	latencies := []time.Duration{5 * time.Microsecond, 10 * time.Microsecond, 500 * time.Microsecond, 2 * time.Millisecond, time.Second}
+	for _, latency := range latencies {
+		addr := NewAddress()
+		addr.Timestamp = now - int64(latency/100)
+		if _, err := wd.Send(NewPacket(ipv4Packet(20), addr)); err != nil {
+			t.Fatalf("Send() = %v", err)
+		}
+	}
+	// 没有时间戳的包不统计
+	if _, err := wd.Send(NewPacket(ipv4Packet(20), NewAddress())); err != nil {
+		t.Fatalf("Send() = %v", err)
+	}
+
+	want := []Bucket{
+		{UpperBound: 10 * time.Microsecond, Count: 2},
+		{UpperBound: time.Millisecond, Count: 1},
+		{UpperBound: math.MaxInt64, Count: 2},
+	}
+	if got := wd.LatencyHistogram(); !reflect.DeepEqual(got, want) {
+		t.Errorf("LatencyHistogram() = %v, want %v", got, want)
+	}
+	if n := len(driver.injected()); n != len(latencies)+1 {
+		t.Errorf("%d packets injected, want %d", n, len(latencies)+1)
+	}
+
+	wd.DisableLatencyTracking()
+	if buckets := wd.LatencyHistogram(); buckets != nil {
+		t.Errorf("LatencyHistogram() = %v after DisableLatencyTracking, want nil", buckets)
+	}
+}
+
+func TestLatencyHistogramDefaultBounds(t *testing.T) {
+	mockPerfCounter(t, 0)
+	newFakeDriver(t)
+	wd := openFake(t, OpenOptions{})
+
+	wd.EnableLatencyTracking(nil)
+	buckets := wd.LatencyHistogram()
+	if len(buckets) != len(DefaultLatencyBounds)+1 {
+		t.Fatalf("%d buckets, want %d", len(buckets), len(DefaultLatencyBounds)+1)
+	}
+	for i, bound := range DefaultLatencyBounds {
+		if buckets[i].UpperBound != bound || buckets[i].Count != 0 {
+			t.Errorf("bucket %d is %v, want an empty bucket up to %v", i, buckets[i], bound)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package godivert
+
+import (
+	"examples/header"
+	"net"
+)
+
+// An IP address of a local interface
+type localAddr struct {
+	ip    net.IP
+	ifIdx uint32
+}
+
+// Returns the IP addresses of the local interfaces with their index, replaced in the tests
+var interfaceAddrs = func() ([]localAddr, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	var local []localAddr
+	for _, iface := range interfaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			switch a := addr.(type) {
+			case *net.IPNet:
+				local = append(local, localAddr{ip: a.IP, ifIdx: uint32(iface.Index)})
+			case *net.IPAddr:
+				local = append(local, localAddr{ip: a.IP, ifIdx: uint32(iface.Index)})
+			}
+		}
+	}
+	return local, nil
+}
+
+// Returns the IP addresses assigned to the local interfaces, loopback addresses included
+func LocalIPs() ([]net.IP, error) {
+	local, err := interfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+
+	ips := make([]net.IP, 0, len(local))
+	for _, addr := range local {
+		ips = append(ips, addr.ip)
+	}
+	return ips, nil
+}
+
+// Returns true if ip is one of the local IPs or a loopback address, with the index of its interface
+// The index is 0 for a loopback address assigned to no interface
+func localInterface(ip net.IP, local []localAddr) (ifIdx uint32, ok bool) {
+	for _, addr := range local {
+		if addr.ip.Equal(ip) {
+			return addr.ifIdx, true
+		}
+	}
+	return 0, ip.IsLoopback()
+}
+
+// Sets the direction of a packet built from scratch from its destination:
+// inbound if the destination is a local IP (see LocalIPs), outbound otherwise.
+// A packet from and to the local host is a loopback packet, WinDivert only injects those outbound.
+// The IPv6 bit is set from the IP version and an inbound packet gets the index of the interface
+// owning its destination, the sub-interface is cleared. An address is created if the packet has none.
+func (p *Packet) InferDirection() error {
+	local, err := interfaceAddrs()
+	if err != nil {
+		return err
+	}
+
+	p.VerifyParsed()
+	if p.Addr == nil {
+		p.Addr = &WinDivertAddress{}
+	}
+	p.Addr.SetIPv6(p.ipVersion == header.IPv6)
+
+	ifIdx, dstLocal := localInterface(p.DstIP(), local)
+	_, srcLocal := localInterface(p.SrcIP(), local)
+	loopback := dstLocal && srcLocal
+	p.Addr.setBit(addrLoopbackBit, loopback)
+
+	switch {
+	case loopback:
+		p.SetDirection(WinDivertDirectionOutbound)
+	case dstLocal:
+		p.SetDirection(WinDivertDirectionInbound)
+		// 入站包从目的地址所在的接口注入
+		p.Addr.SetIfIdx(ifIdx)
+		p.Addr.SetSubIfIdx(0)
+	default:
+		p.SetDirection(WinDivertDirectionOutbound)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package godivert
+
+import (
+	"errors"
+	"examples/header"
+	"net"
+	"testing"
+)
+
+// Replaces the addresses of the local interfaces until the test ends:
+// an Ethernet interface (6) and the loopback interface (1)
+func mockInterfaceAddrs(t *testing.T) {
+	saved := interfaceAddrs
+	t.Cleanup(func() { interfaceAddrs = saved })
+
+	interfaceAddrs = func() ([]localAddr, error) {
+		return []localAddr{
+			{net.ParseIP("127.0.0.1"), 1},
+			{net.ParseIP("::1"), 1},
+			{net.ParseIP("192.168.1.10").To4(), 6},
+			{net.ParseIP("2001:db8::10"), 6},
+		}, nil
+	}
+}
+
+// Returns a UDP packet from src to dst, IPv6 if both are IPv6
+func udpPacket(src, dst string) *Packet {
+	var packet *Packet
+	if net.ParseIP(src).To4() != nil {
+		packet = NewPacket(buildIPv4(header.UDP, udpBytes(1234, 53, nil)), nil)
+	} else {
+		packet = NewPacket(buildIPv6(header.UDP, udpBytes(1234, 53, nil)), nil)
+	}
+	packet.ParseHeaders()
+	packet.SetSrcIP(net.ParseIP(src))
+	packet.SetDstIP(net.ParseIP(dst))
+	return packet
+}
+
+func TestInferDirection(t *testing.T) {
+	mockInterfaceAddrs(t)
+
+	tests := []struct {
+		name     string
+		src, dst string
+		inbound  bool
+		loopback bool
+		ifIdx    uint32
+	}{
+		{"remote IPv4 destination", "192.168.1.10", "8.8.8.8", false, false, 0},
+		{"local IPv4 destination", "8.8.8.8", "192.168.1.10", true, false, 6},
+		{"remote IPv6 destination", "2001:db8::10", "2001:db8::1", false, false, 0},
+		{"local IPv6 destination", "2001:db8::1", "2001:db8::10", true, false, 6},
+		{"loopback", "127.0.0.1", "127.0.0.1", false, true, 0},
+		{"unassigned loopback address", "127.0.0.1", "127.0.0.2", false, true, 0},
+		{"from a local address to another", "192.168.1.10", "127.0.0.1", false, true, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			packet := udpPacket(test.src, test.dst)
+			// 之前的地址信息不能留下
+			packet.Addr = &WinDivertAddress{}
+			packet.Addr.SetIPv6(packet.IpVersion() == header.IPv4)
+			packet.Addr.SetSubIfIdx(3)
+
+			if err := packet.InferDirection(); err != nil {
+				t.Fatalf("InferDirection() = %v", err)
+			}
+			addr := packet.Addr
+			if bool(addr.Direction()) != test.inbound || addr.Loopback() != test.loopback {
+				t.Errorf("inbound %v, loopback %v, want %v, %v", addr.Direction(), addr.Loopback(), test.inbound, test.loopback)
+			}
+			if addr.IPv6() != (packet.IpVersion() == header.IPv6) {
+				t.Errorf("IPv6 bit %v on an IPv%d packet", addr.IPv6(), packet.IpVersion())
+			}
+			if test.inbound && (addr.IfIdx() != test.ifIdx || addr.SubIfIdx() != 0) {
+				t.Errorf("interface %d.%d, want %d.0", addr.IfIdx(), addr.SubIfIdx(), test.ifIdx)
+			}
+		})
+	}
+}
+
+func TestInferDirectionCreatesAddress(t *testing.T) {
+	mockInterfaceAddrs(t)
+
+	packet := udpPacket("8.8.8.8", "192.168.1.10")
+	packet.Addr = nil
+	if err := packet.InferDirection(); err != nil || packet.Addr == nil || !packet.Addr.Direction() {
+		t.Errorf("InferDirection() = %v, address %v, want an inbound address", err, packet.Addr)
+	}
+}
+
+func TestInferDirectionError(t *testing.T) {
+	mockInterfaceAddrs(t)
+	errInterfaces := errors.New("no interfaces")
+	interfaceAddrs = func() ([]localAddr, error) { return nil, errInterfaces }
+
+	if err := udpPacket("8.8.8.8", "192.168.1.10").InferDirection(); err != errInterfaces {
+		t.Errorf("InferDirection() = %v, want %v", err, errInterfaces)
+	}
+	if _, err := LocalIPs(); err != errInterfaces {
+		t.Errorf("LocalIPs() = %v, want %v", err, errInterfaces)
+	}
+}
+
+func TestLocalIPs(t *testing.T) {
+	mockInterfaceAddrs(t)
+
+	ips, err := LocalIPs()
+	if err != nil || len(ips) != 4 || !ips[2].Equal(net.ParseIP("192.168.1.10")) {
+		t.Errorf("LocalIPs() = %v, %v, want the 4 mocked addresses", ips, err)
+	}
+}
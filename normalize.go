@@ -0,0 +1,134 @@
+package godivert
+
+import (
+	"encoding/binary"
+	"examples/header"
+)
+
+// IDS-style TCP normalizations, each one can be toggled
+// The goal is to canonicalize the segments before inspection/forwarding so that ambiguous fields
+// can't be interpreted differently by the inspector and the end host.
+type TCPNormalizer struct {
+	// Clears the 3 reserved bits of the data offset byte
+	ClearReserved bool
+	// Removes every option, the header shrinks to 20 bytes and the lengths are fixed
+	StripOptions bool
+	// Replaces with NOPs the options whose kind isn't in the list (ignored if StripOptions is set)
+	// nil disables it, an empty list NOPs out every option
+	AllowedOptions []uint8
+	// Lowers the MSS option to this value if it is above, 0 disables it
+	ClampMSS uint16
+	// Zeroes the urgent pointer when the URG flag isn't set
+	ZeroUrgPtr bool
+}
+
+// Normalizations applied by Normalize
+var DefaultTCPNormalizer = TCPNormalizer{
+	ClearReserved: true,
+	AllowedOptions: []uint8{
+		header.TCPOptionMSS,
+		header.TCPOptionWindowScale,
+		header.TCPOptionSACKPermitted,
+		header.TCPOptionSACK,
+		header.TCPOptionTimestamps,
+	},
+	ClampMSS:   1460,
+	ZeroUrgPtr: true,
+}
+
+// Applies DefaultTCPNormalizer to the packet, non TCP packets are left untouched
+func Normalize(p *Packet) {
+	DefaultTCPNormalizer.Normalize(p)
+}
+
+// Applies the enabled normalizations to the packet, non TCP packets are left untouched
+// The TCP header is marked as modified when something changed so Send recalculates the checksums
+func (n *TCPNormalizer) Normalize(p *Packet) {
+	p.VerifyParsed()
+
+	tcpHdr, ok := p.NextHeader.(*header.TCPHeader)
+	if !ok {
+		return
+	}
+
+	if n.ClearReserved && tcpHdr.Reserved() != 0 {
+		tcpHdr.Raw[12] &^= 0x0e
+		tcpHdr.Modified = true
+	}
+
+	if n.ZeroUrgPtr && !tcpHdr.URG() && tcpHdr.UrgPtr() != 0 {
+		binary.BigEndian.PutUint16(tcpHdr.Raw[18:20], 0)
+		tcpHdr.Modified = true
+	}
+
+	if n.StripOptions {
+		if tcpHdr.Options() != nil {
+			p.stripTCPOptions(tcpHdr)
+		}
+		return
+	}
+
+	if n.AllowedOptions != nil {
+		n.nopOptions(tcpHdr)
+	}
+
+	if n.ClampMSS != 0 {
+		for _, option := range tcpHdr.ParsedOptions() {
+			if option.Kind == header.TCPOptionMSS && len(option.Data) == 2 && binary.BigEndian.Uint16(option.Data) > n.ClampMSS {
+				binary.BigEndian.PutUint16(option.Data, n.ClampMSS)
+				tcpHdr.Modified = true
+			}
+		}
+	}
+}
+
+// Replaces the options that aren't allowed with NOPs, keeping the header length
+// Anything after a malformed option or the End of Option List is NOPed out too
+func (n *TCPNormalizer) nopOptions(tcpHdr *header.TCPHeader) {
+	options := tcpHdr.Options()
+
+	for i := 0; i < len(options); {
+		kind := options[i]
+		if kind == header.TCPOptionNOP {
+			i++
+			continue
+		}
+
+		optLen := 0
+		if kind != header.TCPOptionEnd && i+1 < len(options) {
+			optLen = int(options[i+1])
+		}
+		if optLen < 2 || i+optLen > len(options) {
+			// 剩余部分无法解析，全部替换为 NOP
+			optLen = len(options) - i
+		} else if n.optionAllowed(kind) {
+			i += optLen
+			continue
+		}
+
+		for j := i; j < i+optLen; j++ {
+			options[j] = header.TCPOptionNOP
+		}
+		tcpHdr.Modified = true
+		i += optLen
+	}
+}
+
+func (n *TCPNormalizer) optionAllowed(kind uint8) bool {
+	for _, allowed := range n.AllowedOptions {
+		if kind == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// Removes the TCP options and moves the payload right after the 20 bytes header
+func (p *Packet) stripTCPOptions(tcpHdr *header.TCPHeader) {
+	optionsEnd := p.hdrLen + tcpHdr.HeaderLen()
+	optionsStart := p.hdrLen + header.TCPHeaderLen
+
+	p.Raw[p.hdrLen+12] = p.Raw[p.hdrLen+12]&0x0f | (header.TCPHeaderLen/4)<<4
+	p.Raw = append(p.Raw[:optionsStart], p.Raw[optionsEnd:]...)
+	p.FixLengths()
+}
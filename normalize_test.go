@@ -0,0 +1,109 @@
+package godivert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"examples/header"
+	"testing"
+)
+
+var normalizeOptions = []byte{
+	header.TCPOptionMSS, 4, 0x05, 0xdc, // 1500
+	header.TCPOptionWindowScale, 3, 7,
+	header.TCPOptionNOP,
+	30, 4, 0, 0, // 未知选项
+}
+
+// Returns a parsed IPv4 TCP packet with valid checksums carrying normalizeOptions,
+// the reserved bits set and an urgent pointer without the URG flag
+func normalizePacket(t *testing.T) *Packet {
+	t.Helper()
+
+	tcp := tcpBytes(50000, 80, 0x18, nil)
+	tcp[12] = uint8(header.TCPHeaderLen+len(normalizeOptions))/4<<4 | 0x0e
+	binary.BigEndian.PutUint16(tcp[18:20], 5)
+	tcp = append(append(tcp, normalizeOptions...), "payload"...)
+
+	packet := NewPacket(buildIPv4(header.TCP, tcp), NewAddress())
+	packet.ParseHeaders()
+	packet.NextHeader.(*header.TCPHeader).Modified = true
+	if err := HelperCalcChecksumBatch([]*Packet{packet}); err != nil {
+		t.Fatal(err)
+	}
+	return packet
+}
+
+func TestNormalize(t *testing.T) {
+	nopped := func(options ...byte) []byte {
+		nops := bytes.Repeat([]byte{header.TCPOptionNOP}, len(normalizeOptions))
+		copy(nops, options)
+		return nops
+	}
+	withMSS := func(mss uint16) []byte {
+		options := append([]byte(nil), normalizeOptions...)
+		binary.BigEndian.PutUint16(options[2:4], mss)
+		return options
+	}
+
+	tests := []struct {
+		name         string
+		normalize    func(p *Packet)
+		wantModified bool
+		wantReserved uint8
+		wantUrgPtr   uint16
+		wantOptions  []byte
+	}{
+		{"nothing enabled", (&TCPNormalizer{}).Normalize, false, 7, 5, normalizeOptions},
+		{"reserved bits", (&TCPNormalizer{ClearReserved: true}).Normalize, true, 0, 5, normalizeOptions},
+		{"urgent pointer", (&TCPNormalizer{ZeroUrgPtr: true}).Normalize, true, 7, 0, normalizeOptions},
+		{"MSS clamped", (&TCPNormalizer{ClampMSS: 1400}).Normalize, true, 7, 5, withMSS(1400)},
+		{"MSS below the clamp", (&TCPNormalizer{ClampMSS: 1600}).Normalize, false, 7, 5, normalizeOptions},
+		{"allowed options", (&TCPNormalizer{AllowedOptions: []uint8{header.TCPOptionMSS}}).Normalize, true, 7, 5, nopped(normalizeOptions[:4]...)},
+		{"no allowed option", (&TCPNormalizer{AllowedOptions: []uint8{}}).Normalize, true, 7, 5, nopped()},
+		{"options stripped", (&TCPNormalizer{StripOptions: true}).Normalize, true, 7, 5, nil},
+		{"defaults", Normalize, true, 0, 0, append(withMSS(1460)[:8], nopped()[:4]...)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			packet := normalizePacket(t)
+			test.normalize(packet)
+
+			tcp := packet.NextHeader.(*header.TCPHeader)
+			if tcp.Reserved() != test.wantReserved || tcp.UrgPtr() != test.wantUrgPtr {
+				t.Errorf("reserved bits %#x, urgent pointer %d, want %#x, %d", tcp.Reserved(), tcp.UrgPtr(), test.wantReserved, test.wantUrgPtr)
+			}
+			if !bytes.Equal(tcp.Options(), test.wantOptions) {
+				t.Errorf("options %v, want %v", tcp.Options(), test.wantOptions)
+			}
+			if !bytes.Equal(tcp.Payload, []byte("payload")) {
+				t.Errorf("payload %q changed", tcp.Payload)
+			}
+			if got := int(binary.BigEndian.Uint16(packet.Raw[2:4])); got != len(packet.Raw) || int(packet.PacketLen) != len(packet.Raw) {
+				t.Errorf("total length %d, PacketLen %d, want %d", got, packet.PacketLen, len(packet.Raw))
+			}
+
+			// 修改过的包重新计算校验和
+			if packet.needNewChecksum() != test.wantModified {
+				t.Fatalf("checksums to recalculate %v, want %v", packet.needNewChecksum(), test.wantModified)
+			}
+			if err := HelperCalcChecksumBatch([]*Packet{packet}); err != nil {
+				t.Fatal(err)
+			}
+			if ip, transport := checksumsValid(packet.Raw); !ip || !transport {
+				t.Errorf("IP checksum valid %v, TCP checksum valid %v after the recalculation", ip, transport)
+			}
+		})
+	}
+}
+
+func TestNormalizeIgnoresUDP(t *testing.T) {
+	raw := buildIPv4(header.UDP, udpBytes(1234, 53, []byte("query")))
+	want := append([]byte(nil), raw...)
+	packet := NewPacket(raw, NewAddress())
+
+	Normalize(packet)
+	if !bytes.Equal(packet.Raw, want) || packet.needNewChecksum() {
+		t.Error("Normalize changed a UDP packet")
+	}
+}
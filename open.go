@@ -0,0 +1,167 @@
+package godivert
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"time"
+)
+
+const (
+	// WINDIVERT_PRIORITY_HIGHEST and WINDIVERT_PRIORITY_LOWEST
+	WinDivertPriorityHighest = 30000
+	WinDivertPriorityLowest  = -WinDivertPriorityHighest
+
+	// Delay between two open attempts when OpenOptions.RetryDelay isn't set
+	DefaultOpenRetryDelay = 100 * time.Millisecond
+)
+
+// Parameters of Open, the zero value of every field is a sensible default
+type OpenOptions struct {
+	// Filter the packets have to match, "true" if empty
+	// See https://reqrypt.org/windivert-doc.html#filter_language
+	Filter string
+	// Layer to open the handle on, NETWORK by default
+	Layer Layer
+	// Priority of the handle, from WinDivertPriorityLowest to WinDivertPriorityHighest, 0 by default
+	Priority int16
+	// WinDivertFlag* values, the flags required by the layer (e.g. SNIFF|RECV_ONLY for FLOW) are added
+	Flags uint8
+	// Number of additional attempts if WinDivertOpen fails, none by default
+	// Useful when the driver is being installed or started by another process
+	Retry int
+	// Delay between the attempts, DefaultOpenRetryDelay by default
+	RetryDelay time.Duration
+	// If set, the DLL is loaded from this path first (see LoadDLL), Open fails if it can't be loaded
+	// It replaces the DLL used by the whole package, not only by this handle
+	DLLPath string
+	// If set, a panic of the ForEach or Process handler (e.g. parsing a malformed packet) or of the
+	// receive loops is recovered: it is reported to ErrorHandler as a *PanicError holding the packet
+	// in hex, the packet is dropped and the loop goes on with the next one
+	RecoverPanics bool
+	// Called with the errors the receive loops (ForEach, Packets, Process) can't return because they
+	// go on: truncated packets they dropped and recovered panics. OpenBidirectional also reports
+	// one-directional filters to it. Nil by default, the errors are ignored.
+	// It can be called from several goroutines at once.
+	ErrorHandler func(error)
+	// If set, the filter is compiled for the layer before WinDivertOpen is called and a malformed
+	// filter is reported as a *FilterError with its position instead of a generic open failure
+	CheckFilter bool
+	// If set, the handle isn't tracked by the package and CloseAll doesn't close it
+	Unregistered bool
+	// If set, Send and SendEx release the packets and return ErrSniffSend and ForEach and Process
+	// release the packets instead of sending them. Set by NewSniffHandle and by the NewWinDivertHandle*
+	// constructors given WinDivertFlagSniff: reinjecting sniffed packets would duplicate them.
+	// Open it without NoSend to inject crafted packets (e.g. TCP resets) on a sniff handle.
+	NoSend bool
+	// Size of the buffers Recv reads the packets in, PacketBufferSize by default
+	// A smaller size saves memory when only small packets are captured (e.g. DNS), a packet
+	// that doesn't fit is reported as an error by Recv. At least MinPacketBufferSize.
+	BufferSize int
+}
+
+// Returns the flags every handle of the layer must have
+func (l Layer) requiredFlags() uint8 {
+	switch l {
+	case WinDivertLayerFlow, WinDivertLayerReflect:
+		return WinDivertFlagSniff | WinDivertFlagRecvOnly
+	case WinDivertLayerSocket:
+		return WinDivertFlagRecvOnly
+	default:
+		return 0
+	}
+}
+
+// Fills the defaults in and checks the combination of options
+func (opts *OpenOptions) normalize() error {
+	if opts.Filter == "" {
+		opts.Filter = "true"
+	}
+	if opts.Layer.events() == nil {
+		return fmt.Errorf("invalid layer %d", uint8(opts.Layer))
+	}
+	if opts.Priority < WinDivertPriorityLowest || opts.Priority > WinDivertPriorityHighest {
+		return fmt.Errorf("invalid priority %d, must be between %d and %d", opts.Priority, WinDivertPriorityLowest, WinDivertPriorityHighest)
+	}
+	if opts.Retry < 0 {
+		return fmt.Errorf("invalid retry count %d", opts.Retry)
+	}
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = DefaultOpenRetryDelay
+	}
+	if opts.BufferSize == 0 {
+		opts.BufferSize = PacketBufferSize
+	}
+	if opts.BufferSize < MinPacketBufferSize || opts.BufferSize > PacketBufferSize {
+		return fmt.Errorf("invalid buffer size %d, must be between %d and %d", opts.BufferSize, MinPacketBufferSize, PacketBufferSize)
+	}
+
+	opts.Flags |= opts.Layer.requiredFlags()
+	if opts.Flags&WinDivertFlagSniff != 0 && opts.Flags&WinDivertFlagDrop != 0 {
+		return errors.New("invalid flags, SNIFF and DROP can't be combined")
+	}
+	if opts.Flags&WinDivertFlagRecvOnly != 0 && opts.Flags&WinDivertFlagSendOnly != 0 {
+		return errors.New("invalid flags, RECV_ONLY and SEND_ONLY can't be combined")
+	}
+	return nil
+}
+
+// Create a new WinDivertHandle by calling WinDivertOpen with the given options and returns it
+// This is the single entry point the NewWinDivertHandle* constructors are built on
+// https://reqrypt.org/windivert-doc.html#divert_open
+func Open(opts OpenOptions) (*WinDivertHandle, error) {
+	if err := opts.normalize(); err != nil {
+		return nil, err
+	}
+
+	if opts.DLLPath != "" {
+		if err := LoadDLL(opts.DLLPath, opts.DLLPath); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.CheckFilter {
+		// 只检查过滤器，不需要编译结果
+		if err := compileFilter(opts.Filter, opts.Layer, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	//使用 syscall.BytePtrFromString 将 filter 字符串转换为一个 C 风格的字符串（以 null 结尾的字节数组），并返回其指针。
+	filterBytePtr, err := syscall.BytePtrFromString(opts.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var handle uintptr
+	for attempt := 0; ; attempt++ {
+		//存储 WinDivert 设备句柄，失败时 err 不为 nil。
+		handle, err = divertOpen(filterBytePtr, opts.Layer, opts.Priority, uint64(opts.Flags))
+		if err == nil {
+			break
+		}
+		if attempt == opts.Retry {
+			return nil, openError(winDivertErr("open", err))
+		}
+		time.Sleep(opts.RetryDelay)
+	}
+
+	//创建一个新的 WinDivertHandle 结构体实例，初始化其 handle 字段为刚刚获得的设备句柄，并标记为已打开。
+	winDivertHandle := &WinDivertHandle{
+		handle:   handle,
+		layer:    opts.Layer,
+		priority: opts.Priority,
+		flags:    opts.Flags,
+		openTime: time.Now(),
+
+		recoverPanics: opts.RecoverPanics,
+		errorHandler:  opts.ErrorHandler,
+		noSend:        opts.NoSend,
+		buffers:       bufferPoolFor(opts.BufferSize),
+	}
+	winDivertHandle.open.Store(true)
+	if !opts.Unregistered {
+		register(winDivertHandle)
+	}
+	return winDivertHandle, nil
+}
@@ -0,0 +1,157 @@
+package godivert
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Arguments of a WinDivertOpen call
+type openCall struct {
+	filter   string
+	layer    Layer
+	priority int16
+	flags    uint64
+}
+
+// Records the WinDivertOpen calls of the fake driver, the first fail calls fail
+func recordOpens(t *testing.T, fail int) *[]openCall {
+	newFakeDriver(t)
+	var calls []openCall
+	fakeOpen := divertOpen
+	divertOpen = func(filter *byte, layer Layer, priority int16, flags uint64) (uintptr, error) {
+		calls = append(calls, openCall{cString(filter), layer, priority, flags})
+		if len(calls) <= fail {
+			return 0, syscall.Errno(2) // ERROR_FILE_NOT_FOUND，驱动还没装好
+		}
+		return fakeOpen(filter, layer, priority, flags)
+	}
+	return &calls
+}
+
+func TestOpenOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    OpenOptions
+		want    openCall
+		wantErr bool
+	}{
+		{"defaults", OpenOptions{}, openCall{"true", WinDivertLayerNetwork, 0, 0}, false},
+		{"filter and priority", OpenOptions{Filter: "tcp", Priority: WinDivertPriorityHighest}, openCall{"tcp", WinDivertLayerNetwork, WinDivertPriorityHighest, 0}, false},
+		{"lowest priority", OpenOptions{Priority: WinDivertPriorityLowest, Flags: WinDivertFlagSniff}, openCall{"true", WinDivertLayerNetwork, WinDivertPriorityLowest, uint64(WinDivertFlagSniff)}, false},
+		{"forward layer", OpenOptions{Layer: WinDivertLayerNetworkForward}, openCall{"true", WinDivertLayerNetworkForward, 0, 0}, false},
+		{"flow layer flags added", OpenOptions{Layer: WinDivertLayerFlow}, openCall{"true", WinDivertLayerFlow, 0, uint64(WinDivertFlagSniff | WinDivertFlagRecvOnly)}, false},
+		{"socket layer flags added", OpenOptions{Layer: WinDivertLayerSocket, Flags: WinDivertFlagSniff}, openCall{"true", WinDivertLayerSocket, 0, uint64(WinDivertFlagSniff | WinDivertFlagRecvOnly)}, false},
+		{"priority too high", OpenOptions{Priority: WinDivertPriorityHighest + 1}, openCall{}, true},
+		{"priority too low", OpenOptions{Priority: WinDivertPriorityLowest - 1}, openCall{}, true},
+		{"invalid layer", OpenOptions{Layer: Layer(9)}, openCall{}, true},
+		{"sniff and drop", OpenOptions{Flags: WinDivertFlagSniff | WinDivertFlagDrop}, openCall{}, true},
+		{"drop on the flow layer", OpenOptions{Layer: WinDivertLayerFlow, Flags: WinDivertFlagDrop}, openCall{}, true},
+		{"receive and send only", OpenOptions{Flags: WinDivertFlagRecvOnly | WinDivertFlagSendOnly}, openCall{}, true},
+		{"send only on the socket layer", OpenOptions{Layer: WinDivertLayerSocket, Flags: WinDivertFlagSendOnly}, openCall{}, true},
+		{"negative retry", OpenOptions{Retry: -1}, openCall{}, true},
+		{"buffer too small", OpenOptions{BufferSize: MinPacketBufferSize - 1}, openCall{}, true},
+		{"buffer too large", OpenOptions{BufferSize: PacketBufferSize + 1}, openCall{}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			calls := recordOpens(t, 0)
+			test.opts.Unregistered = true
+
+			wd, err := Open(test.opts)
+			if test.wantErr {
+				if err == nil {
+					wd.Close()
+					t.Fatalf("Open(%+v) = nil error", test.opts)
+				}
+				if len(*calls) != 0 {
+					t.Errorf("WinDivertOpen called with invalid options")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Open(%+v) = %v", test.opts, err)
+			}
+			defer wd.Close()
+
+			if len(*calls) != 1 || (*calls)[0] != test.want {
+				t.Fatalf("WinDivertOpen calls %+v, want %+v", *calls, test.want)
+			}
+			if wd.Layer() != test.want.layer || wd.priority != test.want.priority || uint64(wd.flags) != test.want.flags {
+				t.Errorf("handle layer %v, priority %d, flags %#x, want the options passed to WinDivertOpen", wd.Layer(), wd.priority, wd.flags)
+			}
+		})
+	}
+}
+
+func TestOpenRetry(t *testing.T) {
+	tests := []struct {
+		name      string
+		fail      int
+		retry     int
+		wantCalls int
+		wantErr   bool
+	}{
+		{"no failure", 0, 2, 1, false},
+		{"succeeds on the last attempt", 2, 2, 3, false},
+		{"every attempt fails", 3, 2, 3, true},
+		{"no retry", 1, 0, 1, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			calls := recordOpens(t, test.fail)
+
+			wd, err := Open(OpenOptions{Retry: test.retry, RetryDelay: time.Millisecond, Unregistered: true})
+			if err == nil {
+				wd.Close()
+			}
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Open() = %v, want an error %v", err, test.wantErr)
+			}
+			var winDivertErr *WinDivertError
+			if err != nil && !errors.As(err, &winDivertErr) {
+				t.Errorf("Open() = %v, want a *WinDivertError", err)
+			}
+			if len(*calls) != test.wantCalls {
+				t.Errorf("%d calls to WinDivertOpen, want %d", len(*calls), test.wantCalls)
+			}
+		})
+	}
+}
+
+func TestOpenConstructors(t *testing.T) {
+	tests := []struct {
+		name       string
+		open       func() (*WinDivertHandle, error)
+		want       openCall
+		wantNoSend bool
+	}{
+		{"NewWinDivertHandle", func() (*WinDivertHandle, error) { return NewWinDivertHandle("udp") }, openCall{"udp", WinDivertLayerNetwork, 0, 0}, false},
+		{"with sniff flag", func() (*WinDivertHandle, error) { return NewWinDivertHandleWithFlags("tcp", WinDivertFlagSniff) }, openCall{"tcp", WinDivertLayerNetwork, 0, uint64(WinDivertFlagSniff)}, true},
+		{"with priority", func() (*WinDivertHandle, error) { return NewWinDivertHandleWithPriority("", -5, 0) }, openCall{"true", WinDivertLayerNetwork, -5, 0}, false},
+		{"with layer", func() (*WinDivertHandle, error) {
+			return NewWinDivertHandleWithLayer("true", WinDivertLayerReflect, 0, 0)
+		}, openCall{"true", WinDivertLayerReflect, 0, uint64(WinDivertFlagSniff | WinDivertFlagRecvOnly)}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			calls := recordOpens(t, 0)
+
+			wd, err := test.open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer wd.Close()
+			if len(*calls) != 1 || (*calls)[0] != test.want {
+				t.Errorf("WinDivertOpen calls %+v, want %+v", *calls, test.want)
+			}
+			if wd.noSend != test.wantNoSend {
+				t.Errorf("NoSend %v, want %v", wd.noSend, test.wantNoSend)
+			}
+		})
+	}
+}
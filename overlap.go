@@ -0,0 +1,166 @@
+package godivert
+
+import (
+	"encoding/binary"
+	"examples/header"
+	"net"
+)
+
+// Ports used to generate the FiltersOverlap corpus, paired with an ephemeral port
+var overlapPorts = []uint16{22, 25, 53, 67, 80, 123, 443, 445, 3389, 8080}
+
+// Ephemeral port of the FiltersOverlap corpus
+const overlapEphemeralPort = 50000
+
+// Address pairs (local, remote) used to generate the FiltersOverlap corpus
+var overlapAddrs = [][2]string{
+	{"192.168.1.10", "8.8.8.8"},
+	{"10.0.0.2", "10.0.0.1"},
+	{"127.0.0.1", "127.0.0.1"},
+	{"192.168.1.10", "192.168.1.255"},
+	{"2001:db8::10", "2001:4860:4860::8888"},
+	{"fe80::10", "fe80::1"},
+	{"::1", "::1"},
+}
+
+// TCP flags used to generate the FiltersOverlap corpus
+var overlapTCPFlags = []uint16{
+	header.TCPFlagSYN,
+	header.TCPFlagSYN | header.TCPFlagACK,
+	header.TCPFlagPSH | header.TCPFlagACK,
+	header.TCPFlagFIN | header.TCPFlagACK,
+	header.TCPFlagRST,
+}
+
+// Returns true if a packet matching both filters has been found
+// This is an empirical check, not a proof: lacking a symbolic solver the filters are evaluated
+// against a corpus of generated packets (IPv4 and IPv6, TCP, UDP and ICMP, common ports,
+// both directions, loopback or not). false means no packet of the corpus matched both filters,
+// filters on fields the corpus doesn't vary (e.g. specific addresses or payload bytes) can still overlap.
+// Returns an error if one of the filters is invalid.
+func FiltersOverlap(a, b string) (bool, error) {
+	// 两个过滤器都先编译，第二个过滤器的错误不会被短路掉
+	filterA, err := CompileFilter(a)
+	if err != nil {
+		return false, err
+	}
+	filterB, err := CompileFilter(b)
+	if err != nil {
+		return false, err
+	}
+
+	for _, packet := range overlapCorpus() {
+		if filterA.Eval(packet) && filterB.Eval(packet) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Generates the packets FiltersOverlap evaluates the filters against
+func overlapCorpus() []*Packet {
+	var corpus []*Packet
+
+	for _, pair := range overlapAddrs {
+		local, remote := net.ParseIP(pair[0]), net.ParseIP(pair[1])
+		loopback := local.IsLoopback()
+
+		for _, outbound := range []bool{true, false} {
+			src, dst := local, remote
+			if !outbound {
+				src, dst = remote, local
+			}
+
+			for _, port := range overlapPorts {
+				// 本机作为客户端和作为服务端两种情况
+				for _, ports := range [][2]uint16{{overlapEphemeralPort, port}, {port, overlapEphemeralPort}} {
+					for _, flags := range overlapTCPFlags {
+						corpus = append(corpus, overlapPacket(src, dst, header.TCP, ports, flags, outbound, loopback))
+					}
+					corpus = append(corpus, overlapPacket(src, dst, header.UDP, ports, 0, outbound, loopback))
+				}
+			}
+
+			icmp := uint8(header.ICMPv4)
+			if local.To4() == nil {
+				icmp = header.ICMPv6
+			}
+			corpus = append(corpus, overlapPacket(src, dst, icmp, [2]uint16{}, 0, outbound, loopback))
+		}
+	}
+	return corpus
+}
+
+// Builds a corpus packet, ports holds the source and destination ports of TCP and UDP packets
+// The checksums aren't computed but flagged as valid, filters don't look at them
+func overlapPacket(src, dst net.IP, protocol uint8, ports [2]uint16, flags uint16, outbound, loopback bool) *Packet {
+	ipv4 := src.To4() != nil
+
+	ipHdrLen := 40
+	if ipv4 {
+		ipHdrLen = 20
+	}
+
+	var transport []byte
+	switch protocol {
+	case header.TCP:
+		transport = make([]byte, 20)
+		binary.BigEndian.PutUint32(transport[4:8], 1)
+		if flags&header.TCPFlagACK != 0 {
+			binary.BigEndian.PutUint32(transport[8:12], 1)
+		}
+		transport[12] = 5 << 4
+		transport[13] = uint8(flags)
+		binary.BigEndian.PutUint16(transport[14:16], 65535)
+		if flags&header.TCPFlagPSH != 0 {
+			transport = append(transport, "GET / HTTP/1.1\r\n"...)
+		}
+	case header.UDP:
+		transport = make([]byte, 8+16)
+		binary.BigEndian.PutUint16(transport[4:6], uint16(len(transport)))
+	default:
+		// 回显请求
+		transport = make([]byte, 8)
+		transport[0] = 8
+		if protocol == header.ICMPv6 {
+			transport[0] = 128
+		}
+	}
+	if protocol == header.TCP || protocol == header.UDP {
+		binary.BigEndian.PutUint16(transport[0:2], ports[0])
+		binary.BigEndian.PutUint16(transport[2:4], ports[1])
+	}
+
+	raw := make([]byte, ipHdrLen+len(transport))
+	if ipv4 {
+		raw[0] = 0x45
+		binary.BigEndian.PutUint16(raw[2:4], uint16(len(raw)))
+		raw[8] = 64
+		raw[9] = protocol
+		copy(raw[12:16], src.To4())
+		copy(raw[16:20], dst.To4())
+	} else {
+		raw[0] = 0x60
+		binary.BigEndian.PutUint16(raw[4:6], uint16(len(transport)))
+		raw[6] = protocol
+		raw[7] = 64
+		copy(raw[8:24], src.To16())
+		copy(raw[24:40], dst.To16())
+	}
+	copy(raw[ipHdrLen:], transport)
+
+	addr := &WinDivertAddress{}
+	addr.setLayer(WinDivertLayerNetwork)
+	addr.setBit(addrOutboundBit, outbound)
+	addr.setBit(addrLoopbackBit, loopback)
+	addr.setBit(addrIPv6Bit, !ipv4)
+	addr.setBit(addrIPChecksumBit, true)
+	addr.setBit(addrTCPChecksumBit, true)
+	addr.setBit(addrUDPChecksumBit, true)
+
+	return &Packet{
+		Raw:       raw,
+		Addr:      addr,
+		PacketLen: uint(len(raw)),
+	}
+}
@@ -0,0 +1,109 @@
+package godivert
+
+import (
+	"errors"
+	"examples/header"
+	"testing"
+)
+
+func TestHelperEvalFilter(t *testing.T) {
+	newFakeFilterLanguage(t)
+	tcp := NewPacket(buildIPv4(header.TCP, tcpBytes(50000, 80, 0x02, nil)), nil)
+
+	tests := []struct {
+		name    string
+		filter  string
+		packet  *Packet
+		want    bool
+		wantErr bool
+	}{
+		{"match", "tcp and inbound", tcp, true, false},
+		{"no match", "udp", tcp, false, false},
+		{"port", "tcp.DstPort == 80", tcp, true, false},
+		{"invalid filter", "tcp and bogus", tcp, false, true},
+		{"empty packet", "true", NewPacket(nil, nil), false, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			match, err := HelperEvalFilter(test.packet, test.filter)
+			if match != test.want || (err != nil) != test.wantErr {
+				t.Fatalf("HelperEvalFilter() = %v, %v, want %v, error %v", match, err, test.want, test.wantErr)
+			}
+			var filterErr *FilterError
+			if test.filter == "tcp and bogus" && (!errors.As(err, &filterErr) || filterErr.Position != len("tcp and ")) {
+				t.Errorf("HelperEvalFilter() = %v, want a *FilterError at the second term", err)
+			}
+		})
+	}
+}
+
+func TestFiltersOverlap(t *testing.T) {
+	newFakeFilterLanguage(t)
+
+	tests := []struct {
+		a, b    string
+		want    bool
+		wantErr bool
+	}{
+		{"tcp", "udp", false, false},
+		{"tcp", "inbound", true, false},
+		{"outbound and tcp", "inbound", false, false},
+		{"tcp.DstPort == 443", "outbound and loopback", true, false},
+		{"ipv6 and icmp", "true", false, false},
+		{"false", "true", false, false},
+		// 即使第一个过滤器不匹配任何包，第二个过滤器的错误也要返回
+		{"false", "bogus", false, true},
+		{"bogus", "tcp", false, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.a+" | "+test.b, func(t *testing.T) {
+			overlap, err := FiltersOverlap(test.a, test.b)
+			if overlap != test.want || (err != nil) != test.wantErr {
+				t.Errorf("FiltersOverlap() = %v, %v, want %v, error %v", overlap, err, test.want, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestFilterDirections(t *testing.T) {
+	newFakeFilterLanguage(t)
+
+	tests := []struct {
+		filter            string
+		inbound, outbound bool
+		wantErr           bool
+	}{
+		{"true", true, true, false},
+		{"tcp", true, true, false},
+		{"outbound and tcp", false, true, false},
+		{"inbound and udp", true, false, false},
+		{"false", false, false, false},
+		{"bogus", false, false, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.filter, func(t *testing.T) {
+			inbound, outbound, err := FilterDirections(test.filter)
+			if inbound != test.inbound || outbound != test.outbound || (err != nil) != test.wantErr {
+				t.Errorf("FilterDirections() = %v, %v, %v, want %v, %v, error %v",
+					inbound, outbound, err, test.inbound, test.outbound, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestOverlapCorpus(t *testing.T) {
+	for i, packet := range overlapCorpus() {
+		packet.ParseHeaders()
+		if packet.ipVersion == 4 {
+			if got := int(packet.IpHdr.(*header.IPv4Header).TotalLen()); got != len(packet.Raw) {
+				t.Fatalf("packet %d: total length %d, want %d", i, got, len(packet.Raw))
+			}
+		}
+		if packet.Addr.IPv6() != (packet.ipVersion == 6) {
+			t.Fatalf("packet %d: IPv6 bit doesn't match the IP version %d", i, packet.ipVersion)
+		}
+	}
+}
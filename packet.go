@@ -1,9 +1,15 @@
 package godivert
 
 import (
+	"encoding/binary"
+	"errors"
 	"examples/header"
 	"fmt"
 	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
 // Packet 代表一个网络数据包
@@ -37,56 +43,392 @@ type Packet struct {
 	parsed bool
 
 	// 保存原始缓冲区
+	// Buffer is the pooled array the packet was received in, Raw starts it until an edit outgrows it
+	// (see ensureCapacity). Send or Release returns Buffer to the pool whatever Raw became.
 	Buffer []byte
+
+	// 接收时写入 Buffer 的字节数，放回缓冲池时清理这些字节
+	bufferUsed int
+
+	// RecvEx 收到的包共享的缓冲区，所有包都发送或丢弃后才放回缓冲池
+	batch *recvBatch
+
+	// 缓冲区是否已经放回缓冲池，保证只放回一次
+	released atomic.Bool
+
+	// 接收这个包的句柄，Release 时把包计为已处理
+	handle *WinDivertHandle
+
+	// Metadata 供处理流水线的各个阶段传递信息，首次使用时才分配
+	// 缓冲区放回缓冲池时清空
+	Metadata map[string]any
+}
+
+// Header structs ParseHeadersNoAlloc parses the headers in instead of allocating new ones
+// Reuse one per goroutine: parsing a packet with it overwrites the headers of the previous one.
+// The zero value is ready to use.
+type HeaderStorage struct {
+	ipv4    header.IPv4Header
+	ipv6    header.IPv6Header
+	tcp     header.TCPHeader
+	udp     header.UDPHeader
+	udpLite header.UDPLiteHeader
+	icmpv4  header.ICMPv4Header
+	icmpv6  header.ICMPv6Header
+}
+
+// Creates a packet from raw IP bytes, e.g. to inject a packet built from scratch with Send
+// PacketLen is the length of raw and the headers are parsed on first use. The packet holds no pooled
+// buffer: raw belongs to the caller and the packet can be sent several times. addr is usually NewAddress().
+func NewPacket(raw []byte, addr *WinDivertAddress) *Packet {
+	return &Packet{
+		Raw:       raw,
+		Addr:      addr,
+		PacketLen: uint(len(raw)),
+	}
 }
 
 // Parse the packet's headers
+// A transport header cut short (truncated packet, bogus header length) isn't parsed, NextHeader is then nil
 func (p *Packet) ParseHeaders() {
-	p.ipVersion = int(p.Raw[0] >> 4)
-	if p.ipVersion == 4 {
-		p.hdrLen = int((p.Raw[0] & 0xf) << 2)
-		p.nextHeaderType = p.Raw[9]
-		p.IpHdr = header.NewIPv4Header(p.Raw)
+	p.parseHeaders(nil)
+}
+
+// Parses the IP and transport headers, in hdrs if it isn't nil and in new structs otherwise
+func (p *Packet) parseHeaders(hdrs *HeaderStorage) {
+	p.ipVersion, p.hdrLen, p.nextHeaderType = 0, 0, 0
+	if len(p.Raw) > 0 {
+		p.ipVersion = int(p.Raw[0] >> 4)
+	}
+
+	// 空包也按 IPv4 处理，IpHdr 不为 nil
+	if len(p.Raw) == 0 || p.ipVersion == 4 {
+		if len(p.Raw) > 0 {
+			p.hdrLen = int((p.Raw[0] & 0xf) << 2)
+		}
+		if len(p.Raw) >= header.IPv4HeaderLen {
+			p.nextHeaderType = p.Raw[9]
+		}
+		if hdrs == nil {
+			p.IpHdr = header.NewIPv4Header(p.Raw)
+		} else {
+			hdrs.ipv4 = *header.NewIPv4Header(p.Raw)
+			p.IpHdr = &hdrs.ipv4
+		}
 	} else {
-		p.hdrLen = 40
-		p.nextHeaderType = p.Raw[6]
-		p.IpHdr = header.NewIPv6Header(p.Raw)
+		p.hdrLen, p.nextHeaderType = header.IPv6TransportOffset(p.Raw)
+		if hdrs == nil {
+			p.IpHdr = header.NewIPv6Header(p.Raw)
+		} else {
+			hdrs.ipv6 = *header.NewIPv6Header(p.Raw)
+			p.IpHdr = &hdrs.ipv6
+		}
+	}
+
+	p.NextHeader = p.nextHeader(hdrs)
+	p.parsed = true
+}
+
+// Parses the transport header found at hdrLen, nextHeaderType must be set
+// NextHeader is nil if the header isn't complete
+func (p *Packet) parseNextHeader() {
+	p.NextHeader = p.nextHeader(nil)
+}
+
+// Returns the transport header found at hdrLen, in hdrs if it isn't nil
+// Returns nil for the protocols that aren't parsed and the incomplete headers.
+// 两个分支分别调用构造函数，hdrs 不为 nil 时构造的临时结构体留在栈上
+func (p *Packet) nextHeader(hdrs *HeaderStorage) header.ProtocolHeader {
+	if p.hdrLen > len(p.Raw) || !transportHeaderComplete(p.nextHeaderType, p.Raw[p.hdrLen:]) {
+		// 截断的包，传输层头部不完整
+		return nil
 	}
+	raw := p.Raw[p.hdrLen:]
 
 	switch p.nextHeaderType {
 	case header.ICMPv4:
-		p.NextHeader = header.NewICMPv4Header(p.Raw[p.hdrLen : p.hdrLen+header.ICMPv4HeaderLen])
+		if hdrs == nil {
+			return header.NewICMPv4Header(raw)
+		}
+		hdrs.icmpv4 = *header.NewICMPv4Header(raw)
+		return &hdrs.icmpv4
 	case header.TCP:
-		p.NextHeader = header.NewTCPHeader(p.Raw[p.hdrLen:])
+		if hdrs == nil {
+			return header.NewTCPHeader(raw)
+		}
+		hdrs.tcp = *header.NewTCPHeader(raw)
+		return &hdrs.tcp
 	case header.UDP:
-		p.NextHeader = header.NewUDPHeader(p.Raw[p.hdrLen : p.hdrLen+header.UDPHeaderLen])
+		if hdrs == nil {
+			return header.NewUDPHeader(raw)
+		}
+		hdrs.udp = *header.NewUDPHeader(raw)
+		return &hdrs.udp
 	case header.ICMPv6:
-		p.NextHeader = header.NewICMPv6Header(p.Raw[p.hdrLen : p.hdrLen+header.ICMPv6HeaderLen])
-	default:
-		// Protocol not implemented
-		p.NextHeader = nil
+		if hdrs == nil {
+			return header.NewICMPv6Header(raw)
+		}
+		hdrs.icmpv6 = *header.NewICMPv6Header(raw)
+		return &hdrs.icmpv6
+	case header.UDPLite:
+		if hdrs == nil {
+			return header.NewUDPLiteHeader(raw[:header.UDPLiteHeaderLen])
+		}
+		hdrs.udpLite = *header.NewUDPLiteHeader(raw[:header.UDPLiteHeaderLen])
+		return &hdrs.udpLite
+	}
+	// Protocol not implemented
+	return nil
+}
+
+// Returns true if raw holds the whole transport header of the protocol, or if the protocol isn't parsed
+func transportHeaderComplete(protocol uint8, raw []byte) bool {
+	switch protocol {
+	case header.TCP:
+		if len(raw) < header.TCPHeaderLen {
+			return false
+		}
+		// 数据偏移小于最小头部长度也视为不完整
+		hdrLen := int(raw[12]>>4) << 2
+		return hdrLen >= header.TCPHeaderLen && hdrLen <= len(raw)
+	case header.UDP:
+		return len(raw) >= header.UDPHeaderLen
+	case header.UDPLite:
+		return len(raw) >= header.UDPLiteHeaderLen
+	case header.ICMPv4:
+		return len(raw) >= header.ICMPv4HeaderLen
+	case header.ICMPv6:
+		return len(raw) >= header.ICMPv6HeaderLen
+	}
+	return true
+}
+
+// Headers found in a packet by WinDivertHelperParsePacket
+type helperHeaders struct {
+	// 4 or 6, 0 if the helper rejected the packet
+	ipVersion int
+	protocol  uint8
+	// Offset of the transport header, or of the payload for the protocols WinDivert doesn't know
+	// The length of the packet if there is neither
+	transport int
+}
+
+// Parse the packet's headers with WinDivertHelperParsePacket
+// Unlike ParseHeaders the IPv6 extension headers are walked by WinDivert, hdrLen is then
+// the offset of the transport header and nextHeaderType the transport protocol.
+// If the DLL doesn't export the helper or it rejects the packet, ParseHeaders is used instead.
+// https://reqrypt.org/windivert-doc.html#divert_helper_parse_packet
+func (p *Packet) ParseWithHelper() {
+	if len(p.Raw) == 0 {
+		p.ParseHeaders()
+		return
+	}
+	parsed, err := divertParseHeaders(p.Raw)
+	if err != nil || parsed.ipVersion == 0 {
+		p.ParseHeaders()
+		return
+	}
+
+	p.ipVersion = parsed.ipVersion
+	if parsed.ipVersion == 4 {
+		p.IpHdr = header.NewIPv4Header(p.Raw)
+	} else {
+		p.IpHdr = header.NewIPv6Header(p.Raw)
 	}
+	p.nextHeaderType = parsed.protocol
+	p.hdrLen = parsed.transport
 
+	if p.hdrLen < len(p.Raw) {
+		p.parseNextHeader()
+	} else {
+		p.NextHeader = nil
+	}
 	p.parsed = true
 }
 
+// Parse the packet's headers without allocating, for read-only analysis at high PPS
+// IpHdr and NextHeader point to structs of hdrs instead of new ones. Consequences:
+//   - parsing another packet with hdrs (or this one again) overwrites the structs they point to
+//   - they must not be kept after the packet, like Raw they become invalid once it is sent or released
+//   - hdrs must not be shared between goroutines, copy the fields you need instead
+//
+// Packets parsed with ParseHeaders don't pay for the storage.
+func (p *Packet) ParseHeadersNoAlloc(hdrs *HeaderStorage) {
+	p.parseHeaders(hdrs)
+}
+
 // SetTCPHeader 方法将传入的 TCPHeader 对象的数据替换到 packet.Raw 中
 func (p *Packet) UpdateTCPHeader() {
 	if tcpHeader, ok := p.NextHeader.(header.ProtocolHeader).(*header.TCPHeader); ok {
-		val := tcpHeader.Raw
-		hdrLen := p.hdrLen
-		//如果新负载的长度与当前负载长度相同，则直接替换；否则，重新构建整个 Raw 数据。
-		if len(val) == len(p.Raw[hdrLen:]) {
-			copy(p.Raw[hdrLen:], val)
-		} else {
-			p.Raw = append(p.Raw[:hdrLen], val...)
-			// 更新包长度字段
-			if iPv4Header, ok := p.IpHdr.(header.IPHeader).(*header.IPv4Header); ok {
-				totalLength := uint16(len(p.Raw))
-				iPv4Header.SetTotalLen(totalLength)
-			}
-			p.PacketLen = uint(len(p.Raw))
-		}
+		p.updateTransport(tcpHeader.Raw)
+	}
+}
+
+// Copies the bytes of the UDP header (and its payload) back into Raw
+// To call after UDPHeader.SetPayload changed the payload length: the IPv4 total length
+// or IPv6 payload length and PacketLen are updated
+func (p *Packet) UpdateUDPHeader() {
+	if udpHeader, ok := p.NextHeader.(*header.UDPHeader); ok {
+		p.updateTransport(udpHeader.Raw)
+	}
+}
+
+// Replaces the transport segment of Raw with val and updates the lengths if its length changed
+func (p *Packet) updateTransport(val []byte) {
+	hdrLen := p.hdrLen
+	//如果新负载的长度与当前负载长度相同，则直接替换；否则，重新构建整个 Raw 数据。
+	if len(val) == len(p.Raw[hdrLen:]) {
+		copy(p.Raw[hdrLen:], val)
+		return
+	}
+
+	// 先保证容量，避免 append 悄悄重新分配 Raw
+	p.ensureCapacity(hdrLen + len(val))
+	p.Raw = append(p.Raw[:hdrLen], val...)
+	// 更新包长度字段
+	switch ipHdr := p.IpHdr.(type) {
+	case *header.IPv4Header:
+		ipHdr.SetTotalLen(uint16(len(p.Raw)))
+	case *header.IPv6Header:
+		ipHdr.SetPayloadLength(uint16(len(p.Raw) - header.IPv6HeaderLen))
+	}
+	p.PacketLen = uint(len(p.Raw))
+}
+
+// Calls UpdateTCPHeader or UpdateUDPHeader (or the ICMP equivalent) if the transport header's bytes no longer are Raw's,
+// e.g. after a SetPayload changing the payload length
+func (p *Packet) updateNextHeader() {
+	var raw []byte
+	switch nextHdr := p.NextHeader.(type) {
+	case *header.TCPHeader:
+		raw = nextHdr.Raw
+	case *header.UDPHeader:
+		raw = nextHdr.Raw
+	case *header.ICMPv4Header:
+		raw = nextHdr.Raw
+	case *header.ICMPv6Header:
+		raw = nextHdr.Raw
+	default:
+		return
+	}
+
+	if p.hdrLen > len(p.Raw) || len(raw) == 0 {
+		return
+	}
+	if len(raw) == len(p.Raw)-p.hdrLen && &raw[0] == &p.Raw[p.hdrLen] {
+		return
+	}
+	p.updateTransport(raw)
+}
+
+// Returns the payload of the TCP, UDP or ICMP message, nil for the other protocols
+func (p *Packet) Payload() []byte {
+	p.VerifyParsed()
+
+	switch nextHdr := p.NextHeader.(type) {
+	case *header.TCPHeader:
+		return nextHdr.Payload
+	case *header.UDPHeader:
+		return nextHdr.Payload
+	case *header.ICMPv4Header:
+		return nextHdr.Payload
+	case *header.ICMPv6Header:
+		return nextHdr.Payload
+	default:
+		return nil
+	}
+}
+
+// Replaces the payload of the TCP, UDP or ICMP message (the data after the 8 bytes ICMP header)
+// The IP and UDP lengths and PacketLen are updated, the headers are parsed again
+// and marked as modified so Send recalculates the checksums.
+// Returns an error for the other protocols
+func (p *Packet) SetPayload(payload []byte) error {
+	p.VerifyParsed()
+
+	switch nextHdr := p.NextHeader.(type) {
+	case *header.TCPHeader:
+		nextHdr.SetPayload(payload)
+		p.UpdateTCPHeader()
+	case *header.UDPHeader:
+		nextHdr.SetPayload(payload)
+		p.UpdateUDPHeader()
+	case *header.ICMPv4Header:
+		nextHdr.SetPayload(payload)
+		p.updateTransport(nextHdr.Raw)
+	case *header.ICMPv6Header:
+		nextHdr.SetPayload(payload)
+		p.updateTransport(nextHdr.Raw)
+	default:
+		return fmt.Errorf("cannot set payload on protocolID=%d, only TCP, UDP and ICMP are supported", p.nextHeaderType)
+	}
+
+	p.ParseHeaders()
+	p.markModified()
+	return nil
+}
+
+// Recomputes the length fields after a structural edit of Raw (inserted options, resized payload...)
+// The headers are parsed again from Raw, the IHL and data offset fields describe the new layout
+// and must have been updated along with the edit. Then the IPv4 total length, IPv6 payload length,
+// UDP length and PacketLen are derived from the length of Raw and the headers are marked as modified
+// so Send recalculates the checksums. It is the counterpart of the checksum helpers for lengths.
+func (p *Packet) FixLengths() {
+	p.ParseHeaders()
+
+	rawLen := len(p.Raw)
+	p.PacketLen = uint(rawLen)
+
+	switch ipHdr := p.IpHdr.(type) {
+	case *header.IPv4Header:
+		ipHdr.SetTotalLen(uint16(rawLen))
+	case *header.IPv6Header:
+		ipHdr.SetPayloadLength(uint16(rawLen - header.IPv6HeaderLen))
+	}
+
+	if udpHdr, ok := p.NextHeader.(*header.UDPHeader); ok {
+		binary.BigEndian.PutUint16(udpHdr.Raw[4:6], uint16(rawLen-p.hdrLen))
+	}
+	p.markModified()
+}
+
+// Marks the IP and transport headers as modified so Send recalculates the checksums
+func (p *Packet) markModified() {
+	if ipv4Hdr, ok := p.IpHdr.(*header.IPv4Header); ok {
+		ipv4Hdr.Modified = true
+	}
+
+	switch nextHdr := p.NextHeader.(type) {
+	case *header.TCPHeader:
+		nextHdr.Modified = true
+	case *header.UDPHeader:
+		nextHdr.Modified = true
+	case *header.UDPLiteHeader:
+		nextHdr.Modified = true
+	case *header.ICMPv4Header:
+		nextHdr.Modified = true
+	case *header.ICMPv6Header:
+		nextHdr.Modified = true
+	}
+}
+
+// Makes sure Raw can hold n bytes without append reallocating it behind our back
+// Received packets already have the whole pooled buffer as capacity so nothing changes for them.
+// Otherwise Raw is copied to a new allocation and detached from Buffer: Buffer stays owned by the packet
+// and is still returned to the pool by Send, it is just not used for the data anymore.
+// The headers are parsed again against the new Raw (and marked as modified), previous IpHdr/NextHeader are stale.
+func (p *Packet) ensureCapacity(n int) {
+	if n <= cap(p.Raw) {
+		return
+	}
+
+	raw := make([]byte, len(p.Raw), n)
+	copy(raw, p.Raw)
+	p.Raw = raw
+
+	if p.parsed {
+		p.ParseHeaders()
+		p.markModified()
 	}
 }
 
@@ -104,6 +446,59 @@ func (p *Packet) String() string {
 		p.IpHdr, nextHeaderType, header.ProtocolName(nextHeaderType), p.NextHeader, p.Addr, p.Raw)
 }
 
+// Returns a compact one-line description of the packet, like tcpdump does
+// e.g. OUT 10.0.0.2:54321 > 1.1.1.1:443 TCP [SYN] len=0
+func (p *Packet) Summary() string {
+	p.VerifyParsed()
+
+	var sb strings.Builder
+	if p.Addr != nil {
+		if p.Direction() == WinDivertDirectionInbound {
+			sb.WriteString("IN ")
+		} else {
+			sb.WriteString("OUT ")
+		}
+	}
+
+	srcPort, srcErr := p.SrcPort()
+	dstPort, dstErr := p.DstPort()
+	sb.WriteString(summaryEndpoint(p.SrcIP(), srcPort, srcErr == nil))
+	sb.WriteString(" > ")
+	sb.WriteString(summaryEndpoint(p.DstIP(), dstPort, dstErr == nil))
+	sb.WriteString(" ")
+	sb.WriteString(p.NextHeaderProtocolName())
+
+	if tcpHdr, ok := p.NextHeader.(*header.TCPHeader); ok {
+		sb.WriteString(" [")
+		sb.WriteString(tcpHdr.FlagsString())
+		sb.WriteString("]")
+	}
+
+	fmt.Fprintf(&sb, " len=%d", p.payloadLen())
+	return sb.String()
+}
+
+// Formats an endpoint as ip:port, IPv6 addresses are put between brackets
+func summaryEndpoint(ip net.IP, port uint16, hasPort bool) string {
+	if !hasPort {
+		return ip.String()
+	}
+	return net.JoinHostPort(ip.String(), strconv.Itoa(int(port)))
+}
+
+// Returns the number of bytes following the transport header
+// or following the IP header if the protocol isn't implemented
+func (p *Packet) payloadLen() int {
+	offset := p.hdrLen
+	if p.NextHeader != nil {
+		offset += p.NextHeader.HeaderLen()
+	}
+	if offset > len(p.Raw) {
+		return 0
+	}
+	return len(p.Raw) - offset
+}
+
 // Returns the version of the IP protocol
 // Shortcut for ipHdr.Version()
 func (p *Packet) IpVersion() int {
@@ -150,6 +545,18 @@ func (p *Packet) SetDstIP(ip net.IP) {
 	p.IpHdr.SetDstIP(ip)
 }
 
+// Returns the TCP flags of the packet, e.g. "SYN,ACK"
+// Shortcut for NextHeader.FlagsString(), returns an error if the packet isn't TCP
+func (p *Packet) TCPFlagsString() (string, error) {
+	p.VerifyParsed()
+
+	tcpHdr, ok := p.NextHeader.(*header.TCPHeader)
+	if !ok {
+		return "", fmt.Errorf("cannot get TCP flags on protocolID=%d, packet isn't TCP", p.nextHeaderType)
+	}
+	return tcpHdr.FlagsString(), nil
+}
+
 // Returns the source port of the packet
 // Shortcut for NextHeader.SrcPort()
 func (p *Packet) SrcPort() (uint16, error) {
@@ -204,18 +611,42 @@ func (p *Packet) NextHeaderProtocolName() string {
 	return header.ProtocolName(p.NextHeaderType())
 }
 
+// Returns the name and number of the protocol, e.g. GRE(47)
+func (p *Packet) IPProtocolString() string {
+	nextHeaderType := p.NextHeaderType()
+	return fmt.Sprintf("%s(%d)", header.ProtocolName(nextHeaderType), nextHeaderType)
+}
+
 // Inject the packet on the Network Stack
+// A TCP or UDP header whose length changed (SetPayload) is first written back with UpdateTCPHeader/UpdateUDPHeader
 // If the packet has been modified calls WinDivertHelperCalcChecksum to get a new checksum
+// If the checksums can't be calculated the packet is released without being sent and the error returned
+// On a NoSend handle the packet is released and ErrSniffSend returned, like with WinDivertHandle.Send
 func (p *Packet) Send(wd *WinDivertHandle) (uint, error) {
+	if err := wd.canSend(); err != nil {
+		if err == ErrSniffSend {
+			p.Release()
+		}
+		return 0, err
+	}
+	// 修改 Raw 之前先取得所有权
+	if !p.claim() {
+		return 0, ErrPacketReleased
+	}
+	// 负载长度变化后先把传输层头部写回 Raw
+	if p.parsed {
+		p.updateNextHeader()
+	}
 	// 检查数据包是否已解析
 	if p.parsed && (p.IpHdr.NeedNewChecksum() || p.NextHeader != nil && p.NextHeader.NeedNewChecksum()) {
 		// 调用 HelperCalcChecksum 方法重新计算校验和
-		err := wd.HelperCalcChecksum(p)
-		if err != nil {
-			fmt.Println("HelperCalcChecksum Error:", err)
+		if err := wd.HelperCalcChecksum(p); err != nil {
+			// 校验和错误的包不能注入
+			wd.returnClaimed(p)
+			return 0, err
 		}
 	}
-	return wd.Send(p)
+	return wd.sendClaimed(p)
 }
 
 // Recalculate the packet's checksum
@@ -224,6 +655,137 @@ func (p *Packet) CalcNewChecksum(wd *WinDivertHandle) {
 	wd.HelperCalcChecksum(p)
 }
 
+// Decrements the IPv4 TTL or the IPv6 hop limit by calling WinDivertHelperDecrementTTL
+// Returns true if the field reached 0: a forwarder must then drop the packet
+// and answer with an ICMP time exceeded message instead of injecting it.
+// WinDivert updates the IPv4 checksum incrementally, the packet isn't marked as modified.
+// Returns an error if the packet isn't a complete IP header or WinDivert rejects it.
+// https://reqrypt.org/windivert-doc.html#divert_helper_decrement_ttl
+func (p *Packet) DecrementTTLHelper() (expired bool, err error) {
+	if p.PacketLen == 0 || int(p.PacketLen) > len(p.Raw) {
+		return false, fmt.Errorf("cannot decrement the TTL, packet length is %d and the buffer has %d bytes", p.PacketLen, len(p.Raw))
+	}
+
+	var ttl uint8
+	switch version := p.Raw[0] >> 4; {
+	case version == header.IPv4 && p.PacketLen >= header.IPv4HeaderLen:
+		ttl = p.Raw[8]
+	case version == header.IPv6 && p.PacketLen >= header.IPv6HeaderLen:
+		ttl = p.Raw[7]
+	default:
+		return false, fmt.Errorf("cannot decrement the TTL of a %d bytes IPv%d packet", p.PacketLen, version)
+	}
+
+	if divertDecrementTTL(p.Raw[:p.PacketLen]) {
+		return false, nil
+	}
+	// FALSE 既表示 TTL 耗尽也表示包被拒绝
+	if ttl <= 1 {
+		return true, nil
+	}
+	return false, errors.New("cannot decrement the TTL, the packet is malformed")
+}
+
+// Returns the 64 bits hash WinDivertHelperHashPacket computes for the packet with the given seed, 0 for an empty packet
+// The IP and transport headers are hashed whole, including the fields that change from one packet
+// to the next (IP ID, TCP sequence numbers, checksums): the hash depends weakly on the payload through
+// the checksums and isn't stable per flow. WinDivert documents nothing about its symmetry either.
+// To shard the packets of a flow between workers, key them by FlowKey instead.
+// https://reqrypt.org/windivert-doc.html#divert_helper_hash_packet
+func (p *Packet) Hash(seed uint64) uint64 {
+	if len(p.Raw) == 0 {
+		return 0
+	}
+	// 修改后 PacketLen 可能比 Raw 长，只传入 Raw
+	return divertHashPacket(p.Raw, seed)
+}
+
+// Returns the packet's buffer to the pool without sending it, for packets that are neither sent nor dropped
+// Only the first call (or Send) returns the buffer, the next ones do nothing. Raw must not be used afterwards.
+// A received packet is accounted as processed by the handle it came from, like a dropped one.
+// Packets built by the caller or cloned hold no pooled buffer, Release only clears their metadata.
+func (p *Packet) Release() {
+	if !p.claim() {
+		return
+	}
+	if p.handle != nil {
+		p.handle.returnClaimed(p)
+		return
+	}
+	if p.pooled() {
+		p.returnBuffer()
+	}
+	p.resetMeta()
+}
+
+// Takes the ownership of a received packet's buffer before it is sent or returned
+// Returns false if the packet has already been sent or released. Only one caller gets true, so a
+// buffer is never returned twice nor returned while another goroutine still uses it.
+// Packets holding no pooled buffer can always be claimed, they can be sent several times.
+func (p *Packet) claim() bool {
+	return !p.pooled() || p.released.CompareAndSwap(false, true)
+}
+
+// Returns a deep copy of the packet owning its bytes and its address
+// The clone isn't parsed: its headers are parsed again against its own bytes when needed,
+// so modifying the headers of one packet never changes the other.
+// The clone doesn't hold a pooled buffer, it stays valid after the original is sent
+// and sending it never returns anything to the pool. The metadata entries are copied (not the values).
+func (p *Packet) Clone() *Packet {
+	raw := make([]byte, len(p.Raw))
+	copy(raw, p.Raw)
+
+	clone := &Packet{
+		Raw:       raw,
+		PacketLen: p.PacketLen,
+	}
+	if p.Addr != nil {
+		addr := *p.Addr
+		clone.Addr = &addr
+	}
+	if p.Metadata != nil {
+		clone.Metadata = make(map[string]any, len(p.Metadata))
+		for key, value := range p.Metadata {
+			clone.Metadata[key] = value
+		}
+	}
+	return clone
+}
+
+// Copies the packet's bytes into dst and returns the number of bytes copied
+// For callers managing their own storage: dst doesn't depend on the buffer pool
+// and stays valid once the packet is sent or dropped
+func (p *Packet) CopyTo(dst []byte) (int, error) {
+	if len(dst) < len(p.Raw) {
+		return 0, fmt.Errorf("cannot copy the packet, %d bytes needed but dst holds %d", len(p.Raw), len(dst))
+	}
+	return copy(dst, p.Raw), nil
+}
+
+// Returns true if the bytes of Raw at offset, masked by mask, equal value
+// Works on the raw bytes without parsing, for cheap pre-filtering at high PPS
+// (e.g. MatchBytes(9, []byte{0xff}, []byte{header.TCP}) for IPv4 TCP packets).
+// mask and value must have the same length, a nil mask compares the bytes as they are.
+// Returns false if the pattern goes past the end of the packet.
+func (p *Packet) MatchBytes(offset int, mask, value []byte) bool {
+	if offset < 0 || offset > len(p.Raw)-len(value) {
+		return false
+	}
+	if mask != nil && len(mask) != len(value) {
+		return false
+	}
+
+	for i, b := range p.Raw[offset : offset+len(value)] {
+		if mask != nil {
+			b &= mask[i]
+		}
+		if b != value[i] {
+			return false
+		}
+	}
+	return true
+}
+
 // Check if the headers have already been parsed and call ParseHeaders() if not
 func (p *Packet) VerifyParsed() {
 	if !p.parsed {
@@ -239,6 +801,60 @@ func (p *Packet) Direction() Direction {
 	return p.Addr.Direction()
 }
 
+// Returns the wall clock time WinDivert captured the packet at
+// The address timestamp is a QueryPerformanceCounter value, only meaningful relative to the performance
+// counter of this machine since boot: it is converted using the counter's frequency (queried once) and its
+// distance to the current counter value, so the result is only as accurate as the clock right now.
+// Returns the zero time if the packet has no address or no timestamp (packets built with NewPacket).
+func (p *Packet) Timestamp() time.Time {
+	if p.Addr == nil || p.Addr.Timestamp == 0 {
+		return time.Time{}
+	}
+	return qpcTime(p.Addr.Timestamp)
+}
+
+// Returns the raw address timestamp, the QueryPerformanceCounter value of the capture, 0 without address
+// Differences between two raw timestamps give the precise delay between two packets of this machine
+func (p *Packet) TimestampRaw() int64 {
+	if p.Addr == nil {
+		return 0
+	}
+	return p.Addr.Timestamp
+}
+
+// Sets the Direction of the packet
+// Loopback packets stay outbound, see WinDivertAddress.SetDirection
+// Shortcut for Addr.SetDirection()
+func (p *Packet) SetDirection(direction Direction) {
+	p.Addr.SetDirection(direction)
+}
+
+// Flips the Direction of the packet, e.g. before injecting a reply built from it
+// Loopback packets stay outbound as their replies are loopback packets too
+func (p *Packet) ReverseDirection() {
+	p.Addr.SetDirection(!p.Addr.Direction())
+}
+
+// Attaches a value to the packet, e.g. to pass information between pipeline stages
+// The metadata is cleared once the packet's buffer goes back to the pool (Send or drop)
+func (p *Packet) SetMeta(key string, value any) {
+	if p.Metadata == nil {
+		p.Metadata = make(map[string]any)
+	}
+	p.Metadata[key] = value
+}
+
+// Returns the value attached to the packet under key
+func (p *Packet) GetMeta(key string) (any, bool) {
+	value, ok := p.Metadata[key]
+	return value, ok
+}
+
+// Clears the metadata, the map isn't kept to avoid holding onto values
+func (p *Packet) resetMeta() {
+	p.Metadata = nil
+}
+
 // Check the packet with the filter
 // Returns true if the packet matches the filter
 func (p *Packet) EvalFilter(filter string) (bool, error) {
@@ -0,0 +1,68 @@
+package godivert
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"examples/header"
+)
+
+// The JSON form of a packet, see Packet.MarshalJSON
+type packetJSON struct {
+	Direction     string  `json:"direction,omitempty"`
+	IPVersion     int     `json:"ipVersion"`
+	SrcIP         string  `json:"srcIP"`
+	DstIP         string  `json:"dstIP"`
+	Protocol      string  `json:"protocol"`
+	ProtocolID    uint8   `json:"protocolID"`
+	SrcPort       *uint16 `json:"srcPort,omitempty"`
+	DstPort       *uint16 `json:"dstPort,omitempty"`
+	TCPFlags      string  `json:"tcpFlags,omitempty"`
+	Length        int     `json:"length"`
+	PayloadLength int     `json:"payloadLength"`
+	Raw           string  `json:"raw,omitempty"`
+}
+
+// Returns the packet as a JSON object holding its addresses, ports, protocol, direction,
+// TCP flags and lengths, the raw bytes are left out (see MarshalJSONWithRaw)
+// The ports are only present for TCP and UDP and the direction when the packet has an address.
+func (p *Packet) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.jsonValue(false))
+}
+
+// Same as MarshalJSON with the raw bytes of the packet added as a hex string ("raw")
+func (p *Packet) MarshalJSONWithRaw() ([]byte, error) {
+	return json.Marshal(p.jsonValue(true))
+}
+
+func (p *Packet) jsonValue(withRaw bool) packetJSON {
+	p.VerifyParsed()
+
+	value := packetJSON{
+		IPVersion:     p.IpVersion(),
+		SrcIP:         p.SrcIP().String(),
+		DstIP:         p.DstIP().String(),
+		Protocol:      p.NextHeaderProtocolName(),
+		ProtocolID:    p.NextHeaderType(),
+		Length:        len(p.Raw),
+		PayloadLength: p.payloadLen(),
+	}
+	if p.Addr != nil {
+		value.Direction = p.Direction().String()
+	}
+
+	// NextHeader 为 nil 时 SrcPort/DstPort 返回错误，端口字段省略
+	if srcPort, err := p.SrcPort(); err == nil {
+		value.SrcPort = &srcPort
+	}
+	if dstPort, err := p.DstPort(); err == nil {
+		value.DstPort = &dstPort
+	}
+	if tcpHdr, ok := p.NextHeader.(*header.TCPHeader); ok {
+		value.TCPFlags = tcpHdr.FlagsString()
+	}
+
+	if withRaw {
+		value.Raw = hex.EncodeToString(p.Raw)
+	}
+	return value
+}
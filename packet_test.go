@@ -0,0 +1,1069 @@
+package godivert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"examples/header"
+	"net"
+	"sync"
+	"syscall"
+	"testing"
+)
+
+// Returns an IPv4 packet from 10.0.0.1 to 10.0.0.2 carrying the transport bytes, with a valid IP checksum
+func buildIPv4(protocol uint8, transport []byte) []byte {
+	raw := make([]byte, header.IPv4HeaderLen, header.IPv4HeaderLen+len(transport))
+	raw[0] = 0x45
+	binary.BigEndian.PutUint16(raw[2:4], uint16(header.IPv4HeaderLen+len(transport)))
+	binary.BigEndian.PutUint16(raw[4:6], 0x1234)
+	raw[8] = 64
+	raw[9] = protocol
+	copy(raw[12:16], []byte{10, 0, 0, 1})
+	copy(raw[16:20], []byte{10, 0, 0, 2})
+	binary.BigEndian.PutUint16(raw[10:12], foldChecksum(sumBytes(raw, 0)))
+	return append(raw, transport...)
+}
+
+// Returns an IPv6 packet from fd00::1 to fd00::2 carrying the transport bytes
+func buildIPv6(nextHeader uint8, transport []byte) []byte {
+	raw := make([]byte, header.IPv6HeaderLen, header.IPv6HeaderLen+len(transport))
+	raw[0] = 0x60
+	binary.BigEndian.PutUint16(raw[4:6], uint16(len(transport)))
+	raw[6] = nextHeader
+	raw[7] = 64
+	raw[8], raw[23] = 0xfd, 1
+	raw[24], raw[39] = 0xfd, 2
+	return append(raw, transport...)
+}
+
+// Returns a UDP header and its payload, the checksum is left to 0
+func udpBytes(src, dst uint16, payload []byte) []byte {
+	udp := make([]byte, header.UDPHeaderLen, header.UDPHeaderLen+len(payload))
+	binary.BigEndian.PutUint16(udp[0:2], src)
+	binary.BigEndian.PutUint16(udp[2:4], dst)
+	binary.BigEndian.PutUint16(udp[4:6], uint16(header.UDPHeaderLen+len(payload)))
+	return append(udp, payload...)
+}
+
+// Returns a TCP header without options and its payload, the checksum is left to 0
+func tcpBytes(src, dst uint16, flags uint8, payload []byte) []byte {
+	tcp := make([]byte, header.TCPHeaderLen, header.TCPHeaderLen+len(payload))
+	binary.BigEndian.PutUint16(tcp[0:2], src)
+	binary.BigEndian.PutUint16(tcp[2:4], dst)
+	binary.BigEndian.PutUint32(tcp[4:8], 1000)
+	tcp[12] = 5 << 4
+	tcp[13] = flags
+	binary.BigEndian.PutUint16(tcp[14:16], 65535)
+	return append(tcp, payload...)
+}
+
+// Returns an ICMP echo message of the given type and its payload, the checksum is left to 0
+func icmpBytes(icmpType uint8, payload []byte) []byte {
+	icmp := make([]byte, header.ICMPv4HeaderLen, header.ICMPv4HeaderLen+len(payload))
+	icmp[0] = icmpType
+	binary.BigEndian.PutUint16(icmp[4:6], 7)
+	binary.BigEndian.PutUint16(icmp[6:8], 1)
+	return append(icmp, payload...)
+}
+
+func TestParseHeadersTruncated(t *testing.T) {
+	tcpLongOffset := tcpBytes(1234, 80, 0x10, nil)
+	tcpLongOffset[12] = 15 << 4
+	tcpShortOffset := tcpBytes(1234, 80, 0x10, nil)
+	tcpShortOffset[12] = 2 << 4
+	badIHL := buildIPv4(header.UDP, udpBytes(1234, 53, nil))
+	badIHL[0] = 0x4f
+
+	tests := []struct {
+		name     string
+		raw      []byte
+		wantNext bool
+	}{
+		{"empty", nil, false},
+		{"IPv4 shorter than its header", buildIPv4(header.TCP, nil)[:12], false},
+		{"IPv4 header length past the end", badIHL, false},
+		{"TCP cut", buildIPv4(header.TCP, tcpBytes(1234, 80, 0x10, nil)[:12]), false},
+		{"TCP data offset past the end", buildIPv4(header.TCP, tcpLongOffset), false},
+		{"TCP data offset below 5", buildIPv4(header.TCP, tcpShortOffset), false},
+		{"UDP cut", buildIPv4(header.UDP, udpBytes(1234, 53, nil)[:4]), false},
+		{"UDP-Lite cut", buildIPv4(header.UDPLite, udpBytes(1234, 53, nil)[:6]), false},
+		{"ICMPv4 cut", buildIPv4(header.ICMPv4, icmpBytes(header.ICMPEchoRequest, nil)[:4]), false},
+		{"ICMPv6 cut", buildIPv6(header.ICMPv6, icmpBytes(header.ICMPv6EchoRequest, nil)[:7]), false},
+		{"IPv6 shorter than its header", buildIPv6(header.UDP, nil)[:30], false},
+		{"IPv6 extension header cut", buildIPv6(header.IPv6HopByHop, []byte{header.UDP, 0, 0, 0}), false},
+		{"TCP", buildIPv4(header.TCP, tcpBytes(1234, 80, 0x10, []byte("data"))), true},
+		{"UDP without payload", buildIPv6(header.UDP, udpBytes(1234, 53, nil)), true},
+		{"UDP-Lite", buildIPv4(header.UDPLite, udpBytes(1234, 53, nil)), true},
+		{"ICMPv4", buildIPv4(header.ICMPv4, icmpBytes(header.ICMPEchoRequest, nil)), true},
+	}
+
+	parsers := []struct {
+		name  string
+		parse func(p *Packet)
+	}{
+		{"ParseHeaders", (*Packet).ParseHeaders},
+		{"ParseHeadersNoAlloc", func(p *Packet) { p.ParseHeadersNoAlloc(&HeaderStorage{}) }},
+	}
+
+	for _, parser := range parsers {
+		for _, test := range tests {
+			t.Run(parser.name+"/"+test.name, func(t *testing.T) {
+				packet := NewPacket(test.raw, nil)
+				parser.parse(packet)
+
+				if got := packet.NextHeader != nil; got != test.wantNext {
+					t.Fatalf("NextHeader parsed: %v, want %v", got, test.wantNext)
+				}
+				if packet.IpHdr == nil {
+					t.Fatal("IpHdr is nil")
+				}
+				// 解析出的传输层头部的访问器不能越界
+				if packet.NextHeader != nil {
+					packet.NextHeader.SrcPort()
+					packet.NextHeader.DstPort()
+					packet.NextHeader.Checksum()
+				}
+			})
+		}
+	}
+}
+
+func TestParseIPv4Options(t *testing.T) {
+	// 在 IP 头后插入选项，更新 IHL 和总长度
+	withOptions := func(raw, options []byte) []byte {
+		out := append(append(append([]byte(nil), raw[:header.IPv4HeaderLen]...), options...), raw[header.IPv4HeaderLen:]...)
+		out[0] = 0x40 | uint8(header.IPv4HeaderLen+len(options))/4
+		binary.BigEndian.PutUint16(out[2:4], uint16(len(out)))
+		return out
+	}
+	routerAlert := []byte{0x94, 0x04, 0x00, 0x00}
+	recordRoute := []byte{0x07, 0x0b, 0x04, 0, 0, 0, 0, 0, 0, 0, 0, 0x00}
+	longest := append([]byte{0x07, 39, 0x04}, make([]byte, 37)...)
+
+	tests := []struct {
+		name        string
+		raw         []byte
+		options     []byte
+		wantDstPort uint16
+		wantPayload string
+	}{
+		{"router alert then UDP", buildIPv4(header.UDP, udpBytes(1234, 53, []byte("query"))), routerAlert, 53, "query"},
+		{"record route then TCP", buildIPv4(header.TCP, tcpBytes(50000, 443, 0x18, []byte("data"))), recordRoute, 443, "data"},
+		{"40 bytes of options", buildIPv4(header.UDP, udpBytes(1234, 5353, []byte("mdns"))), longest, 5353, "mdns"},
+		{"no option", buildIPv4(header.UDP, udpBytes(1234, 53, []byte("query"))), nil, 53, "query"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			packet := NewPacket(withOptions(test.raw, test.options), nil)
+			packet.ParseHeaders()
+
+			if want := header.IPv4HeaderLen + len(test.options); packet.hdrLen != want {
+				t.Fatalf("header length %d, want %d", packet.hdrLen, want)
+			}
+			ip := packet.IpHdr.(*header.IPv4Header)
+			if !bytes.Equal(ip.Options(), test.options) {
+				t.Errorf("Options() = %x, want %x", ip.Options(), test.options)
+			}
+			if packet.NextHeader == nil {
+				t.Fatal("NextHeader is nil")
+			}
+			if dstPort, err := packet.DstPort(); err != nil || dstPort != test.wantDstPort {
+				t.Errorf("DstPort() = %d, %v, want %d", dstPort, err, test.wantDstPort)
+			}
+			if payload := packet.Payload(); string(payload) != test.wantPayload {
+				t.Errorf("Payload() = %q, want %q", payload, test.wantPayload)
+			}
+
+			// 修改 TTL 后发送前重新计算 IP 校验和
+			ip.SetTTL(5)
+			if !packet.needNewChecksum() {
+				t.Error("SetTTL doesn't flag the checksums for recalculation")
+			}
+			if err := HelperCalcChecksumBatch([]*Packet{packet}); err != nil {
+				t.Fatal(err)
+			}
+			if valid, _ := checksumsValid(packet.Raw); !valid {
+				t.Error("IP checksum invalid after the recalculation")
+			}
+		})
+	}
+}
+
+func TestParseIPv6ExtensionHeaders(t *testing.T) {
+	// 返回扩展头，next 为下一个头部的协议号，length 为 8 字节的倍数
+	extension := func(next uint8, length int) []byte {
+		ext := make([]byte, length)
+		ext[0], ext[1] = next, uint8(length/8-1)
+		return ext
+	}
+	fragment := func(next uint8, offset uint16) []byte {
+		frag := []byte{next, 0, 0, 0, 0, 0, 0x12, 0x34}
+		binary.BigEndian.PutUint16(frag[2:4], offset<<3|1)
+		return frag
+	}
+	concat := func(parts ...[]byte) []byte {
+		var raw []byte
+		for _, part := range parts {
+			raw = append(raw, part...)
+		}
+		return raw
+	}
+	udp := udpBytes(5353, 53, []byte("query"))
+	tcp := tcpBytes(50000, 443, 0x18, []byte("data"))
+
+	tests := []struct {
+		name        string
+		raw         []byte
+		wantHdrLen  int
+		wantNext    uint8
+		wantDstPort uint16
+		wantPayload string
+	}{
+		{"fragment then UDP", buildIPv6(header.IPv6Fragment, concat(fragment(header.UDP, 0), udp)), header.IPv6HeaderLen + 8, header.UDP, 53, "query"},
+		{"hop-by-hop then TCP", buildIPv6(header.IPv6HopByHop, concat(extension(header.TCP, 8), tcp)), header.IPv6HeaderLen + 8, header.TCP, 443, "data"},
+		{"routing then UDP", buildIPv6(header.IPv6Routing, concat(extension(header.UDP, 24), udp)), header.IPv6HeaderLen + 24, header.UDP, 53, "query"},
+		{"chain of four headers", buildIPv6(header.IPv6HopByHop, concat(
+			extension(header.IPv6DestOptions, 8),
+			extension(header.IPv6Routing, 16),
+			extension(header.IPv6Fragment, 8),
+			fragment(header.UDP, 0), udp)), header.IPv6HeaderLen + 40, header.UDP, 53, "query"},
+		// 非首个分片没有传输层头部
+		{"later fragment", buildIPv6(header.IPv6Fragment, concat(fragment(header.UDP, 185), udp)), header.IPv6HeaderLen, header.IPv6Fragment, 0, ""},
+	}
+
+	parsers := []struct {
+		name  string
+		parse func(p *Packet)
+	}{
+		{"ParseHeaders", (*Packet).ParseHeaders},
+		{"ParseHeadersNoAlloc", func(p *Packet) { p.ParseHeadersNoAlloc(&HeaderStorage{}) }},
+	}
+
+	for _, parser := range parsers {
+		for _, test := range tests {
+			t.Run(parser.name+"/"+test.name, func(t *testing.T) {
+				packet := NewPacket(test.raw, nil)
+				parser.parse(packet)
+
+				if packet.hdrLen != test.wantHdrLen || packet.nextHeaderType != test.wantNext {
+					t.Fatalf("header length %d, next header %d, want %d, %d", packet.hdrLen, packet.nextHeaderType, test.wantHdrLen, test.wantNext)
+				}
+				if test.wantNext == header.IPv6Fragment {
+					if packet.NextHeader != nil {
+						t.Errorf("NextHeader = %v for a later fragment, want nil", packet.NextHeader)
+					}
+					return
+				}
+				if packet.NextHeader == nil {
+					t.Fatal("NextHeader is nil")
+				}
+				if dstPort, err := packet.DstPort(); err != nil || dstPort != test.wantDstPort {
+					t.Errorf("DstPort() = %d, %v, want %d", dstPort, err, test.wantDstPort)
+				}
+				if payload := packet.Payload(); string(payload) != test.wantPayload {
+					t.Errorf("Payload() = %q, want %q", payload, test.wantPayload)
+				}
+			})
+		}
+	}
+}
+
+// Replaces WinDivertHelperParsePacket: it walks the IPv6 extension headers like WinDivert,
+// rejects the packets that aren't IP and returns missing instead if it isn't nil
+// Returns the number of calls
+func fakeParseHeaders(t *testing.T, missing error) *int {
+	saved := divertParseHeaders
+	t.Cleanup(func() { divertParseHeaders = saved })
+
+	calls := new(int)
+	divertParseHeaders = func(raw []byte) (helperHeaders, error) {
+		*calls++
+		if missing != nil {
+			return helperHeaders{}, missing
+		}
+
+		parsed := helperHeaders{ipVersion: int(raw[0] >> 4), transport: len(raw)}
+		offset := 0
+		switch parsed.ipVersion {
+		case header.IPv4:
+			parsed.protocol, offset = raw[9], int(raw[0]&0xf)*4
+		case header.IPv6:
+			parsed.protocol, offset = raw[6], header.IPv6HeaderLen
+			for offset+8 <= len(raw) {
+				if parsed.protocol == header.IPv6Fragment {
+					parsed.protocol, offset = raw[offset], offset+8
+				} else if parsed.protocol == header.IPv6HopByHop || parsed.protocol == header.IPv6Routing || parsed.protocol == header.IPv6DestOptions {
+					parsed.protocol, offset = raw[offset], offset+(int(raw[offset+1])+1)*8
+				} else {
+					break
+				}
+			}
+		default:
+			return helperHeaders{}, nil
+		}
+		if offset < len(raw) {
+			parsed.transport = offset
+		}
+		return parsed, nil
+	}
+	return calls
+}
+
+func TestParseWithHelper(t *testing.T) {
+	hopByHopTCP := buildIPv6(header.IPv6HopByHop, hopByHop(header.TCP, tcpBytes(50000, 443, 0x18, []byte("data"))))
+
+	tests := []struct {
+		name        string
+		raw         []byte
+		missing     error
+		wantHdrLen  int
+		wantNext    uint8
+		wantDstPort uint16
+		wantPayload string
+	}{
+		{"IPv4 UDP", buildIPv4(header.UDP, udpBytes(5353, 53, []byte("query"))), nil, header.IPv4HeaderLen, header.UDP, 53, "query"},
+		{"IPv6 hop-by-hop then TCP", hopByHopTCP, nil, header.IPv6HeaderLen + 8, header.TCP, 443, "data"},
+		{"IPv6 no next header", buildIPv6(59, nil), nil, header.IPv6HeaderLen, 59, 0, ""},
+		{"IPv4 without its TCP header", buildIPv4(header.TCP, nil), nil, header.IPv4HeaderLen, header.TCP, 0, ""},
+		// DLL 没有导出这个函数时退回 ParseHeaders
+		{"helper missing", hopByHopTCP, errors.New("proc WinDivertHelperParsePacket not found"), header.IPv6HeaderLen + 8, header.TCP, 443, "data"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			calls := fakeParseHeaders(t, test.missing)
+			packet := NewPacket(test.raw, nil)
+			packet.ParseWithHelper()
+
+			if *calls != 1 {
+				t.Errorf("helper called %d times, want 1", *calls)
+			}
+			if !packet.parsed || packet.hdrLen != test.wantHdrLen || packet.nextHeaderType != test.wantNext {
+				t.Fatalf("parsed %v, header length %d, next header %d, want %d, %d", packet.parsed, packet.hdrLen, packet.nextHeaderType, test.wantHdrLen, test.wantNext)
+			}
+			if packet.IpHdr == nil || packet.IpVersion() != int(test.raw[0]>>4) {
+				t.Errorf("IpHdr %v, version %d", packet.IpHdr, packet.IpVersion())
+			}
+			if test.wantDstPort == 0 {
+				if packet.NextHeader != nil {
+					t.Errorf("NextHeader = %v without a transport header, want nil", packet.NextHeader)
+				}
+				return
+			}
+			if dstPort, err := packet.DstPort(); err != nil || dstPort != test.wantDstPort {
+				t.Errorf("DstPort() = %d, %v, want %d", dstPort, err, test.wantDstPort)
+			}
+			if payload := packet.Payload(); string(payload) != test.wantPayload {
+				t.Errorf("Payload() = %q, want %q", payload, test.wantPayload)
+			}
+		})
+	}
+
+	// WinDivert 拒绝的包由 ParseHeaders 解析
+	fakeParseHeaders(t, nil)
+	packet := NewPacket([]byte{0x10, 0, 0, 0}, nil)
+	packet.ParseWithHelper()
+	// ParseHeaders 直接取第一个字节的版本号
+	if !packet.parsed || packet.IpVersion() != 1 {
+		t.Errorf("rejected packet: parsed %v, version %d, want parsed by ParseHeaders", packet.parsed, packet.IpVersion())
+	}
+}
+
+// Replaces WinDivertHelperDecrementTTL: it rejects the packets whose IPv4 total length doesn't match,
+// decrements the field and fixes the IPv4 checksum like WinDivert does
+func fakeDecrementTTL(t *testing.T) {
+	saved := divertDecrementTTL
+	t.Cleanup(func() { divertDecrementTTL = saved })
+
+	divertDecrementTTL = func(raw []byte) bool {
+		if raw[0]>>4 == header.IPv6 {
+			if raw[7] == 0 {
+				return false
+			}
+			raw[7]--
+			return raw[7] != 0
+		}
+		if int(binary.BigEndian.Uint16(raw[2:4])) != len(raw) || raw[8] == 0 {
+			return false
+		}
+		raw[8]--
+		binary.BigEndian.PutUint16(raw[10:12], 0)
+		binary.BigEndian.PutUint16(raw[10:12], foldChecksum(sumBytes(raw[:header.IPv4HeaderLen], 0)))
+		return raw[8] != 0
+	}
+}
+
+func TestDecrementTTLHelper(t *testing.T) {
+	withTTL := func(raw []byte, ttl uint8) []byte {
+		if raw[0]>>4 == header.IPv6 {
+			raw[7] = ttl
+			return raw
+		}
+		raw[8] = ttl
+		binary.BigEndian.PutUint16(raw[10:12], 0)
+		binary.BigEndian.PutUint16(raw[10:12], foldChecksum(sumBytes(raw[:header.IPv4HeaderLen], 0)))
+		return raw
+	}
+	udp := udpBytes(1234, 53, []byte("query"))
+	badLength := buildIPv4(header.UDP, udp)
+	binary.BigEndian.PutUint16(badLength[2:4], 1000)
+
+	tests := []struct {
+		name        string
+		raw         []byte
+		wantTTL     uint8
+		wantExpired bool
+		wantErr     bool
+	}{
+		{"IPv4", buildIPv4(header.UDP, udp), 63, false, false},
+		{"IPv4 expired", withTTL(buildIPv4(header.UDP, udp), 1), 0, true, false},
+		{"IPv4 TTL already 0", withTTL(buildIPv4(header.UDP, udp), 0), 0, true, false},
+		{"IPv6", buildIPv6(header.UDP, udp), 63, false, false},
+		{"IPv6 expired", withTTL(buildIPv6(header.UDP, udp), 1), 0, true, false},
+		{"rejected by WinDivert", withTTL(badLength, 64), 64, false, true},
+		{"empty", nil, 0, false, true},
+		{"IPv4 shorter than its header", buildIPv4(header.UDP, nil)[:12], 64, false, true},
+		{"not IP", []byte{0x10, 0, 0, 0}, 0, false, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fakeDecrementTTL(t)
+			packet := NewPacket(test.raw, nil)
+			packet.ParseHeaders()
+
+			expired, err := packet.DecrementTTLHelper()
+			if expired != test.wantExpired || (err != nil) != test.wantErr {
+				t.Fatalf("DecrementTTLHelper() = %v, %v, want %v, error %v", expired, err, test.wantExpired, test.wantErr)
+			}
+			if len(test.raw) < header.IPv4HeaderLen {
+				return
+			}
+			ttl := test.raw[8]
+			if packet.ipVersion == header.IPv6 {
+				ttl = test.raw[7]
+			}
+			if ttl != test.wantTTL {
+				t.Errorf("TTL = %d, want %d", ttl, test.wantTTL)
+			}
+			// 校验和已由 WinDivert 增量更新，不需要重新计算
+			if packet.needNewChecksum() {
+				t.Error("the packet is marked as modified")
+			}
+			if ip, _ := checksumsValid(test.raw); packet.ipVersion == header.IPv4 && !ip {
+				t.Error("the IPv4 checksum isn't valid anymore")
+			}
+		})
+	}
+}
+
+func TestIPProtocolString(t *testing.T) {
+	tests := []struct {
+		raw  []byte
+		want string
+	}{
+		{buildIPv4(header.TCP, tcpBytes(1234, 80, 0x02, nil)), "TCP(6)"},
+		{buildIPv4(47, make([]byte, 4)), "GRE(47)"},
+		{buildIPv6(132, make([]byte, 12)), "SCTP(132)"},
+		{buildIPv4(200, nil), "Unimplemented Protocol(200)"},
+	}
+
+	for _, test := range tests {
+		packet := NewPacket(test.raw, nil)
+		packet.ParseHeaders()
+		if got := packet.IPProtocolString(); got != test.want {
+			t.Errorf("IPProtocolString() = %q, want %q", got, test.want)
+		}
+	}
+}
+
+func TestParseHeadersNoAlloc(t *testing.T) {
+	packets := [][]byte{
+		buildIPv4(header.TCP, tcpBytes(1234, 80, 0x18, []byte("data"))),
+		buildIPv4(header.UDP, udpBytes(1234, 53, []byte("query"))),
+		buildIPv4(header.ICMPv4, icmpBytes(header.ICMPEchoRequest, nil)),
+		buildIPv6(header.UDP, udpBytes(1234, 53, nil)),
+		buildIPv6(header.ICMPv6, icmpBytes(header.ICMPv6EchoRequest, nil)),
+		buildIPv4(header.UDPLite, udpBytes(1234, 53, nil)),
+	}
+
+	var hdrs HeaderStorage
+	for i, raw := range packets {
+		want := NewPacket(raw, nil)
+		want.ParseHeaders()
+		got := NewPacket(raw, nil)
+		got.ParseHeadersNoAlloc(&hdrs)
+
+		// 两种解析方式得到相同的头部
+		if got.IpHdr.String() != want.IpHdr.String() || got.NextHeader.String() != want.NextHeader.String() {
+			t.Errorf("packet %d parsed as %v %v, want %v %v", i, got.IpHdr, got.NextHeader, want.IpHdr, want.NextHeader)
+		}
+	}
+
+	packet := NewPacket(packets[0], nil)
+	allocs := testing.AllocsPerRun(100, func() {
+		packet.ParseHeadersNoAlloc(&hdrs)
+	})
+	if allocs != 0 {
+		t.Errorf("ParseHeadersNoAlloc() allocates %v times", allocs)
+	}
+}
+
+func BenchmarkParseHeaders(b *testing.B) {
+	packet := NewPacket(buildIPv4(header.TCP, tcpBytes(1234, 80, 0x18, make([]byte, 1200))), nil)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		packet.ParseHeaders()
+	}
+}
+
+func BenchmarkParseHeadersNoAlloc(b *testing.B) {
+	packet := NewPacket(buildIPv4(header.TCP, tcpBytes(1234, 80, 0x18, make([]byte, 1200))), nil)
+	var hdrs HeaderStorage
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		packet.ParseHeadersNoAlloc(&hdrs)
+	}
+}
+
+func TestPacketHash(t *testing.T) {
+	saved := divertHashPacket
+	t.Cleanup(func() { divertHashPacket = saved })
+	var hashed []byte
+	divertHashPacket = func(packet []byte, seed uint64) uint64 {
+		hashed = packet
+		return seed + uint64(len(packet))
+	}
+
+	raw := buildIPv4(header.UDP, udpBytes(1234, 53, []byte("payload")))
+	tests := []struct {
+		name   string
+		packet *Packet
+		want   uint64
+		called bool
+	}{
+		{"whole packet", NewPacket(raw, nil), 100 + uint64(len(raw)), true},
+		// PacketLen 仍是截断前的长度
+		{"Raw shorter than PacketLen", &Packet{Raw: raw[:header.IPv4HeaderLen], PacketLen: uint(len(raw))}, 100 + header.IPv4HeaderLen, true},
+		{"empty", NewPacket(nil, nil), 0, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hashed = nil
+			if got := test.packet.Hash(100); got != test.want {
+				t.Errorf("Hash() = %d, want %d", got, test.want)
+			}
+			if (hashed != nil) != test.called || test.called && len(hashed) != len(test.packet.Raw) {
+				t.Errorf("hashed %d bytes, want the %d bytes of Raw", len(hashed), len(test.packet.Raw))
+			}
+		})
+	}
+}
+
+func TestSummary(t *testing.T) {
+	inbound := NewAddress()
+	inbound.SetOutbound(false)
+
+	tests := []struct {
+		name string
+		raw  []byte
+		addr *WinDivertAddress
+		want string
+	}{
+		{"TCP SYN", buildIPv4(header.TCP, tcpBytes(54321, 443, 0x02, nil)), NewAddress(), "OUT 10.0.0.1:54321 > 10.0.0.2:443 TCP [SYN] len=0"},
+		{"TCP data", buildIPv4(header.TCP, tcpBytes(443, 54321, 0x18, []byte("hello"))), inbound, "IN 10.0.0.1:443 > 10.0.0.2:54321 TCP [PSH,ACK] len=5"},
+		{"UDP", buildIPv4(header.UDP, udpBytes(1234, 53, []byte("query"))), NewAddress(), "OUT 10.0.0.1:1234 > 10.0.0.2:53 UDP len=5"},
+		{"UDP over IPv6", buildIPv6(header.UDP, udpBytes(1234, 53, nil)), inbound, "IN [fd00::1]:1234 > [fd00::2]:53 UDP len=0"},
+		{"ICMP", buildIPv4(header.ICMPv4, icmpBytes(8, []byte("ping"))), NewAddress(), "OUT 10.0.0.1 > 10.0.0.2 ICMPv4 len=4"},
+		{"without address", buildIPv4(header.UDP, udpBytes(1234, 53, nil)), nil, "10.0.0.1:1234 > 10.0.0.2:53 UDP len=0"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := NewPacket(test.raw, test.addr).Summary(); got != test.want {
+				t.Errorf("Summary() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFixLengths(t *testing.T) {
+	payload := []byte("payload")
+
+	// TCP 头后插入 MSS 选项
+	withTCPOption := buildIPv4(header.TCP, tcpBytes(50000, 80, 0x18, payload))
+	tcpEnd := header.IPv4HeaderLen + header.TCPHeaderLen
+	withTCPOption = append(withTCPOption[:tcpEnd], append([]byte{header.TCPOptionMSS, 4, 0x05, 0xb4}, withTCPOption[tcpEnd:]...)...)
+	withTCPOption[header.IPv4HeaderLen+12] = 6 << 4
+
+	// IPv4 头后插入 Router Alert 选项
+	withIPOption := buildIPv4(header.UDP, udpBytes(1234, 53, payload))
+	withIPOption = append(withIPOption[:header.IPv4HeaderLen], append([]byte{0x94, 4, 0, 0}, withIPOption[header.IPv4HeaderLen:]...)...)
+	withIPOption[0] = 0x46
+
+	// UDP 负载变长
+	longerUDP := append(buildIPv6(header.UDP, udpBytes(1234, 53, payload)), "more"...)
+
+	tests := []struct {
+		name         string
+		raw          []byte
+		wantHdrLen   int
+		wantNextLen  int
+		wantUDPLen   int
+		wantDstPort  uint16
+		wantIPLength int
+	}{
+		{"TCP option inserted", withTCPOption, header.IPv4HeaderLen, header.TCPHeaderLen + 4, 0, 80, len(withTCPOption)},
+		{"IPv4 options added", withIPOption, header.IPv4HeaderLen + 4, header.UDPHeaderLen, header.UDPHeaderLen + len(payload), 53, len(withIPOption)},
+		{"IPv6 UDP payload grown", longerUDP, header.IPv6HeaderLen, header.UDPHeaderLen, header.UDPHeaderLen + len(payload) + 4, 53, len(longerUDP) - header.IPv6HeaderLen},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			packet := NewPacket(test.raw, NewAddress())
+			packet.PacketLen = 20
+			packet.FixLengths()
+
+			if int(packet.PacketLen) != len(test.raw) {
+				t.Errorf("PacketLen %d, want %d", packet.PacketLen, len(test.raw))
+			}
+			if packet.IpVersion() == header.IPv4 {
+				if got := int(binary.BigEndian.Uint16(test.raw[2:4])); got != test.wantIPLength {
+					t.Errorf("total length %d, want %d", got, test.wantIPLength)
+				}
+			} else if got := int(binary.BigEndian.Uint16(test.raw[4:6])); got != test.wantIPLength {
+				t.Errorf("payload length %d, want %d", got, test.wantIPLength)
+			}
+			if packet.hdrLen != test.wantHdrLen || packet.NextHeader.HeaderLen() != test.wantNextLen {
+				t.Fatalf("headers of %d and %d bytes, want %d and %d", packet.hdrLen, packet.NextHeader.HeaderLen(), test.wantHdrLen, test.wantNextLen)
+			}
+			if dstPort, _ := packet.DstPort(); dstPort != test.wantDstPort {
+				t.Errorf("destination port %d, want %d", dstPort, test.wantDstPort)
+			}
+			if udp, ok := packet.NextHeader.(*header.UDPHeader); ok {
+				if got := int(binary.BigEndian.Uint16(udp.Raw[4:6])); got != test.wantUDPLen {
+					t.Errorf("UDP length %d, want %d", got, test.wantUDPLen)
+				}
+				if !udp.Modified {
+					t.Error("the UDP header isn't marked as modified")
+				}
+			}
+			if tcp, ok := packet.NextHeader.(*header.TCPHeader); ok && (!bytes.Equal(tcp.Payload, payload) || !tcp.Modified) {
+				t.Errorf("TCP payload %q, modified %v, want %q and modified", tcp.Payload, tcp.Modified, payload)
+			}
+		})
+	}
+}
+
+func TestGrowPacketKeepsPooling(t *testing.T) {
+	tests := []struct {
+		name       string
+		bufferSize int
+		payload    int
+		wantShared bool
+	}{
+		{"within the pooled buffer", 0, 1000, true},
+		{"past the pooled buffer", 333, 1000, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			saved := ZeroReturnedBuffers
+			ZeroReturnedBuffers = true
+			t.Cleanup(func() { ZeroReturnedBuffers = saved })
+
+			driver := newFakeDriver(t)
+			wd := openFake(t, OpenOptions{BufferSize: test.bufferSize})
+			driver.divert(buildIPv4(header.TCP, tcpBytes(50000, 80, 0x18, []byte("hello"))), *NewAddress())
+			packet, err := wd.Recv()
+			if err != nil {
+				t.Fatal(err)
+			}
+			buffer := packet.Buffer
+			packet.ParseHeaders()
+
+			payload := bytes.Repeat([]byte{0xab}, test.payload)
+			packet.NextHeader.(*header.TCPHeader).SetPayload(payload)
+			packet.UpdateTCPHeader()
+			wantLen := header.IPv4HeaderLen + header.TCPHeaderLen + test.payload
+			if len(packet.Raw) != wantLen || int(packet.PacketLen) != wantLen {
+				t.Fatalf("%d bytes, PacketLen %d after growing, want %d", len(packet.Raw), packet.PacketLen, wantLen)
+			}
+			if packet.sharesBuffer() != test.wantShared || cap(packet.Buffer) != wd.BufferSize() {
+				t.Fatalf("Raw in the pooled buffer %v, buffer capacity %d, want %v and %d", packet.sharesBuffer(), cap(packet.Buffer), test.wantShared, wd.BufferSize())
+			}
+
+			if _, err := wd.Send(packet); err != nil {
+				t.Fatalf("Send() = %v", err)
+			}
+			sent := driver.injected()
+			if len(sent) != 1 || len(sent[0].raw) != wantLen || !bytes.Equal(sent[0].raw[wantLen-test.payload:], payload) {
+				t.Fatal("the grown packet isn't injected whole")
+			}
+			// 缓冲区按原大小放回缓冲池，写过的字节都被清零
+			written := min(wantLen, len(buffer))
+			if !test.wantShared {
+				written = header.IPv4HeaderLen + header.TCPHeaderLen + 5
+			}
+			if !bytes.Equal(buffer[:written], make([]byte, written)) {
+				t.Error("the buffer returned to the pool isn't cleared, it wasn't recognized as pooled")
+			}
+		})
+	}
+}
+
+func TestGrowPacketRelease(t *testing.T) {
+	tests := []struct {
+		name        string
+		bufferSize  int
+		payload     int
+		wantCleared int
+	}{
+		// 在缓冲区内增长时清理增长后的字节，否则只清理接收时写入的字节
+		{"within the pooled buffer", 0, 1000, header.IPv4HeaderLen + header.TCPHeaderLen + 1000},
+		{"past the pooled buffer", 333, 1000, header.IPv4HeaderLen + header.TCPHeaderLen + 5},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			saved := ZeroReturnedBuffers
+			ZeroReturnedBuffers = true
+			t.Cleanup(func() { ZeroReturnedBuffers = saved })
+
+			newFakeDriver(t)
+			wd := openFake(t, OpenOptions{BufferSize: test.bufferSize})
+			packet := fakeRecv(wd, buildIPv4(header.TCP, tcpBytes(50000, 80, 0x18, []byte("hello"))))
+			buffer := packet.Buffer
+			packet.ParseHeaders()
+			packet.NextHeader.(*header.TCPHeader).SetPayload(bytes.Repeat([]byte{0xab}, test.payload))
+			packet.UpdateTCPHeader()
+
+			packet.Release()
+			if !bytes.Equal(buffer[:test.wantCleared], make([]byte, test.wantCleared)) {
+				t.Error("the buffer released after growing isn't cleared, it wasn't returned to the pool")
+			}
+			if _, err := wd.Send(packet); err != ErrPacketReleased {
+				t.Errorf("Send() after Release = %v, want ErrPacketReleased", err)
+			}
+		})
+	}
+}
+
+// Workers growing and sending packets while others are received, -race checks no grown packet
+// still uses a buffer returned to the pool
+func TestGrowPacketSendConcurrent(t *testing.T) {
+	const workers, perWorker = 8, 50
+	driver := newFakeDriver(t)
+	wd := openFake(t, OpenOptions{BufferSize: 512})
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				packet := fakeRecv(wd, buildIPv4(header.TCP, tcpBytes(uint16(w), uint16(i), 0x18, []byte("hello"))))
+				packet.ParseHeaders()
+				// 一半的包增长到超过缓冲区
+				size := 100
+				if i%2 == 1 {
+					size = 600
+				}
+				packet.NextHeader.(*header.TCPHeader).SetPayload(bytes.Repeat([]byte{byte(w)}, size))
+				packet.UpdateTCPHeader()
+				if _, err := wd.Send(packet); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	sent := driver.injected()
+	if len(sent) != workers*perWorker {
+		t.Fatalf("%d packets injected, want %d", len(sent), workers*perWorker)
+	}
+	for _, p := range sent {
+		packet := NewPacket(p.raw, nil)
+		packet.ParseHeaders()
+		w, _ := packet.SrcPort()
+		i, _ := packet.DstPort()
+		want := 100
+		if i%2 == 1 {
+			want = 600
+		}
+		if payload := packet.Payload(); len(payload) != want || !bytes.Equal(payload, bytes.Repeat([]byte{byte(w)}, want)) {
+			t.Fatalf("packet %d of worker %d injected with a payload of %d bytes, changed after Send", i, w, len(payload))
+		}
+	}
+}
+
+func TestReverseDirectionLoopback(t *testing.T) {
+	tests := []struct {
+		name         string
+		loopback     bool
+		outbound     bool
+		wantOutbound bool
+	}{
+		{"loopback", true, true, true},
+		{"outbound", false, true, false},
+		{"inbound", false, false, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			driver := newFakeDriver(t)
+			wd := openFake(t, OpenOptions{})
+			// 和 WinDivert 一样拒绝入站的环回包
+			fakeSend := divertSend
+			divertSend = func(handle uintptr, packet *byte, packetLen uint, sendLen *uint, addr *WinDivertAddress) error {
+				if addr.Loopback() && !addr.Outbound() {
+					return syscall.Errno(87)
+				}
+				return fakeSend(handle, packet, packetLen, sendLen, addr)
+			}
+
+			addr := NewAddress()
+			addr.SetOutbound(test.outbound)
+			addr.setBit(addrLoopbackBit, test.loopback)
+			packet := NewPacket(buildIPv4(header.UDP, udpBytes(1234, 53, nil)), addr)
+
+			packet.ReverseDirection()
+			if packet.Addr.Outbound() != test.wantOutbound {
+				t.Fatalf("outbound %v after ReverseDirection, want %v", packet.Addr.Outbound(), test.wantOutbound)
+			}
+			packet.SetDirection(WinDivertDirectionInbound)
+			if packet.Addr.Outbound() != test.loopback {
+				t.Fatalf("outbound %v after SetDirection(inbound), want %v", packet.Addr.Outbound(), test.loopback)
+			}
+			if _, err := wd.Send(packet); err != nil {
+				t.Fatalf("Send() = %v", err)
+			}
+			if n := len(driver.injected()); n != 1 {
+				t.Errorf("%d packets injected, want 1", n)
+			}
+		})
+	}
+}
+
+func TestPacketMetadata(t *testing.T) {
+	tests := []struct {
+		name    string
+		release func(wd *WinDivertHandle, p *Packet)
+	}{
+		{"sent", func(wd *WinDivertHandle, p *Packet) { wd.Send(p) }},
+		{"dropped", func(wd *WinDivertHandle, p *Packet) { wd.dropPacket(p) }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			driver := newFakeDriver(t)
+			wd := openFake(t, OpenOptions{})
+			driver.divert(ipv4Packet(20), *NewAddress())
+			packet, err := wd.Recv()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if packet.Metadata != nil {
+				t.Fatal("Metadata allocated before the first SetMeta")
+			}
+			if _, ok := packet.GetMeta("rule"); ok {
+				t.Error("GetMeta found a value on an empty packet")
+			}
+			packet.SetMeta("rule", 3)
+			packet.SetMeta("flagged", true)
+			packet.SetMeta("rule", 4)
+			if value, ok := packet.GetMeta("rule"); !ok || value != 4 {
+				t.Errorf("GetMeta(rule) = %v, %v, want 4", value, ok)
+			}
+			if value, ok := packet.GetMeta("flagged"); !ok || value != true {
+				t.Errorf("GetMeta(flagged) = %v, %v, want true", value, ok)
+			}
+
+			// 缓冲区放回缓冲池时清空
+			test.release(wd, packet)
+			if packet.Metadata != nil {
+				t.Errorf("Metadata %v left once the buffer is returned", packet.Metadata)
+			}
+		})
+	}
+}
+
+func TestCopyTo(t *testing.T) {
+	raw := buildIPv4(header.UDP, udpBytes(1234, 53, []byte("query")))
+
+	tests := []struct {
+		name    string
+		dst     []byte
+		wantErr bool
+	}{
+		{"exact size", make([]byte, len(raw)), false},
+		{"larger", make([]byte, len(raw)+10), false},
+		{"too small", make([]byte, len(raw)-1), true},
+		{"nil", nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			packet := NewPacket(append([]byte(nil), raw...), nil)
+			n, err := packet.CopyTo(test.dst)
+			if test.wantErr {
+				if err == nil || n != 0 {
+					t.Errorf("CopyTo() = %d, %v, want an error", n, err)
+				}
+				return
+			}
+			if err != nil || n != len(raw) || !bytes.Equal(test.dst[:n], raw) {
+				t.Fatalf("CopyTo() = %d, %v, want the %d bytes of the packet", n, err, len(raw))
+			}
+			// 副本不依赖包的缓冲区
+			packet.Raw[0] = 0
+			if test.dst[0] != raw[0] {
+				t.Error("the copy shares the packet's bytes")
+			}
+		})
+	}
+}
+
+func TestMatchBytes(t *testing.T) {
+	packet := NewPacket(buildIPv4(header.TCP, tcpBytes(50000, 443, 0x02, nil)), nil)
+	dstPort := header.IPv4HeaderLen + 2
+
+	tests := []struct {
+		name        string
+		offset      int
+		mask, value []byte
+		want        bool
+	}{
+		{"TCP protocol byte", 9, []byte{0xff}, []byte{header.TCP}, true},
+		{"UDP protocol byte", 9, nil, []byte{header.UDP}, false},
+		{"destination port", dstPort, nil, []byte{0x01, 0xbb}, true},
+		{"other destination port", dstPort, nil, []byte{0x00, 0x50}, false},
+		{"IP version under a mask", 0, []byte{0xf0}, []byte{0x40}, true},
+		{"SYN flag under a mask", header.IPv4HeaderLen + 13, []byte{0x02}, []byte{0x02}, true},
+		{"last byte", len(packet.Raw) - 1, nil, packet.Raw[len(packet.Raw)-1:], true},
+		{"past the end", len(packet.Raw) - 1, nil, []byte{0, 0}, false},
+		{"negative offset", -1, nil, []byte{0x45}, false},
+		{"mask of another length", 9, []byte{0xff, 0xff}, []byte{header.TCP}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := packet.MatchBytes(test.offset, test.mask, test.value); got != test.want {
+				t.Errorf("MatchBytes(%d, %x, %x) = %v, want %v", test.offset, test.mask, test.value, got, test.want)
+			}
+		})
+	}
+	if packet.parsed {
+		t.Error("MatchBytes parsed the headers")
+	}
+}
+
+func TestCloneOwnsHeaders(t *testing.T) {
+	addr := NewAddress()
+	original := NewPacket(buildIPv4(header.TCP, tcpBytes(50000, 443, 0x18, []byte("data"))), addr)
+	original.ParseHeaders()
+	original.SetMeta("rule", 1)
+
+	clone := original.Clone()
+	clone.ParseHeaders()
+	clone.SetDstPort(8443)
+	clone.SetSrcIP(net.ParseIP("10.9.9.9"))
+	clone.Addr.SetOutbound(false)
+	clone.SetMeta("rule", 2)
+
+	if dstPort, _ := original.DstPort(); dstPort != 443 {
+		t.Errorf("original destination port %d after changing the clone's, want 443", dstPort)
+	}
+	if got := binary.BigEndian.Uint16(original.Raw[header.IPv4HeaderLen+2:]); got != 443 {
+		t.Errorf("original bytes carry the port %d, want 443", got)
+	}
+	if !original.SrcIP().Equal(net.ParseIP("10.0.0.1")) || !original.Addr.Outbound() {
+		t.Errorf("original source %v, outbound %v, want them unchanged", original.SrcIP(), original.Addr.Outbound())
+	}
+	if value, _ := original.GetMeta("rule"); value != 1 {
+		t.Errorf("original metadata %v, want 1", value)
+	}
+	if dstPort, _ := clone.DstPort(); dstPort != 8443 {
+		t.Errorf("clone destination port %d, want 8443", dstPort)
+	}
+	if clone.pooled() {
+		t.Error("the clone holds a pooled buffer")
+	}
+}
+
+func TestCloneOutlivesSend(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     []byte
+		wantSrc string
+	}{
+		{"IPv4 TCP", buildIPv4(header.TCP, tcpBytes(50000, 443, 0x18, []byte("data"))), "10.0.0.1"},
+		{"IPv6 UDP", buildIPv6(header.UDP, udpBytes(1234, 53, []byte("query"))), "fd00::1"},
+		{"ICMP", buildIPv4(header.ICMPv4, icmpBytes(8, []byte("ping"))), "10.0.0.1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			driver := newFakeDriver(t)
+			wd := openFake(t, OpenOptions{})
+
+			original := fakeRecv(wd, test.raw)
+			original.Addr.SetIfIdx(3)
+			srcPort, _ := original.SrcPort()
+			clone := original.Clone()
+			if &clone.Raw[0] == &original.Buffer[0] || clone.parsed {
+				t.Fatal("the clone shares the pooled buffer or its parsed headers")
+			}
+
+			if _, err := wd.Send(original); err != nil {
+				t.Fatal(err)
+			}
+			// 缓冲区已经放回缓冲池，模拟下一次接收覆盖它
+			buffer := original.Buffer[:cap(original.Buffer)]
+			for i := range buffer {
+				buffer[i] = 0xff
+			}
+			reused := wd.buffers.get()
+			for i := range reused {
+				reused[i] = 0xee
+			}
+			wd.buffers.put(reused, len(reused))
+
+			if !bytes.Equal(clone.Raw, test.raw) {
+				t.Errorf("clone bytes %x after the pool was reused, want %x", clone.Raw, test.raw)
+			}
+			if clone.Addr.IfIdx() != 3 {
+				t.Errorf("clone interface %d, want 3", clone.Addr.IfIdx())
+			}
+			if got, _ := clone.SrcPort(); got != srcPort || !clone.SrcIP().Equal(net.ParseIP(test.wantSrc)) {
+				t.Errorf("clone parsed source %v port %d from stale bytes", clone.SrcIP(), got)
+			}
+
+			// 克隆不持有缓冲池的缓冲区，可以多次发送
+			for i := 0; i < 2; i++ {
+				if _, err := wd.Send(clone); err != nil {
+					t.Fatalf("Send(clone) #%d = %v", i+1, err)
+				}
+			}
+			if sent := driver.injected(); len(sent) != 3 || !bytes.Equal(sent[2].raw, test.raw) {
+				t.Errorf("%d packets injected, want the original and the clone twice", len(sent))
+			}
+		})
+	}
+}
+
+func TestTCPFlagsString(t *testing.T) {
+	flags, err := NewPacket(buildIPv4(header.TCP, tcpBytes(50000, 443, 0x12, nil)), nil).TCPFlagsString()
+	if err != nil || flags != "SYN,ACK" {
+		t.Errorf("TCPFlagsString() = %q, %v, want SYN,ACK", flags, err)
+	}
+	if flags, err := NewPacket(buildIPv4(header.UDP, udpBytes(1234, 53, nil)), nil).TCPFlagsString(); err == nil {
+		t.Errorf("TCPFlagsString() = %q on a UDP packet, want an error", flags)
+	}
+}
@@ -0,0 +1,100 @@
+package godivert
+
+import (
+	"errors"
+	"fmt"
+)
+
+// WINDIVERT_PARAM values
+type Param uint32
+
+const (
+	// Maximum number of packets in the queue
+	WinDivertParamQueueLength Param = 0
+	// Maximum time in milliseconds a packet stays in the queue before being dropped
+	WinDivertParamQueueTime Param = 1
+	// Maximum number of bytes in the queue
+	WinDivertParamQueueSize Param = 2
+	// Major and minor version of the driver, read-only
+	WinDivertParamVersionMajor Param = 3
+	WinDivertParamVersionMinor Param = 4
+)
+
+// Default, minimum and maximum values of the queue parameters
+const (
+	WinDivertParamQueueLengthDefault = 4096
+	WinDivertParamQueueLengthMin     = 32
+	WinDivertParamQueueLengthMax     = 16384
+	WinDivertParamQueueTimeDefault   = 2000
+	WinDivertParamQueueTimeMin       = 100
+	WinDivertParamQueueTimeMax       = 16000
+	WinDivertParamQueueSizeDefault   = 4194304
+	WinDivertParamQueueSizeMin       = 65535
+	WinDivertParamQueueSizeMax       = 33554432
+)
+
+func (p Param) String() string {
+	switch p {
+	case WinDivertParamQueueLength:
+		return "QueueLength"
+	case WinDivertParamQueueTime:
+		return "QueueTime"
+	case WinDivertParamQueueSize:
+		return "QueueSize"
+	case WinDivertParamVersionMajor:
+		return "VersionMajor"
+	case WinDivertParamVersionMinor:
+		return "VersionMinor"
+	default:
+		return fmt.Sprintf("Param(%d)", uint32(p))
+	}
+}
+
+// Returns the range of the values WinDivert accepts for the parameter, ok is false if it is read-only
+func (p Param) bounds() (min, max uint64, ok bool) {
+	switch p {
+	case WinDivertParamQueueLength:
+		return WinDivertParamQueueLengthMin, WinDivertParamQueueLengthMax, true
+	case WinDivertParamQueueTime:
+		return WinDivertParamQueueTimeMin, WinDivertParamQueueTimeMax, true
+	case WinDivertParamQueueSize:
+		return WinDivertParamQueueSizeMin, WinDivertParamQueueSizeMax, true
+	default:
+		return 0, 0, false
+	}
+}
+
+// Sets a queue parameter of the handle, e.g. raise WinDivertParamQueueLength when packets are dropped
+// Returns an error if the value is out of the range documented by WinDivert or the parameter is read-only
+// https://reqrypt.org/windivert-doc.html#divert_set_param
+func (wd *WinDivertHandle) SetParam(param Param, value uint64) error {
+	if !wd.open.Load() {
+		return errors.New("can't set a parameter, the handle isn't open")
+	}
+	min, max, ok := param.bounds()
+	if !ok {
+		return fmt.Errorf("the %v parameter can't be set", param)
+	}
+	if value < min || value > max {
+		return fmt.Errorf("invalid %v %d, must be between %d and %d", param, value, min, max)
+	}
+
+	return divertSetParam(wd.handle, param, value)
+}
+
+// Returns the value of a parameter of the handle
+// https://reqrypt.org/windivert-doc.html#divert_get_param
+func (wd *WinDivertHandle) GetParam(param Param) (uint64, error) {
+	if !wd.open.Load() {
+		return 0, errors.New("can't get a parameter, the handle isn't open")
+	}
+	if param > WinDivertParamVersionMinor {
+		return 0, fmt.Errorf("invalid parameter %d", uint32(param))
+	}
+
+	var value uint64
+	if err := divertGetParam(wd.handle, param, &value); err != nil {
+		return 0, err
+	}
+	return value, nil
+}
@@ -0,0 +1,130 @@
+package godivert
+
+import (
+	"math"
+	"testing"
+)
+
+// Replaces the parameter calls of the DLL by a table of values, the driver version is 2.2
+func fakeParams(t *testing.T) map[Param]uint64 {
+	savedSet, savedGet := divertSetParam, divertGetParam
+	t.Cleanup(func() {
+		divertSetParam, divertGetParam = savedSet, savedGet
+	})
+
+	params := map[Param]uint64{
+		WinDivertParamQueueLength:  WinDivertParamQueueLengthDefault,
+		WinDivertParamQueueTime:    WinDivertParamQueueTimeDefault,
+		WinDivertParamQueueSize:    WinDivertParamQueueSizeDefault,
+		WinDivertParamVersionMajor: 2,
+		WinDivertParamVersionMinor: 2,
+	}
+	divertSetParam = func(handle uintptr, param Param, value uint64) error {
+		params[param] = value
+		return nil
+	}
+	divertGetParam = func(handle uintptr, param Param, value *uint64) error {
+		*value = params[param]
+		return nil
+	}
+	return params
+}
+
+func TestParamRoundTrip(t *testing.T) {
+	tests := []struct {
+		param Param
+		value uint64
+	}{
+		{WinDivertParamQueueLength, WinDivertParamQueueLengthMin},
+		{WinDivertParamQueueLength, WinDivertParamQueueLengthMax},
+		{WinDivertParamQueueTime, 5000},
+		{WinDivertParamQueueSize, WinDivertParamQueueSizeMin},
+		{WinDivertParamQueueSize, WinDivertParamQueueSizeMax},
+	}
+
+	for _, test := range tests {
+		t.Run(test.param.String(), func(t *testing.T) {
+			newFakeDriver(t)
+			params := fakeParams(t)
+			wd := openFake(t, OpenOptions{})
+
+			if err := wd.SetParam(test.param, test.value); err != nil {
+				t.Fatalf("SetParam(%v, %d) = %v", test.param, test.value, err)
+			}
+			if params[test.param] != test.value {
+				t.Errorf("driver got %d, want %d", params[test.param], test.value)
+			}
+			got, err := wd.GetParam(test.param)
+			if err != nil || got != test.value {
+				t.Errorf("GetParam(%v) = %d, %v, want %d", test.param, got, err, test.value)
+			}
+		})
+	}
+}
+
+func TestSetParamInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		param Param
+		value uint64
+	}{
+		{"queue length too small", WinDivertParamQueueLength, WinDivertParamQueueLengthMin - 1},
+		{"queue length too large", WinDivertParamQueueLength, WinDivertParamQueueLengthMax + 1},
+		{"queue time too small", WinDivertParamQueueTime, WinDivertParamQueueTimeMin - 1},
+		{"queue time too large", WinDivertParamQueueTime, WinDivertParamQueueTimeMax + 1},
+		{"queue size too large", WinDivertParamQueueSize, math.MaxUint64},
+		{"read-only version", WinDivertParamVersionMajor, 3},
+		{"unknown parameter", Param(9), 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			newFakeDriver(t)
+			params := fakeParams(t)
+			before := params[test.param]
+			wd := openFake(t, OpenOptions{})
+
+			if err := wd.SetParam(test.param, test.value); err == nil {
+				t.Errorf("SetParam(%v, %d) = nil error", test.param, test.value)
+			}
+			if params[test.param] != before {
+				t.Errorf("driver got %d for an invalid value", params[test.param])
+			}
+		})
+	}
+}
+
+func TestGetParam(t *testing.T) {
+	newFakeDriver(t)
+	fakeParams(t)
+	wd := openFake(t, OpenOptions{})
+
+	if major, err := wd.GetParam(WinDivertParamVersionMajor); err != nil || major != 2 {
+		t.Errorf("GetParam(VersionMajor) = %d, %v, want 2", major, err)
+	}
+	if _, err := wd.GetParam(Param(9)); err == nil {
+		t.Error("GetParam(Param(9)) = nil error")
+	}
+
+	wd.Close()
+	if _, err := wd.GetParam(WinDivertParamQueueLength); err == nil {
+		t.Error("GetParam() on a closed handle = nil error")
+	}
+	if err := wd.SetParam(WinDivertParamQueueLength, WinDivertParamQueueLengthDefault); err == nil {
+		t.Error("SetParam() on a closed handle = nil error")
+	}
+}
+
+// The UINT64 arguments and results are split in two words on x86
+func TestUint64Args(t *testing.T) {
+	for _, value := range []uint64{0, WinDivertParamQueueSizeMax, 0x1_0000_0000, 0xdead_beef_0bad_f00d, math.MaxUint64} {
+		args := uint64Args(value)
+		var r2 uintptr
+		if len(args) == 2 {
+			r2 = args[1]
+		}
+		if got := uint64Result(args[0], r2); got != value {
+			t.Errorf("uint64Result(uint64Args(%#x)) = %#x", value, got)
+		}
+	}
+}
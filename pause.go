@@ -0,0 +1,103 @@
+package godivert
+
+import (
+	"context"
+	"sync"
+)
+
+// How a paused handle treats the traffic
+type PauseMode int
+
+const (
+	// Stop calling Recv: packets wait in the kernel queue and are dropped
+	// by WinDivert once the queue is full (see QUEUE_LENGTH/QUEUE_TIME/QUEUE_SIZE)
+	PauseStopReading PauseMode = iota
+	// Keep calling Recv and drop the received packets, they never reach their destination
+	PauseDrop
+)
+
+// Pause state of a handle
+// resume is closed by Resume to wake up the loops waiting in PauseStopReading mode
+type pauseState struct {
+	mu     sync.Mutex
+	paused bool
+	mode   PauseMode
+	resume chan struct{}
+}
+
+// Sets how the handle behaves while paused, PauseStopReading by default
+// In PauseStopReading mode the kernel queue absorbs the traffic until it overflows,
+// a long pause will then drop packets (and the connections going through the filter will stall).
+// In PauseDrop mode the packets are read and dropped right away.
+func (wd *WinDivertHandle) SetPauseMode(mode PauseMode) {
+	wd.pause.mu.Lock()
+	defer wd.pause.mu.Unlock()
+
+	wd.pause.mode = mode
+}
+
+// Stops delivering packets to the channel returned by Packets and to ForEach
+// The handle stays open, see SetPauseMode for what happens to the traffic meanwhile
+func (wd *WinDivertHandle) Pause() {
+	wd.pause.mu.Lock()
+	defer wd.pause.mu.Unlock()
+
+	if !wd.pause.paused {
+		wd.pause.paused = true
+		wd.pause.resume = make(chan struct{})
+	}
+}
+
+// Resumes the delivery of packets after a Pause
+func (wd *WinDivertHandle) Resume() {
+	wd.pause.mu.Lock()
+	defer wd.pause.mu.Unlock()
+
+	if wd.pause.paused {
+		wd.pause.paused = false
+		close(wd.pause.resume)
+	}
+}
+
+// Returns true if the handle is paused
+func (wd *WinDivertHandle) Paused() bool {
+	wd.pause.mu.Lock()
+	defer wd.pause.mu.Unlock()
+
+	return wd.pause.paused
+}
+
+func (wd *WinDivertHandle) pauseMode() (bool, PauseMode, chan struct{}) {
+	wd.pause.mu.Lock()
+	defer wd.pause.mu.Unlock()
+
+	return wd.pause.paused, wd.pause.mode, wd.pause.resume
+}
+
+// Calls RecvContext while honoring the pause state
+// Blocks while paused in PauseStopReading mode, drops the packets while paused in PauseDrop mode
+func (wd *WinDivertHandle) recvUnpaused(ctx context.Context) (*Packet, error) {
+	for {
+		paused, mode, resume := wd.pauseMode()
+		if paused && mode == PauseStopReading {
+			select {
+			case <-resume:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			continue
+		}
+
+		packet, err := wd.RecvContext(ctx)
+		if err != nil {
+			// 截断时 packet 不为 nil，交给调用者丢弃
+			return packet, err
+		}
+
+		if paused, mode, _ = wd.pauseMode(); paused && mode == PauseDrop {
+			wd.dropPacket(packet)
+			continue
+		}
+		return packet, nil
+	}
+}
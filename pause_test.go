@@ -0,0 +1,105 @@
+package godivert
+
+import (
+	"testing"
+	"time"
+)
+
+// Returns the number of packets still waiting in the driver
+func (d *fakeDriver) queued() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return len(d.queue)
+}
+
+func TestPauseStopReading(t *testing.T) {
+	driver := newFakeDriver(t)
+	wd := openFake(t, OpenOptions{})
+
+	wd.Pause()
+	packetChan, err := wd.Packets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	driver.divert(ipv4Packet(20), WinDivertAddress{})
+	driver.divert(ipv4Packet(21), WinDivertAddress{})
+
+	// 暂停期间包留在驱动的队列里
+	select {
+	case packet := <-packetChan:
+		t.Fatalf("received %v while paused", packet)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if n := driver.queued(); n != 2 {
+		t.Fatalf("%d packets left in the driver while paused, want 2", n)
+	}
+
+	wd.Resume()
+	var received int
+	for packet := range packetChan {
+		if int(packet.PacketLen) != 20+received {
+			t.Errorf("packet %d of %d bytes, want the packets in order", received, packet.PacketLen)
+		}
+		wd.dropPacket(packet)
+		received++
+	}
+	if received != 2 {
+		t.Errorf("received %d packets after Resume, want 2", received)
+	}
+}
+
+func TestPauseDrop(t *testing.T) {
+	driver := newFakeDriver(t)
+	wd := openFake(t, OpenOptions{})
+
+	wd.SetPauseMode(PauseDrop)
+	wd.Pause()
+	driver.divert(ipv4Packet(20), WinDivertAddress{})
+	driver.divert(ipv4Packet(21), WinDivertAddress{})
+
+	packetChan, err := wd.Packets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 读取后丢弃，通道在队列读空后关闭
+	for packet := range packetChan {
+		t.Errorf("received %v while paused", packet)
+	}
+	if n := driver.queued(); n != 0 {
+		t.Errorf("%d packets left in the driver, want them read and dropped", n)
+	}
+
+	wd.Resume()
+	driver.divert(ipv4Packet(22), WinDivertAddress{})
+	if err := wd.ForEach(func(p *Packet) Action { return ActionSend }); err != nil {
+		t.Fatalf("ForEach() = %v", err)
+	}
+	if sent := driver.injected(); len(sent) != 1 || len(sent[0].raw) != 22 {
+		t.Errorf("%d packets injected, want only the packet received after Resume", len(sent))
+	}
+}
+
+func TestPauseClose(t *testing.T) {
+	newFakeDriver(t)
+	wd := openFake(t, OpenOptions{})
+
+	wd.Pause()
+	packetChan, err := wd.Packets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !wd.Paused() {
+		t.Error("Paused() = false after Pause")
+	}
+	wd.Close()
+
+	select {
+	case _, ok := <-packetChan:
+		if ok {
+			t.Error("received a packet after Close")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the paused loop is still running after Close")
+	}
+}
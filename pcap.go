@@ -0,0 +1,203 @@
+package godivert
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// pcap 文件格式的常量
+// https://www.ietf.org/archive/id/draft-ietf-opsawg-pcap-01.html
+const (
+	pcapMagicMicro      = 0xa1b2c3d4
+	pcapMagicNano       = 0xa1b23c4d
+	pcapHeaderLen       = 24
+	pcapRecordHeaderLen = 16
+
+	// Link types of the captures read, WinDivert works on IP packets
+	LinkTypeEthernet = 1
+	LinkTypeRaw      = 101
+	LinkTypeIPv4     = 228
+	LinkTypeIPv6     = 229
+)
+
+// Length of the ethernet header stripped from LinkTypeEthernet captures
+const ethernetHeaderLen = 14
+
+// Reads the IP packets of a pcap capture
+// Raw, IPv4, IPv6 and ethernet link types are supported, ethernet headers are stripped
+// and non IP frames skipped.
+type PcapReader struct {
+	r        io.Reader
+	order    binary.ByteOrder
+	nano     bool
+	linkType uint32
+	// Largest record accepted, the capture's snapshot length bounded by what a handle can inject
+	maxLen uint32
+	hdr    [pcapRecordHeaderLen]byte
+}
+
+// Reads the global header of the capture and returns a PcapReader
+func NewPcapReader(r io.Reader) (*PcapReader, error) {
+	var hdr [pcapHeaderLen]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, fmt.Errorf("cannot read the pcap header: %w", err)
+	}
+
+	reader := &PcapReader{r: r}
+	switch {
+	case binary.LittleEndian.Uint32(hdr[0:4]) == pcapMagicMicro:
+		reader.order = binary.LittleEndian
+	case binary.LittleEndian.Uint32(hdr[0:4]) == pcapMagicNano:
+		reader.order, reader.nano = binary.LittleEndian, true
+	case binary.BigEndian.Uint32(hdr[0:4]) == pcapMagicMicro:
+		reader.order = binary.BigEndian
+	case binary.BigEndian.Uint32(hdr[0:4]) == pcapMagicNano:
+		reader.order, reader.nano = binary.BigEndian, true
+	default:
+		return nil, errors.New("not a pcap capture")
+	}
+
+	reader.linkType = reader.order.Uint32(hdr[20:24]) & 0x0fffffff
+	switch reader.linkType {
+	case LinkTypeEthernet, LinkTypeRaw, LinkTypeIPv4, LinkTypeIPv6:
+	default:
+		return nil, fmt.Errorf("unsupported pcap link type %d", reader.linkType)
+	}
+
+	// 记录长度来自文件，不能信任：不超过 snaplen，也不超过可注入的包长度
+	reader.maxLen = PacketBufferSize
+	if reader.linkType == LinkTypeEthernet {
+		reader.maxLen += ethernetHeaderLen
+	}
+	if snapLen := reader.order.Uint32(hdr[16:20]); snapLen != 0 && snapLen < reader.maxLen {
+		reader.maxLen = snapLen
+	}
+
+	return reader, nil
+}
+
+// Returns the link type of the capture
+func (r *PcapReader) LinkType() uint32 {
+	return r.linkType
+}
+
+// Returns the next IP packet of the capture and its timestamp
+// Returns io.EOF at the end of the capture and an error for a record longer than the capture's
+// snapshot length or PacketBufferSize. A record cut by the snapshot length is returned with a
+// *TruncatedError (errors.Is(err, ErrTruncated)): it must not be injected as it is.
+func (r *PcapReader) ReadPacket() ([]byte, time.Time, error) {
+	for {
+		if _, err := io.ReadFull(r.r, r.hdr[:]); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				err = errors.New("truncated pcap record header")
+			}
+			return nil, time.Time{}, err
+		}
+
+		sec := int64(r.order.Uint32(r.hdr[0:4]))
+		frac := int64(r.order.Uint32(r.hdr[4:8]))
+		if !r.nano {
+			frac *= int64(time.Microsecond)
+		}
+		timestamp := time.Unix(sec, frac)
+
+		inclLen := r.order.Uint32(r.hdr[8:12])
+		origLen := r.order.Uint32(r.hdr[12:16])
+		if inclLen > r.maxLen {
+			return nil, time.Time{}, fmt.Errorf("invalid pcap record of %d bytes, at most %d expected", inclLen, r.maxLen)
+		}
+
+		data := make([]byte, inclLen)
+		if _, err := io.ReadFull(r.r, data); err != nil {
+			return nil, time.Time{}, errors.New("truncated pcap record")
+		}
+
+		if r.linkType == LinkTypeEthernet {
+			// 跳过非 IP 帧
+			if len(data) < ethernetHeaderLen {
+				continue
+			}
+			etherType := binary.BigEndian.Uint16(data[12:14])
+			if etherType != 0x0800 && etherType != 0x86dd {
+				continue
+			}
+			data = data[ethernetHeaderLen:]
+			if origLen >= ethernetHeaderLen {
+				origLen -= ethernetHeaderLen
+			}
+		}
+
+		if len(data) == 0 {
+			continue
+		}
+		if origLen > uint32(len(data)) {
+			return data, timestamp, &TruncatedError{
+				Length:   int(origLen),
+				Captured: len(data),
+			}
+		}
+		return data, timestamp, nil
+	}
+}
+
+// Writes packets to a pcap capture that Wireshark or tcpdump can open
+// The link type is LinkTypeRaw: WinDivert packets are IPv4 or IPv6 packets without link layer.
+// Writes are buffered, call Flush once done (and before closing the underlying writer).
+// A PcapWriter isn't safe for concurrent use.
+type PcapWriter struct {
+	w *bufio.Writer
+}
+
+// Creates a PcapWriter and writes the global pcap header to w
+func NewPcapWriter(w io.Writer) (*PcapWriter, error) {
+	bw := bufio.NewWriter(w)
+	if err := writePcapHeader(bw); err != nil {
+		return nil, fmt.Errorf("cannot write pcap header: %w", err)
+	}
+	return &PcapWriter{w: bw}, nil
+}
+
+// Writes a record holding the packet's bytes
+// The record is timestamped with the packet's address timestamp if it has one, otherwise with the current time
+func (pw *PcapWriter) WritePacket(p *Packet) error {
+	timestamp := p.Timestamp()
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	return writePcapRecord(pw.w, timestamp, p.Raw)
+}
+
+// Writes the buffered records to the underlying writer
+func (pw *PcapWriter) Flush() error {
+	return pw.w.Flush()
+}
+
+// Writes the global header of a pcap capture of raw IP packets, timestamps in microseconds
+func writePcapHeader(w io.Writer) error {
+	var hdr [pcapHeaderLen]byte
+	binary.LittleEndian.PutUint32(hdr[0:4], pcapMagicMicro)
+	binary.LittleEndian.PutUint16(hdr[4:6], 2) // version 2.4
+	binary.LittleEndian.PutUint16(hdr[6:8], 4)
+	binary.LittleEndian.PutUint32(hdr[16:20], PacketBufferSize) // snaplen
+	binary.LittleEndian.PutUint32(hdr[20:24], LinkTypeRaw)
+	_, err := w.Write(hdr[:])
+	return err
+}
+
+// Writes a pcap record holding data captured at timestamp
+func writePcapRecord(w io.Writer, timestamp time.Time, data []byte) error {
+	var hdr [pcapRecordHeaderLen]byte
+	binary.LittleEndian.PutUint32(hdr[0:4], uint32(timestamp.Unix()))
+	binary.LittleEndian.PutUint32(hdr[4:8], uint32(timestamp.Nanosecond()/int(time.Microsecond)))
+	binary.LittleEndian.PutUint32(hdr[8:12], uint32(len(data)))
+	binary.LittleEndian.PutUint32(hdr[12:16], uint32(len(data)))
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
@@ -0,0 +1,202 @@
+package godivert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// Builds a pcap capture with the given byte order, magic, snapshot length and link type
+func pcapCapture(order binary.ByteOrder, magic, snapLen, linkType uint32) *bytes.Buffer {
+	var hdr [pcapHeaderLen]byte
+	order.PutUint32(hdr[0:4], magic)
+	order.PutUint16(hdr[4:6], 2)
+	order.PutUint16(hdr[6:8], 4)
+	order.PutUint32(hdr[16:20], snapLen)
+	order.PutUint32(hdr[20:24], linkType)
+	return bytes.NewBuffer(hdr[:])
+}
+
+// Appends a record to a capture built by pcapCapture
+func pcapAppend(buf *bytes.Buffer, order binary.ByteOrder, sec, frac, origLen uint32, data []byte) {
+	var hdr [pcapRecordHeaderLen]byte
+	order.PutUint32(hdr[0:4], sec)
+	order.PutUint32(hdr[4:8], frac)
+	order.PutUint32(hdr[8:12], uint32(len(data)))
+	order.PutUint32(hdr[12:16], origLen)
+	buf.Write(hdr[:])
+	buf.Write(data)
+}
+
+// Returns an IPv4 header of the given total length
+func ipv4Packet(totalLen int) []byte {
+	packet := make([]byte, totalLen)
+	packet[0] = 0x45
+	binary.BigEndian.PutUint16(packet[2:4], uint16(totalLen))
+	return packet
+}
+
+func TestPcapRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	pw, err := NewPcapWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packets := [][]byte{ipv4Packet(20), ipv4Packet(60)}
+	timestamp := time.Unix(1700000000, 123456000)
+	for _, raw := range packets {
+		if err := writePcapRecord(pw.w, timestamp, raw); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewPcapReader(&buf)
+	if err != nil {
+		t.Fatalf("NewPcapReader() = %v", err)
+	}
+	if r.LinkType() != LinkTypeRaw {
+		t.Errorf("LinkType() = %d, want %d", r.LinkType(), LinkTypeRaw)
+	}
+	for i, want := range packets {
+		data, ts, err := r.ReadPacket()
+		if err != nil {
+			t.Fatalf("ReadPacket() %d = %v", i, err)
+		}
+		if !bytes.Equal(data, want) || !ts.Equal(timestamp) {
+			t.Errorf("ReadPacket() %d = %x at %v, want %x at %v", i, data, ts, want, timestamp)
+		}
+	}
+	if _, _, err := r.ReadPacket(); err != io.EOF {
+		t.Errorf("ReadPacket() at the end = %v, want io.EOF", err)
+	}
+}
+
+func TestPcapReader(t *testing.T) {
+	ethernet := append(make([]byte, ethernetHeaderLen), ipv4Packet(20)...)
+	binary.BigEndian.PutUint16(ethernet[12:14], 0x0800)
+	arp := make([]byte, ethernetHeaderLen+28)
+	binary.BigEndian.PutUint16(arp[12:14], 0x0806)
+
+	tests := []struct {
+		name     string
+		capture  func() *bytes.Buffer
+		want     []byte
+		wantTime time.Time
+		wantErr  error
+	}{
+		{
+			name: "big endian nanoseconds",
+			capture: func() *bytes.Buffer {
+				buf := pcapCapture(binary.BigEndian, pcapMagicNano, 65535, LinkTypeIPv4)
+				pcapAppend(buf, binary.BigEndian, 10, 42, 20, ipv4Packet(20))
+				return buf
+			},
+			want:     ipv4Packet(20),
+			wantTime: time.Unix(10, 42),
+		},
+		{
+			name: "ethernet stripped, other frames skipped",
+			capture: func() *bytes.Buffer {
+				buf := pcapCapture(binary.LittleEndian, pcapMagicMicro, 65535, LinkTypeEthernet)
+				pcapAppend(buf, binary.LittleEndian, 1, 0, uint32(len(arp)), arp)
+				pcapAppend(buf, binary.LittleEndian, 2, 5, uint32(len(ethernet)), ethernet)
+				return buf
+			},
+			want:     ipv4Packet(20),
+			wantTime: time.Unix(2, 5000),
+		},
+		{
+			name: "record cut by the snapshot length",
+			capture: func() *bytes.Buffer {
+				buf := pcapCapture(binary.LittleEndian, pcapMagicMicro, 40, LinkTypeRaw)
+				pcapAppend(buf, binary.LittleEndian, 1, 0, 100, ipv4Packet(100)[:40])
+				return buf
+			},
+			want:     ipv4Packet(100)[:40],
+			wantTime: time.Unix(1, 0),
+			wantErr:  ErrTruncated,
+		},
+		{
+			name: "record longer than the snapshot length",
+			capture: func() *bytes.Buffer {
+				buf := pcapCapture(binary.LittleEndian, pcapMagicMicro, 40, LinkTypeRaw)
+				pcapAppend(buf, binary.LittleEndian, 1, 0, 60, ipv4Packet(60))
+				return buf
+			},
+			wantErr: errInvalidRecord,
+		},
+		{
+			name: "record longer than a packet",
+			capture: func() *bytes.Buffer {
+				buf := pcapCapture(binary.LittleEndian, pcapMagicMicro, 0, LinkTypeRaw)
+				var hdr [pcapRecordHeaderLen]byte
+				binary.LittleEndian.PutUint32(hdr[8:12], 0xffffffff)
+				binary.LittleEndian.PutUint32(hdr[12:16], 0xffffffff)
+				buf.Write(hdr[:])
+				return buf
+			},
+			wantErr: errInvalidRecord,
+		},
+		{
+			name: "truncated record",
+			capture: func() *bytes.Buffer {
+				buf := pcapCapture(binary.LittleEndian, pcapMagicMicro, 65535, LinkTypeRaw)
+				pcapAppend(buf, binary.LittleEndian, 1, 0, 60, ipv4Packet(60))
+				buf.Truncate(buf.Len() - 10)
+				return buf
+			},
+			wantErr: errInvalidRecord,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r, err := NewPcapReader(test.capture())
+			if err != nil {
+				t.Fatalf("NewPcapReader() = %v", err)
+			}
+
+			data, ts, err := r.ReadPacket()
+			switch {
+			case test.wantErr == errInvalidRecord:
+				if err == nil || errors.Is(err, ErrTruncated) || err == io.EOF {
+					t.Fatalf("ReadPacket() = %v, want an invalid record error", err)
+				}
+				return
+			case !errors.Is(err, test.wantErr):
+				t.Fatalf("ReadPacket() = %v, want %v", err, test.wantErr)
+			}
+			if !bytes.Equal(data, test.want) || !ts.Equal(test.wantTime) {
+				t.Errorf("ReadPacket() = %x at %v, want %x at %v", data, ts, test.want, test.wantTime)
+			}
+		})
+	}
+}
+
+// Stands for the errors of malformed records in TestPcapReader
+var errInvalidRecord = errors.New("invalid record")
+
+func TestNewPcapReaderErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		capture *bytes.Buffer
+	}{
+		{"short header", bytes.NewBuffer(make([]byte, 10))},
+		{"bad magic", pcapCapture(binary.LittleEndian, 0x12345678, 65535, LinkTypeRaw)},
+		{"unsupported link type", pcapCapture(binary.LittleEndian, pcapMagicMicro, 65535, 105)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := NewPcapReader(test.capture); err == nil {
+				t.Error("NewPcapReader() = nil, want an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package godivert
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// What to do with a packet once it has been handled
+type Action int
+
+const (
+	// Inject the packet back on the network stack
+	ActionSend Action = iota
+	// Drop the packet, its buffer is returned to the pool
+	ActionDrop
+)
+
+// Function deciding the fate of each packet, it can modify the packet before it is sent
+type PacketHandler func(*Packet) Action
+
+// Holds the handler used by ForEach
+// Stored in an atomic.Value so it can be swapped while the loop is running
+type handlerStore struct {
+	value atomic.Value
+}
+
+func (s *handlerStore) load() PacketHandler {
+	handler, _ := s.value.Load().(PacketHandler)
+	return handler
+}
+
+func (s *handlerStore) store(handler PacketHandler) {
+	s.value.Store(handler)
+}
+
+// Replaces the handler of a running ForEach loop
+// The new handler applies to every packet received after the call, the loop isn't restarted
+// and no packet is dropped during the swap
+func (wd *WinDivertHandle) SetHandler(fn func(*Packet) Action) {
+	wd.handler.store(fn)
+}
+
+// Receives packets and passes them to the current handler until the handle is closed
+// fn is installed as the handler, use SetHandler to change it while the loop is running
+// Returns nil once the handle is closed or receiving is shut down, the error returned by Recv otherwise
+// Truncated packets are dropped and passed to OpenOptions.ErrorHandler, like the Send errors, the loop goes on
+func (wd *WinDivertHandle) ForEach(fn func(*Packet) Action) error {
+	if fn != nil {
+		wd.SetHandler(fn)
+	}
+
+	for wd.open.Load() {
+		packet, err := wd.recvRecovered(context.Background())
+		if errors.Is(err, ErrTruncated) {
+			// 截断的包交给 ErrorHandler 后丢弃
+			wd.reportError(err)
+			wd.dropPacket(packet)
+			continue
+		}
+		var panicErr *PanicError
+		if errors.As(err, &panicErr) {
+			wd.reportError(err)
+			continue
+		}
+		if err != nil {
+			if errors.Is(err, ErrShutdown) || !wd.open.Load() {
+				return nil
+			}
+			return err
+		}
+
+		wd.apply(wd.handler.load(), packet)
+	}
+	return nil
+}
+
+// Receives packets with recvLoop and dispatches them to numWorkers goroutines running handler
+// Each packet is sent or dropped according to the action handler returns (sent without handler),
+// a panic is handled like in ForEach (see OpenOptions.RecoverPanics). The packets are handled concurrently
+// so handler must be safe for concurrent use and the order of the packets isn't kept.
+//
+// Process blocks until ctx is done, the handle is closed or receiving is shut down. The packets already
+// received are still handled before it returns, so every packet is sent or dropped and no buffer leaks.
+// Returns ctx.Err() if ctx is done, the Recv error that stopped the loop if any, nil otherwise.
+func (wd *WinDivertHandle) Process(ctx context.Context, numWorkers int, handler PacketHandler) error {
+	if numWorkers < 1 {
+		return fmt.Errorf("cannot process packets with %d workers", numWorkers)
+	}
+	if !wd.open.Load() {
+		return errors.New("the handle isn't open")
+	}
+
+	loopCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	packetChan := make(chan *Packet, PacketChanCapacity)
+	loopErr := make(chan error, 1)
+	go func() {
+		loopErr <- wd.recvLoop(loopCtx, packetChan)
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+	for i := 0; i < numWorkers; i++ {
+		go func() {
+			defer wg.Done()
+			for packet := range packetChan {
+				wd.apply(handler, packet)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// 通道关闭后 recvLoop 已经返回
+	if err := <-loopErr; err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// Runs the handler on the packet and applies the resulting action
+// Without handler the packet is sent unchanged
+func (wd *WinDivertHandle) apply(handler PacketHandler, packet *Packet) {
+	action := ActionSend
+	if handler != nil {
+		action = wd.runHandler(handler, packet)
+	}
+
+	switch {
+	case action == ActionDrop || wd.noSend:
+		// 嗅探句柄上的包已经继续传输，只放回缓冲区
+		wd.dropPacket(packet)
+	default:
+		// 注入失败的包已经放回缓冲池，错误交给 ErrorHandler
+		if _, err := packet.Send(wd); err != nil {
+			wd.reportError(err)
+		}
+	}
+}
+
+// Reported to OpenOptions.ErrorHandler when a panic is recovered (OpenOptions.RecoverPanics)
+// Packet holds a copy of the bytes of the packet being handled, nil if the panic happened while
+// receiving it. The packet is dropped and the loop goes on with the next one.
+type PanicError struct {
+	Value  interface{}
+	Packet []byte
+}
+
+func (e *PanicError) Error() string {
+	if e.Packet == nil {
+		return fmt.Sprintf("panic while receiving a packet: %v", e.Value)
+	}
+	return fmt.Sprintf("panic while handling a packet: %v, packet dropped: %s", e.Value, hex.EncodeToString(e.Packet))
+}
+
+// Calls the handler, if the handle was opened with OpenOptions.RecoverPanics a panic is reported
+// as a *PanicError with the packet's bytes and the packet is dropped instead of stopping the loop
+func (wd *WinDivertHandle) runHandler(handler PacketHandler, packet *Packet) (action Action) {
+	if wd.recoverPanics {
+		defer func() {
+			if r := recover(); r != nil {
+				wd.reportError(&PanicError{Value: r, Packet: append([]byte{}, packet.Raw...)})
+				action = ActionDrop
+			}
+		}()
+	}
+	return handler(packet)
+}
+
+// Like recvUnpaused but with OpenOptions.RecoverPanics a panic while receiving the packet
+// (e.g. the drop estimator parsing it) is returned as a *PanicError instead of stopping the loop
+func (wd *WinDivertHandle) recvRecovered(ctx context.Context) (packet *Packet, err error) {
+	if wd.recoverPanics {
+		defer func() {
+			if r := recover(); r != nil {
+				packet, err = nil, &PanicError{Value: r}
+			}
+		}()
+	}
+	return wd.recvUnpaused(ctx)
+}
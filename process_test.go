@@ -0,0 +1,300 @@
+package godivert
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"syscall"
+	"testing"
+)
+
+// Handler reading the UDP ports without checking the length, it panics on a bare IP header
+func portsHandler(p *Packet) Action {
+	hdrLen := int(p.Raw[0]&0xf) << 2
+	if dstPort := uint16(p.Raw[hdrLen+2])<<8 | uint16(p.Raw[hdrLen+3]); dstPort == 0 {
+		return ActionDrop
+	}
+	return ActionSend
+}
+
+// Collects the errors passed to OpenOptions.ErrorHandler
+type errorLog struct {
+	mu     sync.Mutex
+	errors []error
+}
+
+func (l *errorLog) handle(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.errors = append(l.errors, err)
+}
+
+func (l *errorLog) panics() []*PanicError {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var panics []*PanicError
+	for _, err := range l.errors {
+		var panicErr *PanicError
+		if errors.As(err, &panicErr) {
+			panics = append(panics, panicErr)
+		}
+	}
+	return panics
+}
+
+func TestHandlerPanicRecovered(t *testing.T) {
+	crafted := ipv4Packet(20)
+	valid := ipv4Packet(28)
+	binary.BigEndian.PutUint16(valid[22:24], 53)
+
+	tests := []struct {
+		name string
+		run  func(wd *WinDivertHandle) error
+	}{
+		{
+			name: "ForEach",
+			run: func(wd *WinDivertHandle) error {
+				return wd.ForEach(portsHandler)
+			},
+		},
+		{
+			name: "Process",
+			run: func(wd *WinDivertHandle) error {
+				return wd.Process(context.Background(), 2, portsHandler)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			driver := newFakeDriver(t)
+			var log errorLog
+			wd := openFake(t, OpenOptions{RecoverPanics: true, ErrorHandler: log.handle})
+			driver.divert(crafted, WinDivertAddress{})
+			driver.divert(valid, WinDivertAddress{})
+
+			if err := test.run(wd); err != nil {
+				t.Fatalf("loop stopped with %v", err)
+			}
+
+			// 循环继续处理下一个包
+			sent := driver.injected()
+			if len(sent) != 1 || !bytes.Equal(sent[0].raw, valid) {
+				t.Errorf("%d packets injected, want only the valid packet", len(sent))
+			}
+			panics := log.panics()
+			if len(panics) != 1 || !bytes.Equal(panics[0].Packet, crafted) {
+				t.Fatalf("reported %v, want the panic of the crafted packet", log.errors)
+			}
+			if occupancy, _ := wd.QueueOccupancy(); occupancy != 0 {
+				t.Errorf("QueueOccupancy() = %d, the crafted packet wasn't dropped", occupancy)
+			}
+		})
+	}
+}
+
+func TestHandlerPanicNotRecovered(t *testing.T) {
+	driver := newFakeDriver(t)
+	wd := openFake(t, OpenOptions{})
+	driver.divert(ipv4Packet(20), WinDivertAddress{})
+
+	defer func() {
+		if recover() == nil {
+			t.Error("ForEach recovered the panic without RecoverPanics")
+		}
+	}()
+	wd.ForEach(portsHandler)
+}
+
+func TestRecvLoopPanicRecovered(t *testing.T) {
+	driver := newFakeDriver(t)
+	var log errorLog
+	wd := openFake(t, OpenOptions{RecoverPanics: true, ErrorHandler: log.handle})
+	driver.divert(ipv4Packet(20), WinDivertAddress{})
+
+	// 第一次接收时 panic，之后正常
+	recv := divertRecv
+	var calls int
+	divertRecv = func(handle uintptr, packet []byte, recvLen *uint, addr *WinDivertAddress) error {
+		calls++
+		if calls == 1 {
+			panic("malformed packet")
+		}
+		return recv(handle, packet, recvLen, addr)
+	}
+
+	packetChan, err := wd.Packets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var received int
+	for packet := range packetChan {
+		received++
+		packet.Release()
+	}
+
+	if received != 1 {
+		t.Errorf("received %d packets after the panic, want 1", received)
+	}
+	if panics := log.panics(); len(panics) != 1 || panics[0].Value != "malformed packet" || panics[0].Packet != nil {
+		t.Errorf("reported %v, want the receive panic", log.errors)
+	}
+	if err := wd.Err(); err != nil {
+		t.Errorf("Err() = %v, want nil", err)
+	}
+}
+
+func TestProcess(t *testing.T) {
+	tests := []struct {
+		name    string
+		recvErr error
+		wantErr error
+	}{
+		{"shut down", errNoData, nil},
+		{"recv error", syscall.Errno(87), ErrInvalidParameter},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			driver := newFakeDriver(t)
+			driver.recvErr = test.recvErr
+			wd := openFake(t, OpenOptions{})
+			for i := 0; i < 10; i++ {
+				driver.divert(ipv4Packet(20), WinDivertAddress{})
+			}
+
+			err := wd.Process(context.Background(), 3, func(p *Packet) Action {
+				return ActionSend
+			})
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("Process() = %v, want %v", err, test.wantErr)
+			}
+			// 出错之前收到的包都已处理
+			if n := len(driver.injected()); n != 10 {
+				t.Errorf("%d packets injected, want 10", n)
+			}
+			if occupancy, _ := wd.QueueOccupancy(); occupancy != 0 {
+				t.Errorf("QueueOccupancy() = %d after Process returned", occupancy)
+			}
+		})
+	}
+}
+
+func TestLoopReportsSendError(t *testing.T) {
+	send := func(p *Packet) Action { return ActionSend }
+	tests := []struct {
+		name string
+		run  func(wd *WinDivertHandle) error
+	}{
+		{"ForEach", func(wd *WinDivertHandle) error { return wd.ForEach(send) }},
+		{"Process", func(wd *WinDivertHandle) error { return wd.Process(context.Background(), 2, send) }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			driver := newFakeDriver(t)
+			var log errorLog
+			wd := openFake(t, OpenOptions{ErrorHandler: log.handle})
+			for i := 0; i < 3; i++ {
+				driver.divert(ipv4Packet(20+i), WinDivertAddress{})
+			}
+
+			// 第二个包注入失败
+			saved := divertSend
+			t.Cleanup(func() { divertSend = saved })
+			divertSend = func(handle uintptr, packet *byte, packetLen uint, sendLen *uint, addr *WinDivertAddress) error {
+				if packetLen == 21 {
+					return syscall.Errno(1232) // ERROR_HOST_UNREACHABLE
+				}
+				return saved(handle, packet, packetLen, sendLen, addr)
+			}
+
+			// 接收关闭后循环返回 nil
+			if err := test.run(wd); err != nil {
+				t.Fatalf("loop stopped with %v", err)
+			}
+			if n := len(driver.injected()); n != 2 {
+				t.Errorf("%d packets injected, want 2", n)
+			}
+			var winDivertErr *WinDivertError
+			if len(log.errors) != 1 || !errors.As(log.errors[0], &winDivertErr) || !errors.Is(log.errors[0], syscall.Errno(1232)) {
+				t.Errorf("reported %v, want the Send error", log.errors)
+			}
+			if occupancy, _ := wd.QueueOccupancy(); occupancy != 0 {
+				t.Errorf("QueueOccupancy() = %d, the packet that failed wasn't returned", occupancy)
+			}
+		})
+	}
+}
+
+func TestProcessContextDone(t *testing.T) {
+	newFakeDriver(t)
+	wd := openFake(t, OpenOptions{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := wd.Process(ctx, 1, nil); err != context.Canceled {
+		t.Errorf("Process() = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestSetHandler(t *testing.T) {
+	driver := newFakeDriver(t)
+	wd := openFake(t, OpenOptions{})
+	for i := 0; i < 6; i++ {
+		driver.divert(ipv4Packet(20+i), WinDivertAddress{})
+	}
+
+	// 第三个包之后切换为丢弃
+	var seen int
+	drop := func(p *Packet) Action { return ActionDrop }
+	err := wd.ForEach(func(p *Packet) Action {
+		seen++
+		if seen == 3 {
+			wd.SetHandler(drop)
+		}
+		return ActionSend
+	})
+	if err != nil {
+		t.Fatalf("ForEach() = %v", err)
+	}
+
+	sent := driver.injected()
+	if len(sent) != 3 || seen != 3 {
+		t.Fatalf("%d packets injected by the first handler called %d times, want 3", len(sent), seen)
+	}
+	for i, packet := range sent {
+		if len(packet.raw) != 20+i {
+			t.Errorf("packet %d of %d bytes injected, want the packets received before the swap", i, len(packet.raw))
+		}
+	}
+}
+
+func TestSetHandlerWhileRunning(t *testing.T) {
+	driver := newFakeDriver(t)
+	wd := openFake(t, OpenOptions{})
+	for i := 0; i < 100; i++ {
+		driver.divert(ipv4Packet(20), WinDivertAddress{})
+	}
+
+	// -race 检查并发替换处理函数
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			wd.SetHandler(func(p *Packet) Action { return ActionSend })
+		}
+	}()
+	if err := wd.ForEach(func(p *Packet) Action { return ActionSend }); err != nil {
+		t.Fatalf("ForEach() = %v", err)
+	}
+	<-done
+
+	if n := len(driver.injected()); n != 100 {
+		t.Errorf("%d packets injected, want 100", n)
+	}
+}
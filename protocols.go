@@ -0,0 +1,46 @@
+package godivert
+
+import (
+	"examples/header"
+	"sync"
+)
+
+// A protocol ParseHeaders decodes into a NextHeader
+type ProtocolInfo struct {
+	Number uint8
+	Name   string
+}
+
+var (
+	supportedProtocolsOnce sync.Once
+	supportedProtocols     []ProtocolInfo
+)
+
+// Returns the transport protocols ParseHeaders decodes, sorted by number
+// The list isn't maintained by hand: every protocol number is probed once with a minimal packet,
+// so it follows the parsers added to ParseHeaders.
+// The returned slice belongs to the caller.
+func SupportedProtocols() []ProtocolInfo {
+	supportedProtocolsOnce.Do(func() {
+		// IPv4 头部加足够长的零负载，满足所有解析器的最小长度
+		raw := make([]byte, 20+60)
+		raw[0] = 0x45
+		// TCP 的数据偏移要覆盖最小头部，否则头部被视为不完整
+		raw[20+12] = 5 << 4
+		for protocol := 0; protocol <= 0xff; protocol++ {
+			raw[9] = uint8(protocol)
+			probe := Packet{Raw: raw, PacketLen: uint(len(raw))}
+			probe.ParseHeaders()
+			if probe.NextHeader != nil {
+				supportedProtocols = append(supportedProtocols, ProtocolInfo{
+					Number: uint8(protocol),
+					Name:   header.ProtocolName(uint8(protocol)),
+				})
+			}
+		}
+	})
+
+	protocols := make([]ProtocolInfo, len(supportedProtocols))
+	copy(protocols, supportedProtocols)
+	return protocols
+}
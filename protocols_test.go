@@ -0,0 +1,32 @@
+package godivert
+
+import (
+	"examples/header"
+	"testing"
+)
+
+func TestSupportedProtocols(t *testing.T) {
+	want := []ProtocolInfo{
+		{header.ICMPv4, "ICMPv4"},
+		{header.TCP, "TCP"},
+		{header.UDP, "UDP"},
+		{header.ICMPv6, "ICMPv6"},
+		{header.UDPLite, "UDP-Lite"},
+	}
+
+	protocols := SupportedProtocols()
+	if len(protocols) != len(want) {
+		t.Fatalf("SupportedProtocols() = %v, want %v", protocols, want)
+	}
+	for i := range want {
+		if protocols[i] != want[i] {
+			t.Errorf("SupportedProtocols()[%d] = %v, want %v", i, protocols[i], want[i])
+		}
+	}
+
+	// 返回的切片属于调用者
+	protocols[0].Name = "changed"
+	if got := SupportedProtocols()[0].Name; got != "ICMPv4" {
+		t.Errorf("SupportedProtocols()[0].Name = %q after changing a previous result", got)
+	}
+}
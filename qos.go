@@ -0,0 +1,53 @@
+package godivert
+
+import "examples/header"
+
+// Standard names of the DSCP values
+// See https://www.iana.org/assignments/dscp-registry/dscp-registry.xhtml
+var dscpNames = map[uint8]string{
+	0:  "default",
+	1:  "LE",
+	8:  "CS1",
+	10: "AF11",
+	12: "AF12",
+	14: "AF13",
+	16: "CS2",
+	18: "AF21",
+	20: "AF22",
+	22: "AF23",
+	24: "CS3",
+	26: "AF31",
+	28: "AF32",
+	30: "AF33",
+	32: "CS4",
+	34: "AF41",
+	36: "AF42",
+	38: "AF43",
+	40: "CS5",
+	44: "VOICE-ADMIT",
+	46: "EF",
+	48: "CS6",
+	56: "CS7",
+}
+
+// Returns the name of the DSCP value, "unassigned" if it isn't a standard one
+// 0 is named "default" (best effort, also known as CS0)
+func DSCPName(dscp uint8) string {
+	if name, ok := dscpNames[dscp]; ok {
+		return name
+	}
+	return "unassigned"
+}
+
+// Returns the traffic class the packet is marked with and its DSCP value, for IPv4 and IPv6 alike
+func (p *Packet) TrafficClass() (name string, dscp uint8) {
+	p.VerifyParsed()
+
+	switch ipHdr := p.IpHdr.(type) {
+	case *header.IPv4Header:
+		dscp = ipHdr.DSCP()
+	case *header.IPv6Header:
+		dscp = ipHdr.DSCP()
+	}
+	return DSCPName(dscp), dscp
+}
@@ -0,0 +1,49 @@
+package godivert
+
+import (
+	"examples/header"
+	"testing"
+)
+
+func TestTrafficClass(t *testing.T) {
+	// 返回 DSCP 为 dscp、ECN 为 1 的包
+	marked := func(version int, dscp uint8) *Packet {
+		var raw []byte
+		if version == header.IPv4 {
+			raw = buildIPv4(header.UDP, udpBytes(1234, 5004, nil))
+			raw[1] = dscp<<2 | 1
+		} else {
+			raw = buildIPv6(header.UDP, udpBytes(1234, 5004, nil))
+			trafficClass := dscp<<2 | 1
+			raw[0] = 0x60 | trafficClass>>4
+			raw[1] = trafficClass << 4
+		}
+		return NewPacket(raw, nil)
+	}
+
+	tests := []struct {
+		dscp uint8
+		want string
+	}{
+		{0, "default"},
+		{8, "CS1"},
+		{10, "AF11"},
+		{22, "AF23"},
+		{34, "AF41"},
+		{38, "AF43"},
+		{46, "EF"},
+		{48, "CS6"},
+		{56, "CS7"},
+		{5, "unassigned"},
+		{63, "unassigned"},
+	}
+
+	for _, version := range []int{header.IPv4, header.IPv6} {
+		for _, test := range tests {
+			name, dscp := marked(version, test.dscp).TrafficClass()
+			if name != test.want || dscp != test.dscp {
+				t.Errorf("IPv%d TrafficClass() = %s, %d, want %s, %d", version, name, dscp, test.want, test.dscp)
+			}
+		}
+	}
+}
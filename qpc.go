@@ -0,0 +1,52 @@
+package godivert
+
+import (
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// WinDivert timestamps are QueryPerformanceCounter values
+var (
+	kernel32DLL                = syscall.NewLazyDLL("kernel32.dll")
+	kernel32QueryPerfCounter   = kernel32DLL.NewProc("QueryPerformanceCounter")
+	kernel32QueryPerfFrequency = kernel32DLL.NewProc("QueryPerformanceFrequency")
+	qpcFrequencyOnce           sync.Once
+	qpcFrequencyValue          int64
+)
+
+// Calls to the performance counter, variables so the tests can replace them
+var (
+	// Returns the current value of the performance counter
+	qpcNow = func() int64 {
+		var counter int64
+		kernel32QueryPerfCounter.Call(uintptr(unsafe.Pointer(&counter)))
+		return counter
+	}
+
+	// Returns the frequency of the performance counter in ticks per second, queried once
+	qpcFrequency = func() int64 {
+		qpcFrequencyOnce.Do(func() {
+			kernel32QueryPerfFrequency.Call(uintptr(unsafe.Pointer(&qpcFrequencyValue)))
+		})
+		return qpcFrequencyValue
+	}
+)
+
+// Converts a number of performance counter ticks to a duration
+func qpcDuration(ticks int64) time.Duration {
+	frequency := qpcFrequency()
+	if frequency <= 0 {
+		return 0
+	}
+	// 分开计算秒和余数，避免乘法溢出
+	seconds := ticks / frequency
+	rest := ticks % frequency
+	return time.Duration(seconds)*time.Second + time.Duration(rest*int64(time.Second)/frequency)
+}
+
+// Converts a performance counter value (e.g. WinDivertAddress.Timestamp) to wall clock time
+func qpcTime(counter int64) time.Time {
+	return time.Now().Add(-qpcDuration(qpcNow() - counter))
+}
@@ -0,0 +1,53 @@
+package godivert
+
+import "errors"
+
+// Returns an approximation of the number of packets waiting to be processed
+// WinDivert exposes no statistic about the kernel queue occupancy (only its limits through
+// QUEUE_LENGTH/QUEUE_TIME/QUEUE_SIZE) so this is the lag measured in the library:
+// packets received by Recv that haven't been sent or dropped by the library yet,
+// including the ones buffered in the Packets channel.
+// A growing value means the processing is slower than the capture, which is when the kernel queue fills up.
+// Packet.Release accounts the packet as processed too, packets abandoned without Send, Release or a library drop never are.
+func (wd *WinDivertHandle) QueueOccupancy() (uint64, error) {
+	if !wd.open.Load() {
+		return 0, errors.New("the handle isn't open")
+	}
+
+	received := wd.received.Load()
+	processed := wd.processed.Load()
+	if processed >= received {
+		return 0, nil
+	}
+	return received - processed, nil
+}
+
+// Drops a received packet: its buffer is returned to the pool and it is accounted as processed
+func (wd *WinDivertHandle) dropPacket(packet *Packet) {
+	wd.releasePacket(packet)
+}
+
+// Returns the packet's buffer and accounts it as processed, only the first call on a packet does anything
+// Packets built by the caller hold no pooled buffer: nothing is returned and they can be sent again
+func (wd *WinDivertHandle) releasePacket(packet *Packet) {
+	if !packet.claim() {
+		return
+	}
+	wd.returnClaimed(packet)
+}
+
+// Returns the buffer of a packet the caller claimed and accounts it as processed
+func (wd *WinDivertHandle) returnClaimed(packet *Packet) {
+	if packet.pooled() {
+		wd.countProcessed(packet)
+		packet.returnBuffer()
+	}
+	packet.resetMeta()
+}
+
+// Accounts a packet as processed, only received packets (the ones holding a pooled buffer) are counted
+func (wd *WinDivertHandle) countProcessed(packet *Packet) {
+	if packet.pooled() {
+		wd.processed.Add(1)
+	}
+}
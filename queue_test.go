@@ -0,0 +1,108 @@
+package godivert
+
+import "testing"
+
+func TestQueueOccupancy(t *testing.T) {
+	driver := newFakeDriver(t)
+	wd := openFake(t, OpenOptions{})
+	for i := 0; i < 3; i++ {
+		driver.divert(ipv4Packet(20), WinDivertAddress{})
+	}
+
+	occupancy := func(want uint64) {
+		t.Helper()
+		if got, err := wd.QueueOccupancy(); err != nil || got != want {
+			t.Errorf("QueueOccupancy() = %d, %v, want %d", got, err, want)
+		}
+	}
+
+	occupancy(0)
+	var packets []*Packet
+	for i := 0; i < 3; i++ {
+		packet, err := wd.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		packets = append(packets, packet)
+	}
+	occupancy(3)
+
+	if _, err := wd.Send(packets[0]); err != nil {
+		t.Fatal(err)
+	}
+	occupancy(2)
+	wd.dropPacket(packets[1])
+	occupancy(1)
+	// 重复丢弃和自己构造的包不计入
+	wd.dropPacket(packets[1])
+	if _, err := wd.Send(NewPacket(ipv4Packet(20), NewAddress())); err != nil {
+		t.Fatal(err)
+	}
+	occupancy(1)
+	if _, err := wd.Send(packets[2]); err != nil {
+		t.Fatal(err)
+	}
+	occupancy(0)
+
+	wd.Close()
+	if _, err := wd.QueueOccupancy(); err == nil {
+		t.Error("QueueOccupancy() = nil error on a closed handle")
+	}
+}
+
+func TestQueueOccupancyPacketsChannel(t *testing.T) {
+	driver := newFakeDriver(t)
+	wd := openFake(t, OpenOptions{})
+	for i := 0; i < 5; i++ {
+		driver.divert(ipv4Packet(20), WinDivertAddress{})
+	}
+
+	packetChan, err := wd.Packets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 通道关闭时所有包都在缓冲中等待处理
+	var packets []*Packet
+	for packet := range packetChan {
+		packets = append(packets, packet)
+	}
+	if got, err := wd.QueueOccupancy(); err != nil || got != 5 {
+		t.Errorf("QueueOccupancy() = %d, %v with the packets still buffered, want 5", got, err)
+	}
+
+	for _, packet := range packets {
+		wd.dropPacket(packet)
+	}
+	if got, err := wd.QueueOccupancy(); err != nil || got != 0 {
+		t.Errorf("QueueOccupancy() = %d, %v once the packets are dropped, want 0", got, err)
+	}
+}
+
+func TestQueueOccupancyRelease(t *testing.T) {
+	driver := newFakeDriver(t)
+	wd := openFake(t, OpenOptions{})
+	for i := 0; i < 2; i++ {
+		driver.divert(ipv4Packet(20), WinDivertAddress{})
+	}
+
+	var packets []*Packet
+	for i := 0; i < 2; i++ {
+		packet, err := wd.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		packets = append(packets, packet)
+	}
+
+	// 克隆的包不属于句柄，Release 不计入
+	packets[0].Clone().Release()
+	packets[0].Release()
+	packets[0].Release()
+	if got, err := wd.QueueOccupancy(); err != nil || got != 1 {
+		t.Errorf("QueueOccupancy() = %d, %v after Release, want 1", got, err)
+	}
+	packets[1].Release()
+	if got, err := wd.QueueOccupancy(); err != nil || got != 0 {
+		t.Errorf("QueueOccupancy() = %d, %v once every packet is released, want 0", got, err)
+	}
+}
@@ -0,0 +1,122 @@
+package godivert
+
+import (
+	"sync"
+	"time"
+)
+
+// Limits the packets per second and the bytes per second with two token buckets
+// Each bucket holds up to one second of its rate, so bursts of up to one second of traffic pass.
+// The bytes bucket holds at least PacketBufferSize bytes: under a tiny bps a packet larger than
+// bps still passes once the bucket refilled, instead of never fitting. A rate of 0 doesn't limit that dimension. It is safe for concurrent use and can be
+// reconfigured while running with SetRate.
+type RateLimiter struct {
+	mu  sync.Mutex
+	pps uint64
+	bps uint64
+
+	// 当前令牌数和上次补充令牌的时间
+	packetTokens float64
+	byteTokens   float64
+	last         time.Time
+}
+
+// Creates a RateLimiter allowing pps packets and bps bytes per second, its buckets start full
+func NewRateLimiter(pps, bps uint64) *RateLimiter {
+	return &RateLimiter{
+		pps:          pps,
+		bps:          bps,
+		packetTokens: float64(pps),
+		byteTokens:   byteBurst(bps),
+		last:         time.Now(),
+	}
+}
+
+// Returns true and consumes the tokens if the packet fits in the current rates
+func (r *RateLimiter) Allow(p *Packet) bool {
+	return r.allowAt(time.Now(), len(p.Raw))
+}
+
+// Allow for a packet of size bytes at the given time
+func (r *RateLimiter) allowAt(now time.Time, size int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.refill(now)
+
+	if r.pps > 0 && r.packetTokens < 1 {
+		return false
+	}
+	if r.bps > 0 && r.byteTokens < float64(size) {
+		return false
+	}
+	if r.pps > 0 {
+		r.packetTokens--
+	}
+	if r.bps > 0 {
+		r.byteTokens -= float64(size)
+	}
+	return true
+}
+
+// PacketHandler dropping the packets over the rates, to use with ForEach
+func (r *RateLimiter) Handle(p *Packet) Action {
+	if r.Allow(p) {
+		return ActionSend
+	}
+	return ActionDrop
+}
+
+// Returns the current rates
+func (r *RateLimiter) Rate() (pps, bps uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.pps, r.bps
+}
+
+// Changes the rates without resetting the buckets
+// The tokens earned under the old rates are kept: raising a rate refills faster from now on,
+// lowering it only caps the buckets to the new size rather than emptying them.
+// A bucket whose limit is enabled starts full.
+func (r *RateLimiter) SetRate(pps, bps uint64) {
+	r.setRateAt(time.Now(), pps, bps)
+}
+
+// SetRate at the given time
+func (r *RateLimiter) setRateAt(now time.Time, pps, bps uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.refill(now)
+
+	if r.pps == 0 {
+		r.packetTokens = float64(pps)
+	}
+	if r.bps == 0 {
+		r.byteTokens = byteBurst(bps)
+	}
+	r.pps, r.bps = pps, bps
+	r.packetTokens = min(r.packetTokens, float64(pps))
+	r.byteTokens = min(r.byteTokens, byteBurst(bps))
+}
+
+// Adds the tokens earned since the last refill, the caller holds the lock
+func (r *RateLimiter) refill(now time.Time) {
+	elapsed := now.Sub(r.last).Seconds()
+	r.last = now
+	if elapsed <= 0 {
+		return
+	}
+
+	r.packetTokens = min(r.packetTokens+elapsed*float64(r.pps), float64(r.pps))
+	r.byteTokens = min(r.byteTokens+elapsed*float64(r.bps), byteBurst(r.bps))
+}
+
+// Returns the size of the bytes bucket for bps, one second of traffic but at least the largest packet
+func byteBurst(bps uint64) float64 {
+	if bps == 0 {
+		return 0
+	}
+	return float64(max(bps, PacketBufferSize))
+}
@@ -0,0 +1,126 @@
+package godivert
+
+import (
+	"testing"
+	"time"
+)
+
+// Sends a packet of size bytes every interval during d and returns the number of packets allowed
+func rateRun(r *RateLimiter, start time.Time, d, interval time.Duration, size int) int {
+	var allowed int
+	for t := time.Duration(0); t < d; t += interval {
+		if r.allowAt(start.Add(t), size) {
+			allowed++
+		}
+	}
+	return allowed
+}
+
+func TestRateLimiter(t *testing.T) {
+	tests := []struct {
+		name     string
+		pps, bps uint64
+		size     int
+		// 1 秒的突发之后，接下来 10 秒通过的包数
+		want int
+	}{
+		{"packets per second", 100, 0, 100, 1000},
+		{"bytes per second", 0, 100_000, 1000, 1000},
+		{"packets bound first", 50, 100_000, 1000, 500},
+		{"unlimited", 0, 0, 1000, 10000},
+		// 比 bps 大的包每 1.5 秒通过一个，平均速率不变
+		{"packet larger than bps", 0, 1000, 1500, 6},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			start := time.Unix(1700000000, 0)
+			r := NewRateLimiter(test.pps, test.bps)
+			r.last = start
+			// 先用完初始的突发
+			rateRun(r, start, time.Second, time.Millisecond, test.size)
+
+			allowed := rateRun(r, start.Add(time.Second), 10*time.Second, time.Millisecond, test.size)
+			if want := test.want; allowed < want-want/10-1 || allowed > want+want/10+1 {
+				t.Errorf("%d packets allowed in 10s, want about %d", allowed, want)
+			}
+		})
+	}
+}
+
+func TestRateLimiterOversizePacket(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	r := NewRateLimiter(0, 100)
+	r.last = start
+
+	// 满的桶能放行一个最大的包
+	if !r.allowAt(start, PacketBufferSize) {
+		t.Fatal("a packet larger than bps never passes")
+	}
+	if r.allowAt(start.Add(time.Second), 1000) {
+		t.Error("the bucket refilled faster than bps")
+	}
+}
+
+func TestRateLimiterSetRate(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to uint64
+	}{
+		{"increase", 100, 400},
+		{"decrease", 400, 100},
+		{"enable", 0, 200},
+		{"disable", 200, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			start := time.Unix(1700000000, 0)
+			r := NewRateLimiter(test.from, 0)
+			r.last = start
+			rateRun(r, start, 5*time.Second, time.Millisecond, 100)
+
+			// 运行中改变速率
+			now := start.Add(5 * time.Second)
+			r.setRateAt(now, test.to, 0)
+			if pps, _ := r.Rate(); pps != test.to {
+				t.Fatalf("Rate() = %d, want %d", pps, test.to)
+			}
+
+			// 突发用完之后达到新的速率
+			rateRun(r, now, time.Second, time.Millisecond, 100)
+			allowed := rateRun(r, now.Add(time.Second), 5*time.Second, time.Millisecond, 100)
+			want := int(test.to) * 5
+			if test.to == 0 {
+				want = 5000
+			}
+			if allowed < want-want/20-1 || allowed > want+want/20+1 {
+				t.Errorf("%d packets allowed in 5s after SetRate(%d), want about %d", allowed, test.to, want)
+			}
+		})
+	}
+}
+
+func TestRateLimiterIncreaseKeepsTokens(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	r := NewRateLimiter(100, 0)
+	r.last = start
+
+	// 桶里的 100 个令牌保留，不清空也不直接加满到 400
+	r.setRateAt(start, 400, 0)
+	if allowed := rateRun(r, start, time.Millisecond, time.Microsecond, 100); allowed != 100 {
+		t.Errorf("%d packets allowed right after raising the rate, want 100", allowed)
+	}
+}
+
+func TestRateLimiterDecreaseCapsBurst(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	r := NewRateLimiter(1000, 0)
+	r.last = start
+
+	// 满桶 1000 个令牌，降到 10 之后最多突发 10 个
+	r.setRateAt(start, 10, 0)
+	if allowed := rateRun(r, start, 10*time.Millisecond, 10*time.Microsecond, 100); allowed != 10 {
+		t.Errorf("%d packets allowed right after lowering the rate, want 10", allowed)
+	}
+}
@@ -0,0 +1,274 @@
+package godivert
+
+import (
+	"encoding/binary"
+	"examples/header"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// Time an incomplete datagram is kept when Reassembler.Timeout isn't set
+	// RFC 791 suggests 15 seconds, Linux keeps the fragments 30 seconds
+	DefaultReassemblyTimeout = 30 * time.Second
+
+	// Number of incomplete datagrams kept when Reassembler.MaxPending isn't set
+	DefaultReassemblyMaxPending = 1024
+)
+
+// Largest IPv4 datagram, a reassembled payload can't make it longer
+const maxIPv4DatagramLen = 65535
+
+// Identifies the fragments of a datagram (RFC 791)
+type fragmentKey struct {
+	src, dst [4]byte
+	id       uint16
+	protocol uint8
+}
+
+// Payload of a fragment, start and end are in bytes from the start of the datagram's payload
+type fragmentData struct {
+	start, end int
+	data       []byte
+}
+
+// Fragments of a datagram received so far
+type fragmentSet struct {
+	// 按偏移排序
+	fragments []fragmentData
+	// Header and address of the first fragment, nil until it arrives
+	header []byte
+	addr   *WinDivertAddress
+	// Length of the payload, -1 until the last fragment (More Fragments not set) arrives
+	total   int
+	expires time.Time
+	// Set once the datagram has been dropped, its next fragments are dropped too until it expires
+	dropped bool
+}
+
+// Reassembles the IPv4 fragments into whole datagrams, e.g. to inspect the payload of large UDP datagrams
+// The fragments are grouped by source, destination, ID and protocol. A datagram with overlapping
+// fragments is dropped as RFC 1858 and RFC 5722 recommend, an incomplete one once Timeout elapsed.
+// The zero value is ready to use and it is safe for concurrent use.
+type Reassembler struct {
+	// Time an incomplete datagram is kept, DefaultReassemblyTimeout by default
+	Timeout time.Duration
+	// Number of incomplete datagrams kept, the fragments of new datagrams are dropped once it is
+	// reached. Bounds the memory a fragment flood can take, DefaultReassemblyMaxPending by default
+	MaxPending int
+
+	mu        sync.Mutex
+	sets      map[fragmentKey]*fragmentSet
+	nextSweep time.Time
+}
+
+// Returns a Reassembler with the default Timeout and MaxPending, the same as the zero value
+func NewReassembler() *Reassembler {
+	return &Reassembler{
+		sets: make(map[fragmentKey]*fragmentSet),
+	}
+}
+
+// Adds a packet and returns the datagram it completes
+// Packets that aren't IPv4 fragments are returned as they are with true. For a fragment, the
+// reassembled datagram and true are returned once the last missing fragment arrives, nil and false
+// until then or if the fragment is dropped (malformed, overlapping or too many pending datagrams).
+// The fragment's bytes are copied: the caller keeps the packet and still has to send or drop it.
+// The reassembled packet holds no pooled buffer, its IPv4 checksum is valid and its address is the
+// first fragment's one. It can exceed the MTU: reinject the fragments rather than the datagram.
+func (r *Reassembler) Push(p *Packet) (*Packet, bool) {
+	return r.pushAt(time.Now(), p)
+}
+
+// Push of a packet received at the given time
+func (r *Reassembler) pushAt(now time.Time, p *Packet) (*Packet, bool) {
+	if len(p.Raw) < 20 || p.Raw[0]>>4 != header.IPv4 {
+		return p, true
+	}
+	hdrLen := int(p.Raw[0]&0xf) << 2
+	if hdrLen < 20 || hdrLen > len(p.Raw) {
+		return p, true
+	}
+	ipv4Hdr := header.NewIPv4Header(p.Raw)
+	if !ipv4Hdr.IsFragment() {
+		return p, true
+	}
+
+	totalLen := int(ipv4Hdr.TotalLen())
+	if totalLen < hdrLen || totalLen > len(p.Raw) {
+		// 截断或长度字段错误的分片
+		return nil, false
+	}
+	fragment := fragmentData{
+		start: int(ipv4Hdr.FragOff()) * 8,
+		data:  append([]byte(nil), p.Raw[hdrLen:totalLen]...),
+	}
+	fragment.end = fragment.start + len(fragment.data)
+
+	key := fragmentKey{
+		id:       ipv4Hdr.ID(),
+		protocol: ipv4Hdr.Protocol(),
+	}
+	copy(key.src[:], p.Raw[12:16])
+	copy(key.dst[:], p.Raw[16:20])
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.expire(now)
+
+	set, ok := r.sets[key]
+	if !ok {
+		if len(r.sets) >= r.maxPending() {
+			return nil, false
+		}
+		if r.sets == nil {
+			r.sets = make(map[fragmentKey]*fragmentSet)
+		}
+		set = &fragmentSet{
+			total:   -1,
+			expires: now.Add(r.timeout()),
+		}
+		r.sets[key] = set
+	}
+	if set.dropped {
+		return nil, false
+	}
+
+	if !set.add(fragment, hdrLen, ipv4Hdr.MoreFragments()) {
+		set.drop()
+		return nil, false
+	}
+	if fragment.start == 0 && set.header == nil {
+		set.header = append([]byte(nil), p.Raw[:hdrLen]...)
+		if p.Addr != nil {
+			addr := *p.Addr
+			set.addr = &addr
+		}
+	}
+	if !set.complete() {
+		return nil, false
+	}
+
+	delete(r.sets, key)
+	return set.datagram(), true
+}
+
+// Returns the number of incomplete datagrams
+func (r *Reassembler) Pending() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.sets)
+}
+
+func (r *Reassembler) timeout() time.Duration {
+	if r.Timeout <= 0 {
+		return DefaultReassemblyTimeout
+	}
+	return r.Timeout
+}
+
+func (r *Reassembler) maxPending() int {
+	if r.MaxPending <= 0 {
+		return DefaultReassemblyMaxPending
+	}
+	return r.MaxPending
+}
+
+// Forgets the datagrams that expired, at most once per second
+func (r *Reassembler) expire(now time.Time) {
+	if now.Before(r.nextSweep) {
+		return
+	}
+	r.nextSweep = now.Add(time.Second)
+
+	for key, set := range r.sets {
+		if now.After(set.expires) {
+			delete(r.sets, key)
+		}
+	}
+}
+
+// Adds a fragment to the set
+// Returns false if the fragment is invalid or overlaps another one, the datagram must then be dropped
+func (s *fragmentSet) add(fragment fragmentData, hdrLen int, more bool) bool {
+	if fragment.end > maxIPv4DatagramLen-hdrLen {
+		return false
+	}
+	if more {
+		// 除最后一个分片外，分片数据长度必须是 8 的倍数
+		if fragment.end == fragment.start || len(fragment.data)%8 != 0 {
+			return false
+		}
+		if s.total >= 0 && fragment.end > s.total {
+			return false
+		}
+	} else {
+		if s.total >= 0 && s.total != fragment.end {
+			return false
+		}
+		if n := len(s.fragments); n > 0 && s.fragments[n-1].end > fragment.end {
+			return false
+		}
+		s.total = fragment.end
+	}
+
+	i := sort.Search(len(s.fragments), func(i int) bool {
+		return s.fragments[i].start >= fragment.start
+	})
+	for _, other := range s.fragments {
+		if fragment.start < other.end && other.start < fragment.end {
+			// 完全相同的重复分片直接忽略，其它重叠丢弃整个数据报
+			return other.start == fragment.start && other.end == fragment.end
+		}
+	}
+	s.fragments = append(s.fragments, fragmentData{})
+	copy(s.fragments[i+1:], s.fragments[i:])
+	s.fragments[i] = fragment
+	return true
+}
+
+// Drops the fragments, the set is kept until it expires to drop the fragments still arriving
+func (s *fragmentSet) drop() {
+	s.dropped = true
+	s.fragments = nil
+	s.header = nil
+	s.addr = nil
+}
+
+// Returns true if every byte of the payload has been received
+func (s *fragmentSet) complete() bool {
+	if s.total < 0 || s.header == nil {
+		return false
+	}
+	next := 0
+	for _, fragment := range s.fragments {
+		if fragment.start != next {
+			return false
+		}
+		next = fragment.end
+	}
+	return next == s.total
+}
+
+// Builds the datagram from the first fragment's header and the payloads
+func (s *fragmentSet) datagram() *Packet {
+	hdrLen := len(s.header)
+	raw := make([]byte, hdrLen+s.total)
+	copy(raw, s.header)
+	for _, fragment := range s.fragments {
+		copy(raw[hdrLen+fragment.start:], fragment.data)
+	}
+
+	binary.BigEndian.PutUint16(raw[2:4], uint16(len(raw)))
+	// 只保留 DF 标志，清除 MF 和片偏移
+	raw[6] &= header.IPv4FlagDontFragment << 5
+	raw[7] = 0
+	binary.BigEndian.PutUint16(raw[10:12], 0)
+	binary.BigEndian.PutUint16(raw[10:12], foldChecksum(sumBytes(raw[:hdrLen], 0)))
+
+	packet := NewPacket(raw, s.addr)
+	packet.setAddrChecksumBit(addrIPChecksumBit)
+	return packet
+}
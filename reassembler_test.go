@@ -0,0 +1,142 @@
+package godivert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"examples/header"
+	"testing"
+	"time"
+)
+
+// Returns an IPv4 fragment of the UDP datagram id from 10.0.0.1 to 10.0.0.2
+// offset is in bytes from the start of the datagram's payload
+func ipv4Fragment(id uint16, offset int, more bool, data []byte) []byte {
+	raw := make([]byte, header.IPv4HeaderLen+len(data))
+	raw[0] = 0x45
+	binary.BigEndian.PutUint16(raw[2:4], uint16(len(raw)))
+	binary.BigEndian.PutUint16(raw[4:6], id)
+	flags := uint16(offset / 8)
+	if more {
+		flags |= uint16(header.IPv4FlagMoreFragments) << 13
+	}
+	binary.BigEndian.PutUint16(raw[6:8], flags)
+	raw[8], raw[9] = 64, header.UDP
+	copy(raw[12:16], []byte{10, 0, 0, 1})
+	copy(raw[16:20], []byte{10, 0, 0, 2})
+	copy(raw[20:], data)
+	return raw
+}
+
+func TestReassembler(t *testing.T) {
+	payload := make([]byte, 40)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+	// 三个分片：0-16、16-32、32-40
+	first := ipv4Fragment(1, 0, true, payload[:16])
+	second := ipv4Fragment(1, 16, true, payload[16:32])
+	last := ipv4Fragment(1, 32, false, payload[32:])
+
+	type push struct {
+		raw []byte
+		at  time.Duration
+	}
+	tests := []struct {
+		name   string
+		pushes []push
+		// Index of the push completing the datagram, -1 if it never completes
+		complete int
+		pending  int
+	}{
+		{"in order", []push{{first, 0}, {second, 0}, {last, 0}}, 2, 0},
+		{"out of order", []push{{last, 0}, {first, 0}, {second, 0}}, 2, 0},
+		{"duplicate", []push{{first, 0}, {first, 0}, {last, 0}, {second, 0}}, 3, 0},
+		{"overlapping", []push{
+			{first, 0},
+			{ipv4Fragment(1, 8, true, payload[8:24]), 0},
+			{second, 0},
+			{last, 0},
+		}, -1, 1},
+		{"same offset, other length", []push{{first, 0}, {ipv4Fragment(1, 0, true, payload[:8]), 0}, {second, 0}, {last, 0}}, -1, 1},
+		{"oversize", []push{{first, 0}, {ipv4Fragment(1, 65528, false, payload[:16]), 0}}, -1, 1},
+		{"payload not a multiple of 8", []push{{ipv4Fragment(1, 0, true, payload[:12]), 0}}, -1, 1},
+		{"two last fragments", []push{{last, 0}, {ipv4Fragment(1, 24, false, payload[24:]), 0}, {first, 0}, {second, 0}}, -1, 1},
+		{"expired", []push{{first, 0}, {second, 0}, {last, 3 * time.Second}}, -1, 1},
+		{"within the timeout", []push{{first, 0}, {second, time.Second}, {last, 1500 * time.Millisecond}}, 2, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := &Reassembler{Timeout: 2 * time.Second}
+			start := time.Now()
+			addr := &WinDivertAddress{}
+			addr.SetIfIdx(4)
+
+			for i, push := range test.pushes {
+				fragment := NewPacket(append([]byte(nil), push.raw...), addr)
+				datagram, ok := r.pushAt(start.Add(push.at), fragment)
+				if ok != (i == test.complete) {
+					t.Fatalf("push %d: complete %v, want %v", i, ok, i == test.complete)
+				}
+				if !ok {
+					continue
+				}
+
+				raw := datagram.Raw
+				if !bytes.Equal(raw[header.IPv4HeaderLen:], payload) {
+					t.Errorf("reassembled payload %v, want %v", raw[header.IPv4HeaderLen:], payload)
+				}
+				if got := int(binary.BigEndian.Uint16(raw[2:4])); got != len(raw) {
+					t.Errorf("total length %d, want %d", got, len(raw))
+				}
+				if raw[6] != 0 || raw[7] != 0 {
+					t.Errorf("fragment flags and offset %#x%02x, want 0", raw[6], raw[7])
+				}
+				if ip, _ := checksumsValid(raw); !ip {
+					t.Error("invalid IPv4 checksum")
+				}
+				if datagram.Addr.IfIdx() != 4 {
+					t.Errorf("address of interface %d, want the first fragment's one", datagram.Addr.IfIdx())
+				}
+			}
+			if n := r.Pending(); n != test.pending {
+				t.Errorf("Pending() = %d, want %d", n, test.pending)
+			}
+		})
+	}
+}
+
+func TestReassemblerNotFragment(t *testing.T) {
+	r := NewReassembler()
+	tests := []struct {
+		name string
+		raw  []byte
+	}{
+		{"whole datagram", buildIPv4(header.UDP, udpBytes(1, 2, nil))},
+		{"IPv6", buildIPv6(header.UDP, udpBytes(1, 2, nil))},
+		{"short", []byte{0x45, 0}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			packet := NewPacket(test.raw, nil)
+			if got, ok := r.Push(packet); got != packet || !ok {
+				t.Errorf("Push() = %p, %v, want the packet itself", got, ok)
+			}
+		})
+	}
+}
+
+func TestReassemblerMaxPending(t *testing.T) {
+	r := &Reassembler{MaxPending: 2}
+	for id := uint16(1); id <= 3; id++ {
+		r.Push(NewPacket(ipv4Fragment(id, 0, true, make([]byte, 8)), nil))
+	}
+	if n := r.Pending(); n != 2 {
+		t.Fatalf("Pending() = %d, want 2", n)
+	}
+	// 第三个数据报的分片被丢弃
+	if _, ok := r.Push(NewPacket(ipv4Fragment(3, 8, false, make([]byte, 8)), nil)); ok {
+		t.Error("Push() completed a datagram past MaxPending")
+	}
+}
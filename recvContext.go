@@ -0,0 +1,200 @@
+package godivert
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32CreateEvent         = kernel32DLL.NewProc("CreateEventW")
+	kernel32GetOverlappedResult = kernel32DLL.NewProc("GetOverlappedResult")
+)
+
+const (
+	errIOPending        = syscall.Errno(997)
+	errOperationAborted = syscall.Errno(995)
+)
+
+// Like Recv but returns ctx.Err() as soon as ctx is done
+// The read is an overlapped WinDivertRecvEx cancelled with CancelIoEx: the handle isn't shut down
+// and the pooled buffer goes back to the pool, so the handle can still be used after a deadline.
+// A packet received right when ctx is done is returned rather than lost.
+// A context that can't be cancelled falls back to Recv.
+// https://reqrypt.org/windivert-doc.html#divert_recv_ex
+func (wd *WinDivertHandle) RecvContext(ctx context.Context) (*Packet, error) {
+	if ctx.Done() == nil {
+		return wd.Recv()
+	}
+	if !wd.open.Load() {
+		return nil, errors.New("can't receive, the handle isn't open")
+	}
+	if !wd.layer.isNetwork() {
+		return nil, fmt.Errorf("can't receive packets on the %v layer, use RecvAddress", wd.layer)
+	}
+	if wd.flags&WinDivertFlagDrop != 0 {
+		return nil, errDropRecv
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return divertRecvContext(wd, ctx)
+}
+
+// Reads a packet with an overlapped WinDivertRecvEx, cancelled with CancelIoEx once ctx is done
+func (wd *WinDivertHandle) recvOverlapped(ctx context.Context) (*Packet, error) {
+	state, err := wd.overlapped.get()
+	if err != nil {
+		return nil, err
+	}
+	defer wd.overlapped.put(state)
+
+	packetBuffer := wd.buffers.get()
+	state.addrLen = uint32(winDivertAddressSize)
+	state.packetLen = 0
+
+	args := []uintptr{
+		wd.handle,
+		uintptr(unsafe.Pointer(&packetBuffer[0])),
+		uintptr(len(packetBuffer)),
+		0, // pRecvLen，重叠模式下由 GetOverlappedResult 返回
+	}
+	args = append(args, uint64Args(0)...)
+	args = append(args,
+		uintptr(unsafe.Pointer(&state.addr)),
+		uintptr(unsafe.Pointer(&state.addrLen)),
+		uintptr(unsafe.Pointer(&state.overlapped)))
+
+	success, _, err := winDivertRecvEx.Call(args...)
+	if success == 0 && err != errIOPending {
+		ReturnBuffer(packetBuffer, 0)
+		if err == errNoData {
+			return nil, ErrShutdown
+		}
+		return nil, winDivertErr("recv", err)
+	}
+
+	// 等待完成，context 结束时取消读取；取消后仍要等到 I/O 真正结束才能释放缓冲区和状态
+	stop := state.cancelOnDone(ctx, wd.handle)
+	success, _, err = kernel32GetOverlappedResult.Call(
+		wd.handle,
+		uintptr(unsafe.Pointer(&state.overlapped)),
+		uintptr(unsafe.Pointer(&state.packetLen)),
+		1)
+	stop()
+	runtime.KeepAlive(packetBuffer)
+
+	// 地址要复制出来，状态会被下一次读取复用
+	addr := state.addr
+	packetLen := uint(state.packetLen)
+	if success == 0 {
+		if err == errInsufficientBuffer {
+			return wd.truncatedPacket(packetBuffer, packetLen, &addr)
+		}
+		ReturnBuffer(packetBuffer, int(packetLen))
+		if err == errOperationAborted && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err == errNoData {
+			return nil, ErrShutdown
+		}
+		return nil, winDivertErr("recv", err)
+	}
+
+	return wd.receivedPacket(packetBuffer, packetLen, &addr), nil
+}
+
+// State of an overlapped read: the driver writes it when the read completes, after WinDivertRecvEx
+// returned, so it lives on the heap and is only reused once the read is over
+type overlappedRecv struct {
+	overlapped syscall.Overlapped
+	addr       WinDivertAddress
+	addrLen    uint32
+	packetLen  uint32
+
+	// Guards the cancellation: a cancel running late, once its read is over, must not cancel the next read
+	mu      sync.Mutex
+	pending bool
+	gen     uint64
+}
+
+// Cancels the pending read once ctx is done, returns the function to call after the read completed
+// No goroutine runs until ctx is done
+func (s *overlappedRecv) cancelOnDone(ctx context.Context, handle uintptr) func() {
+	s.mu.Lock()
+	s.pending = true
+	gen := s.gen
+	s.mu.Unlock()
+
+	stopCancel := context.AfterFunc(ctx, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if s.pending && s.gen == gen {
+			syscall.CancelIoEx(syscall.Handle(handle), &s.overlapped)
+		}
+	})
+	return func() {
+		stopCancel()
+		s.mu.Lock()
+		s.pending = false
+		s.gen++
+		s.mu.Unlock()
+	}
+}
+
+// Read states of a handle, each with its own event so that several RecvContext can run at once
+// The events are created once and closed with the handle
+type overlappedStates struct {
+	mu     sync.Mutex
+	free   []*overlappedRecv
+	closed bool
+}
+
+func (s *overlappedStates) get() (*overlappedRecv, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, errors.New("can't receive, the handle isn't open")
+	}
+	if n := len(s.free); n > 0 {
+		state := s.free[n-1]
+		s.free = s.free[:n-1]
+		return state, nil
+	}
+
+	// 手动重置的事件，开始读取时由 WinDivertRecvEx 复位，完成时由内核置位
+	event, _, err := kernel32CreateEvent.Call(0, 1, 0, 0)
+	if event == 0 {
+		return nil, err
+	}
+	return &overlappedRecv{overlapped: syscall.Overlapped{HEvent: syscall.Handle(event)}}, nil
+}
+
+func (s *overlappedStates) put(state *overlappedRecv) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		syscall.CloseHandle(state.overlapped.HEvent)
+		return
+	}
+	s.free = append(s.free, state)
+}
+
+// Closes the events, those of the reads still running are closed when they end
+func (s *overlappedStates) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	for _, state := range s.free {
+		syscall.CloseHandle(state.overlapped.HEvent)
+	}
+	s.free = nil
+}
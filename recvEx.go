@@ -0,0 +1,134 @@
+package godivert
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// WINDIVERT_BATCH_MAX, the maximum number of packets of a RecvEx
+const WinDivertBatchMax = 0xff
+
+// Room reserved per packet in a RecvEx buffer past the first one, a typical MTU with some headroom
+// The buffer always fits one packet of the BufferSize of the handle, bigger packets just make the batch shorter
+const recvExPacketSize = 1600
+
+// A buffer shared by the packets of a RecvEx
+// refs counts the packets still holding it, it goes back to the pool when the last one is sent or dropped
+type recvBatch struct {
+	buffer []byte
+	refs   atomic.Int32
+}
+
+var recvBatchPool sync.Pool
+
+func getRecvBatch(size int) *recvBatch {
+	if batch, ok := recvBatchPool.Get().(*recvBatch); ok && len(batch.buffer) >= size {
+		return batch
+	}
+	return &recvBatch{buffer: make([]byte, size)}
+}
+
+// Releases one reference, the last one returns the buffer to the pool
+func (b *recvBatch) release() {
+	if b.refs.Add(-1) == 0 {
+		recvBatchPool.Put(b)
+	}
+}
+
+// Returns the buffer of a received packet: to the pool for Recv, to its batch for RecvEx
+// The packet must not be used afterwards
+func (p *Packet) returnBuffer() {
+	if p.batch != nil {
+		p.batch.release()
+		return
+	}
+	ReturnBuffer(p.Buffer, p.bufferLen())
+}
+
+// Returns the number of bytes of Buffer the packet may have written: the received bytes,
+// or more if Raw grew in place within Buffer
+func (p *Packet) bufferLen() int {
+	n := p.bufferUsed
+	if p.sharesBuffer() && len(p.Raw) > n {
+		n = len(p.Raw)
+	}
+	return n
+}
+
+// Returns true if Raw still lives in Buffer, false once an edit moved it to its own allocation
+func (p *Packet) sharesBuffer() bool {
+	return len(p.Raw) > 0 && len(p.Buffer) > 0 && &p.Raw[:1][0] == &p.Buffer[0]
+}
+
+// Returns true if the packet holds a buffer of the pool or of a RecvEx batch, i.e. it has been received
+func (p *Packet) pooled() bool {
+	return p.Buffer != nil || p.batch != nil
+}
+
+// Receives up to maxPackets packets in one call to WinDivertRecvEx
+// The packets share one buffer: each one holds a reference released by Send or by dropping it
+// (e.g. ActionDrop), the buffer goes back to the pool once every packet of the batch released it.
+// A packet that is neither sent nor dropped keeps the whole batch buffer alive.
+// Returns at least one packet, blocking until one is available like Recv.
+// https://reqrypt.org/windivert-doc.html#divert_recv_ex
+func (wd *WinDivertHandle) RecvEx(maxPackets int) ([]*Packet, error) {
+	if !wd.open.Load() {
+		return nil, errors.New("can't receive, the handle isn't open")
+	}
+	if !wd.layer.isNetwork() {
+		return nil, fmt.Errorf("can't receive packets on the %v layer, use RecvAddress", wd.layer)
+	}
+	if wd.flags&WinDivertFlagDrop != 0 {
+		return nil, errDropRecv
+	}
+	if maxPackets < 1 || maxPackets > WinDivertBatchMax {
+		return nil, fmt.Errorf("invalid batch size %d, must be between 1 and %d", maxPackets, WinDivertBatchMax)
+	}
+
+	// 第一个包的空间和 Recv 一样是 BufferSize
+	size := wd.buffers.size + (maxPackets-1)*min(recvExPacketSize, wd.buffers.size)
+	batch := getRecvBatch(size)
+	addrs := make([]WinDivertAddress, maxPackets)
+	addrLen := uint32(maxPackets * winDivertAddressSize)
+	var recvLen uint32
+
+	if err := divertRecvEx(wd.handle, batch.buffer[:size], &recvLen, addrs, &addrLen); err != nil {
+		recvBatchPool.Put(batch)
+		if err == errNoData {
+			return nil, ErrShutdown
+		}
+		return nil, winDivertErr("recv", err)
+	}
+
+	count := int(addrLen) / winDivertAddressSize
+	if count == 0 || recvLen == 0 {
+		recvBatchPool.Put(batch)
+		return nil, errors.New("can't receive, WinDivertRecvEx returned no packet")
+	}
+	packets := make([]*Packet, 0, count)
+	data := batch.buffer[:recvLen]
+	batch.refs.Store(int32(count))
+
+	// 用 WinDivertHelperParsePacket 找到每个包的边界
+	for i := 0; i < count && len(data) > 0; i++ {
+		nextLen, ok := divertParsePacket(data)
+		packetLen := len(data) - int(nextLen)
+		if !ok || packetLen <= 0 {
+			packetLen = len(data)
+		}
+
+		packet := wd.receivedPacket(data[:packetLen:packetLen], uint(packetLen), &addrs[i])
+		packet.Buffer = nil
+		packet.batch = batch
+		packets = append(packets, packet)
+		data = data[packetLen:]
+	}
+
+	// 地址数多于解析出的包时释放多余的引用
+	for i := len(packets); i < count; i++ {
+		batch.release()
+	}
+	return packets, nil
+}
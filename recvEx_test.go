@@ -0,0 +1,206 @@
+package godivert
+
+import (
+	"bytes"
+	"errors"
+	"examples/header"
+	"testing"
+)
+
+func TestRecvEx(t *testing.T) {
+	small, large := ipv4Packet(60), ipv4Packet(1400)
+
+	tests := []struct {
+		name       string
+		bufferSize int
+		maxPackets int
+		queue      [][]byte
+		wantLen    int
+		want       [][]byte
+	}{
+		{
+			name:       "default buffer size",
+			maxPackets: 3,
+			queue:      [][]byte{small, large, small, small},
+			wantLen:    PacketBufferSize + 2*recvExPacketSize,
+			want:       [][]byte{small, large, small},
+		},
+		{
+			name:       "small buffer size",
+			bufferSize: 1500,
+			maxPackets: 4,
+			queue:      [][]byte{large, large, small},
+			wantLen:    4 * 1500,
+			want:       [][]byte{large, large, small},
+		},
+		{
+			name:       "buffer smaller than the room per packet",
+			bufferSize: 200,
+			maxPackets: 2,
+			queue:      [][]byte{small, small, small, small},
+			wantLen:    400,
+			want:       [][]byte{small, small, small, small},
+		},
+		{
+			name:       "IPv6",
+			maxPackets: 2,
+			queue:      [][]byte{buildIPv6(header.UDP, udpBytes(1, 2, []byte("ipv6"))), small},
+			wantLen:    PacketBufferSize + recvExPacketSize,
+			want:       [][]byte{buildIPv6(header.UDP, udpBytes(1, 2, []byte("ipv6"))), small},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			driver := newFakeDriver(t)
+			wd := openFake(t, OpenOptions{BufferSize: test.bufferSize})
+			for i, raw := range test.queue {
+				var addr WinDivertAddress
+				addr.SetIfIdx(uint32(i))
+				driver.divert(raw, addr)
+			}
+
+			packets, err := wd.RecvEx(test.maxPackets)
+			if err != nil {
+				t.Fatalf("RecvEx() = %v", err)
+			}
+			if driver.recvExLen != test.wantLen {
+				t.Errorf("RecvEx() used a %d bytes buffer, want %d", driver.recvExLen, test.wantLen)
+			}
+			// 缓冲区只装得下 200 字节时，剩下的包留给下一次 RecvEx
+			for len(packets) < len(test.want) {
+				more, err := wd.RecvEx(test.maxPackets)
+				if err != nil {
+					t.Fatalf("RecvEx() = %v", err)
+				}
+				packets = append(packets, more...)
+			}
+			if len(packets) != len(test.want) {
+				t.Fatalf("received %d packets, want %d", len(packets), len(test.want))
+			}
+			for i, packet := range packets {
+				if !bytes.Equal(packet.Raw, test.want[i]) || packet.Addr.IfIdx() != uint32(i) {
+					t.Errorf("packet %d: %d bytes on interface %d, want %d bytes on %d",
+						i, len(packet.Raw), packet.Addr.IfIdx(), len(test.want[i]), i)
+				}
+			}
+
+			batch := packets[0].batch
+			for _, packet := range packets {
+				packet.Release()
+			}
+			if refs := batch.refs.Load(); refs != 0 {
+				t.Errorf("%d references left on the batch after releasing every packet", refs)
+			}
+		})
+	}
+}
+
+func TestRecvExSendReleasesBatch(t *testing.T) {
+	driver := newFakeDriver(t)
+	wd := openFake(t, OpenOptions{})
+	for i := 0; i < 3; i++ {
+		driver.divert(ipv4Packet(40), WinDivertAddress{})
+	}
+
+	packets, err := wd.RecvEx(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := packets[0].batch
+	for i, packet := range packets {
+		if _, err := packet.Send(wd); err != nil {
+			t.Fatalf("Send() of packet %d = %v", i, err)
+		}
+		if refs, want := batch.refs.Load(), int32(len(packets)-i-1); refs != want {
+			t.Fatalf("%d references after sending packet %d, want %d", refs, i, want)
+		}
+	}
+	if n := len(driver.injected()); n != 3 {
+		t.Errorf("%d packets injected, want 3", n)
+	}
+}
+
+func TestRecvExNoPacket(t *testing.T) {
+	newFakeDriver(t)
+	wd := openFake(t, OpenOptions{})
+
+	// 成功但没有返回任何地址
+	divertRecvEx = func(handle uintptr, buffer []byte, recvLen *uint32, addrs []WinDivertAddress, addrLen *uint32) error {
+		*recvLen, *addrLen = 0, 0
+		return nil
+	}
+	if packets, err := wd.RecvEx(4); err == nil || packets != nil {
+		t.Errorf("RecvEx() = %d packets, %v, want an error", len(packets), err)
+	}
+}
+
+func TestRecvExErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		recvErr error
+		max     int
+		wantErr error
+	}{
+		{"shut down", errNoData, 1, ErrShutdown},
+		{"DLL error", errInsufficientBuffer, 1, ErrInsufficientBuffer},
+		{"batch too large", errNoData, WinDivertBatchMax + 1, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			driver := newFakeDriver(t)
+			driver.recvErr = test.recvErr
+			wd := openFake(t, OpenOptions{})
+
+			_, err := wd.RecvEx(test.max)
+			if err == nil || test.wantErr != nil && !errors.Is(err, test.wantErr) {
+				t.Errorf("RecvEx() = %v, want %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+// Receives batches of 64 packets of a typical MTU with RecvEx and with as many Recv calls
+func benchmarkRecv(b *testing.B, recv func(wd *WinDivertHandle) int) {
+	driver := newFakeDriver(b)
+	wd := openFake(b, OpenOptions{})
+	raw := ipv4Packet(1400)
+	b.SetBytes(64 * int64(len(raw)))
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		for j := 0; j < 64; j++ {
+			driver.divert(raw, WinDivertAddress{})
+		}
+		b.StartTimer()
+		for n := 0; n < 64; {
+			n += recv(wd)
+		}
+	}
+}
+
+func BenchmarkRecvEx(b *testing.B) {
+	benchmarkRecv(b, func(wd *WinDivertHandle) int {
+		packets, err := wd.RecvEx(64)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for _, packet := range packets {
+			packet.Release()
+		}
+		return len(packets)
+	})
+}
+
+func BenchmarkRecv(b *testing.B) {
+	benchmarkRecv(b, func(wd *WinDivertHandle) int {
+		packet, err := wd.Recv()
+		if err != nil {
+			b.Fatal(err)
+		}
+		packet.Release()
+		return 1
+	})
+}
@@ -0,0 +1,206 @@
+package godivert
+
+import (
+	"examples/header"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Time a redirect made by RedirectTo is kept without traffic on its connection
+	RedirectIdleTimeout = 2 * time.Minute
+	// Time a redirect made by RedirectTo is kept after a FIN or RST, for the last segments of the connection
+	RedirectCloseTimeout = 10 * time.Second
+)
+
+// Original destination of a redirected connection
+type redirectTarget struct {
+	ip   net.IP
+	port uint16
+	// UnixNano after which the redirect is forgotten, 0 if it is kept until UnregisterRedirect
+	// Every packet of the connection pushes it back, under the read lock of the table
+	expires atomic.Int64
+	// Set after a FIN or RST, the expiry isn't pushed back anymore
+	closing atomic.Bool
+}
+
+// Returns true if the redirect has expired at now
+func (r *redirectTarget) expired(now time.Time) bool {
+	expires := r.expires.Load()
+	return expires != 0 && now.UnixNano() > expires
+}
+
+// Pushes back the expiry of a redirect made by RedirectTo after a packet of its connection
+// A FIN or RST shortens it to RedirectCloseTimeout.
+func (r *redirectTarget) touch(now time.Time, p *Packet) {
+	expires := r.expires.Load()
+	if expires == 0 {
+		return
+	}
+	if tcpHdr, ok := p.NextHeader.(*header.TCPHeader); ok && (tcpHdr.FIN() || tcpHdr.RST()) {
+		r.closing.Store(true)
+		if closing := now.Add(RedirectCloseTimeout).UnixNano(); closing < expires {
+			r.expires.Store(closing)
+		}
+		return
+	}
+	if !r.closing.Load() {
+		r.expires.Store(now.Add(RedirectIdleTimeout).UnixNano())
+	}
+}
+
+// Redirect table, keyed by the 4-tuple the local listener sees for the proxied connection
+var redirects = struct {
+	sync.RWMutex
+	targets   map[FlowKey]*redirectTarget
+	nextSweep time.Time
+}{targets: make(map[FlowKey]*redirectTarget)}
+
+// Records that the connection seen by the local listener as localTuple was originally sent to origIP:origPort
+// RedirectTo calls it, the code rewriting the packets towards the proxy by itself must call it before the proxy accepts the connection.
+// localTuple is the connection from the proxy's point of view: the remote end as source, the listener as destination.
+// Windows has no SO_ORIGINAL_DST so this table is the only way for the proxy to find where to connect.
+// The redirect is kept until UnregisterRedirect, the ones registered by RedirectTo expire by themselves.
+func RegisterRedirect(localTuple FlowKey, origIP net.IP, origPort uint16) {
+	registerRedirectAt(time.Now(), localTuple, origIP, origPort, 0)
+}
+
+// Registers a redirect expiring at the given UnixNano (0 for never) and forgets the expired ones
+func registerRedirectAt(now time.Time, localTuple FlowKey, origIP net.IP, origPort uint16, expires int64) *redirectTarget {
+	target := &redirectTarget{ip: make(net.IP, len(origIP)), port: origPort}
+	copy(target.ip, origIP)
+	target.expires.Store(expires)
+
+	redirects.Lock()
+	defer redirects.Unlock()
+
+	// 最多每秒清理一次过期的重定向
+	if !now.Before(redirects.nextSweep) {
+		redirects.nextSweep = now.Add(time.Second)
+		for key, other := range redirects.targets {
+			if other.expired(now) {
+				delete(redirects.targets, key)
+			}
+		}
+	}
+	redirects.targets[localTuple] = target
+	return target
+}
+
+// Removes a redirect, to be called once the proxied connection is closed
+func UnregisterRedirect(localTuple FlowKey) {
+	redirects.Lock()
+	delete(redirects.targets, localTuple)
+	redirects.Unlock()
+}
+
+// Returns the redirect of localTuple, nil if there is none or it has expired
+func lookupRedirect(now time.Time, localTuple FlowKey) *redirectTarget {
+	redirects.RLock()
+	target := redirects.targets[localTuple]
+	redirects.RUnlock()
+
+	if target == nil || target.expired(now) {
+		return nil
+	}
+	return target
+}
+
+// Returns the original destination of a redirected connection
+// The boolean is false if no redirect has been registered for localTuple or it has expired
+func OriginalDestination(localTuple FlowKey) (net.IP, uint16, bool) {
+	target := lookupRedirect(time.Now(), localTuple)
+	if target == nil {
+		return nil, 0, false
+	}
+	// 返回副本，调用者修改它不影响重定向表
+	ip := make(net.IP, len(target.ip))
+	copy(ip, target.ip)
+	return ip, target.port, true
+}
+
+// Reflects an outbound TCP or UDP packet to the proxy listening on proxyPort of the local host
+// and registers its original destination for OriginalDestination
+// The source and destination addresses are swapped, the destination port is set to proxyPort and
+// the packet is turned inbound, so the proxy accepts a connection from the original destination
+// to the client's address: it must listen on that address or on the unspecified one. Loopback packets
+// stay outbound as WinDivert only delivers them this way.
+// Call it on the outbound packets of the connections to redirect and send the packet, the proxy's replies go
+// through UndoRedirect. The proxy's own connections to the original destinations must not be redirected.
+// The redirect is registered on the SYN of a TCP connection and on the first datagram of a UDP one,
+// it is forgotten RedirectCloseTimeout after a FIN or RST or after RedirectIdleTimeout without traffic.
+// Returns false and leaves the packet unchanged if it doesn't start a connection and doesn't belong to a redirected one.
+// The checksums are recomputed when the packet is sent.
+func (p *Packet) RedirectTo(proxyPort uint16) (bool, error) {
+	return p.redirectToAt(time.Now(), proxyPort)
+}
+
+func (p *Packet) redirectToAt(now time.Time, proxyPort uint16) (bool, error) {
+	p.VerifyParsed()
+
+	if p.nextHeaderType != header.TCP && p.nextHeaderType != header.UDP {
+		return false, fmt.Errorf("cannot redirect protocolID=%d, packet isn't TCP or UDP", p.nextHeaderType)
+	}
+	if p.NextHeader == nil {
+		return false, fmt.Errorf("cannot redirect protocolID=%d, transport header is truncated", p.nextHeaderType)
+	}
+	if p.Addr == nil || p.Addr.Direction() != WinDivertDirectionOutbound {
+		return false, fmt.Errorf("cannot redirect protocolID=%d, packet isn't outbound", p.nextHeaderType)
+	}
+
+	key := p.FlowKey()
+	srcIP, dstIP := p.SrcIP(), p.DstIP()
+	// 代理看到的连接：原目的地址作为源，客户端地址作为目的
+	localTuple := NewFlowKey(dstIP, key.SrcPort, srcIP, proxyPort, key.Protocol)
+
+	target := lookupRedirect(now, localTuple)
+	tcpHdr, isTCP := p.NextHeader.(*header.TCPHeader)
+	switch {
+	case isTCP && tcpHdr.SYN() && !tcpHdr.ACK():
+		// 新的 SYN 覆盖同一四元组之前的连接
+		target = registerRedirectAt(now, localTuple, dstIP, key.DstPort, now.Add(RedirectIdleTimeout).UnixNano())
+	case target == nil && !isTCP:
+		target = registerRedirectAt(now, localTuple, dstIP, key.DstPort, now.Add(RedirectIdleTimeout).UnixNano())
+	case target == nil:
+		return false, nil
+	default:
+		target.touch(now, p)
+	}
+
+	p.SetSrcIP(dstIP)
+	p.SetDstIP(srcIP)
+	p.Addr.SetDirection(WinDivertDirectionInbound)
+	return true, p.SetDstPort(proxyPort)
+}
+
+// Rewrites a packet the proxy sends back to the client so the client sees the reply coming
+// from the server it connected to, the packet is reflected inbound like in RedirectTo
+// Returns false and leaves the packet unchanged if it doesn't belong to a redirected connection.
+func (p *Packet) UndoRedirect() (bool, error) {
+	return p.undoRedirectAt(time.Now())
+}
+
+func (p *Packet) undoRedirectAt(now time.Time) (bool, error) {
+	p.VerifyParsed()
+
+	if p.nextHeaderType != header.TCP && p.nextHeaderType != header.UDP || p.NextHeader == nil {
+		return false, nil
+	}
+	// 反方向就是监听端看到的连接
+	target := lookupRedirect(now, p.FlowKey().Reverse())
+	if target == nil {
+		return false, nil
+	}
+	target.touch(now, p)
+
+	clientIP := p.SrcIP()
+	p.SetSrcIP(target.ip.To16())
+	p.SetDstIP(clientIP)
+	if p.Addr != nil {
+		p.Addr.SetDirection(WinDivertDirectionInbound)
+	}
+	return true, p.SetSrcPort(target.port)
+}
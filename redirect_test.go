@@ -0,0 +1,211 @@
+package godivert
+
+import (
+	"examples/header"
+	"net"
+	"testing"
+	"time"
+)
+
+// Returns a parsed outbound TCP packet from src to dst
+func tcpPacket(t *testing.T, flags uint8, srcIP string, srcPort uint16, dstIP string, dstPort uint16) *Packet {
+	t.Helper()
+
+	packet := NewPacket(buildIPv4(header.TCP, tcpBytes(srcPort, dstPort, flags, nil)), &WinDivertAddress{})
+	packet.ParseHeaders()
+	packet.SetSrcIP(net.ParseIP(srcIP))
+	packet.SetDstIP(net.ParseIP(dstIP))
+	packet.Addr.SetDirection(WinDivertDirectionOutbound)
+	return packet
+}
+
+func TestRedirect(t *testing.T) {
+	// 代理看到的连接：来自原目的地址，去往客户端地址上的代理端口
+	localTuple := NewFlowKey(net.ParseIP("93.184.216.34"), 50000, net.ParseIP("10.0.0.1"), 8080, header.TCP)
+	t.Cleanup(func() { UnregisterRedirect(localTuple) })
+
+	syn := tcpPacket(t, 0x02, "10.0.0.1", 50000, "93.184.216.34", 443)
+	if ok, err := syn.RedirectTo(8080); !ok || err != nil {
+		t.Fatalf("RedirectTo() = %v, %v, want true", ok, err)
+	}
+	if key := syn.FlowKey(); key != localTuple {
+		t.Errorf("redirected packet %v, want %v", key, localTuple)
+	}
+	if syn.Direction() != WinDivertDirectionInbound {
+		t.Error("redirected packet isn't reflected inbound")
+	}
+
+	ip, port, ok := OriginalDestination(localTuple)
+	if !ok || !ip.Equal(net.ParseIP("93.184.216.34")) || port != 443 {
+		t.Fatalf("OriginalDestination() = %v, %d, %v, want 93.184.216.34, 443", ip, port, ok)
+	}
+	// 修改返回的地址不影响重定向表
+	ip[len(ip)-1] = 0
+	if ip, _, _ := OriginalDestination(localTuple); !ip.Equal(net.ParseIP("93.184.216.34")) {
+		t.Errorf("OriginalDestination() = %v after the caller modified the previous result", ip)
+	}
+
+	reply := tcpPacket(t, 0x12, "10.0.0.1", 8080, "93.184.216.34", 50000)
+	if ok, err := reply.UndoRedirect(); !ok || err != nil {
+		t.Fatalf("UndoRedirect() = %v, %v, want true", ok, err)
+	}
+	want := NewFlowKey(net.ParseIP("93.184.216.34"), 443, net.ParseIP("10.0.0.1"), 50000, header.TCP)
+	if key := reply.FlowKey(); key != want {
+		t.Errorf("reply rewritten to %v, want %v", key, want)
+	}
+	if reply.Direction() != WinDivertDirectionInbound {
+		t.Error("reply isn't reflected inbound")
+	}
+
+	other := tcpPacket(t, 0x10, "10.0.0.1", 8080, "93.184.216.34", 50001)
+	if ok, err := other.UndoRedirect(); ok || err != nil {
+		t.Errorf("UndoRedirect() of another connection = %v, %v, want false", ok, err)
+	}
+
+	UnregisterRedirect(localTuple)
+	if _, _, ok := OriginalDestination(localTuple); ok {
+		t.Error("OriginalDestination() found an unregistered redirect")
+	}
+}
+
+func TestRedirectLoopback(t *testing.T) {
+	localTuple := NewFlowKey(net.ParseIP("127.0.0.1"), 50000, net.ParseIP("127.0.0.1"), 8080, header.TCP)
+	t.Cleanup(func() { UnregisterRedirect(localTuple) })
+
+	syn := tcpPacket(t, 0x02, "127.0.0.1", 50000, "127.0.0.1", 443)
+	syn.Addr.setBit(addrLoopbackBit, true)
+	if ok, err := syn.RedirectTo(8080); !ok || err != nil {
+		t.Fatalf("RedirectTo() = %v, %v, want true", ok, err)
+	}
+	if key := syn.FlowKey(); key != localTuple {
+		t.Errorf("redirected packet %v, want %v", key, localTuple)
+	}
+	// 回环包只能按出站方向注入
+	if syn.Direction() != WinDivertDirectionOutbound {
+		t.Error("redirected loopback packet turned inbound")
+	}
+}
+
+func TestRedirectLifetime(t *testing.T) {
+	start := time.Unix(1_700_000_000, 0)
+	udp := func() *Packet {
+		packet := NewPacket(buildIPv4(header.UDP, udpBytes(50000, 53, nil)), &WinDivertAddress{})
+		packet.ParseHeaders()
+		packet.Addr.SetDirection(WinDivertDirectionOutbound)
+		return packet
+	}
+	tcp := func(flags uint8) *Packet {
+		return tcpPacket(t, flags, "10.0.0.1", 50000, "10.0.0.2", 443)
+	}
+
+	type step struct {
+		after      time.Duration
+		packet     *Packet
+		redirected bool
+	}
+	tests := []struct {
+		name  string
+		steps []step
+		// Time after start at which the redirect must be found and forgotten
+		foundAt, goneAt time.Duration
+	}{
+		{"no SYN", []step{{0, tcp(0x10), false}}, -1, 0},
+		{"SYN then data", []step{{0, tcp(0x02), true}, {time.Minute, tcp(0x10), true}},
+			time.Minute + RedirectIdleTimeout, time.Minute + RedirectIdleTimeout + time.Second},
+		{"idle", []step{{0, tcp(0x02), true}}, RedirectIdleTimeout, RedirectIdleTimeout + time.Second},
+		{"FIN", []step{{0, tcp(0x02), true}, {time.Second, tcp(0x11), true}, {2 * time.Second, tcp(0x10), true}},
+			time.Second + RedirectCloseTimeout, 2 * RedirectCloseTimeout},
+		{"RST", []step{{0, tcp(0x02), true}, {time.Second, tcp(0x04), true}},
+			time.Second + RedirectCloseTimeout, 2 * RedirectCloseTimeout},
+		{"UDP first datagram", []step{{0, udp(), true}, {time.Minute, udp(), true}},
+			time.Minute + RedirectIdleTimeout, time.Minute + RedirectIdleTimeout + time.Second},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var localTuple FlowKey
+			for _, step := range test.steps {
+				key := step.packet.FlowKey()
+				localTuple = NewFlowKey(net.IP(key.DstIP[:]), key.SrcPort, net.IP(key.SrcIP[:]), 8080, key.Protocol)
+				redirected, err := step.packet.redirectToAt(start.Add(step.after), 8080)
+				if err != nil || redirected != step.redirected {
+					t.Fatalf("RedirectTo() after %v = %v, %v, want %v", step.after, redirected, err, step.redirected)
+				}
+				if !redirected && step.packet.FlowKey() != key {
+					t.Error("RedirectTo() modified a packet it didn't redirect")
+				}
+			}
+			t.Cleanup(func() { UnregisterRedirect(localTuple) })
+
+			if test.foundAt >= 0 && lookupRedirect(start.Add(test.foundAt), localTuple) == nil {
+				t.Errorf("redirect forgotten after %v", test.foundAt)
+			}
+			if lookupRedirect(start.Add(test.goneAt), localTuple) != nil {
+				t.Errorf("redirect still found after %v", test.goneAt)
+			}
+		})
+	}
+}
+
+// The expired redirects are removed from the table by the next registration
+func TestRedirectSweep(t *testing.T) {
+	start := time.Unix(1_700_000_000, 0)
+	expired := NewFlowKey(net.ParseIP("10.0.0.2"), 40000, net.ParseIP("10.0.0.1"), 8080, header.TCP)
+	kept := NewFlowKey(net.ParseIP("10.0.0.2"), 40001, net.ParseIP("10.0.0.1"), 8080, header.TCP)
+	t.Cleanup(func() {
+		UnregisterRedirect(expired)
+		UnregisterRedirect(kept)
+	})
+
+	// 其他测试用当前时间清理过
+	redirects.Lock()
+	redirects.nextSweep = time.Time{}
+	redirects.Unlock()
+
+	registerRedirectAt(start, expired, net.ParseIP("10.0.0.2"), 443, start.Add(time.Second).UnixNano())
+	RegisterRedirect(kept, net.ParseIP("10.0.0.2"), 443)
+
+	syn := tcpPacket(t, 0x02, "10.0.0.1", 40002, "10.0.0.2", 443)
+	t.Cleanup(func() { UnregisterRedirect(syn.FlowKey()) })
+	if _, err := syn.redirectToAt(start.Add(time.Minute), 8080); err != nil {
+		t.Fatal(err)
+	}
+
+	redirects.RLock()
+	_, expiredFound := redirects.targets[expired]
+	_, keptFound := redirects.targets[kept]
+	redirects.RUnlock()
+	if expiredFound {
+		t.Error("expired redirect still in the table")
+	}
+	// RegisterRedirect 注册的重定向不会过期
+	if !keptFound {
+		t.Error("RegisterRedirect's redirect swept")
+	}
+}
+
+func TestRedirectToErrors(t *testing.T) {
+	inbound := NewPacket(buildIPv4(header.TCP, tcpBytes(1, 2, 0x02, nil)), &WinDivertAddress{})
+	inbound.Addr.SetDirection(WinDivertDirectionInbound)
+
+	tests := []struct {
+		name   string
+		packet *Packet
+	}{
+		{"ICMP", NewPacket(buildIPv4(header.ICMPv4, icmpBytes(header.ICMPEchoRequest, nil)), &WinDivertAddress{})},
+		{"inbound", inbound},
+		{"no address", NewPacket(buildIPv6(header.UDP, udpBytes(1, 2, nil)), nil)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			before := append([]byte(nil), test.packet.Raw...)
+			if ok, err := test.packet.RedirectTo(8080); ok || err == nil {
+				t.Errorf("RedirectTo() = %v, %v, want an error", ok, err)
+			}
+			if string(before) != string(test.packet.Raw) {
+				t.Error("RedirectTo() modified the packet it refused")
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package godivert
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// WINDIVERT_DATA_REFLECT, the union of the REFLECT layer
+type ReflectData struct {
+	// QueryPerformanceCounter value of the WinDivertOpen call of the observed handle
+	Timestamp int64
+	ProcessID uint32
+	Layer     Layer
+	Flags     uint64
+	Priority  int16
+}
+
+// Returns the union decoded as reflect data
+// Returns an error if the address isn't on the REFLECT layer
+func (w *WinDivertAddress) Reflect() (ReflectData, error) {
+	if w.Layer() != WinDivertLayerReflect {
+		return ReflectData{}, fmt.Errorf("the %v layer carries no reflect data", w.Layer())
+	}
+	return ReflectData{
+		Timestamp: int64(binary.LittleEndian.Uint64(w.Union[0:8])),
+		ProcessID: binary.LittleEndian.Uint32(w.Union[8:12]),
+		Layer:     Layer(binary.LittleEndian.Uint32(w.Union[12:16])),
+		Flags:     binary.LittleEndian.Uint64(w.Union[16:24]),
+		Priority:  int16(binary.LittleEndian.Uint16(w.Union[24:26])),
+	}, nil
+}
+
+// A WinDivert handle opened (WinDivertEventReflectOpen) or closed (WinDivertEventReflectClose)
+// by a process, this one included, as seen on the REFLECT layer
+type ReflectEvent struct {
+	Event Event
+	ReflectData
+	// Compiled filter object of the handle, the form CompiledFilter holds
+	Object string
+	// Human readable form of the filter, empty if WinDivert couldn't format the object
+	Filter string
+}
+
+// Returns the time the observed handle has been opened
+func (e *ReflectEvent) Opened() time.Time {
+	return qpcTime(e.Timestamp)
+}
+
+func (e *ReflectEvent) String() string {
+	return fmt.Sprintf("%v pid=%d layer=%v priority=%d flags=%#x filter=%q",
+		e.Event, e.ProcessID, e.Layer, e.Priority, e.Flags, e.Filter)
+}
+
+// Reads the next event of a REFLECT handle and decodes it
+// The packet data of these events is the filter object of the observed handle, it is formatted
+// back into a filter string for its layer. Handles at a higher priority on the same layer see
+// the packets first: listing them explains why a handle doesn't receive what it expects.
+// https://reqrypt.org/windivert-doc.html#divert_layers
+func (wd *WinDivertHandle) RecvReflect() (*ReflectEvent, error) {
+	if !wd.open.Load() {
+		return nil, errors.New("can't receive, the handle isn't open")
+	}
+	if wd.layer != WinDivertLayerReflect {
+		return nil, fmt.Errorf("can't receive reflect events on the %v layer", wd.layer)
+	}
+
+	buffer := make([]byte, compiledFilterMaxLen)
+	var recvLen uint
+	var addr WinDivertAddress
+	if err := divertRecv(wd.handle, buffer, &recvLen, &addr); err != nil {
+		if err == errNoData {
+			return nil, ErrShutdown
+		}
+		return nil, winDivertErr("recv", err)
+	}
+	wd.lastRecv.Store(time.Now().UnixNano())
+
+	data, err := addr.Reflect()
+	if err != nil {
+		return nil, err
+	}
+	event := &ReflectEvent{
+		Event:       addr.Event(),
+		ReflectData: data,
+		// 对象以 NUL 结尾
+		Object: strings.TrimRight(string(buffer[:recvLen]), "\x00"),
+	}
+	if filter, err := FormatFilter(event.Object, data.Layer); err == nil {
+		event.Filter = filter
+	}
+	return event, nil
+}
@@ -0,0 +1,48 @@
+package godivert
+
+import "sync"
+
+// Handles opened and not closed yet, see CloseAll
+var (
+	registryMu sync.Mutex
+	registry   = make(map[*WinDivertHandle]struct{})
+)
+
+func register(wd *WinDivertHandle) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	registry[wd] = struct{}{}
+}
+
+func unregister(wd *WinDivertHandle) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	delete(registry, wd)
+}
+
+// Shuts down and closes every handle that is still open, e.g. on application exit
+// Receiving and sending are shut down first (WinDivertShutdownBoth) so the loops reading the
+// handles stop cleanly. The handles opened with OpenOptions.Unregistered are left alone.
+// Every handle is closed even if some fail, the first error is returned.
+func CloseAll() error {
+	registryMu.Lock()
+	handles := make([]*WinDivertHandle, 0, len(registry))
+	for wd := range registry {
+		handles = append(handles, wd)
+	}
+	registryMu.Unlock()
+
+	var firstErr error
+	for _, wd := range handles {
+		shutdownErr := wd.Shutdown(WinDivertShutdownBoth)
+		if err := wd.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if shutdownErr != nil && firstErr == nil {
+			firstErr = shutdownErr
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,50 @@
+package godivert
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+)
+
+func TestCloseAll(t *testing.T) {
+	driver := newFakeDriver(t)
+
+	var handles []*WinDivertHandle
+	for i := 0; i < 3; i++ {
+		handles = append(handles, openFake(t, OpenOptions{}))
+	}
+	unregistered := openFake(t, OpenOptions{Unregistered: true})
+
+	if err := CloseAll(); err != nil {
+		t.Fatalf("CloseAll() = %v", err)
+	}
+	for i, wd := range handles {
+		if wd.open.Load() {
+			t.Errorf("handle %d is still open", i)
+		}
+		if got := driver.shutdownOf(wd); got != WinDivertShutdownBoth {
+			t.Errorf("handle %d shut down with %v, want %v", i, got, WinDivertShutdownBoth)
+		}
+	}
+	if !unregistered.open.Load() || driver.openHandles() != 1 {
+		t.Errorf("CloseAll closed the unregistered handle, %d handles left", driver.openHandles())
+	}
+	if err := CloseAll(); err != nil {
+		t.Errorf("CloseAll() with no handle left = %v", err)
+	}
+}
+
+func TestCloseAllReportsFailures(t *testing.T) {
+	driver := newFakeDriver(t)
+	for i := 0; i < 2; i++ {
+		openFake(t, OpenOptions{})
+	}
+	driver.closeErr = syscall.Errno(87)
+
+	if err := CloseAll(); !errors.Is(err, ErrInvalidParameter) {
+		t.Fatalf("CloseAll() = %v, want %v", err, ErrInvalidParameter)
+	}
+	if n := driver.openHandles(); n != 0 {
+		t.Errorf("%d handles left open after a failure", n)
+	}
+}
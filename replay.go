@@ -0,0 +1,57 @@
+package godivert
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// Injects the packets of a capture through wd, keeping the delays between them
+// The recorded delays are divided by speed: 2 replays twice as fast, 0.5 twice as slow
+// and 0 injects the packets as fast as possible.
+// The packets are injected as outbound with their recorded checksums, the schedule is relative
+// to the start of the replay so a slow injection doesn't accumulate drift.
+// The records cut by the capture's snapshot length are skipped, their bytes aren't the whole packet.
+func ReplayTimed(r *PcapReader, wd *WinDivertHandle, speed float64) error {
+	if speed < 0 {
+		return errors.New("the replay speed can't be negative")
+	}
+
+	var first time.Time
+	var start time.Time
+	for {
+		data, timestamp, err := r.ReadPacket()
+		if err == io.EOF {
+			return nil
+		}
+		if errors.Is(err, ErrTruncated) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if start.IsZero() {
+			first, start = timestamp, time.Now()
+		} else if speed > 0 {
+			offset := time.Duration(float64(timestamp.Sub(first)) / speed)
+			if wait := time.Until(start.Add(offset)); wait > 0 {
+				time.Sleep(wait)
+			}
+		}
+
+		addr := WinDivertAddress{}
+		addr.setLayer(wd.layer)
+		addr.setBit(addrIPv6Bit, data[0]>>4 == 6)
+		addr.SetDirection(WinDivertDirectionOutbound)
+
+		packet := &Packet{
+			Raw:       data,
+			Addr:      &addr,
+			PacketLen: uint(len(data)),
+		}
+		if _, err := wd.Send(packet); err != nil {
+			return err
+		}
+	}
+}
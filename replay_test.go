@@ -0,0 +1,80 @@
+package godivert
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestReplayTimed(t *testing.T) {
+	// 记录的包间隔
+	offsets := []time.Duration{0, 40 * time.Millisecond, 120 * time.Millisecond}
+
+	tests := []struct {
+		name  string
+		speed float64
+		scale float64
+	}{
+		{"recorded speed", 1, 1},
+		{"twice as fast", 2, 0.5},
+		{"as fast as possible", 0, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			driver := newFakeDriver(t)
+			wd := openFake(t, OpenOptions{})
+
+			buf := pcapCapture(binary.LittleEndian, pcapMagicNano, 65535, LinkTypeRaw)
+			start := time.Unix(1700000000, 0)
+			for _, offset := range offsets {
+				ts := start.Add(offset)
+				pcapAppend(buf, binary.LittleEndian, uint32(ts.Unix()), uint32(ts.Nanosecond()), 20, ipv4Packet(20))
+			}
+			r, err := NewPcapReader(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := ReplayTimed(r, wd, test.speed); err != nil {
+				t.Fatalf("ReplayTimed() = %v", err)
+			}
+			sent := driver.injected()
+			if len(sent) != len(offsets) {
+				t.Fatalf("%d packets injected, want %d", len(sent), len(offsets))
+			}
+			for i, packet := range sent {
+				if !packet.addr.Outbound() {
+					t.Errorf("packet %d injected inbound", i)
+				}
+				want := time.Duration(float64(offsets[i]) * test.scale)
+				got := packet.at.Sub(sent[0].at)
+				// 不会早于计划时间，允许调度带来的延迟
+				if got < want-time.Millisecond || got > want+50*time.Millisecond {
+					t.Errorf("packet %d injected after %v, want about %v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestReplayTimedSkipsTruncatedRecords(t *testing.T) {
+	driver := newFakeDriver(t)
+	wd := openFake(t, OpenOptions{})
+
+	buf := pcapCapture(binary.LittleEndian, pcapMagicMicro, 40, LinkTypeRaw)
+	pcapAppend(buf, binary.LittleEndian, 1, 0, 100, ipv4Packet(100)[:40])
+	pcapAppend(buf, binary.LittleEndian, 1, 0, 20, ipv4Packet(20))
+	r, err := NewPcapReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ReplayTimed(r, wd, 0); err != nil {
+		t.Fatalf("ReplayTimed() = %v", err)
+	}
+	sent := driver.injected()
+	if len(sent) != 1 || len(sent[0].raw) != 20 {
+		t.Errorf("injected %d packets, want only the 20 bytes one", len(sent))
+	}
+}
@@ -0,0 +1,112 @@
+package godivert
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// A packet kept by RingCapture
+type ringEntry struct {
+	timestamp time.Time
+	data      []byte
+}
+
+// Keeps a copy of the most recent packets in memory and dumps them as pcap on demand
+// Meant to be fed every packet and dumped when a detection rule fires, to get the
+// packets leading up to the event without writing the whole capture to disk.
+// Packets are evicted once there are more than the capacity or once older than the max age.
+// It is safe for concurrent use.
+type RingCapture struct {
+	mu      sync.Mutex
+	entries []ringEntry
+	// 最旧的包的位置和包的数量
+	start  int
+	count  int
+	maxAge time.Duration
+}
+
+// Creates a RingCapture keeping at most capacity packets
+// If maxAge is positive the packets older than maxAge are evicted too
+func NewRingCapture(capacity int, maxAge time.Duration) (*RingCapture, error) {
+	if capacity <= 0 {
+		return nil, errors.New("the ring capacity must be positive")
+	}
+	return &RingCapture{
+		entries: make([]ringEntry, capacity),
+		maxAge:  maxAge,
+	}, nil
+}
+
+// Stores a copy of the packet, evicting the oldest one if the ring is full
+// The packet isn't kept, it can be sent or dropped right after the call
+func (r *RingCapture) Add(p *Packet) {
+	now := time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.evictOlder(now)
+
+	index := (r.start + r.count) % len(r.entries)
+	if r.count == len(r.entries) {
+		r.start = (r.start + 1) % len(r.entries)
+	} else {
+		r.count++
+	}
+
+	// 复用被淘汰的包的内存
+	entry := &r.entries[index]
+	entry.timestamp = now
+	entry.data = append(entry.data[:0], p.Raw...)
+}
+
+// Returns the number of packets in the ring
+func (r *RingCapture) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.evictOlder(time.Now())
+	return r.count
+}
+
+// Writes the packets in the ring, oldest first, as a pcap capture of raw IP packets
+// The ring isn't emptied, call Reset for that
+func (r *RingCapture) Dump(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.evictOlder(time.Now())
+
+	if err := writePcapHeader(w); err != nil {
+		return err
+	}
+	for i := 0; i < r.count; i++ {
+		entry := &r.entries[(r.start+i)%len(r.entries)]
+		if err := writePcapRecord(w, entry.timestamp, entry.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Removes every packet from the ring
+func (r *RingCapture) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.start = 0
+	r.count = 0
+}
+
+// Evicts the packets older than maxAge, the caller holds the lock
+func (r *RingCapture) evictOlder(now time.Time) {
+	if r.maxAge <= 0 {
+		return
+	}
+	for r.count > 0 && now.Sub(r.entries[r.start].timestamp) > r.maxAge {
+		r.start = (r.start + 1) % len(r.entries)
+		r.count--
+	}
+}
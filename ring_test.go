@@ -0,0 +1,101 @@
+package godivert
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+// Returns the lengths of the packets of a pcap capture, in order
+func dumpedLengths(t *testing.T, r *RingCapture) []int {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := r.Dump(&buf); err != nil {
+		t.Fatalf("Dump() = %v", err)
+	}
+	reader, err := NewPcapReader(&buf)
+	if err != nil {
+		t.Fatalf("NewPcapReader() = %v", err)
+	}
+	var lengths []int
+	for {
+		data, _, err := reader.ReadPacket()
+		if err == io.EOF {
+			return lengths
+		}
+		if err != nil {
+			t.Fatalf("ReadPacket() = %v", err)
+		}
+		lengths = append(lengths, len(data))
+	}
+}
+
+func TestRingCapture(t *testing.T) {
+	tests := []struct {
+		name  string
+		added int
+		want  []int
+	}{
+		{"empty", 0, nil},
+		{"below capacity", 2, []int{20, 21}},
+		{"full", 3, []int{20, 21, 22}},
+		{"past capacity", 5, []int{22, 23, 24}},
+		{"wrapped twice", 7, []int{24, 25, 26}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r, err := NewRingCapture(3, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < test.added; i++ {
+				packet := NewPacket(ipv4Packet(20+i), nil)
+				r.Add(packet)
+				// 环里保存的是副本
+				packet.Raw[0] = 0
+			}
+
+			if r.Len() != len(test.want) {
+				t.Errorf("Len() = %d, want %d", r.Len(), len(test.want))
+			}
+			got := dumpedLengths(t, r)
+			if len(got) != len(test.want) {
+				t.Fatalf("dumped packets of %v bytes, want %v", got, test.want)
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Fatalf("dumped packets of %v bytes, want %v", got, test.want)
+				}
+			}
+
+			r.Reset()
+			if r.Len() != 0 || len(dumpedLengths(t, r)) != 0 {
+				t.Error("packets left after Reset")
+			}
+		})
+	}
+}
+
+func TestRingCaptureMaxAge(t *testing.T) {
+	r, err := NewRingCapture(10, 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Add(NewPacket(ipv4Packet(20), nil))
+	r.Add(NewPacket(ipv4Packet(21), nil))
+	time.Sleep(50 * time.Millisecond)
+	r.Add(NewPacket(ipv4Packet(22), nil))
+
+	if got := dumpedLengths(t, r); len(got) != 1 || got[0] != 22 {
+		t.Errorf("dumped packets of %v bytes, want only the recent 22 bytes one", got)
+	}
+}
+
+func TestNewRingCaptureInvalid(t *testing.T) {
+	if _, err := NewRingCapture(0, time.Second); err == nil {
+		t.Error("NewRingCapture(0) = nil error")
+	}
+}
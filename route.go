@@ -0,0 +1,180 @@
+package godivert
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Lifetime of the routing table cached by RouteLookup, routes change rarely but they do (VPN, DHCP)
+var RouteCacheTTL = 30 * time.Second
+
+var (
+	iphlpapiDLL                = syscall.NewLazyDLL("iphlpapi.dll")
+	iphlpapiGetIpForwardTable2 = iphlpapiDLL.NewProc("GetIpForwardTable2")
+	iphlpapiFreeMibTable       = iphlpapiDLL.NewProc("FreeMibTable")
+
+	// Returns the MIB_IPFORWARD_TABLE2 of the system, replaced in the tests
+	getForwardTable = func() ([]route, error) {
+		var table unsafe.Pointer
+		ret, _, _ := iphlpapiGetIpForwardTable2.Call(afUnspec, uintptr(unsafe.Pointer(&table)))
+		if ret != 0 {
+			return nil, fmt.Errorf("cannot read the routing table: %w", syscall.Errno(ret))
+		}
+		defer iphlpapiFreeMibTable.Call(uintptr(table))
+
+		// 表由系统分配，先读条目数再确定大小
+		numEntries := *(*uint32)(table)
+		size := forwardTableRowsOff + int(numEntries)*forwardRowSize
+		return parseForwardTable(unsafe.Slice((*byte)(table), size))
+	}
+
+	routeCache routeTable
+)
+
+// AF_UNSPEC, AF_INET and AF_INET6 on Windows
+const (
+	afUnspec = 0
+	afInet   = 2
+	afInet6  = 23
+)
+
+// Layout of MIB_IPFORWARD_TABLE2 and MIB_IPFORWARD_ROW2, the same on 386 and amd64
+// See https://learn.microsoft.com/en-us/windows/win32/api/netioapi/ns-netioapi-mib_ipforward_row2
+const (
+	forwardTableRowsOff    = 8 // NumEntries then the rows, aligned on the NET_LUID
+	forwardRowSize         = 104
+	forwardRowIfIdxOff     = 8
+	forwardRowPrefixOff    = 12 // SOCKADDR_INET
+	forwardRowPrefixLenOff = 40
+	forwardRowMetricOff    = 84
+	sockaddrInetIPv4Off    = 4
+	sockaddrInetIPv6Off    = 8
+)
+
+// A route of the routing table
+type route struct {
+	prefix net.IPNet
+	ifIdx  uint32
+	metric uint32
+}
+
+// The routing table cached by RouteLookup, it is read again once older than RouteCacheTTL
+type routeTable struct {
+	mu     sync.Mutex
+	routes []route
+	loaded time.Time
+}
+
+// Returns the index of the interface the system routes dst through
+// Meant for forwarding handlers: set it as the IfIdx of the packets injected towards dst.
+// The route is the longest prefix of the routing table (GetIpForwardTable2) matching dst, the one
+// with the lowest route metric among equal prefixes; the metrics of the interfaces aren't considered.
+// The table is cached for RouteCacheTTL.
+func RouteLookup(dst net.IP) (ifIdx uint32, err error) {
+	if dst.To4() == nil && len(dst) != net.IPv6len {
+		return 0, fmt.Errorf("cannot look a route up, invalid IP %v", dst)
+	}
+
+	routes, err := routeCache.get(time.Now())
+	if err != nil {
+		return 0, err
+	}
+	best, ok := bestRoute(routes, dst)
+	if !ok {
+		return 0, fmt.Errorf("cannot find a route to %v", dst)
+	}
+	return best.ifIdx, nil
+}
+
+// Empties the RouteLookup cache, e.g. after a change of the routing table
+func FlushRouteCache() {
+	routeCache.mu.Lock()
+	defer routeCache.mu.Unlock()
+
+	routeCache.routes, routeCache.loaded = nil, time.Time{}
+}
+
+// Returns the cached routes, reading the table again if they are older than RouteCacheTTL
+func (t *routeTable) get(now time.Time) ([]route, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.routes != nil && now.Sub(t.loaded) < RouteCacheTTL {
+		return t.routes, nil
+	}
+	routes, err := getForwardTable()
+	if err != nil {
+		return nil, err
+	}
+	// 路由表为空也要缓存
+	if routes == nil {
+		routes = []route{}
+	}
+	t.routes, t.loaded = routes, now
+	return routes, nil
+}
+
+// Returns the longest prefix matching dst, the lowest metric first among equal prefixes
+func bestRoute(routes []route, dst net.IP) (route, bool) {
+	var best route
+	bestLen := -1
+	ipv4 := dst.To4() != nil
+	for _, r := range routes {
+		// Contains 会把 ::ffff:0:0/96 当作 IPv4 路由
+		if (len(r.prefix.IP) == net.IPv4len) != ipv4 || !r.prefix.Contains(dst) {
+			continue
+		}
+		ones, _ := r.prefix.Mask.Size()
+		if ones > bestLen || ones == bestLen && r.metric < best.metric {
+			best, bestLen = r, ones
+		}
+	}
+	return best, bestLen >= 0
+}
+
+// Parses a MIB_IPFORWARD_TABLE2, the rows of an unknown address family are skipped
+func parseForwardTable(table []byte) ([]route, error) {
+	if len(table) < forwardTableRowsOff {
+		return nil, errors.New("cannot parse the routing table, it is truncated")
+	}
+	numEntries := int(binary.LittleEndian.Uint32(table[0:4]))
+	if len(table) < forwardTableRowsOff+numEntries*forwardRowSize {
+		return nil, fmt.Errorf("cannot parse the routing table, %d bytes for %d routes", len(table), numEntries)
+	}
+
+	routes := make([]route, 0, numEntries)
+	for i := 0; i < numEntries; i++ {
+		row := table[forwardTableRowsOff+i*forwardRowSize:][:forwardRowSize]
+		prefix := row[forwardRowPrefixOff:]
+
+		var ip net.IP
+		switch binary.LittleEndian.Uint16(prefix[0:2]) {
+		case afInet:
+			ip = prefix[sockaddrInetIPv4Off : sockaddrInetIPv4Off+net.IPv4len]
+		case afInet6:
+			ip = prefix[sockaddrInetIPv6Off : sockaddrInetIPv6Off+net.IPv6len]
+		default:
+			continue
+		}
+		bits := len(ip) * 8
+		ones := int(row[forwardRowPrefixLenOff])
+		if ones > bits {
+			return nil, fmt.Errorf("cannot parse the routing table, prefix length %d in route %d", ones, i)
+		}
+
+		mask := net.CIDRMask(ones, bits)
+		routes = append(routes, route{
+			// Mask 返回副本，不引用系统的表
+			prefix: net.IPNet{IP: ip.Mask(mask), Mask: mask},
+			ifIdx:  binary.LittleEndian.Uint32(row[forwardRowIfIdxOff:]),
+			metric: binary.LittleEndian.Uint32(row[forwardRowMetricOff:]),
+		})
+	}
+	return routes, nil
+}
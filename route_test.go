@@ -0,0 +1,219 @@
+package godivert
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// A row of the mock routing table
+type mockRoute struct {
+	family uint16
+	prefix string
+	ones   int
+	ifIdx  uint32
+	metric uint32
+}
+
+// Builds a MIB_IPFORWARD_TABLE2 holding routes, laid out like GetIpForwardTable2 does
+func mockForwardTable(routes ...mockRoute) []byte {
+	table := make([]byte, forwardTableRowsOff+len(routes)*forwardRowSize)
+	binary.LittleEndian.PutUint32(table[0:4], uint32(len(routes)))
+	for i, r := range routes {
+		row := table[forwardTableRowsOff+i*forwardRowSize:]
+		binary.LittleEndian.PutUint64(row[0:8], uint64(r.ifIdx)<<24) // NET_LUID
+		binary.LittleEndian.PutUint32(row[forwardRowIfIdxOff:], r.ifIdx)
+		prefix := row[forwardRowPrefixOff:]
+		binary.LittleEndian.PutUint16(prefix[0:2], r.family)
+		if ip := net.ParseIP(r.prefix); r.family == afInet {
+			copy(prefix[sockaddrInetIPv4Off:], ip.To4())
+		} else if ip != nil {
+			copy(prefix[sockaddrInetIPv6Off:], ip)
+		}
+		row[forwardRowPrefixLenOff] = byte(r.ones)
+		binary.LittleEndian.PutUint32(row[forwardRowMetricOff:], r.metric)
+	}
+	return table
+}
+
+// Routing table of a host with an Ethernet interface (6), a VPN (12) and the loopback (1)
+var mockRoutes = []mockRoute{
+	{afInet, "0.0.0.0", 0, 6, 25},
+	{afInet, "0.0.0.0", 0, 12, 5},
+	{afInet, "192.168.1.0", 24, 6, 256},
+	{afInet, "10.8.0.0", 16, 12, 256},
+	{afInet, "127.0.0.0", 8, 1, 256},
+	{afInet6, "::", 0, 6, 256},
+	{afInet6, "fe80::", 64, 6, 256},
+	{afInet6, "::1", 128, 1, 256},
+	{afInet6, "::ffff:0:0", 96, 9, 0},
+}
+
+// Replaces the routing table of the system until the test ends, returns the number of reads
+func mockRoutingTable(t *testing.T, table []byte) *int {
+	saved := getForwardTable
+	FlushRouteCache()
+	t.Cleanup(func() {
+		getForwardTable = saved
+		FlushRouteCache()
+	})
+
+	var reads int
+	getForwardTable = func() ([]route, error) {
+		reads++
+		return parseForwardTable(table)
+	}
+	return &reads
+}
+
+func TestParseForwardTable(t *testing.T) {
+	tests := []struct {
+		name    string
+		table   []byte
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:  "IPv4 and IPv6",
+			table: mockForwardTable(mockRoutes[2], mockRoutes[6]),
+			want:  []string{"192.168.1.0/24", "fe80::/64"},
+		},
+		{
+			name:  "host bits cleared",
+			table: mockForwardTable(mockRoute{afInet, "10.8.3.4", 16, 12, 0}),
+			want:  []string{"10.8.0.0/16"},
+		},
+		{
+			name:  "unknown family skipped",
+			table: mockForwardTable(mockRoute{family: 0xff}, mockRoutes[0]),
+			want:  []string{"0.0.0.0/0"},
+		},
+		{name: "empty", table: mockForwardTable(), want: []string{}},
+		{name: "no header", table: make([]byte, 4), wantErr: true},
+		{name: "truncated rows", table: mockForwardTable(mockRoutes[:2]...)[:forwardTableRowsOff+forwardRowSize], wantErr: true},
+		{name: "prefix too long", table: mockForwardTable(mockRoute{afInet, "10.0.0.0", 33, 1, 0}), wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			routes, err := parseForwardTable(test.table)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("parseForwardTable() = %v, want error %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if len(routes) != len(test.want) {
+				t.Fatalf("parsed %d routes, want %d", len(routes), len(test.want))
+			}
+			for i, r := range routes {
+				if r.prefix.String() != test.want[i] {
+					t.Errorf("route %d = %v, want %v", i, &r.prefix, test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRouteLookup(t *testing.T) {
+	mockRoutingTable(t, mockForwardTable(mockRoutes...))
+
+	tests := []struct {
+		dst     string
+		want    uint32
+		wantErr bool
+	}{
+		{"192.168.1.20", 6, false},
+		{"10.8.0.1", 12, false},
+		// 两条默认路由，取跃点数低的
+		{"8.8.8.8", 12, false},
+		{"127.0.0.1", 1, false},
+		{"2001:db8::1", 6, false},
+		{"::1", 1, false},
+		{"fe80::1", 6, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.dst, func(t *testing.T) {
+			ifIdx, err := RouteLookup(net.ParseIP(test.dst))
+			if ifIdx != test.want || (err != nil) != test.wantErr {
+				t.Errorf("RouteLookup() = %d, %v, want %d, error %v", ifIdx, err, test.want, test.wantErr)
+			}
+		})
+	}
+
+	if _, err := RouteLookup(net.IP{1, 2, 3}); err == nil {
+		t.Error("RouteLookup() of an invalid IP = nil, want an error")
+	}
+}
+
+func TestRouteLookupNoRoute(t *testing.T) {
+	mockRoutingTable(t, mockForwardTable(mockRoutes[2]))
+
+	if _, err := RouteLookup(net.ParseIP("8.8.8.8")); err == nil {
+		t.Error("RouteLookup() without a matching route = nil, want an error")
+	}
+	if _, err := RouteLookup(net.ParseIP("2001:db8::1")); err == nil {
+		t.Error("RouteLookup() without an IPv6 route = nil, want an error")
+	}
+}
+
+func TestRouteCacheRefresh(t *testing.T) {
+	reads := mockRoutingTable(t, mockForwardTable(mockRoutes...))
+	start := time.Now()
+
+	// 不同目的地址共用同一张表
+	for _, dst := range []string{"8.8.8.8", "1.1.1.1", "192.168.1.1", "::1"} {
+		if _, err := routeCache.get(start); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := RouteLookup(net.ParseIP(dst)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if *reads != 1 {
+		t.Fatalf("routing table read %d times, want once", *reads)
+	}
+
+	if _, err := routeCache.get(start.Add(RouteCacheTTL)); err != nil {
+		t.Fatal(err)
+	}
+	if *reads != 2 {
+		t.Errorf("routing table read %d times after RouteCacheTTL, want twice", *reads)
+	}
+
+	FlushRouteCache()
+	RouteLookup(net.ParseIP("8.8.8.8"))
+	if *reads != 3 {
+		t.Errorf("routing table read %d times after FlushRouteCache, want 3 times", *reads)
+	}
+}
+
+func TestRouteCacheReadError(t *testing.T) {
+	mockRoutingTable(t, nil)
+	errTable := errors.New("no table")
+	getForwardTable = func() ([]route, error) { return nil, errTable }
+
+	if _, err := RouteLookup(net.ParseIP("8.8.8.8")); !errors.Is(err, errTable) {
+		t.Errorf("RouteLookup() = %v, want %v", err, errTable)
+	}
+}
+
+// Reads the routing table of the system, needs Windows
+func TestRouteLookupSystem(t *testing.T) {
+	if err := iphlpapiGetIpForwardTable2.Find(); err != nil {
+		t.Skip("iphlpapi.dll not available:", err)
+	}
+	FlushRouteCache()
+	defer FlushRouteCache()
+
+	ifIdx, err := RouteLookup(net.ParseIP("127.0.0.1"))
+	if err != nil {
+		t.Fatalf("RouteLookup() = %v", err)
+	}
+	if ifIdx == 0 {
+		t.Error("RouteLookup() = 0, want the index of the loopback interface")
+	}
+}
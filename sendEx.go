@@ -0,0 +1,89 @@
+package godivert
+
+import "fmt"
+
+// Injects the packets in one call to WinDivertSendEx
+// The packets must have an address and all share the same layer. Up to WinDivertBatchMax packets are
+// injected per call. The modified packets get their checksums recalculated like with Packet.Send.
+// Once the call is made every packet's buffer is returned exactly once, whether the batch succeeded
+// or not, so the packets must not be used afterwards. If the arguments are rejected or a checksum
+// can't be calculated before the call, the packets are left untouched. On a NoSend handle they are
+// released and ErrSniffSend returned.
+// Returns the number of bytes injected, if the kernel only accepts a prefix of the batch
+// the count is returned with ErrPartialSend.
+// https://reqrypt.org/windivert-doc.html#divert_send_ex
+func (wd *WinDivertHandle) SendEx(packets []*Packet) (uint, error) {
+	if err := wd.canSend(); err != nil {
+		// 嗅探句柄上的包不会再被发送，直接释放
+		if err == ErrSniffSend {
+			for _, packet := range packets {
+				packet.Release()
+			}
+		}
+		return 0, err
+	}
+	if len(packets) == 0 {
+		return 0, nil
+	}
+	if len(packets) > WinDivertBatchMax {
+		return 0, fmt.Errorf("can't Send a batch of %d packets, maximum is %d", len(packets), WinDivertBatchMax)
+	}
+
+	var totalLen int
+	for i, packet := range packets {
+		if packet.Addr == nil {
+			return 0, fmt.Errorf("can't Send, packet %d has no address", i)
+		}
+		if packet.Addr.Layer() != packets[0].Addr.Layer() {
+			return 0, fmt.Errorf("can't Send, packet %d is on the %v layer and packet 0 on the %v layer",
+				i, packet.Addr.Layer(), packets[0].Addr.Layer())
+		}
+		totalLen += int(packet.PacketLen)
+	}
+
+	// 先取得所有包的所有权，失败时归还已取得的，包保持原样
+	for i, packet := range packets {
+		if !packet.claim() {
+			unclaim(packets[:i])
+			return 0, fmt.Errorf("packet %d: %w", i, ErrPacketReleased)
+		}
+	}
+
+	// 校验和错误的批次不注入
+	if err := HelperCalcChecksumBatch(packets); err != nil {
+		unclaim(packets)
+		return 0, fmt.Errorf("can't Send the batch: %w", err)
+	}
+
+	// 拼接所有包和地址
+	buffer := make([]byte, 0, totalLen)
+	addrs := make([]WinDivertAddress, len(packets))
+	for i, packet := range packets {
+		wd.observeLatency(packet)
+		buffer = append(buffer, packet.Raw[:packet.PacketLen]...)
+		addrs[i] = *packet.Addr
+	}
+
+	var sendLen uint32
+	err := divertSendEx(wd.handle, buffer, &sendLen, addrs)
+
+	// 无论成功与否，每个包的缓冲区都只放回一次
+	for _, packet := range packets {
+		wd.returnClaimed(packet)
+	}
+
+	if err != nil {
+		return 0, winDivertErr("send", err)
+	}
+	if int(sendLen) < totalLen {
+		return uint(sendLen), fmt.Errorf("%w: %d of %d bytes injected", ErrPartialSend, sendLen, totalLen)
+	}
+	return uint(sendLen), nil
+}
+
+// Gives back the ownership taken by claim, the packets can be sent or released again
+func unclaim(packets []*Packet) {
+	for _, packet := range packets {
+		packet.released.Store(false)
+	}
+}
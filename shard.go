@@ -0,0 +1,83 @@
+package godivert
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Opens shards handles that together divert the packets matching filter, each packet being diverted by exactly one of them
+// Every handle can then run its own recvLoop so the kernel splits the load between them.
+//
+// The filter language has no hash or modulo so the packets are sharded by ranges of their local port:
+// the source port of outbound packets and the destination port of inbound packets.
+// Loopback packets are always outbound, so both ends of a loopback flow are local: they are sharded
+// by the lowest of their two ports instead.
+// Both directions of a TCP/UDP flow thus end up on the same shard, loopback flows included.
+// Packets without ports (ICMP, ...) all go to the first shard.
+// All the shards are closed if one of them cannot be opened.
+func OpenSharded(filter string, shards int) ([]*WinDivertHandle, error) {
+	filters, err := shardFilters(filter, shards)
+	if err != nil {
+		return nil, err
+	}
+
+	handles := make([]*WinDivertHandle, 0, shards)
+	for _, shardFilter := range filters {
+		wd, err := NewWinDivertHandle(shardFilter)
+		if err != nil {
+			for _, opened := range handles {
+				opened.Close()
+			}
+			return nil, err
+		}
+		handles = append(handles, wd)
+	}
+	return handles, nil
+}
+
+// Returns the filters of each shard, see OpenSharded
+func shardFilters(filter string, shards int) ([]string, error) {
+	if shards < 1 || shards > 1<<16 {
+		return nil, fmt.Errorf("cannot shard in %d, the number of shards must be between 1 and 65536", shards)
+	}
+	if shards == 1 {
+		return []string{filter}, nil
+	}
+
+	filters := make([]string, shards)
+	for i := 0; i < shards; i++ {
+		low := i * (1 << 16) / shards
+		high := (i+1)*(1<<16)/shards - 1
+
+		expr := fmt.Sprintf("(not loopback and ((outbound and (%s)) or (inbound and (%s)))) or (loopback and (%s))",
+			portRangeExpr("SrcPort", low, high), portRangeExpr("DstPort", low, high), minPortRangeExpr(low, high))
+		if i == 0 {
+			expr += " or (not tcp and not udp)"
+		}
+		filters[i] = fmt.Sprintf("(%s) and (%s)", filter, expr)
+	}
+	return filters, nil
+}
+
+// Returns a test matching the TCP and UDP packets whose port field is in [low, high]
+func portRangeExpr(field string, low, high int) string {
+	var tests []string
+	for _, protocol := range []string{"tcp", "udp"} {
+		tests = append(tests, fmt.Sprintf("(%s.%s >= %d and %s.%s <= %d)", protocol, field, low, protocol, field, high))
+	}
+	return strings.Join(tests, " or ")
+}
+
+// Returns a test matching the TCP and UDP packets whose lowest port is in [low, high]
+// The filter language only compares fields to constants: min(a, b) is in [low, high] if and only if
+// one of the ports is in [low, high] and the other one is at least low.
+func minPortRangeExpr(low, high int) string {
+	var tests []string
+	for _, protocol := range []string{"tcp", "udp"} {
+		for _, fields := range [][2]string{{"SrcPort", "DstPort"}, {"DstPort", "SrcPort"}} {
+			tests = append(tests, fmt.Sprintf("(%s.%s >= %d and %s.%s <= %d and %s.%s >= %d)",
+				protocol, fields[0], low, protocol, fields[0], high, protocol, fields[1], low))
+		}
+	}
+	return strings.Join(tests, " or ")
+}
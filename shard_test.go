@@ -0,0 +1,125 @@
+package godivert
+
+import (
+	"examples/header"
+	"strconv"
+	"testing"
+)
+
+// The fields of a packet the shard filters test
+type shardPacket struct {
+	protocol           string // tcp, udp or icmp
+	outbound, loopback bool
+	srcPort, dstPort   int
+}
+
+// Returns the packet with the fields the shard filters test
+func (p shardPacket) packet() *Packet {
+	var raw []byte
+	switch p.protocol {
+	case "tcp":
+		raw = buildIPv4(header.TCP, tcpBytes(uint16(p.srcPort), uint16(p.dstPort), 0x10, nil))
+	case "udp":
+		raw = buildIPv4(header.UDP, udpBytes(uint16(p.srcPort), uint16(p.dstPort), nil))
+	default:
+		raw = buildIPv4(header.ICMPv4, icmpBytes(header.ICMPEchoRequest, nil))
+	}
+	addr := &WinDivertAddress{}
+	addr.SetOutbound(p.outbound)
+	addr.setBit(addrLoopbackBit, p.loopback)
+
+	packet := NewPacket(raw, addr)
+	packet.ParseHeaders()
+	return packet
+}
+
+// Returns the indexes of the shards whose filter matches the packet
+func matchingShards(t *testing.T, filters []string, packet shardPacket) []int {
+	t.Helper()
+
+	var shards []int
+	parsed := packet.packet()
+	for i, filter := range filters {
+		match, _, err := evalFilterExpr(filter, parsed)
+		if err != nil {
+			t.Fatalf("shard %d: %v in %s", i, err, filter)
+		}
+		if match {
+			shards = append(shards, i)
+		}
+	}
+	return shards
+}
+
+// Returns the packet the other end of the flow sends back
+func (p shardPacket) reply() shardPacket {
+	reply := p
+	reply.srcPort, reply.dstPort = p.dstPort, p.srcPort
+	// 环回包两个方向都是出站
+	if !p.loopback {
+		reply.outbound = !p.outbound
+	}
+	return reply
+}
+
+func TestShardFilters(t *testing.T) {
+	ports := []int{0, 1, 80, 443, 1023, 16383, 16384, 32767, 32768, 49151, 49152, 50000, 65534, 65535}
+
+	for _, shards := range []int{1, 2, 3, 4, 7} {
+		t.Run(strconv.Itoa(shards), func(t *testing.T) {
+			filters, err := shardFilters("true", shards)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(filters) != shards {
+				t.Fatalf("%d filters, want %d", len(filters), shards)
+			}
+
+			for _, protocol := range []string{"tcp", "udp", "icmp"} {
+				for _, loopback := range []bool{false, true} {
+					for _, outbound := range []bool{false, true} {
+						if loopback && !outbound {
+							continue
+						}
+						for _, src := range ports {
+							for _, dst := range ports {
+								packet := shardPacket{protocol, outbound, loopback, src, dst}
+								// 各分片互不重叠且覆盖原过滤器
+								got := matchingShards(t, filters, packet)
+								if len(got) != 1 {
+									t.Fatalf("%+v matches the shards %v, want exactly one", packet, got)
+								}
+								if protocol == "icmp" && got[0] != 0 {
+									t.Fatalf("%+v on shard %d, want the first shard", packet, got[0])
+								}
+								if reply := matchingShards(t, filters, packet.reply()); reply[0] != got[0] {
+									t.Fatalf("%+v on shard %d but its reply on shard %d", packet, got[0], reply[0])
+								}
+							}
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestShardFiltersKeepFilter(t *testing.T) {
+	filters, err := shardFilters("tcp", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, packet := range []shardPacket{{"udp", true, false, 1, 2}, {"udp", true, true, 50000, 53}} {
+		if got := matchingShards(t, filters, packet); len(got) != 0 {
+			t.Errorf("%+v outside the filter matches the shards %v", packet, got)
+		}
+	}
+}
+
+func TestShardFiltersInvalid(t *testing.T) {
+	for _, shards := range []int{0, -1, 1<<16 + 1} {
+		if _, err := shardFilters("true", shards); err == nil {
+			t.Errorf("shardFilters(%d) = nil error, want an error", shards)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package godivert
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Layout of the shared ring
+// The header holds the geometry of the ring then the write and read counters, each on its own
+// cache line. Every slot holds the packet length, the WINDIVERT_ADDRESS and the packet bytes.
+const (
+	sharedRingMagic     = 0x57445652 // "WDVR"
+	sharedRingHeaderLen = 192
+	sharedRingWriteOff  = 64
+	sharedRingReadOff   = 128
+	sharedRingAddrOff   = 8
+	sharedRingDataOff   = sharedRingAddrOff + winDivertAddressSize
+	sharedRingSlotSize  = (sharedRingDataOff + PacketBufferSize + 7) &^ 7
+	fileMapAllAccess    = 0xf001f
+)
+
+var (
+	kernel32CreateFileMapping = kernel32DLL.NewProc("CreateFileMappingW")
+	kernel32OpenFileMapping   = kernel32DLL.NewProc("OpenFileMappingW")
+	kernel32VirtualQuery      = kernel32DLL.NewProc("VirtualQuery")
+)
+
+// MEMORY_BASIC_INFORMATION, PartitionId only exists on x64 where it sits in the padding before
+// RegionSize, leaving it out gives the offsets of the C struct on both x86 and x64
+type memoryBasicInformation struct {
+	baseAddress       uintptr
+	allocationBase    uintptr
+	allocationProtect uint32
+	regionSize        uintptr
+	state             uint32
+	protect           uint32
+	typ               uint32
+}
+
+// ERROR_ALREADY_EXISTS, CreateFileMappingW returned the handle of an existing section
+const errAlreadyExists = syscall.Errno(183)
+
+// Returned by RecvShared when the consumer hasn't released any slot
+var ErrSharedRingFull = errors.New("shared ring full")
+
+// A ring of packet slots in a named shared memory section (CreateFileMapping)
+// RecvShared makes WinDivert write the packets straight into the section and a cooperating
+// process maps it with OpenSharedRing and reads them without any IPC copy.
+// There must be a single producer (the process calling RecvShared) and a single consumer
+// (the one calling Read and Release): the counters are published with atomic operations
+// and nothing else synchronizes the two sides.
+type SharedRing struct {
+	mapping syscall.Handle
+	view    uintptr
+	mem     []byte
+	slots   uint64
+}
+
+// Creates the shared section named name holding slots packets, for the producer side
+// Fails with an error wrapping ERROR_ALREADY_EXISTS if the section already exists: resetting
+// it would corrupt the ring of the other producer, use OpenSharedRing to attach to it.
+func CreateSharedRing(name string, slots int) (*SharedRing, error) {
+	if slots <= 0 {
+		return nil, errors.New("the shared ring needs at least one slot")
+	}
+	size := uint64(sharedRingHeaderLen) + uint64(slots)*sharedRingSlotSize
+
+	namePtr, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+	// syscall.CreateFileMapping 不返回 ERROR_ALREADY_EXISTS，直接调用以读取错误码
+	handle, _, err := kernel32CreateFileMapping.Call(
+		uintptr(syscall.InvalidHandle),
+		0,
+		syscall.PAGE_READWRITE,
+		uintptr(uint32(size>>32)),
+		uintptr(uint32(size)),
+		uintptr(unsafe.Pointer(namePtr)))
+	if handle == 0 {
+		return nil, fmt.Errorf("cannot create the shared section %q: %w", name, err)
+	}
+	if err == errAlreadyExists {
+		syscall.CloseHandle(syscall.Handle(handle))
+		return nil, fmt.Errorf("cannot create the shared section %q: %w", name, err)
+	}
+
+	ring, err := mapSharedRing(syscall.Handle(handle), uintptr(size))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ring.init(slots); err != nil {
+		ring.Close()
+		return nil, err
+	}
+	return ring, nil
+}
+
+// Maps the shared section created by CreateSharedRing, for the consumer side
+func OpenSharedRing(name string) (*SharedRing, error) {
+	namePtr, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+	handle, _, err := kernel32OpenFileMapping.Call(fileMapAllAccess, 0, uintptr(unsafe.Pointer(namePtr)))
+	if handle == 0 {
+		return nil, fmt.Errorf("cannot open the shared section %q: %w", name, err)
+	}
+
+	// 映射整个区段，attach 检查头部的槽位都在视图之内
+	ring, err := mapSharedRing(syscall.Handle(handle), 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := ring.attach(); err != nil {
+		ring.Close()
+		return nil, err
+	}
+	return ring, nil
+}
+
+// Maps size bytes of the section, the whole section if size is 0
+func mapSharedRing(mapping syscall.Handle, size uintptr) (*SharedRing, error) {
+	view, err := syscall.MapViewOfFile(mapping, fileMapAllAccess, 0, 0, size)
+	if err != nil {
+		syscall.CloseHandle(mapping)
+		return nil, fmt.Errorf("cannot map the shared section: %w", err)
+	}
+
+	if size == 0 {
+		// 区段由另一个进程创建，视图的实际大小由 VirtualQuery 给出
+		size, err = sharedViewSize(view)
+		if err != nil {
+			syscall.UnmapViewOfFile(view)
+			syscall.CloseHandle(mapping)
+			return nil, err
+		}
+	}
+
+	ring := &SharedRing{mapping: mapping, view: view}
+	ring.mem = unsafe.Slice((*byte)(*(*unsafe.Pointer)(unsafe.Pointer(&view))), size)
+	return ring, nil
+}
+
+// Returns the size of the view mapped at view, rounded up to the page size
+func sharedViewSize(view uintptr) (uintptr, error) {
+	var info memoryBasicInformation
+	n, _, err := kernel32VirtualQuery.Call(view, uintptr(unsafe.Pointer(&info)), unsafe.Sizeof(info))
+	if n == 0 {
+		return 0, fmt.Errorf("cannot query the size of the shared section: %w", err)
+	}
+	return info.regionSize, nil
+}
+
+// Writes the header of a new ring
+func (r *SharedRing) init(slots int) error {
+	if len(r.mem) < sharedRingHeaderLen+slots*sharedRingSlotSize {
+		return errors.New("the shared section is too small for the ring")
+	}
+	binary.LittleEndian.PutUint32(r.mem[4:8], uint32(slots))
+	binary.LittleEndian.PutUint32(r.mem[8:12], sharedRingSlotSize)
+	atomic.StoreUint64(r.counter(sharedRingWriteOff), 0)
+	atomic.StoreUint64(r.counter(sharedRingReadOff), 0)
+	// 最后写入魔数，消费者据此判断头部已初始化
+	atomic.StoreUint32((*uint32)(unsafe.Pointer(&r.mem[0])), sharedRingMagic)
+	r.slots = uint64(slots)
+	return nil
+}
+
+// Reads the header of an existing ring, mem must span the whole mapped view
+// The header is written by another process: its geometry is checked against the view.
+func (r *SharedRing) attach() error {
+	if len(r.mem) < sharedRingHeaderLen {
+		return errors.New("the shared section is too small for the ring")
+	}
+	if atomic.LoadUint32((*uint32)(unsafe.Pointer(&r.mem[0]))) != sharedRingMagic {
+		return errors.New("the shared section doesn't hold a ring")
+	}
+	if binary.LittleEndian.Uint32(r.mem[8:12]) != sharedRingSlotSize {
+		return errors.New("the shared ring has a different slot size")
+	}
+	slots := uint64(binary.LittleEndian.Uint32(r.mem[4:8]))
+	if slots == 0 {
+		return errors.New("the shared ring has no slot")
+	}
+	size := sharedRingHeaderLen + slots*sharedRingSlotSize
+	if size > uint64(len(r.mem)) {
+		return fmt.Errorf("the %d slots of the shared ring don't fit in the %d bytes of the section", slots, len(r.mem))
+	}
+	r.mem = r.mem[:size]
+	r.slots = slots
+	return nil
+}
+
+func (r *SharedRing) counter(offset int) *uint64 {
+	return (*uint64)(unsafe.Pointer(&r.mem[offset]))
+}
+
+func (r *SharedRing) slot(index uint64) []byte {
+	start := sharedRingHeaderLen + int(index%r.slots)*sharedRingSlotSize
+	return r.mem[start : start+sharedRingSlotSize]
+}
+
+// Returns the next free slot, false if the ring is full
+func (r *SharedRing) reserve() ([]byte, bool) {
+	write := atomic.LoadUint64(r.counter(sharedRingWriteOff))
+	if write-atomic.LoadUint64(r.counter(sharedRingReadOff)) == r.slots {
+		return nil, false
+	}
+	return r.slot(write), true
+}
+
+// Makes the reserved slot visible to the consumer
+func (r *SharedRing) publish() {
+	atomic.AddUint64(r.counter(sharedRingWriteOff), 1)
+}
+
+// Returns the oldest unread packet and its address, false if the ring is empty
+// data points into the shared section: it stays valid until Release is called.
+// The length written by the other process isn't trusted, it is clamped to the slot.
+func (r *SharedRing) Read() (data []byte, addr *WinDivertAddress, ok bool) {
+	read := atomic.LoadUint64(r.counter(sharedRingReadOff))
+	if read == atomic.LoadUint64(r.counter(sharedRingWriteOff)) {
+		return nil, nil, false
+	}
+
+	slot := r.slot(read)
+	length := min(binary.LittleEndian.Uint32(slot[0:4]), sharedRingSlotSize-sharedRingDataOff)
+	addr = (*WinDivertAddress)(unsafe.Pointer(&slot[sharedRingAddrOff]))
+	return slot[sharedRingDataOff : sharedRingDataOff+int(length)], addr, true
+}
+
+// Gives the slot returned by the last Read back to the producer
+func (r *SharedRing) Release() {
+	atomic.AddUint64(r.counter(sharedRingReadOff), 1)
+}
+
+// Returns the number of packets waiting to be read
+func (r *SharedRing) Len() int {
+	return int(atomic.LoadUint64(r.counter(sharedRingWriteOff)) - atomic.LoadUint64(r.counter(sharedRingReadOff)))
+}
+
+// Unmaps the section, it is destroyed once both sides closed it
+func (r *SharedRing) Close() error {
+	r.mem = nil
+	err := syscall.UnmapViewOfFile(r.view)
+	if closeErr := syscall.CloseHandle(r.mapping); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Receives a packet straight into the next free slot of the shared ring
+// No buffer of the pool is used and the packet isn't returned: the consumer reads it from the ring.
+// Returns ErrSharedRingFull without receiving anything if the consumer is behind.
+func (wd *WinDivertHandle) RecvShared(ring *SharedRing) error {
+	if !wd.open.Load() {
+		return errors.New("can't receive, the handle isn't open")
+	}
+
+	slot, ok := ring.reserve()
+	if !ok {
+		return ErrSharedRingFull
+	}
+
+	var packetLen uint
+	addr := (*WinDivertAddress)(unsafe.Pointer(&slot[sharedRingAddrOff]))
+	if err := divertRecv(wd.handle, slot[sharedRingDataOff:sharedRingDataOff+PacketBufferSize], &packetLen, addr); err != nil {
+		if err == errNoData {
+			return ErrShutdown
+		}
+		return winDivertErr("recv", err)
+	}
+
+	wd.lastRecv.Store(time.Now().UnixNano())
+	binary.LittleEndian.PutUint32(slot[0:4], uint32(packetLen))
+	ring.publish()
+	return nil
+}
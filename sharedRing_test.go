@@ -0,0 +1,144 @@
+package godivert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// Ring backed by process memory, the shared section isn't needed to test the layout
+func memSharedRing(t *testing.T, slots int) *SharedRing {
+	ring := &SharedRing{mem: make([]byte, sharedRingHeaderLen+slots*sharedRingSlotSize)}
+	if err := ring.init(slots); err != nil {
+		t.Fatal(err)
+	}
+	return ring
+}
+
+func TestSharedRingOrder(t *testing.T) {
+	ring := memSharedRing(t, 2)
+
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 2; i++ {
+			slot, ok := ring.reserve()
+			if !ok {
+				t.Fatalf("round %d: reserve() of slot %d failed", round, i)
+			}
+			binary.LittleEndian.PutUint32(slot[0:4], 3)
+			copy(slot[sharedRingDataOff:], []byte{byte(round), byte(i), 0xff})
+			ring.publish()
+		}
+		if _, ok := ring.reserve(); ok {
+			t.Fatalf("round %d: reserve() of a full ring succeeded", round)
+		}
+		if n := ring.Len(); n != 2 {
+			t.Fatalf("round %d: Len() = %d, want 2", round, n)
+		}
+
+		for i := 0; i < 2; i++ {
+			data, _, ok := ring.Read()
+			if want := []byte{byte(round), byte(i), 0xff}; !ok || !bytes.Equal(data, want) {
+				t.Fatalf("round %d: Read() = %v, %v, want %v", round, data, ok, want)
+			}
+			ring.Release()
+		}
+		if _, _, ok := ring.Read(); ok {
+			t.Fatalf("round %d: Read() of an empty ring succeeded", round)
+		}
+	}
+}
+
+func TestSharedRingReadClampsLength(t *testing.T) {
+	ring := memSharedRing(t, 1)
+	slot, _ := ring.reserve()
+	// 长度由另一个进程写入，不能越过槽位
+	binary.LittleEndian.PutUint32(slot[0:4], 0xffffffff)
+	ring.publish()
+
+	data, _, ok := ring.Read()
+	if !ok || len(data) != sharedRingSlotSize-sharedRingDataOff {
+		t.Errorf("Read() returned %d bytes, want the %d bytes of the slot", len(data), sharedRingSlotSize-sharedRingDataOff)
+	}
+}
+
+func TestSharedRingAttach(t *testing.T) {
+	setSlots := func(slots uint32) func(mem []byte) {
+		return func(mem []byte) { binary.LittleEndian.PutUint32(mem[4:8], slots) }
+	}
+	tests := []struct {
+		name    string
+		corrupt func(mem []byte)
+		// Size of the mapped view, the ring of 3 slots if 0
+		viewSize int
+		wantErr  bool
+	}{
+		{"valid", func(mem []byte) {}, 0, false},
+		// 视图按页大小向上取整
+		{"view larger than the ring", func(mem []byte) {}, sharedRingHeaderLen + 3*sharedRingSlotSize + 4096, false},
+		{"no magic", func(mem []byte) { mem[0] = 0 }, 0, true},
+		{"slot size", func(mem []byte) { binary.LittleEndian.PutUint32(mem[8:12], 64) }, 0, true},
+		{"no slot", setSlots(0), 0, true},
+		{"more slots than the view", setSlots(4), 0, true},
+		{"largest slot count", setSlots(0xffffffff), 0, true},
+		{"view smaller than the header", func(mem []byte) {}, sharedRingHeaderLen - 1, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			producer := memSharedRing(t, 3)
+			test.corrupt(producer.mem)
+
+			view := producer.mem
+			if test.viewSize > 0 {
+				view = append(producer.mem, make([]byte, max(test.viewSize-len(producer.mem), 0))...)[:test.viewSize]
+			}
+			consumer := &SharedRing{mem: view}
+			err := consumer.attach()
+			if (err != nil) != test.wantErr {
+				t.Fatalf("attach() = %v, want error %v", err, test.wantErr)
+			}
+			if err == nil && (consumer.slots != 3 || len(consumer.mem) != len(producer.mem)) {
+				t.Errorf("attached %d slots over %d bytes, want 3 over %d", consumer.slots, len(consumer.mem), len(producer.mem))
+			}
+		})
+	}
+}
+
+func TestRecvShared(t *testing.T) {
+	driver := newFakeDriver(t)
+	wd := openFake(t, OpenOptions{})
+	ring := memSharedRing(t, 1)
+	first, second := ipv4Packet(20), ipv4Packet(28)
+	var addr WinDivertAddress
+	addr.SetIfIdx(7)
+	driver.divert(first, addr)
+	driver.divert(second, WinDivertAddress{})
+
+	if err := wd.RecvShared(ring); err != nil {
+		t.Fatalf("RecvShared() = %v", err)
+	}
+	if err := wd.RecvShared(ring); err != ErrSharedRingFull {
+		t.Fatalf("RecvShared() of a full ring = %v, want %v", err, ErrSharedRingFull)
+	}
+	data, got, ok := ring.Read()
+	if !ok || !bytes.Equal(data, first) || got.IfIdx() != 7 {
+		t.Fatalf("Read() = %v, %v, want the first packet on interface 7", data, ok)
+	}
+	ring.Release()
+
+	if err := wd.RecvShared(ring); err != nil {
+		t.Fatalf("RecvShared() = %v", err)
+	}
+	if data, _, _ := ring.Read(); !bytes.Equal(data, second) {
+		t.Errorf("Read() = %v, want the second packet", data)
+	}
+	ring.Release()
+
+	if err := wd.RecvShared(ring); !errors.Is(err, ErrShutdown) {
+		t.Errorf("RecvShared() of a drained handle = %v, want %v", err, ErrShutdown)
+	}
+	if ring.Len() != 0 {
+		t.Errorf("Len() = %d after a failed receive, want 0", ring.Len())
+	}
+}
@@ -0,0 +1,49 @@
+package godivert
+
+import (
+	"errors"
+	"fmt"
+)
+
+// WINDIVERT_SHUTDOWN values, what Shutdown stops
+type ShutdownMode uint32
+
+const (
+	WinDivertShutdownRecv ShutdownMode = 0x1
+	WinDivertShutdownSend ShutdownMode = 0x2
+	WinDivertShutdownBoth ShutdownMode = 0x3
+)
+
+// Returned by Recv once receiving has been shut down and the packets already queued have been read
+var ErrShutdown = errors.New("the handle has been shut down")
+
+func (m ShutdownMode) String() string {
+	switch m {
+	case WinDivertShutdownRecv:
+		return "Recv"
+	case WinDivertShutdownSend:
+		return "Send"
+	case WinDivertShutdownBoth:
+		return "Both"
+	default:
+		return fmt.Sprintf("ShutdownMode(%d)", uint32(m))
+	}
+}
+
+// Stops receiving and/or sending packets without closing the handle
+// After a receive shutdown no new packet is queued, Recv returns the packets already queued
+// then ErrShutdown and the Packets loop closes its channel: the capture can be drained before Close.
+// https://reqrypt.org/windivert-doc.html#divert_shutdown
+func (wd *WinDivertHandle) Shutdown(how ShutdownMode) error {
+	if !wd.open.Load() {
+		return errors.New("can't shut down, the handle isn't open")
+	}
+	if how < WinDivertShutdownRecv || how > WinDivertShutdownBoth {
+		return fmt.Errorf("invalid shutdown mode %d", uint32(how))
+	}
+
+	if err := divertShutdown(wd.handle, how); err != nil {
+		return winDivertErr("shutdown", err)
+	}
+	return nil
+}
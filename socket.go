@@ -0,0 +1,132 @@
+package godivert
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// WINDIVERT_DATA_SOCKET, the union of the SOCKET layer
+// IPv4 addresses are IPv4-mapped IPv6 addresses, the remote endpoint is zero for BIND and LISTEN
+type SocketData struct {
+	Endpoint       uint64
+	ParentEndpoint uint64
+	ProcessID      uint32
+	LocalIP        net.IP
+	RemoteIP       net.IP
+	LocalPort      uint16
+	RemotePort     uint16
+	Protocol       uint8
+}
+
+// Returns the union decoded as socket data
+// Returns an error if the address isn't on the SOCKET layer
+func (w *WinDivertAddress) Socket() (SocketData, error) {
+	if w.Layer() != WinDivertLayerSocket {
+		return SocketData{}, fmt.Errorf("the %v layer carries no socket data", w.Layer())
+	}
+	return SocketData{
+		Endpoint:       binary.LittleEndian.Uint64(w.Union[0:8]),
+		ParentEndpoint: binary.LittleEndian.Uint64(w.Union[8:16]),
+		ProcessID:      binary.LittleEndian.Uint32(w.Union[16:20]),
+		LocalIP:        winDivertIPv6(w.Union[20:36]),
+		RemoteIP:       winDivertIPv6(w.Union[36:52]),
+		LocalPort:      binary.LittleEndian.Uint16(w.Union[52:54]),
+		RemotePort:     binary.LittleEndian.Uint16(w.Union[54:56]),
+		Protocol:       w.Union[56],
+	}, nil
+}
+
+// Converts an address stored by WinDivert as UINT32[4] into a net.IP
+// The words are in host byte order and the most significant one comes last
+func winDivertIPv6(words []byte) net.IP {
+	ip := make(net.IP, net.IPv6len)
+	for i := 0; i < 4; i++ {
+		word := binary.LittleEndian.Uint32(words[(3-i)*4:])
+		binary.BigEndian.PutUint32(ip[i*4:], word)
+	}
+	return ip
+}
+
+// A socket operation (bind, connect, listen, accept or close) seen on the SOCKET layer
+type SocketEvent struct {
+	Event Event
+	SocketData
+	// QueryPerformanceCounter value of the operation
+	Timestamp int64
+	// The operation has been blocked: the handle doesn't sniff (see NewSocketHandle)
+	// CLOSE events can't be blocked
+	Blocked bool
+}
+
+// Returns the time of the operation
+func (e *SocketEvent) Time() time.Time {
+	return qpcTime(e.Timestamp)
+}
+
+// Returns the key of the flow from the local to the remote endpoint
+func (e *SocketEvent) FlowKey() FlowKey {
+	return NewFlowKey(e.LocalIP, e.LocalPort, e.RemoteIP, e.RemotePort, e.Protocol)
+}
+
+func (e *SocketEvent) String() string {
+	s := fmt.Sprintf("%v pid=%d %v", e.Event, e.ProcessID, e.FlowKey())
+	if e.Blocked {
+		s += " blocked"
+	}
+	return s
+}
+
+// Create a new WinDivertHandle on the SOCKET layer and returns it
+// WinDivert can't reinject socket events: whether the operations go on is decided by the handle.
+// With block set the operations matching the filter (bind, connect, listen, accept) are blocked,
+// the calls fail in the process. Otherwise the handle sniffs and the operations are only reported.
+// https://reqrypt.org/windivert-doc.html#divert_layers
+func NewSocketHandle(filter string, block bool) (*WinDivertHandle, error) {
+	var flags uint8
+	if !block {
+		flags = WinDivertFlagSniff
+	}
+	return Open(OpenOptions{
+		Filter: filter,
+		Layer:  WinDivertLayerSocket,
+		Flags:  flags,
+	})
+}
+
+// Reads the next event of a SOCKET handle and decodes it
+// There is no packet on this layer and nothing to send: the event has already been allowed
+// or blocked depending on how the handle was opened, see NewSocketHandle and SocketEvent.Blocked.
+// https://reqrypt.org/windivert-doc.html#divert_recv
+func (wd *WinDivertHandle) RecvSocketEvent() (*SocketEvent, error) {
+	if !wd.open.Load() {
+		return nil, errors.New("can't receive, the handle isn't open")
+	}
+	if wd.layer != WinDivertLayerSocket {
+		return nil, fmt.Errorf("can't receive socket events on the %v layer", wd.layer)
+	}
+
+	var addr WinDivertAddress
+	if err := divertRecv(wd.handle, nil, nil, &addr); err != nil {
+		if err == errNoData {
+			return nil, ErrShutdown
+		}
+		return nil, winDivertErr("recv", err)
+	}
+	wd.lastRecv.Store(time.Now().UnixNano())
+
+	data, err := addr.Socket()
+	if err != nil {
+		return nil, err
+	}
+	event := addr.Event()
+	return &SocketEvent{
+		Event:      event,
+		SocketData: data,
+		Timestamp:  addr.Timestamp,
+		// 嗅探的事件没有被阻止，CLOSE 事件无法阻止
+		Blocked: !addr.Sniffed() && event != WinDivertEventSocketClose,
+	}, nil
+}
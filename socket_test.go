@@ -0,0 +1,190 @@
+package godivert
+
+import (
+	"encoding/binary"
+	"errors"
+	"examples/header"
+	"net"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Returns a SOCKET layer address as WinDivert fills it, the addresses are stored
+// as four host order UINT32 with the least significant word first
+func socketAddress(event Event, sniffed bool, data SocketData) WinDivertAddress {
+	var addr WinDivertAddress
+	addr.setLayer(WinDivertLayerSocket)
+	addr.setEvent(event)
+	addr.setBit(addrSniffedBit, sniffed)
+	binary.LittleEndian.PutUint64(addr.Union[0:8], data.Endpoint)
+	binary.LittleEndian.PutUint64(addr.Union[8:16], data.ParentEndpoint)
+	binary.LittleEndian.PutUint32(addr.Union[16:20], data.ProcessID)
+	for i, ip := range []net.IP{data.LocalIP.To16(), data.RemoteIP.To16()} {
+		if ip == nil {
+			continue
+		}
+		for word := 0; word < 4; word++ {
+			binary.LittleEndian.PutUint32(addr.Union[20+16*i+(3-word)*4:], binary.BigEndian.Uint32(ip[word*4:]))
+		}
+	}
+	binary.LittleEndian.PutUint16(addr.Union[52:54], data.LocalPort)
+	binary.LittleEndian.PutUint16(addr.Union[54:56], data.RemotePort)
+	addr.Union[56] = data.Protocol
+	return addr
+}
+
+func TestSocketData(t *testing.T) {
+	// 192.168.1.10:54321 连接 93.184.216.34:443，按 WinDivert 的内存布局逐字节写入
+	var connect WinDivertAddress
+	connect.setLayer(WinDivertLayerSocket)
+	connect.setEvent(WinDivertEventSocketConnect)
+	copy(connect.Union[:], []byte{
+		0x11, 0x22, 0x33, 0x44, 0x00, 0x00, 0x00, 0x00, // Endpoint
+		0x10, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // ParentEndpoint
+		0xd2, 0x04, 0x00, 0x00, // ProcessID 1234
+		0x0a, 0x01, 0xa8, 0xc0, 0xff, 0xff, 0x00, 0x00, 0, 0, 0, 0, 0, 0, 0, 0, // ::ffff:192.168.1.10
+		0x22, 0xd8, 0xb8, 0x5d, 0xff, 0xff, 0x00, 0x00, 0, 0, 0, 0, 0, 0, 0, 0, // ::ffff:93.184.216.34
+		0x31, 0xd4, 0xbb, 0x01, // ports
+		header.TCP,
+	})
+
+	tests := []struct {
+		name string
+		addr WinDivertAddress
+		want SocketData
+	}{
+		{"IPv4 connect", connect, SocketData{
+			Endpoint:       0x44332211,
+			ParentEndpoint: 0x10,
+			ProcessID:      1234,
+			LocalIP:        net.ParseIP("192.168.1.10"),
+			RemoteIP:       net.ParseIP("93.184.216.34"),
+			LocalPort:      54321,
+			RemotePort:     443,
+			Protocol:       header.TCP,
+		}},
+		{"IPv6 bind", socketAddress(WinDivertEventSocketBind, false, SocketData{
+			Endpoint:  7,
+			ProcessID: 4,
+			LocalIP:   net.ParseIP("fd00::1:2:3"),
+			LocalPort: 5353,
+			Protocol:  header.UDP,
+		}), SocketData{
+			Endpoint:  7,
+			ProcessID: 4,
+			LocalIP:   net.ParseIP("fd00::1:2:3"),
+			RemoteIP:  net.IPv6unspecified,
+			LocalPort: 5353,
+			Protocol:  header.UDP,
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := test.addr.Socket()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.LocalIP.Equal(test.want.LocalIP) || !got.RemoteIP.Equal(test.want.RemoteIP) {
+				t.Errorf("local %v, remote %v, want %v, %v", got.LocalIP, got.RemoteIP, test.want.LocalIP, test.want.RemoteIP)
+			}
+			got.LocalIP, got.RemoteIP = nil, nil
+			test.want.LocalIP, test.want.RemoteIP = nil, nil
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Socket() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+
+	if data, err := NewAddress().Socket(); err == nil {
+		t.Errorf("Socket() = %+v on the network layer, want an error", data)
+	}
+}
+
+func TestRecvSocketEvent(t *testing.T) {
+	const now = 50_000_000
+	mockPerfCounter(t, now)
+
+	data := SocketData{
+		ProcessID:  42,
+		LocalIP:    net.ParseIP("10.0.0.1"),
+		RemoteIP:   net.ParseIP("10.0.0.2"),
+		LocalPort:  50000,
+		RemotePort: 80,
+		Protocol:   header.TCP,
+	}
+	tests := []struct {
+		name        string
+		block       bool
+		event       Event
+		wantBlocked bool
+	}{
+		{"blocked connect", true, WinDivertEventSocketConnect, true},
+		{"blocked accept", true, WinDivertEventSocketAccept, true},
+		{"close can't be blocked", true, WinDivertEventSocketClose, false},
+		{"sniffed listen", false, WinDivertEventSocketListen, false},
+		{"sniffed bind", false, WinDivertEventSocketBind, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			driver := newFakeDriver(t)
+			wd, err := NewSocketHandle("true", test.block)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer wd.Close()
+
+			addr := socketAddress(test.event, !test.block, data)
+			addr.Timestamp = now - 20_000 // 2ms 前
+			driver.divert(nil, addr)
+
+			event, err := wd.RecvSocketEvent()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if event.Event != test.event || event.Blocked != test.wantBlocked {
+				t.Errorf("event %v, blocked %v, want %v, %v", event.Event, event.Blocked, test.event, test.wantBlocked)
+			}
+			if event.ProcessID != 42 || event.FlowKey() != NewFlowKey(data.LocalIP, 50000, data.RemoteIP, 80, header.TCP) {
+				t.Errorf("event %v, want pid 42 and the flow 10.0.0.1:50000 -> 10.0.0.2:80", event)
+			}
+			if age := time.Since(event.Time()); age < 2*time.Millisecond || age > time.Second {
+				t.Errorf("event %v old, want 2ms", age)
+			}
+
+			// 队列读空后和 Recv 一样返回 ErrShutdown
+			if _, err := wd.RecvSocketEvent(); !errors.Is(err, ErrShutdown) {
+				t.Errorf("RecvSocketEvent() = %v on an empty queue, want ErrShutdown", err)
+			}
+		})
+	}
+}
+
+func TestRecvSocketEventErrors(t *testing.T) {
+	driver := newFakeDriver(t)
+
+	network := openFake(t, OpenOptions{})
+	driver.divert(nil, socketAddress(WinDivertEventSocketConnect, true, SocketData{}))
+	if _, err := network.RecvSocketEvent(); err == nil {
+		t.Error("RecvSocketEvent() on a network handle = nil error")
+	}
+	if driver.queued() != 1 {
+		t.Error("RecvSocketEvent() on a network handle read from the driver")
+	}
+
+	socket := openFake(t, OpenOptions{Layer: WinDivertLayerSocket})
+	driver.recvErr = syscall.Errno(6)
+	driver.queue = nil
+	var winDivertErr *WinDivertError
+	if _, err := socket.RecvSocketEvent(); !errors.As(err, &winDivertErr) {
+		t.Errorf("RecvSocketEvent() = %v, want a *WinDivertError", err)
+	}
+
+	socket.Close()
+	if _, err := socket.RecvSocketEvent(); err == nil {
+		t.Error("RecvSocketEvent() on a closed handle = nil error")
+	}
+}
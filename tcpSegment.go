@@ -0,0 +1,181 @@
+package godivert
+
+import (
+	"encoding/binary"
+	"errors"
+	"examples/header"
+	"fmt"
+)
+
+// BuildTCPSegment builds a new TCP segment that fits into the stream ref belongs to.
+// It is meant for lab testing (TCP injection/hijacking tests against your own hosts).
+//
+// With reply false the segment goes in the same direction as ref: its sequence number follows
+// the last byte sent in ref (SYN and FIN count for one) and it acknowledges what ref acknowledged.
+// With reply true it goes towards ref's sender: the addresses and ports are swapped, the direction
+// reversed, the sequence number is what ref acknowledged and the acknowledgment covers what ref sent.
+// flags is a combination of the header.TCPFlag* values.
+//
+// The IP and TCP headers (options and IPv6 extension headers included) are copied from ref and
+// the lengths are updated, the checksums are recalculated by Send. The returned packet does not use the buffer pool.
+func BuildTCPSegment(ref *Packet, payload []byte, flags uint16, reply bool) (*Packet, error) {
+	ref.VerifyParsed()
+
+	refTCP, ok := ref.NextHeader.(*header.TCPHeader)
+	if !ok {
+		return nil, fmt.Errorf("cannot build a TCP segment from protocolID=%d, reference packet isn't TCP", ref.nextHeaderType)
+	}
+
+	hdrLen := ref.hdrLen + refTCP.HeaderLen()
+	if hdrLen > len(ref.Raw) {
+		return nil, errors.New("cannot build a TCP segment, reference packet is truncated")
+	}
+
+	rawLen := hdrLen + len(payload)
+	if rawLen > PacketBufferSize {
+		return nil, fmt.Errorf("cannot build a TCP segment of %d bytes, maximum is %d", rawLen, PacketBufferSize)
+	}
+
+	raw := make([]byte, rawLen)
+	copy(raw, ref.Raw[:hdrLen])
+	copy(raw[hdrLen:], payload)
+
+	// ref 发送的最后一个字节之后的序号
+	nextSeq := refTCP.SeqNum() + uint32(len(refTCP.Payload))
+	if refTCP.SYN() {
+		nextSeq++
+	}
+	if refTCP.FIN() {
+		nextSeq++
+	}
+	seqNum, ackNum := nextSeq, refTCP.AckNum()
+	if reply {
+		seqNum, ackNum = refTCP.AckNum(), nextSeq
+	}
+
+	packet := &Packet{
+		Raw:       raw,
+		PacketLen: uint(rawLen),
+	}
+	if ref.Addr != nil {
+		addr := *ref.Addr
+		packet.Addr = &addr
+	}
+	packet.ParseHeaders()
+
+	switch ipHdr := packet.IpHdr.(type) {
+	case *header.IPv4Header:
+		ipHdr.SetTotalLen(uint16(rawLen))
+	case *header.IPv6Header:
+		// 负载长度包含扩展头
+		ipHdr.SetPayloadLength(uint16(rawLen - header.IPv6HeaderLen))
+	}
+
+	tcpHdr := packet.NextHeader.(*header.TCPHeader)
+	if reply {
+		srcIP, dstIP := packet.SrcIP(), packet.DstIP()
+		packet.SetSrcIP(dstIP)
+		packet.SetDstIP(srcIP)
+		srcPort, _ := tcpHdr.SrcPort()
+		dstPort, _ := tcpHdr.DstPort()
+		tcpHdr.SetSrcPort(dstPort)
+		tcpHdr.SetDstPort(srcPort)
+		if packet.Addr != nil {
+			packet.ReverseDirection()
+		}
+	}
+	tcpHdr.SetSeqNum(seqNum)
+	tcpHdr.SetAckNum(ackNum)
+	tcpHdr.SetFlags(flags)
+	if flags&header.TCPFlagURG == 0 {
+		binary.BigEndian.PutUint16(tcpHdr.Raw[18:20], 0)
+	}
+
+	return packet, nil
+}
+
+// Returns a RST+ACK segment resetting the connection p belongs to, to inject towards p's sender
+// Addresses and ports are swapped and the direction reversed, the segment has no payload,
+// no IP or TCP options (IPv6 extension headers are left out too) and a zero window.
+//
+// Sequence numbers follow RFC 793 reset generation: the sequence number is the acknowledgment
+// number of p if p has ACK set (0 otherwise) and the acknowledgment number covers everything
+// p carried (payload, plus one for SYN and for FIN), so the reset is in window for the sender.
+// E.g. for a SYN with seq=x the reset has seq=0 and ack=x+1.
+//
+// The lengths are set and the headers marked as modified: the checksums are recalculated by Send.
+// The returned packet does not use the buffer pool.
+func (p *Packet) CraftRST() (*Packet, error) {
+	p.VerifyParsed()
+
+	refTCP, ok := p.NextHeader.(*header.TCPHeader)
+	if !ok {
+		return nil, fmt.Errorf("cannot craft a RST from protocolID=%d, packet isn't TCP", p.nextHeaderType)
+	}
+
+	var ipLen int
+	if p.ipVersion == header.IPv4 {
+		ipLen = header.IPv4HeaderLen
+	} else {
+		ipLen = header.IPv6HeaderLen
+	}
+	rawLen := ipLen + header.TCPHeaderLen
+	raw := make([]byte, rawLen)
+
+	// 交换地址，不保留 IP 选项和扩展头
+	if p.ipVersion == header.IPv4 {
+		copy(raw, p.Raw[:header.IPv4HeaderLen])
+		raw[0] = 0x45
+		copy(raw[12:16], p.Raw[16:20])
+		copy(raw[16:20], p.Raw[12:16])
+		binary.BigEndian.PutUint16(raw[2:4], uint16(rawLen))
+		// 不分片，清空标志和偏移
+		binary.BigEndian.PutUint16(raw[6:8], 0)
+		raw[8] = 64
+		raw[9] = header.TCP
+	} else {
+		copy(raw, p.Raw[:8])
+		copy(raw[8:24], p.Raw[24:40])
+		copy(raw[24:40], p.Raw[8:24])
+		binary.BigEndian.PutUint16(raw[4:6], header.TCPHeaderLen)
+		raw[6] = header.TCP
+		raw[7] = 64
+	}
+
+	// 交换端口
+	tcp := raw[ipLen:]
+	copy(tcp[0:2], refTCP.Raw[2:4])
+	copy(tcp[2:4], refTCP.Raw[0:2])
+	tcp[12] = header.TCPHeaderLen / 4 << 4
+
+	packet := &Packet{
+		Raw:       raw,
+		PacketLen: uint(rawLen),
+	}
+	if p.Addr != nil {
+		addr := *p.Addr
+		packet.Addr = &addr
+		packet.ReverseDirection()
+	}
+	packet.ParseHeaders()
+
+	var seqNum uint32
+	if refTCP.ACK() {
+		seqNum = refTCP.AckNum()
+	}
+	ackNum := refTCP.SeqNum() + uint32(len(refTCP.Payload))
+	if refTCP.SYN() {
+		ackNum++
+	}
+	if refTCP.FIN() {
+		ackNum++
+	}
+
+	tcpHdr := packet.NextHeader.(*header.TCPHeader)
+	tcpHdr.SetSeqNum(seqNum)
+	tcpHdr.SetAckNum(ackNum)
+	tcpHdr.SetFlags(header.TCPFlagRST | header.TCPFlagACK)
+	packet.markModified()
+
+	return packet, nil
+}
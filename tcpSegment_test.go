@@ -0,0 +1,199 @@
+package godivert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"examples/header"
+	"net"
+	"testing"
+)
+
+// Returns a TCP segment with seq 1000 and the given acknowledgment number
+func tcpWithAck(flags uint8, ack uint32, payload []byte) []byte {
+	tcp := tcpBytes(50000, 443, flags, payload)
+	binary.BigEndian.PutUint32(tcp[8:12], ack)
+	return tcp
+}
+
+func TestBuildTCPSegment(t *testing.T) {
+	payload := []byte("injected")
+
+	tests := []struct {
+		name    string
+		ref     []byte
+		flags   uint16
+		reply   bool
+		wantSeq uint32
+		wantAck uint32
+	}{
+		{"after data", buildIPv4(header.TCP, tcpWithAck(0x18, 5000, []byte("hello"))), header.TCPFlagPSH | header.TCPFlagACK, false, 1005, 5000},
+		{"after SYN", buildIPv4(header.TCP, tcpWithAck(0x02, 0, nil)), header.TCPFlagACK, false, 1001, 0},
+		{"after FIN with data", buildIPv4(header.TCP, tcpWithAck(0x11, 7, []byte("bye"))), header.TCPFlagRST, false, 1004, 7},
+		{"IPv6", buildIPv6(header.TCP, tcpWithAck(0x10, 42, nil)), header.TCPFlagPSH | header.TCPFlagACK, false, 1000, 42},
+		{"IPv6 hop-by-hop", buildIPv6(0, hopByHop(header.TCP, tcpWithAck(0x10, 42, []byte("hi")))), header.TCPFlagACK, false, 1002, 42},
+		{"reply to data", buildIPv4(header.TCP, tcpWithAck(0x18, 5000, []byte("hello"))), header.TCPFlagPSH | header.TCPFlagACK, true, 5000, 1005},
+		{"reply to SYN", buildIPv4(header.TCP, tcpWithAck(0x02, 0, nil)), header.TCPFlagSYN | header.TCPFlagACK, true, 0, 1001},
+		{"IPv6 reply with hop-by-hop", buildIPv6(0, hopByHop(header.TCP, tcpWithAck(0x11, 9, nil))), header.TCPFlagACK, true, 9, 1001},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ref := NewPacket(test.ref, NewAddress())
+			segment, err := BuildTCPSegment(ref, payload, test.flags, test.reply)
+			if err != nil {
+				t.Fatalf("BuildTCPSegment() = %v", err)
+			}
+
+			tcp := segment.NextHeader.(*header.TCPHeader)
+			if tcp.SeqNum() != test.wantSeq || tcp.AckNum() != test.wantAck {
+				t.Errorf("seq %d ack %d, want %d, %d", tcp.SeqNum(), tcp.AckNum(), test.wantSeq, test.wantAck)
+			}
+			if tcp.Flags() != test.flags {
+				t.Errorf("flags %s, want %#x", tcp.FlagsString(), test.flags)
+			}
+			if !bytes.Equal(tcp.Payload, payload) {
+				t.Errorf("payload %q, want %q", tcp.Payload, payload)
+			}
+			// 回复方向交换地址和端口
+			wantSrc, wantDst, wantSrcPort, wantDstPort := ref.SrcIP(), ref.DstIP(), uint16(50000), uint16(443)
+			if test.reply {
+				wantSrc, wantDst, wantSrcPort, wantDstPort = ref.DstIP(), ref.SrcIP(), 443, 50000
+			}
+			src, _ := tcp.SrcPort()
+			dst, _ := tcp.DstPort()
+			if !segment.SrcIP().Equal(wantSrc) || !segment.DstIP().Equal(wantDst) || src != wantSrcPort || dst != wantDstPort {
+				t.Errorf("%v:%d -> %v:%d, want %v:%d -> %v:%d", segment.SrcIP(), src, segment.DstIP(), dst, wantSrc, wantSrcPort, wantDst, wantDstPort)
+			}
+
+			// 长度字段覆盖新的负载
+			raw := segment.Raw
+			if segment.IpVersion() == header.IPv4 {
+				if got := int(binary.BigEndian.Uint16(raw[2:4])); got != len(raw) {
+					t.Errorf("total length %d, want %d", got, len(raw))
+				}
+			} else if got := int(binary.BigEndian.Uint16(raw[4:6])); got != len(raw)-header.IPv6HeaderLen {
+				t.Errorf("payload length %d, want %d", got, len(raw)-header.IPv6HeaderLen)
+			}
+			wantDirection := ref.Addr.Direction()
+			if test.reply {
+				wantDirection = !wantDirection
+			}
+			if segment.Addr == ref.Addr || segment.Addr.Direction() != wantDirection {
+				t.Errorf("direction %v, want a copy of the reference's address going %v", segment.Addr.Direction(), wantDirection)
+			}
+		})
+	}
+}
+
+func TestBuildTCPSegmentErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		ref     *Packet
+		payload []byte
+	}{
+		{"UDP reference", NewPacket(buildIPv4(header.UDP, udpBytes(1, 2, nil)), nil), nil},
+		{"too large", NewPacket(buildIPv4(header.TCP, tcpBytes(1, 2, 0x10, nil)), nil), make([]byte, PacketBufferSize)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := BuildTCPSegment(test.ref, test.payload, header.TCPFlagACK, false); err == nil {
+				t.Error("BuildTCPSegment() = nil error, want an error")
+			}
+		})
+	}
+}
+
+// A SYN captured from 192.168.1.10:54321 to 93.184.216.34:443 with the usual options
+// (MSS 1460, SACK permitted, timestamps, window scale 7)
+var capturedSYN = []byte{
+	0x45, 0x00, 0x00, 0x3c, 0x6c, 0x9b, 0x40, 0x00, 0x80, 0x06, 0x00, 0x00,
+	192, 168, 1, 10,
+	93, 184, 216, 34,
+	0xd4, 0x31, 0x01, 0xbb, // ports
+	0x5d, 0x3c, 0x8e, 0x21, // seq
+	0x00, 0x00, 0x00, 0x00, // ack
+	0xa0, 0x02, 0xfa, 0xf0, 0x00, 0x00, 0x00, 0x00, // data offset 10, SYN, window 64240
+	0x02, 0x04, 0x05, 0xb4, 0x04, 0x02, 0x08, 0x0a,
+	0x00, 0x9a, 0x3b, 0x11, 0x00, 0x00, 0x00, 0x00,
+	0x01, 0x03, 0x03, 0x07,
+}
+
+func TestCraftRST(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      []byte
+		wantSrc  string
+		wantDst  string
+		wantPort [2]uint16
+		wantSeq  uint32
+		wantAck  uint32
+	}{
+		{"captured SYN", capturedSYN, "93.184.216.34", "192.168.1.10", [2]uint16{443, 54321}, 0, 0x5d3c8e22},
+		{"SYN,ACK", buildIPv4(header.TCP, tcpWithAck(0x12, 77, nil)), "10.0.0.2", "10.0.0.1", [2]uint16{443, 50000}, 77, 1001},
+		{"data", buildIPv4(header.TCP, tcpWithAck(0x18, 5000, []byte("hello"))), "10.0.0.2", "10.0.0.1", [2]uint16{443, 50000}, 5000, 1005},
+		{"FIN with data", buildIPv4(header.TCP, tcpWithAck(0x11, 7, []byte("bye"))), "10.0.0.2", "10.0.0.1", [2]uint16{443, 50000}, 7, 1004},
+		{"IPv6 data", buildIPv6(header.TCP, tcpWithAck(0x18, 42, []byte("data"))), "fd00::2", "fd00::1", [2]uint16{443, 50000}, 42, 1004},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			addr := NewAddress()
+			addr.SetIfIdx(5)
+			received := NewPacket(append([]byte(nil), test.raw...), addr)
+
+			rst, err := received.CraftRST()
+			if err != nil {
+				t.Fatal(err)
+			}
+			ipLen := header.IPv4HeaderLen
+			if rst.ipVersion == header.IPv6 {
+				ipLen = header.IPv6HeaderLen
+			}
+			if len(rst.Raw) != ipLen+header.TCPHeaderLen || int(rst.PacketLen) != len(rst.Raw) {
+				t.Fatalf("RST of %d bytes, PacketLen %d, want %d", len(rst.Raw), rst.PacketLen, ipLen+header.TCPHeaderLen)
+			}
+			if !rst.SrcIP().Equal(net.ParseIP(test.wantSrc)) || !rst.DstIP().Equal(net.ParseIP(test.wantDst)) {
+				t.Errorf("RST from %v to %v, want %s to %s", rst.SrcIP(), rst.DstIP(), test.wantSrc, test.wantDst)
+			}
+			srcPort, _ := rst.SrcPort()
+			dstPort, _ := rst.DstPort()
+			if [2]uint16{srcPort, dstPort} != test.wantPort {
+				t.Errorf("RST ports %d -> %d, want %v", srcPort, dstPort, test.wantPort)
+			}
+
+			tcp := rst.NextHeader.(*header.TCPHeader)
+			if tcp.Flags() != header.TCPFlagRST|header.TCPFlagACK || tcp.DataOffset() != 5 || len(tcp.Payload) != 0 {
+				t.Errorf("flags %s, data offset %d, %d bytes of payload, want RST,ACK without options nor payload", tcp.FlagsString(), tcp.DataOffset(), len(tcp.Payload))
+			}
+			if tcp.SeqNum() != test.wantSeq || tcp.AckNum() != test.wantAck {
+				t.Errorf("seq %d, ack %#x, want %d, %#x", tcp.SeqNum(), tcp.AckNum(), test.wantSeq, test.wantAck)
+			}
+			if rst.Addr.Outbound() || rst.Addr.IfIdx() != 5 || !received.Addr.Outbound() {
+				t.Error("the RST isn't sent back to the sender or the received address changed")
+			}
+
+			// 校验和留给 Send 计算
+			if !rst.needNewChecksum() {
+				t.Fatal("the RST isn't marked for checksum recalculation")
+			}
+			if err := HelperCalcChecksumBatch([]*Packet{rst}); err != nil {
+				t.Fatal(err)
+			}
+			if ip, transport := checksumsValid(rst.Raw); !ip || !transport {
+				t.Errorf("IP checksum valid %v, TCP checksum valid %v after the recalculation", ip, transport)
+			}
+		})
+	}
+}
+
+func TestCraftRSTErrors(t *testing.T) {
+	for _, raw := range [][]byte{
+		buildIPv4(header.UDP, udpBytes(1234, 53, nil)),
+		buildIPv6(header.ICMPv6, icmpBytes(128, nil)),
+	} {
+		if rst, err := NewPacket(raw, nil).CraftRST(); err == nil {
+			t.Errorf("CraftRST() = %v, nil error on a packet that isn't TCP", rst)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package godivert
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Matched by errors.Is when Recv returns a packet that didn't fit in the buffer, see TruncatedError
+var ErrTruncated = errors.New("packet truncated")
+
+// Returned by Recv along with the truncated packet when WinDivert reports ERROR_INSUFFICIENT_BUFFER
+// The packet holds the first Captured bytes, Length is the real length read from its IP header
+// (0 if the header itself is cut). The packet still holds a pooled buffer: drop or Release it,
+// sending it would inject the truncated bytes. Open the handle with a larger OpenOptions.BufferSize to avoid it.
+type TruncatedError struct {
+	Length   int
+	Captured int
+}
+
+func (e *TruncatedError) Error() string {
+	if e.Length == 0 {
+		return fmt.Sprintf("%v: %d bytes captured", ErrTruncated, e.Captured)
+	}
+	return fmt.Sprintf("%v: %d of %d bytes captured", ErrTruncated, e.Captured, e.Length)
+}
+
+// errors.Is matches both ErrTruncated and ErrInsufficientBuffer
+func (e *TruncatedError) Is(target error) bool {
+	return target == ErrTruncated || target == error(ErrInsufficientBuffer)
+}
+
+// Returns the packet length given by the IP header of raw, 0 if raw is too short to tell
+func ipTotalLen(raw []byte) int {
+	if len(raw) == 0 {
+		return 0
+	}
+	switch raw[0] >> 4 {
+	case 4:
+		if len(raw) >= 4 {
+			return int(binary.BigEndian.Uint16(raw[2:4]))
+		}
+	case 6:
+		if len(raw) >= 6 {
+			return 40 + int(binary.BigEndian.Uint16(raw[4:6]))
+		}
+	}
+	return 0
+}
+
+// Returns the truncated packet received in packetBuffer and its TruncatedError
+func (wd *WinDivertHandle) truncatedPacket(packetBuffer []byte, packetLen uint, addr *WinDivertAddress) (*Packet, error) {
+	if packetLen == 0 || packetLen > uint(len(packetBuffer)) {
+		packetLen = uint(len(packetBuffer))
+	}
+	packet := wd.receivedPacket(packetBuffer, packetLen, addr)
+	return packet, &TruncatedError{
+		Length:   ipTotalLen(packet.Raw),
+		Captured: int(packetLen),
+	}
+}
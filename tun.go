@@ -0,0 +1,78 @@
+package godivert
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// Exposes a handle as a TUN-like device reading and writing raw IP packets
+// It is the byte-slice interface userspace TCP/IP stacks (e.g. gVisor netstack channel endpoints) expect:
+// the diverted packets are read by the stack instead of reaching their destination,
+// and the packets written by the stack are injected on the network stack.
+// See tun_netstack.go (build tag netstack) for the netstack LinkEndpoint bridge.
+type TunAdapter struct {
+	wd *WinDivertHandle
+
+	// Direction of the written packets, WinDivertDirectionInbound by default:
+	// the stack usually terminates connections of local applications and answers them
+	WriteDirection Direction
+
+	// Address of the last packet read, its interface indices are reused for the written packets
+	// ReadPacket and WritePacket run on different goroutines (see AttachNetstack)
+	lastAddr atomic.Pointer[WinDivertAddress]
+}
+
+// Creates a TunAdapter on the given handle
+func NewTunAdapter(wd *WinDivertHandle) *TunAdapter {
+	return &TunAdapter{
+		wd:             wd,
+		WriteDirection: WinDivertDirectionInbound,
+	}
+}
+
+// Returns the next diverted packet
+// The bytes are copied, the packet buffer goes back to the pool right away and the slice belongs to the caller
+func (t *TunAdapter) ReadPacket() ([]byte, error) {
+	packet, err := t.wd.Recv()
+	if err != nil {
+		if packet != nil {
+			t.wd.dropPacket(packet)
+		}
+		return nil, err
+	}
+
+	data := make([]byte, len(packet.Raw))
+	copy(data, packet.Raw)
+	addr := *packet.Addr
+	t.lastAddr.Store(&addr)
+	t.wd.dropPacket(packet)
+
+	return data, nil
+}
+
+// Injects a raw IP packet, its checksums must be valid (userspace stacks compute them)
+// The slice isn't kept after the call
+func (t *TunAdapter) WritePacket(data []byte) error {
+	if len(data) == 0 {
+		return errors.New("can't write an empty packet")
+	}
+
+	var addr WinDivertAddress
+	if last := t.lastAddr.Load(); last != nil {
+		addr = *last
+	}
+	addr.setLayer(t.wd.layer)
+	addr.setBit(addrLoopbackBit, false)
+	addr.setBit(addrImpostorBit, false)
+	addr.setBit(addrIPv6Bit, data[0]>>4 == 6)
+	addr.SetDirection(t.WriteDirection)
+
+	packet := &Packet{
+		Raw:       data,
+		Addr:      &addr,
+		PacketLen: uint(len(data)),
+	}
+
+	_, err := t.wd.Send(packet)
+	return err
+}
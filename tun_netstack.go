@@ -0,0 +1,85 @@
+//go:build netstack
+
+package godivert
+
+import (
+	"context"
+	"sync"
+
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// Bridges the adapter and a netstack channel endpoint until ctx is cancelled or reading or writing fails
+// Diverted packets are injected in the endpoint and the packets the endpoint outputs are written back.
+// Returns the first error of ReadPacket or WritePacket, or ctx.Err(). The goroutine writing the
+// endpoint's packets has stopped when it returns, but a pending ReadPacket is only released by the
+// next diverted packet: shut the handle down (or close it) to stop right away.
+// Requires building with the netstack tag.
+func (t *TunAdapter) AttachNetstack(ctx context.Context, ep *channel.Endpoint) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		writeErr error
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			pkt := ep.ReadContext(ctx)
+			if pkt == nil {
+				return
+			}
+			view := pkt.ToView()
+			err := t.WritePacket(view.AsSlice())
+			view.Release()
+			pkt.DecRef()
+			if err != nil {
+				// 写入失败时停止桥接，ReadPacket 返回后由下面的循环报告
+				writeErr = err
+				cancel()
+				return
+			}
+		}
+	}()
+
+	err := t.readLoop(ctx, ep)
+	cancel()
+	wg.Wait()
+	if writeErr != nil {
+		return writeErr
+	}
+	return err
+}
+
+// Injects the diverted packets in the endpoint until ctx is done or ReadPacket fails
+func (t *TunAdapter) readLoop(ctx context.Context, ep *channel.Endpoint) error {
+	for ctx.Err() == nil {
+		data, err := t.ReadPacket()
+		if err != nil {
+			return err
+		}
+
+		var protocol tcpip.NetworkProtocolNumber
+		switch header.IPVersion(data) {
+		case header.IPv4Version:
+			protocol = header.IPv4ProtocolNumber
+		case header.IPv6Version:
+			protocol = header.IPv6ProtocolNumber
+		default:
+			continue
+		}
+
+		pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+			Payload: buffer.MakeWithData(data),
+		})
+		ep.InjectInbound(protocol, pkt)
+		pkt.DecRef()
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,27 @@
+//go:build netstack
+
+package godivert
+
+import (
+	"context"
+	"log"
+
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+)
+
+// Terminates the diverted TCP connections to port 8080 in a userspace stack
+func ExampleTunAdapter_AttachNetstack() {
+	wd, err := NewWinDivertHandle("tcp.DstPort == 8080 or tcp.SrcPort == 8080")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer wd.Close()
+
+	// The endpoint is registered as a NIC of a stack.Stack serving the connections
+	ep := channel.New(512, 1500, "")
+
+	tun := NewTunAdapter(wd)
+	if err := tun.AttachNetstack(context.Background(), ep); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,100 @@
+package godivert
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTunAdapter(t *testing.T) {
+	ipv4 := make([]byte, 20)
+	ipv4[0] = 0x45
+	ipv6 := make([]byte, 40)
+	ipv6[0] = 0x60
+
+	tests := []struct {
+		name     string
+		reply    []byte
+		wantIPv6 bool
+	}{
+		{"IPv4", ipv4, false},
+		{"IPv6", ipv6, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			driver := newFakeDriver(t)
+			wd := openFake(t, OpenOptions{})
+			tun := NewTunAdapter(wd)
+
+			var diverted WinDivertAddress
+			diverted.SetOutbound(true)
+			diverted.SetIfIdx(7)
+			diverted.SetSubIfIdx(3)
+			driver.divert(ipv4, diverted)
+
+			data, err := tun.ReadPacket()
+			if err != nil {
+				t.Fatalf("ReadPacket() = %v", err)
+			}
+			if !bytes.Equal(data, ipv4) {
+				t.Fatalf("ReadPacket() = %x, want %x", data, ipv4)
+			}
+
+			if err := tun.WritePacket(test.reply); err != nil {
+				t.Fatalf("WritePacket() = %v", err)
+			}
+			sent := driver.injected()
+			if len(sent) != 1 {
+				t.Fatalf("%d packets injected, want 1", len(sent))
+			}
+			addr := sent[0].addr
+			if !bytes.Equal(sent[0].raw, test.reply) {
+				t.Errorf("injected %x, want %x", sent[0].raw, test.reply)
+			}
+			if addr.Outbound() || addr.IPv6() != test.wantIPv6 {
+				t.Errorf("injected with outbound %v, IPv6 %v, want inbound and IPv6 %v", addr.Outbound(), addr.IPv6(), test.wantIPv6)
+			}
+			if addr.IfIdx() != 7 || addr.SubIfIdx() != 3 {
+				t.Errorf("injected on interface %d.%d, want 7.3", addr.IfIdx(), addr.SubIfIdx())
+			}
+		})
+	}
+}
+
+func TestTunAdapterConcurrentReadWrite(t *testing.T) {
+	driver := newFakeDriver(t)
+	wd := openFake(t, OpenOptions{})
+	tun := NewTunAdapter(wd)
+
+	packet := make([]byte, 20)
+	packet[0] = 0x45
+	for i := 0; i < 100; i++ {
+		driver.divert(packet, WinDivertAddress{})
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			if err := tun.WritePacket(packet); err != nil {
+				t.Errorf("WritePacket() = %v", err)
+				return
+			}
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if _, err := tun.ReadPacket(); err != nil {
+			t.Fatalf("ReadPacket() = %v", err)
+		}
+	}
+	<-done
+}
+
+func TestTunAdapterWriteEmpty(t *testing.T) {
+	newFakeDriver(t)
+	tun := NewTunAdapter(openFake(t, OpenOptions{}))
+
+	if err := tun.WritePacket(nil); err == nil {
+		t.Error("WritePacket(nil) = nil, want an error")
+	}
+}
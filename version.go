@@ -0,0 +1,72 @@
+package godivert
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Returns the version of the WinDivert driver the handle is talking to
+// https://reqrypt.org/windivert-doc.html#divert_get_param
+func (wd *WinDivertHandle) Version() (major, minor uint64, err error) {
+	if major, err = wd.GetParam(WinDivertParamVersionMajor); err != nil {
+		return 0, 0, err
+	}
+	if minor, err = wd.GetParam(WinDivertParamVersionMinor); err != nil {
+		return 0, 0, err
+	}
+	return major, minor, nil
+}
+
+// Returns the version of the WinDivert driver
+// A handle matching no packet is opened to query it and closed right away, the driver is
+// installed and started by this call if it isn't running yet. Use wd.Version with an open handle.
+func Version() (major, minor uint64, err error) {
+	wd, err := Open(OpenOptions{
+		Filter:       "false",
+		Flags:        WinDivertFlagSniff | WinDivertFlagRecvOnly,
+		Unregistered: true,
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	defer wd.Close()
+	return wd.Version()
+}
+
+// Errors of WinDivertOpen caused by the driver rather than by the options
+var driverErrors = []error{
+	ErrFileNotFound,
+	ErrAccessDenied,
+	ErrInvalidImageHash,
+	ErrDriverFailedPriorUnload,
+	ErrServiceDoesNotExist,
+	ErrDriverBlocked,
+}
+
+// Returns the major version of the loaded DLL, 0 if it can't be told
+// Only the 2.x DLLs export WinDivertHelperCompileFilter and only the 1.x ones WinDivertHelperCheckFilter
+func dllMajorVersion() int {
+	if winDivertHelperCompileFilter.Find() == nil {
+		return 2
+	}
+	if winDivertDLL.NewProc("WinDivertHelperCheckFilter").Find() == nil {
+		return 1
+	}
+	return 0
+}
+
+// Adds the DLL version to a driver error of WinDivertOpen
+// The driver can't be queried when no handle opens, a DLL and driver of different versions
+// (e.g. a 1.x driver left running by another program) is the usual cause
+func openError(err error) error {
+	for _, driverErr := range driverErrors {
+		if !errors.Is(err, driverErr) {
+			continue
+		}
+		if major := dllMajorVersion(); major != 0 {
+			return fmt.Errorf("%w (%s is a WinDivert %d.x DLL, the driver must have the same major version)", err, winDivertDLL.Name, major)
+		}
+		return fmt.Errorf("%w (%s doesn't look like a WinDivert DLL)", err, winDivertDLL.Name)
+	}
+	return err
+}
@@ -0,0 +1,79 @@
+package godivert
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// Error returned by a WinDivert call, it carries the Windows error code set by the DLL
+// Use errors.Is with the Err* values below to test for a code whatever the operation,
+// errors.Is(err, syscall.Errno(n)) works too as the errno is unwrapped.
+// https://reqrypt.org/windivert-doc.html#divert_open
+type WinDivertError struct {
+	// Operation that failed, e.g. "open", "recv" or "send", empty for the Err* values
+	Op    string
+	Errno syscall.Errno
+}
+
+// Errors documented for WinDivertOpen, WinDivertRecv and WinDivertSend
+var (
+	ErrFileNotFound            = &WinDivertError{Errno: 2}
+	ErrAccessDenied            = &WinDivertError{Errno: 5}
+	ErrInvalidParameter        = &WinDivertError{Errno: 87}
+	ErrInsufficientBuffer      = &WinDivertError{Errno: 122}
+	ErrNoData                  = &WinDivertError{Errno: 232}
+	ErrInvalidImageHash        = &WinDivertError{Errno: 577}
+	ErrDriverFailedPriorUnload = &WinDivertError{Errno: 654}
+	ErrServiceDoesNotExist     = &WinDivertError{Errno: 1060}
+	ErrHostUnreachable         = &WinDivertError{Errno: 1232}
+	ErrDriverBlocked           = &WinDivertError{Errno: 1275}
+)
+
+// Symbolic names of the documented error codes
+var winDivertErrorNames = map[syscall.Errno]string{
+	2:    "ERROR_FILE_NOT_FOUND",
+	5:    "ERROR_ACCESS_DENIED",
+	87:   "ERROR_INVALID_PARAMETER",
+	122:  "ERROR_INSUFFICIENT_BUFFER",
+	232:  "ERROR_NO_DATA",
+	577:  "ERROR_INVALID_IMAGE_HASH",
+	654:  "ERROR_DRIVER_FAILED_PRIOR_UNLOAD",
+	1060: "ERROR_SERVICE_DOES_NOT_EXIST",
+	1232: "ERROR_HOST_UNREACHABLE",
+	1275: "ERROR_DRIVER_BLOCKED",
+}
+
+// Returns the symbolic name of the error code, e.g. ERROR_NO_DATA, or "errno <n>" for the undocumented ones
+func (e *WinDivertError) Name() string {
+	if name, ok := winDivertErrorNames[e.Errno]; ok {
+		return name
+	}
+	return fmt.Sprintf("errno %d", uint(e.Errno))
+}
+
+func (e *WinDivertError) Error() string {
+	if e.Op == "" {
+		return e.Name()
+	}
+	return fmt.Sprintf("%s: %s: %v", e.Op, e.Name(), e.Errno)
+}
+
+// Returns the underlying syscall.Errno
+func (e *WinDivertError) Unwrap() error {
+	return e.Errno
+}
+
+// Reports whether target is a WinDivertError with the same error code, the operation isn't compared
+func (e *WinDivertError) Is(target error) bool {
+	t, ok := target.(*WinDivertError)
+	return ok && t.Errno == e.Errno
+}
+
+// Wraps the error of a LazyProc.Call in a WinDivertError
+// Errors that aren't a non zero syscall.Errno are returned as they are
+func winDivertErr(op string, err error) error {
+	if errno, ok := err.(syscall.Errno); ok && errno != 0 {
+		return &WinDivertError{Op: op, Errno: errno}
+	}
+	return err
+}
@@ -1,10 +1,13 @@
 package godivert
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"runtime"
+	"sync/atomic"
 	"syscall"
+	"time"
 	"unsafe"
 )
 
@@ -14,25 +17,137 @@ var (
 	winDivertOpen                *syscall.LazyProc
 	winDivertClose               *syscall.LazyProc
 	winDivertRecv                *syscall.LazyProc
+	winDivertRecvEx              *syscall.LazyProc
+	winDivertHelperParsePacket   *syscall.LazyProc
 	winDivertSend                *syscall.LazyProc
+	winDivertSendEx              *syscall.LazyProc
+	winDivertShutdown            *syscall.LazyProc
+	winDivertSetParam            *syscall.LazyProc
+	winDivertGetParam            *syscall.LazyProc
 	winDivertHelperCalcChecksums *syscall.LazyProc
 	winDivertHelperEvalFilter    *syscall.LazyProc
-	winDivertHelperCheckFilter   *syscall.LazyProc
+	winDivertHelperCompileFilter *syscall.LazyProc
+	winDivertHelperFormatFilter  *syscall.LazyProc
+	winDivertHelperDecrementTTL  *syscall.LazyProc
+	winDivertHelperHashPacket    *syscall.LazyProc
+
+	winDivertHelperParseIPv4Address  *syscall.LazyProc
+	winDivertHelperParseIPv6Address  *syscall.LazyProc
+	winDivertHelperFormatIPv4Address *syscall.LazyProc
+	winDivertHelperFormatIPv6Address *syscall.LazyProc
 )
 
+// Returned by Send and SendEx for a packet already sent or released, its buffer may hold another packet now
+// Clone the packet to inject it several times
+var ErrPacketReleased = errors.New("can't Send, the packet has already been sent or released")
+
+// Returned by Send and SendEx on a handle opened with OpenOptions.NoSend (NewSniffHandle)
+// The packets are released, so the Packets()+Send pattern doesn't leak their buffers on such a handle
+var ErrSniffSend = errors.New("cannot send on a sniff handle")
+
+// Returned by Recv on a handle opened with WinDivertFlagDrop, the driver drops its packets
+var errDropRecv = errors.New("can't receive on a drop handle, its packets are dropped by the driver")
+
+// Returned by Send when WinDivert injected fewer bytes than the packet length
+// WinDivert injects whole packets so the remainder can't be sent on its own
+var ErrPartialSend = errors.New("packet partially sent")
+
+// Returns the arguments to pass a UINT64 parameter to the DLL
+// On x86 a 64 bits parameter takes two stack slots, low word first
+func uint64Args(value uint64) []uintptr {
+	if unsafe.Sizeof(uintptr(0)) == 4 {
+		return []uintptr{uintptr(uint32(value)), uintptr(value >> 32)}
+	}
+	return []uintptr{uintptr(value)}
+}
+
+// Returns the UINT64 returned by a DLL function
+// On x86 it is returned in EDX:EAX, r1 holds the low word and r2 the high one
+func uint64Result(r1, r2 uintptr) uint64 {
+	if unsafe.Sizeof(uintptr(0)) == 4 {
+		return uint64(uint32(r1)) | uint64(uint32(r2))<<32
+	}
+	return uint64(r1)
+}
+
+// ERROR_INSUFFICIENT_BUFFER, the captured packet doesn't fit in the buffer
+const errInsufficientBuffer = syscall.Errno(122)
+
+// ERROR_NO_DATA, returned by WinDivertRecv once the handle has been shut down and its queue is empty
+const errNoData = syscall.Errno(232)
+
 func init() {
-	LoadDLL("WinDivert.dll", "WinDivert.dll")
+	// 只绑定函数，DLL 在第一次调用时才加载
+	bindDLL("WinDivert.dll")
 }
 
 // Used to call WinDivert's functions
 type WinDivertHandle struct {
-	handle uintptr
-	open   bool
+	handle   uintptr
+	open     atomic.Bool
+	layer    Layer
+	priority int16
+	flags    uint8
+
+	// 打开时间与最后一次收包时间（UnixNano），用于健康检查
+	openTime      time.Time
+	lastRecv      atomic.Int64
+	expectTraffic atomic.Bool
+
+	// ForEach 使用的处理函数，可以在运行时替换
+	handler handlerStore
+
+	// ForEach 是否捕获处理函数的 panic，见 OpenOptions.RecoverPanics
+	recoverPanics bool
+
+	// 接收循环无法返回的错误交给它处理，见 OpenOptions.ErrorHandler
+	errorHandler func(error)
+
+	// 使 Packets 的循环停止的错误，见 Err
+	loopErr atomic.Pointer[error]
+
+	// 禁止发送（嗅探句柄），见 OpenOptions.NoSend
+	noSend bool
+
+	// Recv 使用的缓冲池，大小见 OpenOptions.BufferSize
+	buffers *sizedBufferPool
+
+	// RecvContext 复用的重叠读取状态和事件
+	overlapped overlappedStates
+
+	// 暂停状态，见 Pause/Resume
+	pause pauseState
+
+	// 可选的延迟统计，默认关闭
+	latency atomic.Pointer[latencyHistogram]
+
+	// 可选的丢包估计，默认关闭
+	drops atomic.Pointer[dropEstimator]
+
+	// 收到的包和已处理（发送或丢弃）的包的数量，见 QueueOccupancy
+	received  atomic.Uint64
+	processed atomic.Uint64
+}
+
+// Functions every handle needs, LoadDLL checks they can be found in the DLL
+// The helpers are only resolved when they are first called
+var requiredProcs = []**syscall.LazyProc{
+	&winDivertOpen,
+	&winDivertClose,
+	&winDivertRecv,
+	&winDivertRecvEx,
+	&winDivertSend,
+	&winDivertSendEx,
+	&winDivertShutdown,
+	&winDivertSetParam,
+	&winDivertGetParam,
 }
 
 // LoadDLL loads the WinDivert DLL depending the OS (x64 or x86) and the given DLL path.
 // The path can be a relative path (from the .exe folder) or absolute path.
-func LoadDLL(path64, path32 string) {
+// Returns an error if the DLL can't be loaded or lacks one of the functions handles need,
+// the functions are bound to the DLL anyway and the next calls fail the same way.
+func LoadDLL(path64, path32 string) error {
 	var dllPath string
 
 	if runtime.GOARCH == "amd64" {
@@ -41,15 +156,43 @@ func LoadDLL(path64, path32 string) {
 		dllPath = path32
 	}
 
+	bindDLL(dllPath)
+
+	if err := winDivertDLL.Load(); err != nil {
+		return fmt.Errorf("cannot load the WinDivert DLL: %w", err)
+	}
+	for _, proc := range requiredProcs {
+		if err := (*proc).Find(); err != nil {
+			return fmt.Errorf("cannot load the WinDivert DLL: %w", err)
+		}
+	}
+	return nil
+}
+
+// Binds the functions to the DLL at the given path without loading it
+func bindDLL(dllPath string) {
 	winDivertDLL = syscall.NewLazyDLL(dllPath)
 
 	winDivertOpen = winDivertDLL.NewProc("WinDivertOpen")
 	winDivertClose = winDivertDLL.NewProc("WinDivertClose")
 	winDivertRecv = winDivertDLL.NewProc("WinDivertRecv")
+	winDivertRecvEx = winDivertDLL.NewProc("WinDivertRecvEx")
+	winDivertHelperParsePacket = winDivertDLL.NewProc("WinDivertHelperParsePacket")
 	winDivertSend = winDivertDLL.NewProc("WinDivertSend")
+	winDivertSendEx = winDivertDLL.NewProc("WinDivertSendEx")
+	winDivertShutdown = winDivertDLL.NewProc("WinDivertShutdown")
+	winDivertSetParam = winDivertDLL.NewProc("WinDivertSetParam")
+	winDivertGetParam = winDivertDLL.NewProc("WinDivertGetParam")
 	winDivertHelperCalcChecksums = winDivertDLL.NewProc("WinDivertHelperCalcChecksums")
 	winDivertHelperEvalFilter = winDivertDLL.NewProc("WinDivertHelperEvalFilter")
-	winDivertHelperCheckFilter = winDivertDLL.NewProc("WinDivertHelperCheckFilter")
+	winDivertHelperCompileFilter = winDivertDLL.NewProc("WinDivertHelperCompileFilter")
+	winDivertHelperFormatFilter = winDivertDLL.NewProc("WinDivertHelperFormatFilter")
+	winDivertHelperDecrementTTL = winDivertDLL.NewProc("WinDivertHelperDecrementTTL")
+	winDivertHelperHashPacket = winDivertDLL.NewProc("WinDivertHelperHashPacket")
+	winDivertHelperParseIPv4Address = winDivertDLL.NewProc("WinDivertHelperParseIPv4Address")
+	winDivertHelperParseIPv6Address = winDivertDLL.NewProc("WinDivertHelperParseIPv6Address")
+	winDivertHelperFormatIPv4Address = winDivertDLL.NewProc("WinDivertHelperFormatIPv4Address")
+	winDivertHelperFormatIPv6Address = winDivertDLL.NewProc("WinDivertHelperFormatIPv6Address")
 }
 
 // Create a new WinDivertHandle by calling WinDivertOpen and returns it
@@ -62,73 +205,215 @@ func NewWinDivertHandle(filter string) (*WinDivertHandle, error) {
 // Create a new WinDivertHandle by calling WinDivertOpen and returns it
 // The string parameter is the fiter that packets have to match
 // and flags are the used flags used
+// With WinDivertFlagSniff the handle is opened with OpenOptions.NoSend like NewSniffHandle
 // https://reqrypt.org/windivert-doc.html#divert_open
 func NewWinDivertHandleWithFlags(filter string, flags uint8) (*WinDivertHandle, error) {
-	//使用 syscall.BytePtrFromString 将 filter 字符串转换为一个 C 风格的字符串（以 null 结尾的字节数组），并返回其指针。
-	filterBytePtr, err := syscall.BytePtrFromString(filter)
-	if err != nil {
-		return nil, err
-	}
-	//存储 WinDivert 设备句柄。
-	handle, _, err := winDivertOpen.Call(uintptr(unsafe.Pointer(filterBytePtr)),
-		uintptr(0),
-		uintptr(0),
-		uintptr(flags))
-	//检查 handle 是否等于 syscall.InvalidHandle，表示打开设备失败。
-	if handle == uintptr(syscall.InvalidHandle) {
-		return nil, err
-	}
-	//创建一个新的 WinDivertHandle 结构体实例，初始化其 handle 字段为刚刚获得的设备句柄，open 字段为 true。
-	winDivertHandle := &WinDivertHandle{
-		handle: handle,
-		open:   true,
-	}
-	return winDivertHandle, nil
+	return Open(OpenOptions{
+		Filter: filter,
+		Flags:  flags,
+		NoSend: flags&WinDivertFlagSniff != 0,
+	})
+}
+
+// Create a new WinDivertHandle in sniffing mode (WinDivertFlagSniff) and returns it
+// The packets are copied to the handle, the originals go on: Send and SendEx refuse to reinject them
+// (ErrSniffSend) and ForEach and Process release the packets instead of sending them.
+func NewSniffHandle(filter string) (*WinDivertHandle, error) {
+	return Open(OpenOptions{
+		Filter: filter,
+		Flags:  WinDivertFlagSniff,
+		NoSend: true,
+	})
+}
+
+// Create a new WinDivertHandle dropping the matching packets (WinDivertFlagDrop) and returns it
+// The driver drops the packets itself, Recv returns an error on the handle: keep it open as long as
+// the packets must be blocked and Close it to let them through again.
+func NewDropHandle(filter string) (*WinDivertHandle, error) {
+	return Open(OpenOptions{
+		Filter: filter,
+		Flags:  WinDivertFlagDrop,
+	})
+}
+
+// Create a new WinDivertHandle with the given priority by calling WinDivertOpen and returns it
+// Handles with a higher priority see the packets first, priority must be between
+// WinDivertPriorityLowest and WinDivertPriorityHighest (NewWinDivertHandle uses 0)
+// https://reqrypt.org/windivert-doc.html#divert_open
+func NewWinDivertHandleWithPriority(filter string, priority int16, flags uint8) (*WinDivertHandle, error) {
+	return Open(OpenOptions{
+		Filter:   filter,
+		Priority: priority,
+		Flags:    flags,
+		NoSend:   flags&WinDivertFlagSniff != 0,
+	})
+}
+
+// Create a new WinDivertHandle on the given layer by calling WinDivertOpen and returns it
+// priority orders the handles matching the same packets, from WinDivertPriorityLowest to WinDivertPriorityHighest
+// The FLOW, SOCKET and REFLECT layers carry no packet: use RecvAddress to read their events,
+// Send returns an error on them
+// https://reqrypt.org/windivert-doc.html#divert_open
+func NewWinDivertHandleWithLayer(filter string, layer Layer, priority int16, flags uint8) (*WinDivertHandle, error) {
+	return Open(OpenOptions{
+		Filter:   filter,
+		Layer:    layer,
+		Priority: priority,
+		Flags:    flags,
+		NoSend:   flags&WinDivertFlagSniff != 0,
+	})
 }
 
 // Close the Handle
+// Closing a handle that is already closed does nothing and returns nil
 // See https://reqrypt.org/windivert-doc.html#divert_close
 func (wd *WinDivertHandle) Close() error {
-	_, _, err := winDivertClose.Call(wd.handle)
-	wd.open = false
-	return err
+	if !wd.open.Swap(false) {
+		return nil
+	}
+	err := divertClose(wd.handle)
+	wd.overlapped.close()
+	unregister(wd)
+	// 唤醒暂停中的循环，让它们发现句柄已关闭
+	wd.Resume()
+	if err != nil {
+		return winDivertErr("close", err)
+	}
+	return nil
 }
 
 // Divert a packet from the Network Stack
+// If the packet doesn't fit in the handle's buffer both the truncated packet and a *TruncatedError
+// (errors.Is(err, ErrTruncated)) are returned, the packet must then be dropped or released.
 // https://reqrypt.org/windivert-doc.html#divert_recv
 // api要求要尽可能的快读取数据包，所以消费之前可以提前读取
 func (wd *WinDivertHandle) Recv() (*Packet, error) {
 	//如果 WinDivertHandle 对象的 open 属性为 false，则返回一个错误，表示句柄未打开，无法接收数据包。
-	if !wd.open {
+	if !wd.open.Load() {
 		return nil, errors.New("can't receive, the handle isn't open")
 	}
-	// 从缓冲池中获取一个字节数组 packetBuffer
-	packetBuffer := GetBuffer()
+	if !wd.layer.isNetwork() {
+		return nil, fmt.Errorf("can't receive packets on the %v layer, use RecvAddress", wd.layer)
+	}
+	if wd.flags&WinDivertFlagDrop != 0 {
+		return nil, errDropRecv
+	}
+	// 从句柄的缓冲池中获取一个字节数组 packetBuffer
+	packetBuffer := wd.buffers.get()
 	//定义了一个 packetLen 变量，用于存储接收到的数据包的长度。
 	var packetLen uint
 	//用于存储数据包的地址信息，类型为 WinDivertAddress。
 	var addr WinDivertAddress
 	//调用 winDivertRecv 函数来接收数据包。
-	success, _, err := winDivertRecv.Call(
-		wd.handle,
-		uintptr(unsafe.Pointer(&packetBuffer[0])),
-		uintptr(PacketBufferSize),
-		uintptr(unsafe.Pointer(&packetLen)),
-		uintptr(unsafe.Pointer(&addr)))
-	//如果 success 为 0，表示接收失败，返回错误。
-	if success == 0 {
+	err := divertRecv(wd.handle, packetBuffer, &packetLen, &addr)
+	//如果 err 不为 nil，表示接收失败，把缓冲区放回缓冲池并返回错误。
+	if err != nil {
+		if err == errInsufficientBuffer {
+			// 包被截断但数据已经写入，连同包一起返回
+			return wd.truncatedPacket(packetBuffer, packetLen, &addr)
+		}
+		ReturnBuffer(packetBuffer, int(packetLen))
+		if err == errNoData {
+			return nil, ErrShutdown
+		}
+		return nil, winDivertErr("recv", err)
+	}
+
+	return wd.receivedPacket(packetBuffer, packetLen, &addr), nil
+}
+
+// Same as Recv but the returned packet owns a copy of exactly its bytes, for packets kept for a while
+// The pooled buffer is returned right away (the packet is accounted as processed by QueueOccupancy)
+// and Send or Release never return anything to the pool for the copy: it can be held, sent or dropped freely.
+// A truncated packet is returned as a copy along with its *TruncatedError, like Recv.
+func (wd *WinDivertHandle) RecvCopy() (*Packet, error) {
+	packet, err := wd.Recv()
+	if packet == nil {
 		return nil, err
 	}
 
-	packet := &Packet{
-		Raw:       packetBuffer[:packetLen], //截获的数据包的原始字节数组。
-		Addr:      &addr,                    //数据包的地址信息。
-		PacketLen: packetLen,                //数据包的长度。
-		Buffer:    packetBuffer,             // 保存原始缓冲区
+	held := packet.Clone()
+	wd.releasePacket(packet)
+	return held, err
+}
+
+// Reads the next event of a layer without packet data (FLOW, SOCKET) and returns its address
+// Recv can't be used on these layers, there is no packet to return
+// https://reqrypt.org/windivert-doc.html#divert_recv
+func (wd *WinDivertHandle) RecvAddress() (*WinDivertAddress, error) {
+	if !wd.open.Load() {
+		return nil, errors.New("can't receive, the handle isn't open")
+	}
+
+	var addr WinDivertAddress
+	if err := divertRecv(wd.handle, nil, nil, &addr); err != nil {
+		if err == errNoData {
+			return nil, ErrShutdown
+		}
+		return nil, winDivertErr("recv", err)
+	}
+
+	wd.lastRecv.Store(time.Now().UnixNano())
+	return &addr, nil
+}
+
+// Accounts a received packet and returns it
+func (wd *WinDivertHandle) receivedPacket(packetBuffer []byte, packetLen uint, addr *WinDivertAddress) *Packet {
+	wd.lastRecv.Store(time.Now().UnixNano())
+	wd.received.Add(1)
+	wd.observeDrops(packetBuffer[:packetLen])
+
+	return &Packet{
+		Raw:        packetBuffer[:packetLen], //截获的数据包的原始字节数组。
+		Addr:       addr,                     //数据包的地址信息。
+		PacketLen:  packetLen,                //数据包的长度。
+		Buffer:     packetBuffer,             // 保存原始缓冲区
+		bufferUsed: int(packetLen),
+		handle:     wd,
+	}
+}
+
+// Returns the layer the handle has been opened on
+func (wd *WinDivertHandle) Layer() Layer {
+	return wd.layer
+}
+
+// Returns the size of the buffers Recv reads the packets in, see OpenOptions.BufferSize
+func (wd *WinDivertHandle) BufferSize() int {
+	return wd.buffers.size
+}
+
+// Returns the time of the last successful Recv or the zero time if no packet has been received yet
+func (wd *WinDivertHandle) LastRecvTime() time.Time {
+	lastRecv := wd.lastRecv.Load()
+	if lastRecv == 0 {
+		return time.Time{}
 	}
+	return time.Unix(0, lastRecv)
+}
 
-	return packet, nil
+// Tells the handle whether its filter should always see traffic
+// When set, Healthy reports a handle idle for too long as stuck. Safe to call while another goroutine calls Healthy.
+func (wd *WinDivertHandle) SetExpectTraffic(expectTraffic bool) {
+	wd.expectTraffic.Store(expectTraffic)
+}
 
+// Returns false if the handle is closed or, when traffic is expected (see SetExpectTraffic),
+// if no packet has been received for more than maxIdle (counted from the opening if nothing has been received yet)
+// A filter that isn't expected to see traffic is considered legitimately idle
+func (wd *WinDivertHandle) Healthy(maxIdle time.Duration) bool {
+	if !wd.open.Load() {
+		return false
+	}
+	if !wd.expectTraffic.Load() {
+		return true
+	}
+
+	last := wd.LastRecvTime()
+	if last.IsZero() {
+		last = wd.openTime
+	}
+	return time.Since(last) <= maxIdle
 }
 
 // Inject the packet on the Network Stack
@@ -166,12 +451,41 @@ func (wd *WinDivertHandle) Recv() (*Packet, error) {
 // 对于伪造数据包，WinDivert 会在重新注入之前自动递减 ip.TTL 或 ipv6.HopLimit 字段。
 // 注入的数据包必须具有正确的校验和，或者相应的 pAddr->*Checksum 标志未设置。
 // 使用 WinDivertHelperCalcChecksums() 函数可以重新计算校验和。
+// A packet already sent or released is rejected with ErrPacketReleased, even when Send and Release race.
+// On a NoSend handle the packet is released and ErrSniffSend returned.
 func (wd *WinDivertHandle) Send(packet *Packet) (uint, error) {
-	var sendLen uint
+	if err := wd.canSend(); err != nil {
+		if err == ErrSniffSend {
+			packet.Release()
+		}
+		return 0, err
+	}
+	// 先取得包的所有权再使用 Raw，并发的 Release 或另一次 Send 不会在注入时放回缓冲区
+	if !packet.claim() {
+		return 0, ErrPacketReleased
+	}
+	return wd.sendClaimed(packet)
+}
 
-	if !wd.open {
-		return 0, errors.New("can't Send, the handle isn't open")
+// Returns an error if the handle can't inject packets
+func (wd *WinDivertHandle) canSend() error {
+	if !wd.open.Load() {
+		return errors.New("can't Send, the handle isn't open")
 	}
+	if !wd.layer.isNetwork() {
+		return fmt.Errorf("can't Send on the %v layer, it carries no packet", wd.layer)
+	}
+	if wd.noSend {
+		return ErrSniffSend
+	}
+	return nil
+}
+
+// Injects a packet claimed by the caller (see Packet.claim) and returns its buffer
+func (wd *WinDivertHandle) sendClaimed(packet *Packet) (uint, error) {
+	var sendLen uint
+
+	wd.observeLatency(packet)
 
 	// 调试输出
 	//fmt.Printf("handle: %v\n", wd.handle)
@@ -180,112 +494,200 @@ func (wd *WinDivertHandle) Send(packet *Packet) (uint, error) {
 	//fmt.Printf("sendLen: %v\n", sendLen)
 	//fmt.Printf("packet.Addr: %v\n", packet.Addr)
 
-	success, _, err := winDivertSend.Call(
-		wd.handle, // handle: 一个有效的 WinDivert 句柄，由 WinDivertOpen() 创建
-		uintptr(unsafe.Pointer(&(packet.Raw[0]))), // pPacket: 包含要注入的数据包的缓冲区首字节的内存地址，从该地址按长度往后读
-		uintptr(packet.PacketLen),                 // packetLen: pPacket 缓冲区的总长度
-		uintptr(unsafe.Pointer(&sendLen)),         // pSendLen: 实际注入的字节数，可以为 NULL
-		uintptr(unsafe.Pointer(packet.Addr)))      // pAddr: 要注入的数据包的地址
+	err := divertSend(
+		wd.handle,        // handle: 一个有效的 WinDivert 句柄，由 WinDivertOpen() 创建
+		&packet.Raw[0],   // pPacket: 包含要注入的数据包的缓冲区首字节的内存地址，从该地址按长度往后读
+		packet.PacketLen, // packetLen: pPacket 缓冲区的总长度
+		&sendLen,         // pSendLen: 实际注入的字节数，可以为 NULL
+		packet.Addr)      // pAddr: 要注入的数据包的地址
 
 	// 将缓冲区放回缓冲池
-	ReturnBuffer(packet.Buffer, int(packet.PacketLen))
+	wd.returnClaimed(packet)
 
-	if success == 0 {
-		return 0, err
+	if err != nil {
+		return 0, winDivertErr("send", err)
+	}
+
+	// 注入是以包为单位的，无法只重发剩余部分
+	if sendLen < packet.PacketLen {
+		return sendLen, fmt.Errorf("%w: %d of %d bytes injected", ErrPartialSend, sendLen, packet.PacketLen)
 	}
 
 	return sendLen, nil
 }
 
+// Injects a packet received on another handle, possibly on another layer
+// The address is adapted to this handle's layer first: a packet captured on the NETWORK layer
+// can be forwarded through a NETWORK_FORWARD handle and the other way around.
+// Forwarded packets have no direction, going from NETWORK_FORWARD to NETWORK they are sent as outbound.
+func (wd *WinDivertHandle) Forward(packet *Packet) (uint, error) {
+	if packet.Addr == nil {
+		return 0, errors.New("can't forward, the packet has no address")
+	}
+	adaptAddress(packet.Addr, wd.layer)
+	return packet.Send(wd)
+}
+
+// Converts addr to the given network layer, keeping the interface indices
+func adaptAddress(addr *WinDivertAddress, layer Layer) {
+	if addr.Layer() == layer {
+		return
+	}
+
+	switch layer {
+	case WinDivertLayerNetworkForward:
+		addr.setBit(addrOutboundBit, false)
+		addr.setBit(addrLoopbackBit, false)
+	case WinDivertLayerNetwork:
+		addr.setBit(addrOutboundBit, true)
+	}
+	addr.setLayer(layer)
+}
+
 // Calls WinDivertHelperCalcChecksum to calculate the packet's chacksum
 // https://reqrypt.org/windivert-doc.html#divert_helper_calc_checksums
 func (wd *WinDivertHandle) HelperCalcChecksum(packet *Packet) error {
-	initialPacketLen := packet.PacketLen
+	return HelperCalcChecksumWithFlags(packet, 0)
+}
 
-	success, _, err := winDivertHelperCalcChecksums.Call(
-		uintptr(unsafe.Pointer(&packet.Raw[0])), //将数据包的原始字节数组 Raw 的首地址转换为 uintptr 类型。unsafe.Pointer 用于将 Go 的指针类型转换为通用指针类型，然后再转换为 uintptr
-		uintptr(packet.PacketLen),               //数据包的长度，直接转换为 uintptr 类型。
-		uintptr(unsafe.Pointer(&packet.Addr)),   //数据包的地址信息的首地址，同样通过 unsafe.Pointer 转换为 uintptr。
-		uintptr(0))
-	//用于控制校验和计算的标志，这里传递 0 表示计算所有类型的校验和
-	if initialPacketLen != packet.PacketLen {
-		//fmt.Printf("After Call PacketLen: %d\n", packet.PacketLen)
-		packet.PacketLen = initialPacketLen
+// Calls WinDivertHelperCalcChecksums to calculate the packet's checksums
+// flags is a combination of the WinDivertHelperNo*Checksum constants, the skipped checksums are left as they are
+// https://reqrypt.org/windivert-doc.html#divert_helper_calc_checksums
+func HelperCalcChecksumWithFlags(packet *Packet, flags uint64) error {
+	if packet.PacketLen == 0 || int(packet.PacketLen) > len(packet.Raw) {
+		return fmt.Errorf("cannot calculate the checksums, packet length is %d and the buffer has %d bytes", packet.PacketLen, len(packet.Raw))
 	}
 
-	if success == 0 {
-		return err
+	if err := divertCalcChecksums(packet.Raw[:packet.PacketLen], packet.Addr, flags); err != nil {
+		// 格式错误的包不会设置错误码
+		if errno, ok := err.(syscall.Errno); ok && errno == 0 {
+			return errors.New("cannot calculate the checksums, the packet is malformed")
+		}
+		return fmt.Errorf("cannot calculate the checksums: %w", err)
 	}
 
 	return nil
 }
 
 // Take the given filter and check if it contains any error
-// https://reqrypt.org/windivert-doc.html#divert_helper_check_filter
-func HelperCheckFilter(filter string) (bool, int) {
-	var errorPos uint
-
-	filterBytePtr, _ := syscall.BytePtrFromString(filter)
-
-	success, _, _ := winDivertHelperCheckFilter.Call(
-		uintptr(unsafe.Pointer(filterBytePtr)),
-		uintptr(0),
-		uintptr(0), // Not implemented yet
-		uintptr(unsafe.Pointer(&errorPos)))
-
-	if success == 1 {
-		return true, -1
+// Returns false and a *FilterError holding WinDivert's message and the error position if it does
+// WinDivert 2.x has no WinDivertHelperCheckFilter, the filter is compiled for the network layer without keeping the object
+// https://reqrypt.org/windivert-doc.html#divert_helper_compile_filter
+func HelperCheckFilter(filter string) (bool, error) {
+	if err := compileFilter(filter, WinDivertLayerNetwork, nil); err != nil {
+		return false, err
 	}
-	return false, int(errorPos)
+	return true, nil
 }
 
 // Take a packet and compare it with the given filter
-// Returns true if the packet matches the filter
+// Returns true if the packet matches the filter, false and a nil error if it doesn't
+// A packet without address is evaluated with a zero address (inbound, IPv4, network layer)
+// An invalid filter is reported as a *FilterError. To match many packets against the same filter
+// use CompileFilter, HelperEvalFilter parses the filter on every call.
 // https://reqrypt.org/windivert-doc.html#divert_helper_eval_filter
 func HelperEvalFilter(packet *Packet, filter string) (bool, error) {
 	filterBytePtr, err := syscall.BytePtrFromString(filter)
 	if err != nil {
 		return false, err
 	}
+	if packet.PacketLen == 0 || int(packet.PacketLen) > len(packet.Raw) {
+		return false, fmt.Errorf("cannot evaluate the filter, packet length is %d and the buffer has %d bytes", packet.PacketLen, len(packet.Raw))
+	}
 
-	success, _, err := winDivertHelperEvalFilter.Call(
-		uintptr(unsafe.Pointer(filterBytePtr)),
-		uintptr(0),
-		uintptr(unsafe.Pointer(&packet.Raw[0])),
-		uintptr(packet.PacketLen),
-		uintptr(unsafe.Pointer(&packet.Addr)))
+	// WinDivert 2.x 没有 layer 参数，pAddr 指向地址结构本身
+	addr := packet.Addr
+	if addr == nil {
+		addr = &WinDivertAddress{}
+	}
+	if divertEvalFilter(filterBytePtr, packet.Raw[:packet.PacketLen], addr) {
+		return true, nil
+	}
 
-	if success == 0 {
+	// 不匹配和过滤器错误都返回 FALSE，编译一次区分两者
+	if err := compileFilter(filter, addr.Layer(), nil); err != nil {
 		return false, err
 	}
-
-	return true, nil
+	return false, nil
 }
 
 // A loop that capture packets by calling Recv and sends them on a channel as long as the handle is open
-// If Recv() returns an error, the loop is stopped and the channel is closed
+// The channel is always closed when the loop stops. It returns nil when it stops cleanly (handle closed,
+// receiving shut down (ErrShutdown) or ctx done) and the Recv error that stopped it otherwise.
+// Truncated packets are dropped and reported to OpenOptions.ErrorHandler, the loop goes on.
+// With OpenOptions.RecoverPanics a panic while receiving is reported the same way.
 // 这个函数的主要功能是不断地捕获网络数据包并将其发送到一个通道中，直到发生错误或句柄关闭为止。它是一个典型的生产者-消费者模式的实现，recvLoop 方法作为生产者不断地捕获数据包并将其发送到通道，而消费者可以从通道中接收数据包并进行处理。
-func (wd *WinDivertHandle) recvLoop(packetChan chan<- *Packet) {
-	for wd.open {
+func (wd *WinDivertHandle) recvLoop(ctx context.Context, packetChan chan<- *Packet) error {
+	defer close(packetChan)
+
+	for wd.open.Load() {
 		// 读取数据放到缓冲队列中，这样如果消费比较慢也能提前读取，避免包丢失
-		packet, err := wd.Recv()
+		packet, err := wd.recvRecovered(ctx)
+		if errors.Is(err, ErrTruncated) {
+			// 截断的包不能重新注入，丢弃后继续
+			wd.reportError(err)
+			wd.dropPacket(packet)
+			continue
+		}
+		var panicErr *PanicError
+		if errors.As(err, &panicErr) {
+			wd.reportError(err)
+			continue
+		}
+		if errors.Is(err, ErrShutdown) || err != nil && (ctx.Err() != nil || !wd.open.Load()) {
+			return nil
+		}
 		if err != nil {
-			//close(packetChan)
-			fmt.Println("recvLoop Recv Error:", err)
-			break
+			return err
 		}
 
-		packetChan <- packet
+		select {
+		case packetChan <- packet:
+		case <-ctx.Done():
+			wd.dropPacket(packet)
+			return nil
+		}
 	}
+	return nil
 }
 
 // Create a new channel that will be used to pass captured packets and returns it calls recvLoop to maintain a loop
+// The channel is closed when the loop stops, Err then returns the Recv error that stopped it
 func (wd *WinDivertHandle) Packets() (chan *Packet, error) {
-	if !wd.open {
+	return wd.PacketsContext(context.Background())
+}
+
+// Like Packets but the loop stops once ctx is done, the pending Recv is cancelled
+// The channel is closed when the loop stops (context done, handle closed or Recv error),
+// the packets left in it must still be sent or dropped
+func (wd *WinDivertHandle) PacketsContext(ctx context.Context) (chan *Packet, error) {
+	if !wd.open.Load() {
 		return nil, errors.New("the handle isn't open")
 	}
 	packetChan := make(chan *Packet, PacketChanCapacity)
+	wd.loopErr.Store(nil)
 	// 异步把数据读到缓冲队列中
-	go wd.recvLoop(packetChan)
+	go func() {
+		if err := wd.recvLoop(ctx, packetChan); err != nil {
+			wd.loopErr.Store(&err)
+		}
+	}()
 	return packetChan, nil
 }
+
+// Returns the Recv error that stopped the loop of the last Packets or PacketsContext call
+// Returns nil while the loop runs and when it stopped cleanly (handle closed, receiving shut down or
+// context done). Check it once the channel is closed.
+func (wd *WinDivertHandle) Err() error {
+	if err := wd.loopErr.Load(); err != nil {
+		return *err
+	}
+	return nil
+}
+
+// Passes an error the receive loops can't return to OpenOptions.ErrorHandler, if any
+func (wd *WinDivertHandle) reportError(err error) {
+	if wd.errorHandler != nil {
+		wd.errorHandler(err)
+	}
+}
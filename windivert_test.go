@@ -0,0 +1,842 @@
+package godivert
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"examples/header"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// Driver replacing the DLL calls in the tests, it tracks the handles it opened
+type fakeDriver struct {
+	mu       sync.Mutex
+	next     uintptr
+	open     map[uintptr]bool
+	shutdown map[uintptr]ShutdownMode
+	// Error returned by the next calls to close, nil by default
+	closeErr error
+	// Packets Recv returns in order, once empty Recv fails with recvErr
+	queue []fakePacket
+	// ERROR_NO_DATA (ErrShutdown) by default
+	recvErr error
+	// Length of the buffer passed to the last RecvEx
+	recvExLen int
+	// Packets injected, the bytes are copied: copying them lets -race see a buffer reused too early
+	sent []fakePacket
+}
+
+// A packet going through the fake driver
+type fakePacket struct {
+	raw  []byte
+	addr WinDivertAddress
+	// Time the packet has been injected
+	at time.Time
+}
+
+// Replaces the DLL calls opening, shutting down and closing the handles,
+// receiving and injecting the packets until the test ends
+func newFakeDriver(t testing.TB) *fakeDriver {
+	driver := &fakeDriver{
+		open:     make(map[uintptr]bool),
+		shutdown: make(map[uintptr]ShutdownMode),
+		recvErr:  errNoData,
+	}
+
+	savedOpen, savedClose, savedShutdown := divertOpen, divertClose, divertShutdown
+	savedRecv, savedRecvContext := divertRecv, divertRecvContext
+	savedRecvEx, savedParsePacket := divertRecvEx, divertParsePacket
+	savedSend, savedSendEx := divertSend, divertSendEx
+	savedCalcChecksums := divertCalcChecksums
+	t.Cleanup(func() {
+		divertOpen, divertClose, divertShutdown = savedOpen, savedClose, savedShutdown
+		divertRecv, divertRecvContext = savedRecv, savedRecvContext
+		divertRecvEx, divertParsePacket = savedRecvEx, savedParsePacket
+		divertSend, divertSendEx = savedSend, savedSendEx
+		divertCalcChecksums = savedCalcChecksums
+	})
+
+	divertOpen = func(filter *byte, layer Layer, priority int16, flags uint64) (uintptr, error) {
+		driver.mu.Lock()
+		defer driver.mu.Unlock()
+
+		driver.next++
+		driver.open[driver.next] = true
+		return driver.next, nil
+	}
+	divertClose = func(handle uintptr) error {
+		driver.mu.Lock()
+		defer driver.mu.Unlock()
+
+		if !driver.open[handle] {
+			return syscall.Errno(6) // ERROR_INVALID_HANDLE
+		}
+		delete(driver.open, handle)
+		return driver.closeErr
+	}
+	divertShutdown = func(handle uintptr, how ShutdownMode) error {
+		driver.mu.Lock()
+		defer driver.mu.Unlock()
+
+		if !driver.open[handle] {
+			return syscall.Errno(6)
+		}
+		driver.shutdown[handle] |= how
+		return nil
+	}
+	divertRecv = func(handle uintptr, packet []byte, recvLen *uint, addr *WinDivertAddress) error {
+		driver.mu.Lock()
+		defer driver.mu.Unlock()
+
+		if len(driver.queue) == 0 {
+			return driver.recvErr
+		}
+		next := driver.queue[0]
+		driver.queue = driver.queue[1:]
+		*addr = next.addr
+		if packet == nil {
+			return nil
+		}
+		n := copy(packet, next.raw)
+		*recvLen = uint(n)
+		if n < len(next.raw) {
+			return errInsufficientBuffer
+		}
+		return nil
+	}
+	// 按顺序放入能装下的包，第一个包都装不下时和 WinDivert 一样失败
+	divertRecvEx = func(handle uintptr, buffer []byte, recvLen *uint32, addrs []WinDivertAddress, addrLen *uint32) error {
+		driver.mu.Lock()
+		defer driver.mu.Unlock()
+
+		driver.recvExLen = len(buffer)
+		if len(driver.queue) == 0 {
+			return driver.recvErr
+		}
+		var n, count int
+		for count < len(addrs) && len(driver.queue) > 0 && n+len(driver.queue[0].raw) <= len(buffer) {
+			next := driver.queue[0]
+			driver.queue = driver.queue[1:]
+			n += copy(buffer[n:], next.raw)
+			addrs[count] = next.addr
+			count++
+		}
+		if count == 0 {
+			return errInsufficientBuffer
+		}
+		*recvLen, *addrLen = uint32(n), uint32(count*winDivertAddressSize)
+		return nil
+	}
+	// 根据 IP 头声明的长度找到下一个包
+	divertParsePacket = func(packet []byte) (nextLen uint32, ok bool) {
+		var packetLen int
+		switch {
+		case len(packet) >= header.IPv4HeaderLen && packet[0]>>4 == 4:
+			packetLen = int(binary.BigEndian.Uint16(packet[2:4]))
+		case len(packet) >= header.IPv6HeaderLen && packet[0]>>4 == 6:
+			packetLen = header.IPv6HeaderLen + int(binary.BigEndian.Uint16(packet[4:6]))
+		default:
+			return 0, false
+		}
+		if packetLen >= len(packet) {
+			return 0, false
+		}
+		return uint32(len(packet) - packetLen), true
+	}
+	// 假驱动的接收不会阻塞，不需要重叠读取
+	divertRecvContext = func(wd *WinDivertHandle, ctx context.Context) (*Packet, error) {
+		return wd.Recv()
+	}
+	divertSend = func(handle uintptr, packet *byte, packetLen uint, sendLen *uint, addr *WinDivertAddress) error {
+		driver.inject(unsafe.Slice(packet, packetLen), addr)
+		*sendLen = packetLen
+		return nil
+	}
+	divertSendEx = func(handle uintptr, packets []byte, sendLen *uint32, addrs []WinDivertAddress) error {
+		driver.inject(packets, &addrs[0])
+		*sendLen = uint32(len(packets))
+		return nil
+	}
+	divertCalcChecksums = func(packet []byte, addr *WinDivertAddress, flags uint64) error {
+		// 和 WinDivert 一样，比 IP 头声明的长度短的包不计算
+		if len(packet) < header.IPv4HeaderLen || len(packet) < int(binary.BigEndian.Uint16(packet[2:4])) {
+			return syscall.Errno(0)
+		}
+		return nil
+	}
+	return driver
+}
+
+// Queues a packet for Recv
+func (d *fakeDriver) divert(raw []byte, addr WinDivertAddress) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.queue = append(d.queue, fakePacket{raw: raw, addr: addr})
+}
+
+func (d *fakeDriver) inject(packet []byte, addr *WinDivertAddress) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.sent = append(d.sent, fakePacket{raw: append([]byte(nil), packet...), addr: *addr, at: time.Now()})
+}
+
+// Returns a packet received on the handle, its bytes are copied in a pooled buffer like Recv does
+func fakeRecv(wd *WinDivertHandle, raw []byte) *Packet {
+	buffer := wd.buffers.get()
+	n := copy(buffer, raw)
+	return wd.receivedPacket(buffer, uint(n), &WinDivertAddress{})
+}
+
+// Returns the packets injected so far
+func (d *fakeDriver) injected() []fakePacket {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return append([]fakePacket(nil), d.sent...)
+}
+
+// Returns the number of handles opened and not closed yet
+func (d *fakeDriver) openHandles() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return len(d.open)
+}
+
+// Returns the shutdown modes applied to the handle
+func (d *fakeDriver) shutdownOf(wd *WinDivertHandle) ShutdownMode {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.shutdown[wd.handle]
+}
+
+// Opens a handle on the fake driver, the test fails if it can't be opened
+func openFake(t testing.TB, opts OpenOptions) *WinDivertHandle {
+	t.Helper()
+
+	wd, err := Open(opts)
+	if err != nil {
+		t.Fatalf("Open(%+v): %v", opts, err)
+	}
+	t.Cleanup(func() { wd.Close() })
+	return wd
+}
+
+func TestCloseWrapsErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		closeErr error
+		want     error
+	}{
+		{"success", nil, nil},
+		{"failure", syscall.Errno(87), ErrInvalidParameter},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			driver := newFakeDriver(t)
+			driver.closeErr = test.closeErr
+			wd := openFake(t, OpenOptions{})
+
+			err := wd.Close()
+			if test.want == nil && err != nil {
+				t.Fatalf("Close() = %v, want nil", err)
+			}
+			if test.want != nil {
+				if _, ok := err.(*WinDivertError); !ok || !errors.Is(err, test.want) {
+					t.Fatalf("Close() = %v, want a WinDivertError matching %v", err, test.want)
+				}
+			}
+			if wd.open.Load() {
+				t.Error("the handle is still open after Close")
+			}
+			if err := wd.Close(); err != nil {
+				t.Errorf("second Close() = %v, want nil", err)
+			}
+		})
+	}
+}
+
+func TestSendReleaseRace(t *testing.T) {
+	driver := newFakeDriver(t)
+	wd := openFake(t, OpenOptions{})
+	raw := make([]byte, 60)
+
+	const packets = 200
+	var sent, rejected atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < packets; i++ {
+		packet := fakeRecv(wd, raw)
+		send := func() {
+			defer wg.Done()
+			_, err := wd.Send(packet)
+			switch {
+			case err == nil:
+				sent.Add(1)
+			case errors.Is(err, ErrPacketReleased):
+				rejected.Add(1)
+			default:
+				t.Errorf("Send() = %v", err)
+			}
+		}
+		wg.Add(3)
+		go send()
+		go send()
+		go func() {
+			defer wg.Done()
+			packet.Release()
+		}()
+	}
+	wg.Wait()
+
+	if n := len(driver.injected()); n != int(sent.Load()) {
+		t.Errorf("%d packets injected, %d Send succeeded", n, sent.Load())
+	}
+	if got := sent.Load() + rejected.Load(); got != 2*packets {
+		t.Errorf("%d Send returned, want %d", got, 2*packets)
+	}
+	if sent.Load() > packets {
+		t.Errorf("%d packets sent, at most %d expected", sent.Load(), packets)
+	}
+	// 每个包只被 Send 或 Release 计为已处理一次
+	if n, _ := wd.QueueOccupancy(); n != 0 {
+		t.Errorf("QueueOccupancy() = %d, want 0", n)
+	}
+}
+
+func TestSendEx(t *testing.T) {
+	tests := []struct {
+		name    string
+		packets func(wd *WinDivertHandle) []*Packet
+		wantLen uint
+		wantErr error
+	}{
+		{
+			name: "batch",
+			packets: func(wd *WinDivertHandle) []*Packet {
+				return []*Packet{fakeRecv(wd, make([]byte, 40)), fakeRecv(wd, make([]byte, 60))}
+			},
+			wantLen: 100,
+		},
+		{
+			name: "released packet",
+			packets: func(wd *WinDivertHandle) []*Packet {
+				released := fakeRecv(wd, make([]byte, 40))
+				released.Release()
+				return []*Packet{fakeRecv(wd, make([]byte, 40)), released}
+			},
+			wantErr: ErrPacketReleased,
+		},
+		{
+			name: "same packet twice",
+			packets: func(wd *WinDivertHandle) []*Packet {
+				packet := fakeRecv(wd, make([]byte, 40))
+				return []*Packet{packet, packet}
+			},
+			wantErr: ErrPacketReleased,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			newFakeDriver(t)
+			wd := openFake(t, OpenOptions{})
+			packets := test.packets(wd)
+
+			n, err := wd.SendEx(packets)
+			if !errors.Is(err, test.wantErr) || n != test.wantLen {
+				t.Fatalf("SendEx() = %d, %v, want %d, %v", n, err, test.wantLen, test.wantErr)
+			}
+			if test.wantErr == nil {
+				return
+			}
+			// 被拒绝的批次不动包，第一个包仍然可以发送
+			if _, err := wd.Send(packets[0]); err != nil {
+				t.Errorf("Send() after a rejected batch = %v", err)
+			}
+		})
+	}
+}
+
+func TestSendExChecksumError(t *testing.T) {
+	driver := newFakeDriver(t)
+	wd := openFake(t, OpenOptions{})
+	valid := fakeRecv(wd, buildIPv4(header.UDP, udpBytes(1234, 53, nil)))
+	truncated := fakeRecv(wd, buildIPv4(header.TCP, tcpBytes(1234, 80, 0x10, nil)))
+	truncated.ParseHeaders()
+	truncated.NextHeader.SetDstPort(8080)
+	truncated.Raw = truncated.Raw[:header.IPv4HeaderLen+10]
+
+	if _, err := wd.SendEx([]*Packet{valid, truncated}); err == nil {
+		t.Fatal("SendEx() of a packet without a checksum field = nil, want an error")
+	}
+	if n := len(driver.injected()); n != 0 {
+		t.Errorf("%d packets injected, want none", n)
+	}
+	// 包保持原样，仍然可以发送
+	if _, err := wd.Send(valid); err != nil {
+		t.Errorf("Send() after a rejected batch = %v", err)
+	}
+}
+
+func TestPacketSendChecksumError(t *testing.T) {
+	driver := newFakeDriver(t)
+	wd := openFake(t, OpenOptions{})
+	packet := fakeRecv(wd, buildIPv4(header.UDP, udpBytes(1234, 53, nil)))
+	packet.ParseHeaders()
+	packet.NextHeader.SetDstPort(5353)
+	// IP 头声明的长度超过包的长度
+	binary.BigEndian.PutUint16(packet.Raw[2:4], 100)
+
+	if _, err := packet.Send(wd); err == nil {
+		t.Fatal("Send() of a malformed packet = nil, want an error")
+	}
+	if n := len(driver.injected()); n != 0 {
+		t.Errorf("%d packets injected, want none", n)
+	}
+	// 未发送的包已经释放
+	if _, err := packet.Send(wd); !errors.Is(err, ErrPacketReleased) {
+		t.Errorf("second Send() = %v, want %v", err, ErrPacketReleased)
+	}
+}
+
+func TestSendOnSniffHandle(t *testing.T) {
+	constructors := []struct {
+		name string
+		open func() (*WinDivertHandle, error)
+	}{
+		{"NewSniffHandle", func() (*WinDivertHandle, error) { return NewSniffHandle("true") }},
+		{"NewWinDivertHandleWithFlags", func() (*WinDivertHandle, error) {
+			return NewWinDivertHandleWithFlags("true", WinDivertFlagSniff)
+		}},
+		{"NewWinDivertHandleWithLayer", func() (*WinDivertHandle, error) {
+			return NewWinDivertHandleWithLayer("true", WinDivertLayerNetwork, 0, WinDivertFlagSniff)
+		}},
+	}
+	sends := []struct {
+		name string
+		send func(wd *WinDivertHandle, packet *Packet) error
+	}{
+		{"Send", func(wd *WinDivertHandle, packet *Packet) error {
+			_, err := wd.Send(packet)
+			return err
+		}},
+		{"Packet.Send", func(wd *WinDivertHandle, packet *Packet) error {
+			_, err := packet.Send(wd)
+			return err
+		}},
+		{"SendEx", func(wd *WinDivertHandle, packet *Packet) error {
+			_, err := wd.SendEx([]*Packet{packet})
+			return err
+		}},
+	}
+
+	for _, constructor := range constructors {
+		for _, send := range sends {
+			t.Run(constructor.name+"/"+send.name, func(t *testing.T) {
+				driver := newFakeDriver(t)
+				wd, err := constructor.open()
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer wd.Close()
+				packet := fakeRecv(wd, ipv4Packet(20))
+
+				if err := send.send(wd, packet); err != ErrSniffSend {
+					t.Fatalf("%s() = %v, want %v", send.name, err, ErrSniffSend)
+				}
+				if n := len(driver.injected()); n != 0 {
+					t.Errorf("%d packets injected on a sniff handle", n)
+				}
+				// 缓冲区已经放回
+				if !packet.released.Load() {
+					t.Error("the packet wasn't released")
+				}
+			})
+		}
+	}
+}
+
+func TestSendOnSniffHandleWithoutNoSend(t *testing.T) {
+	driver := newFakeDriver(t)
+	wd := openFake(t, OpenOptions{Flags: WinDivertFlagSniff})
+
+	if _, err := wd.Send(NewPacket(ipv4Packet(20), &WinDivertAddress{})); err != nil {
+		t.Fatalf("Send() = %v", err)
+	}
+	if n := len(driver.injected()); n != 1 {
+		t.Errorf("%d packets injected, want 1", n)
+	}
+}
+
+func TestRecvTruncated(t *testing.T) {
+	tests := []struct {
+		name       string
+		bufferSize int
+		packetLen  int
+		wantErr    bool
+	}{
+		{"fits", MinPacketBufferSize, MinPacketBufferSize, false},
+		{"larger than the buffer", MinPacketBufferSize, 200, true},
+		{"jumbo in the default buffer", 0, 9000, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			driver := newFakeDriver(t)
+			wd := openFake(t, OpenOptions{BufferSize: test.bufferSize})
+			driver.divert(ipv4Packet(test.packetLen), WinDivertAddress{})
+
+			packet, err := wd.Recv()
+			if packet == nil {
+				t.Fatalf("Recv() = nil, %v", err)
+			}
+			defer packet.Release()
+
+			var truncated *TruncatedError
+			if !test.wantErr {
+				if err != nil {
+					t.Fatalf("Recv() = %v", err)
+				}
+				if len(packet.Raw) != test.packetLen {
+					t.Errorf("Recv() returned %d bytes, want %d", len(packet.Raw), test.packetLen)
+				}
+				return
+			}
+			if !errors.As(err, &truncated) || !errors.Is(err, ErrTruncated) {
+				t.Fatalf("Recv() = %v, want a *TruncatedError", err)
+			}
+			if truncated.Length != test.packetLen || truncated.Captured != test.bufferSize || len(packet.Raw) != test.bufferSize {
+				t.Errorf("Recv() = %d bytes, %+v, want %d of %d bytes", len(packet.Raw), truncated, test.bufferSize, test.packetLen)
+			}
+		})
+	}
+}
+
+func TestPacketsReportsTruncated(t *testing.T) {
+	driver := newFakeDriver(t)
+	var reported []error
+	wd := openFake(t, OpenOptions{
+		BufferSize:   MinPacketBufferSize,
+		ErrorHandler: func(err error) { reported = append(reported, err) },
+	})
+	driver.divert(ipv4Packet(200), WinDivertAddress{})
+	driver.divert(ipv4Packet(20), WinDivertAddress{})
+
+	packetChan, err := wd.Packets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var received []*Packet
+	for packet := range packetChan {
+		received = append(received, packet)
+		packet.Release()
+	}
+
+	if len(received) != 1 || received[0].PacketLen != 20 {
+		t.Errorf("received %d packets, want only the 20 bytes one", len(received))
+	}
+	if len(reported) != 1 || !errors.Is(reported[0], ErrTruncated) {
+		t.Errorf("reported %v, want one truncated packet", reported)
+	}
+	if err := wd.Err(); err != nil {
+		t.Errorf("Err() after a shutdown = %v, want nil", err)
+	}
+}
+
+func TestPacketsErr(t *testing.T) {
+	driver := newFakeDriver(t)
+	driver.recvErr = syscall.Errno(87)
+	wd := openFake(t, OpenOptions{})
+
+	packetChan, err := wd.Packets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for packet := range packetChan {
+		packet.Release()
+	}
+	if err := wd.Err(); !errors.Is(err, ErrInvalidParameter) {
+		t.Errorf("Err() = %v, want %v", err, ErrInvalidParameter)
+	}
+}
+
+func TestLastRecvTime(t *testing.T) {
+	driver := newFakeDriver(t)
+	wd := openFake(t, OpenOptions{})
+	if last := wd.LastRecvTime(); !last.IsZero() {
+		t.Fatalf("LastRecvTime() = %v before any Recv, want the zero time", last)
+	}
+
+	var previous time.Time
+	for i := 0; i < 2; i++ {
+		driver.divert(ipv4Packet(20), WinDivertAddress{})
+		before := time.Now()
+		packet, err := wd.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		packet.Release()
+
+		last := wd.LastRecvTime()
+		if last.Before(before) || !last.After(previous) {
+			t.Errorf("Recv %d: LastRecvTime() = %v, want after %v", i, last, before)
+		}
+		previous = last
+	}
+
+	// 失败的接收不更新时间
+	if _, err := wd.Recv(); err == nil {
+		t.Fatal("Recv() of an empty queue succeeded")
+	}
+	if last := wd.LastRecvTime(); !last.Equal(previous) {
+		t.Errorf("LastRecvTime() = %v after a failed Recv, want %v", last, previous)
+	}
+}
+
+func TestHealthy(t *testing.T) {
+	tests := []struct {
+		name          string
+		expectTraffic bool
+		opened        time.Duration // ago
+		lastRecv      time.Duration // ago, 0 if nothing has been received
+		closed        bool
+		want          bool
+	}{
+		{"idle filter", false, time.Hour, 0, false, true},
+		{"just opened", true, time.Second, 0, false, true},
+		{"nothing since opened", true, time.Hour, 0, false, false},
+		{"recent packet", true, time.Hour, time.Second, false, true},
+		{"stuck", true, time.Hour, 10 * time.Minute, false, false},
+		{"closed", false, time.Second, 0, true, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			newFakeDriver(t)
+			wd := openFake(t, OpenOptions{})
+			wd.SetExpectTraffic(test.expectTraffic)
+			wd.openTime = time.Now().Add(-test.opened)
+			if test.lastRecv != 0 {
+				wd.lastRecv.Store(time.Now().Add(-test.lastRecv).UnixNano())
+			}
+			if test.closed {
+				wd.Close()
+			}
+
+			if got := wd.Healthy(time.Minute); got != test.want {
+				t.Errorf("Healthy() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+// -race 检查健康检查和 SetExpectTraffic 并发调用
+func TestHealthyConcurrent(t *testing.T) {
+	newFakeDriver(t)
+	wd := openFake(t, OpenOptions{})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			wd.SetExpectTraffic(i%2 == 0)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		wd.Healthy(time.Minute)
+	}
+	<-done
+
+	if !wd.Healthy(time.Minute) {
+		t.Error("Healthy() = false for a handle just opened")
+	}
+}
+
+func TestForward(t *testing.T) {
+	loopback := NewAddress()
+	loopback.setBit(addrLoopbackBit, true)
+	forwarded := &WinDivertAddress{}
+	forwarded.setLayer(WinDivertLayerNetworkForward)
+	inbound := NewAddress()
+	inbound.SetOutbound(false)
+
+	tests := []struct {
+		name         string
+		addr         *WinDivertAddress
+		layer        Layer
+		wantOutbound bool
+	}{
+		{"network to forward", NewAddress(), WinDivertLayerNetworkForward, false},
+		{"loopback to forward", loopback, WinDivertLayerNetworkForward, false},
+		{"forward to network", forwarded, WinDivertLayerNetwork, true},
+		{"same layer", inbound, WinDivertLayerNetwork, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			driver := newFakeDriver(t)
+			wd := openFake(t, OpenOptions{Layer: test.layer})
+			test.addr.SetIfIdx(7)
+			test.addr.SetSubIfIdx(3)
+
+			if _, err := wd.Forward(NewPacket(ipv4Packet(20), test.addr)); err != nil {
+				t.Fatalf("Forward() = %v", err)
+			}
+			// 检查传给 WinDivertSend 的地址
+			sent := driver.injected()
+			if len(sent) != 1 {
+				t.Fatalf("%d packets injected, want 1", len(sent))
+			}
+			addr := sent[0].addr
+			if addr.Layer() != test.layer {
+				t.Errorf("sent on the %v layer, want %v", addr.Layer(), test.layer)
+			}
+			if addr.Outbound() != test.wantOutbound || test.layer == WinDivertLayerNetworkForward && addr.Loopback() {
+				t.Errorf("outbound %v, loopback %v, want outbound %v", addr.Outbound(), addr.Loopback(), test.wantOutbound)
+			}
+			if addr.IfIdx() != 7 || addr.SubIfIdx() != 3 {
+				t.Errorf("interface %d.%d, want 7.3", addr.IfIdx(), addr.SubIfIdx())
+			}
+		})
+	}
+}
+
+func TestForwardWithoutAddress(t *testing.T) {
+	driver := newFakeDriver(t)
+	wd := openFake(t, OpenOptions{Layer: WinDivertLayerNetworkForward})
+
+	if _, err := wd.Forward(NewPacket(ipv4Packet(20), nil)); err == nil {
+		t.Error("Forward() = nil error for a packet without address")
+	}
+	if n := len(driver.injected()); n != 0 {
+		t.Errorf("%d packets injected, want none", n)
+	}
+}
+
+func TestRecvLargeLoopbackSegment(t *testing.T) {
+	payload := bytes.Repeat([]byte("segment"), 10000)
+	tests := []struct {
+		name string
+		raw  []byte
+	}{
+		{"60KB IPv4", buildIPv4(header.TCP, tcpBytes(50000, 8080, 0x18, payload[:60000-header.IPv4HeaderLen-header.TCPHeaderLen]))},
+		{"largest IPv6", buildIPv6(header.TCP, tcpBytes(50000, 8080, 0x18, payload[:0xffff-header.TCPHeaderLen]))},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			driver := newFakeDriver(t)
+			wd := openFake(t, OpenOptions{})
+			addr := NewAddress()
+			addr.setBit(addrLoopbackBit, true)
+			driver.divert(test.raw, *addr)
+
+			packet, err := wd.Recv()
+			if err != nil {
+				t.Fatalf("Recv() = %v", err)
+			}
+			defer wd.dropPacket(packet)
+			if int(packet.PacketLen) != len(test.raw) || !bytes.Equal(packet.Raw, test.raw) {
+				t.Errorf("received %d bytes, want the %d bytes of the segment", packet.PacketLen, len(test.raw))
+			}
+			if !packet.Addr.Loopback() {
+				t.Error("the loopback flag is lost")
+			}
+		})
+	}
+}
+
+func TestSendPartial(t *testing.T) {
+	tests := []struct {
+		name    string
+		sendLen uint
+		wantErr bool
+	}{
+		{"whole packet", 40, false},
+		{"short write", 30, true},
+		{"nothing written", 0, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			newFakeDriver(t)
+			wd := openFake(t, OpenOptions{})
+			divertSend = func(handle uintptr, packet *byte, packetLen uint, sendLen *uint, addr *WinDivertAddress) error {
+				*sendLen = test.sendLen
+				return nil
+			}
+
+			n, err := wd.Send(NewPacket(ipv4Packet(40), NewAddress()))
+			if n != test.sendLen {
+				t.Errorf("Send() = %d bytes, want the %d reported by WinDivert", n, test.sendLen)
+			}
+			if test.wantErr != errors.Is(err, ErrPartialSend) {
+				t.Errorf("Send() = %v, want ErrPartialSend %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestHandleLayerPacketIO(t *testing.T) {
+	tests := []struct {
+		layer     Layer
+		wantIO    bool
+		wantEvent Event
+	}{
+		{WinDivertLayerNetwork, true, WinDivertEventNetworkPacket},
+		{WinDivertLayerNetworkForward, true, WinDivertEventNetworkPacket},
+		{WinDivertLayerFlow, false, WinDivertEventFlowEstablished},
+		{WinDivertLayerSocket, false, WinDivertEventSocketConnect},
+		{WinDivertLayerReflect, false, WinDivertEventReflectOpen},
+	}
+
+	for _, test := range tests {
+		t.Run(test.layer.String(), func(t *testing.T) {
+			driver := newFakeDriver(t)
+			wd := openFake(t, OpenOptions{Layer: test.layer})
+			if wd.Layer() != test.layer {
+				t.Fatalf("Layer() = %v, want %v", wd.Layer(), test.layer)
+			}
+
+			var addr WinDivertAddress
+			addr.setLayer(test.layer)
+			addr.setEvent(test.wantEvent)
+			driver.divert(ipv4Packet(20), addr)
+
+			_, sendErr := wd.Send(NewPacket(ipv4Packet(20), NewAddress()))
+			_, recvErr := wd.Recv()
+			_, recvCtxErr := wd.RecvContext(context.Background())
+			_, recvExErr := wd.RecvEx(4)
+			if test.wantIO {
+				if sendErr != nil || recvErr != nil {
+					t.Errorf("Send() = %v, Recv() = %v on the %v layer", sendErr, recvErr, test.layer)
+				}
+				return
+			}
+
+			// 没有数据包的层拒绝收发，不调用驱动
+			for name, err := range map[string]error{"Send": sendErr, "Recv": recvErr, "RecvContext": recvCtxErr, "RecvEx": recvExErr} {
+				if err == nil || errors.Is(err, ErrShutdown) || err == ErrSniffSend {
+					t.Errorf("%s() = %v on the %v layer, want the layer rejected", name, err, test.layer)
+				}
+			}
+			if len(driver.injected()) != 0 || driver.queued() != 1 {
+				t.Fatal("the driver was called on a layer without packets")
+			}
+			got, err := wd.RecvAddress()
+			if err != nil || got.Layer() != test.layer || got.Event() != test.wantEvent {
+				t.Errorf("RecvAddress() = %v, %v, want the %v event", got, err, test.wantEvent)
+			}
+		})
+	}
+}